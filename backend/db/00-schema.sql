@@ -6,25 +6,115 @@ CREATE TABLE IF NOT EXISTS users (
     name VARCHAR(255),
     picture_url TEXT,
     auth_provider VARCHAR(50) NOT NULL,
+    quota_bytes BIGINT, -- Storage quota override, NULL uses the configured default, 0 is unlimited
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
 );
 
+-- Columns added after the users table was first created. Running this file again
+-- upgrades an existing database.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT;
+
+-- Per-user preferences, users without a row use the defaults
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    strip_metadata BOOLEAN NOT NULL DEFAULT FALSE, -- Remove GPS and camera metadata from downloads and shared links
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+-- Content-addressed blobs, shared by every image with identical bytes
+CREATE TABLE IF NOT EXISTS blobs (
+    hash CHAR(64) PRIMARY KEY, -- Hex encoded SHA-256 of the content
+    storage_path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    ref_count INT NOT NULL DEFAULT 0, -- Number of images referencing this blob
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
 -- Create the images table
 CREATE TABLE IF NOT EXISTS images (
     id UUID PRIMARY KEY, -- Changed to UUID type based on image_handler.go
     user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
     filename VARCHAR(255) NOT NULL,
     storage_path TEXT, -- Path in blob storage
+    storage_backend VARCHAR(50), -- BLOB_STORAGE_TYPE holding the file, NULL for images uploaded before it was tracked
+    blob_hash CHAR(64) REFERENCES blobs (hash), -- Set for content-addressed uploads
+    checksum CHAR(64), -- Hex encoded SHA-256 of the content, verified by the scrubber
     content_type VARCHAR(100),
     size BIGINT,
     width INT,
     height INT,
+    taken_at TIMESTAMPTZ, -- Capture time from EXIF, copied from image_exif for sorting
+    phash BIGINT, -- Perceptual hash (dHash) for near-duplicate detection, NULL until computed
+    blurhash TEXT, -- BlurHash placeholder shown while the image loads, NULL until computed
+    dominant_color TEXT, -- Most common colour as "#rrggbb", NULL until computed
+    edits JSONB NOT NULL DEFAULT '[]', -- Non-destructive edits applied on top of the original, in order
+    version INT NOT NULL DEFAULT 1, -- Number of the current file, see image_versions
+    faces_detected_at TIMESTAMPTZ, -- When the faces service last detected the faces of the current file
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
     -- Add indexes later, e.g., ON user_id
 );
 
+-- Columns added after the images table was first created. Running this file again
+-- upgrades an existing database.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS blob_hash CHAR(64) REFERENCES blobs (hash);
+ALTER TABLE images ADD COLUMN IF NOT EXISTS storage_backend VARCHAR(50);
+ALTER TABLE images ADD COLUMN IF NOT EXISTS checksum CHAR(64);
+ALTER TABLE images ADD COLUMN IF NOT EXISTS taken_at TIMESTAMPTZ;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS phash BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS blurhash TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS dominant_color TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS edits JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS faces_detected_at TIMESTAMPTZ;
+
+-- Timeline order: date taken, or upload date for images without one
+CREATE INDEX IF NOT EXISTS images_user_timeline_idx ON images (user_id, (COALESCE(taken_at, created_at)) DESC);
+
+-- Finds a user's exact duplicates of an upload
+CREATE INDEX IF NOT EXISTS images_user_checksum_idx ON images (user_id, checksum);
+
+-- Capture metadata read from the EXIF block at upload. Every image that was
+-- checked has a row, with all fields NULL if it had no EXIF data.
+CREATE TABLE IF NOT EXISTS image_exif (
+    image_id UUID PRIMARY KEY REFERENCES images (id) ON DELETE CASCADE,
+    taken_at TIMESTAMPTZ,
+    camera_make VARCHAR(255),
+    camera_model VARCHAR(255),
+    lens_model VARCHAR(255),
+    focal_length DOUBLE PRECISION, -- Millimetres
+    f_number DOUBLE PRECISION,
+    exposure_time VARCHAR(32), -- Seconds, e.g. '1/250'
+    iso INT,
+    orientation SMALLINT, -- 1 (upright) to 8
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    altitude DOUBLE PRECISION, -- Metres above sea level
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+-- Files an image had before its original was replaced. The current file stays in
+-- images; every version number of an image is either there or here.
+CREATE TABLE IF NOT EXISTS image_versions (
+    image_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    storage_path TEXT NOT NULL,
+    storage_backend VARCHAR(50),
+    blob_hash CHAR(64) REFERENCES blobs (hash), -- Holds a blob reference like images.blob_hash
+    checksum CHAR(64),
+    content_type VARCHAR(100),
+    size BIGINT,
+    width INT,
+    height INT,
+    taken_at TIMESTAMPTZ,
+    edits JSONB NOT NULL DEFAULT '[]', -- Edit stack the image had with this file
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    PRIMARY KEY (image_id, version)
+);
+
 -- Albums table
 CREATE TABLE IF NOT EXISTS albums (
     id UUID PRIMARY KEY,
@@ -42,3 +132,105 @@ CREATE TABLE IF NOT EXISTS album_images (
     added_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
     PRIMARY KEY (album_id, image_id)
 );
+
+-- In-progress resumable (tus) uploads, chunks are staged in blob storage
+CREATE TABLE IF NOT EXISTS uploads (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    chunk_paths TEXT[] NOT NULL DEFAULT '{}',
+    allow_duplicate BOOLEAN NOT NULL DEFAULT FALSE, -- Store the image even if the user already has identical content
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+-- Columns added after the uploads table was first created. Running this file again
+-- upgrades an existing database.
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS allow_duplicate BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Per-owner data keys for encryption at rest, wrapped by the master key
+CREATE TABLE IF NOT EXISTS data_keys (
+    owner_id TEXT PRIMARY KEY, -- User ID, or 'system' for files without an owner
+    wrapped_key BYTEA NOT NULL,
+    master_key_id VARCHAR(64) NOT NULL, -- Fingerprint of the wrapping master key
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+-- Downscaled copies of images, generated after upload
+CREATE TABLE IF NOT EXISTS image_renditions (
+    image_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    size VARCHAR(20) NOT NULL, -- 'small', 'medium' or 'large'
+    storage_path TEXT NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    file_size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    PRIMARY KEY (image_id, size)
+);
+
+-- Background work, consumed by the service named by queue. Workers claim jobs with
+-- SELECT ... FOR UPDATE SKIP LOCKED, so each job is processed by one worker at a time.
+CREATE TABLE IF NOT EXISTS jobs (
+    id UUID PRIMARY KEY,
+    queue VARCHAR(50) NOT NULL, -- Consuming service, e.g. 'faces'
+    kind VARCHAR(100) NOT NULL, -- Type of work, determines the payload
+    payload JSONB NOT NULL,
+    idempotency_key VARCHAR(255), -- Enqueueing a job with a key already in the queue returns the existing job
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'running', 'done' or 'dead'
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    timeout_seconds INT NOT NULL, -- Visibility timeout, a running job is claimed again once it passes
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW (), -- Pending: earliest start, running: when the claim expires
+    locked_by VARCHAR(255), -- Worker that claimed the job last
+    last_error TEXT,
+    finished_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_idempotency_idx ON jobs (queue, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- Jobs a worker may claim, oldest due first
+CREATE INDEX IF NOT EXISTS jobs_claim_idx ON jobs (queue, run_at) WHERE status IN ('pending', 'running');
+
+-- People recognised in a user's images: clusters of similar faces
+CREATE TABLE IF NOT EXISTS people (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(255), -- NULL until the user names the person
+    model VARCHAR(50) NOT NULL, -- Face detector of its faces
+    centroid REAL[] NOT NULL, -- Mean embedding of its faces, new faces join the most similar person
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS people_user_idx ON people (user_id);
+
+-- Faces detected in images by the faces service
+CREATE TABLE IF NOT EXISTS faces (
+    id UUID PRIMARY KEY,
+    image_id UUID NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    person_id UUID REFERENCES people (id) ON DELETE SET NULL, -- NULL until clustered with similar faces
+    box_x REAL NOT NULL, -- Bounding box as fractions of the upright image
+    box_y REAL NOT NULL,
+    box_width REAL NOT NULL,
+    box_height REAL NOT NULL,
+    score REAL NOT NULL, -- Detector confidence
+    embedding REAL[] NOT NULL, -- Unit vector describing the face, only compared within a model
+    model VARCHAR(50) NOT NULL, -- Face detector that found the face and computed the embedding
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS faces_image_idx ON faces (image_id);
+CREATE INDEX IF NOT EXISTS faces_person_idx ON faces (person_id);
+
+-- Faces waiting for a similar face to form a person with
+CREATE INDEX IF NOT EXISTS faces_user_unassigned_idx ON faces (user_id, model) WHERE person_id IS NULL;
@@ -9,20 +9,74 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Apply the trigger to users table
-CREATE TRIGGER set_users_timestamp
+CREATE OR REPLACE TRIGGER set_users_timestamp
 BEFORE UPDATE ON users
 FOR EACH ROW
 EXECUTE FUNCTION trigger_set_timestamp();
 
 -- Apply the trigger to images table
-CREATE TRIGGER set_images_timestamp
+CREATE OR REPLACE TRIGGER set_images_timestamp
 BEFORE UPDATE ON images
 FOR EACH ROW
 EXECUTE FUNCTION trigger_set_timestamp();
 
 
 -- Apply the timestamp trigger to albums table
-CREATE TRIGGER set_albums_timestamp
+CREATE OR REPLACE TRIGGER set_albums_timestamp
 BEFORE UPDATE ON albums
 FOR EACH ROW
 EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Apply the timestamp trigger to uploads table
+CREATE OR REPLACE TRIGGER set_uploads_timestamp
+BEFORE UPDATE ON uploads
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Apply the timestamp trigger to data_keys table
+CREATE OR REPLACE TRIGGER set_data_keys_timestamp
+BEFORE UPDATE ON data_keys
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Apply the timestamp trigger to image_renditions table
+CREATE OR REPLACE TRIGGER set_image_renditions_timestamp
+BEFORE UPDATE ON image_renditions
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Apply the timestamp trigger to user_settings table
+CREATE OR REPLACE TRIGGER set_user_settings_timestamp
+BEFORE UPDATE ON user_settings
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Apply the timestamp trigger to jobs table
+CREATE OR REPLACE TRIGGER set_jobs_timestamp
+BEFORE UPDATE ON jobs
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Apply the timestamp trigger to people table
+CREATE OR REPLACE TRIGGER set_people_timestamp
+BEFORE UPDATE ON people
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Delete people left without faces, e.g. when their only image is deleted or its
+-- faces are detected again
+CREATE OR REPLACE FUNCTION trigger_delete_empty_people()
+RETURNS TRIGGER AS $$
+BEGIN
+  DELETE FROM people p
+  WHERE p.id IN (SELECT person_id FROM deleted_faces)
+    AND NOT EXISTS (SELECT 1 FROM faces f WHERE f.person_id = p.id);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER delete_empty_people
+AFTER DELETE ON faces
+REFERENCING OLD TABLE AS deleted_faces
+FOR EACH STATEMENT
+EXECUTE FUNCTION trigger_delete_empty_people();
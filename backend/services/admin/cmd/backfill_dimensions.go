@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// backfillDimensions records the width and height of images uploaded before dimensions
+// were extracted. Only the image headers are read. Images that already have dimensions
+// are skipped, so the command can be re-run to retry failures. With -all every image is
+// read again, which corrects rotated photos recorded before the EXIF orientation was applied.
+func backfillDimensions(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("backfill-dimensions", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report the dimensions that would be recorded")
+	all := flags.Bool("all", false, "read the dimensions of every image, not only those without")
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	flags.Parse(args)
+
+	blobStorage, err := storage.InitStorage(cfg, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	var done, failed int
+	afterID := ""
+	for {
+		var images []models.ImageMetadata
+		if *all {
+			images, err = store.ListAllImages(ctx, afterID, *batchSize)
+		} else {
+			images, err = store.ListImagesWithoutDimensions(ctx, afterID, *batchSize)
+		}
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			info, err := inspectImage(ctx, blobStorage, img.StoragePath)
+			if err != nil {
+				log.Printf("[%d] Failed to read image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+
+			if *dryRun {
+				log.Printf("[%d] Would set image %s to %dx%d", done, img.ID, info.Width, info.Height)
+				continue
+			}
+
+			err = store.SetImageDimensions(ctx, img.ID, info.Width, info.Height)
+			if err != nil && err.Error() != "image not found" {
+				log.Printf("[%d] Failed to update image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+
+			log.Printf("[%d] Image %s is %dx%d", done, img.ID, info.Width, info.Height)
+		}
+	}
+
+	log.Printf("%d images processed, %d failed (dry run: %v)", done, failed, *dryRun)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be read, re-run the command to retry them", failed)
+	}
+	return nil
+}
+
+// inspectImage reads the header of a stored image
+func inspectImage(ctx context.Context, blobStorage storage.BlobStorage, storagePath string) (*imaging.Info, error) {
+	file, _, err := blobStorage.Open(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return imaging.Inspect(file)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// MockBackfillStore keeps images in memory, ordered by ID
+type MockBackfillStore struct {
+	db.Store
+	images []models.ImageMetadata
+}
+
+func (m *MockBackfillStore) ListAllImages(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	var images []models.ImageMetadata
+	for _, img := range m.images {
+		if img.ID > afterID && len(images) < limit {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+func (m *MockBackfillStore) ListImagesWithoutDimensions(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	var images []models.ImageMetadata
+	for _, img := range m.images {
+		if img.ID > afterID && (img.Width == 0 || img.Height == 0) && len(images) < limit {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+func (m *MockBackfillStore) SetImageDimensions(ctx context.Context, imageID models.ImageID, width, height int) error {
+	for i := range m.images {
+		if m.images[i].ID == imageID {
+			m.images[i].Width, m.images[i].Height = width, height
+			return nil
+		}
+	}
+	return errors.New("image not found")
+}
+
+// dimensions returns the recorded width and height of every image, in order
+func (m *MockBackfillStore) dimensions() [][2]int {
+	var dimensions [][2]int
+	for _, img := range m.images {
+		dimensions = append(dimensions, [2]int{img.Width, img.Height})
+	}
+	return dimensions
+}
+
+func pngFile(t *testing.T, w, h int) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))))
+	return buf.String()
+}
+
+func TestBackfillDimensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		missing bool // The file of the last image is gone
+		want    [][2]int
+		wantErr bool
+	}{
+		{name: "images without dimensions", args: []string{"-batch", "1"}, want: [][2]int{{8, 6}, {3, 3}, {5, 7}}},
+		{name: "all images", args: []string{"-all"}, want: [][2]int{{8, 6}, {5, 5}, {5, 7}}},
+		{name: "dry run", args: []string{"-dry-run"}, want: [][2]int{{0, 0}, {3, 3}, {0, 7}}},
+		{name: "unreadable image", missing: true, want: [][2]int{{8, 6}, {3, 3}, {0, 7}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{BlobStorageType: storage.Local, LocalstoragePath: t.TempDir()}
+			local, err := storage.NewLocalStorage(cfg.LocalstoragePath, nil)
+			require.NoError(t, err)
+
+			store := &MockBackfillStore{images: []models.ImageMetadata{
+				{ID: "1", StoragePath: "alice/1.png"},
+				{ID: "2", StoragePath: "alice/2.png", Width: 3, Height: 3}, // Recorded wrong, only read again with -all
+				{ID: "3", StoragePath: "alice/3.png", Height: 7},
+			}}
+			putFile(t, local, "alice/1.png", pngFile(t, 8, 6))
+			putFile(t, local, "alice/2.png", pngFile(t, 5, 5))
+			if !tt.missing {
+				putFile(t, local, "alice/3.png", pngFile(t, 5, 7))
+			}
+
+			err = backfillDimensions(context.Background(), cfg, store, tt.args)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, store.dimensions())
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// backfillExif reads the capture metadata of images uploaded before EXIF data was
+// extracted. Images that were already checked are skipped, so the command can be
+// re-run to retry failures.
+func backfillExif(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("backfill-exif", flag.ExitOnError)
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	flags.Parse(args)
+
+	blobStorage, err := storage.InitStorage(cfg, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	var done, failed, found int
+	afterID := ""
+	for {
+		images, err := store.ListImagesWithoutExif(ctx, afterID, *batchSize)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			exifData, err := readImageExif(ctx, blobStorage, &img)
+			if err != nil {
+				log.Printf("[%d] Failed to read image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+			if exifData == nil {
+				exifData = &models.ImageExif{} // Recorded as checked
+			} else {
+				found++
+			}
+			exifData.ImageID = img.ID
+
+			err = store.SaveImageExif(ctx, exifData)
+			if err != nil && err.Error() != "image not found" {
+				log.Printf("[%d] Failed to update image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+		}
+		log.Printf("%d images checked, %d with EXIF data", done, found)
+	}
+
+	log.Printf("%d images processed, %d with EXIF data, %d failed", done, found, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be read, re-run the command to retry them", failed)
+	}
+	return nil
+}
+
+// readImageExif reads the EXIF block of a stored image
+func readImageExif(ctx context.Context, blobStorage storage.BlobStorage, img *models.ImageMetadata) (*models.ImageExif, error) {
+	file, _, err := blobStorage.Open(ctx, img.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return imaging.ReadExif(file, img.ContentType)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// backfillHashes computes the perceptual hash of images uploaded before hashes were
+// recorded, so they are included in near-duplicate detection. Images already hashed
+// are skipped, so the command can be re-run to retry failures.
+func backfillHashes(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("backfill-hashes", flag.ExitOnError)
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	flags.Parse(args)
+
+	blobStorage, err := storage.InitStorage(cfg, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	var done, failed int
+	afterID := ""
+	for {
+		images, err := store.ListImagesWithoutPerceptualHash(ctx, afterID, *batchSize)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			hash, err := hashImage(ctx, blobStorage, img.StoragePath)
+			if err != nil {
+				log.Printf("[%d] Failed to read image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+
+			err = store.SetPerceptualHash(ctx, img.ID, hash)
+			if err != nil && err.Error() != "image not found" {
+				log.Printf("[%d] Failed to update image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+		}
+		log.Printf("%d images hashed", done)
+	}
+
+	log.Printf("%d images processed, %d failed", done, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be read, re-run the command to retry them", failed)
+	}
+	return nil
+}
+
+// hashImage decodes a stored image and computes its perceptual hash
+func hashImage(ctx context.Context, blobStorage storage.BlobStorage, storagePath string) (uint64, error) {
+	file, _, err := blobStorage.Open(ctx, storagePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, info, err := imaging.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+	return imaging.PerceptualHash(img, info.Orientation), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// backfillPlaceholders computes the BlurHash and dominant colour of images uploaded
+// before placeholders were recorded. Images that have one are skipped, so the command
+// can be re-run to retry failures.
+func backfillPlaceholders(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("backfill-placeholders", flag.ExitOnError)
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	flags.Parse(args)
+
+	blobStorage, err := storage.InitStorage(cfg, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	var done, failed int
+	afterID := ""
+	for {
+		images, err := store.ListImagesWithoutPlaceholder(ctx, afterID, *batchSize)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			blurHash, dominantColor, err := placeholderOf(ctx, blobStorage, &img)
+			if err != nil {
+				log.Printf("[%d] Failed to read image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+
+			err = store.SetImagePlaceholder(ctx, img.ID, blurHash, dominantColor)
+			if err != nil && err.Error() != "image not found" {
+				log.Printf("[%d] Failed to update image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+		}
+		log.Printf("%d images processed", done)
+	}
+
+	log.Printf("%d images processed, %d failed", done, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be read, re-run the command to retry them", failed)
+	}
+	return nil
+}
+
+// placeholderOf decodes a stored image and computes its placeholder, as edited
+func placeholderOf(ctx context.Context, blobStorage storage.BlobStorage, meta *models.ImageMetadata) (string, string, error) {
+	file, _, err := blobStorage.Open(ctx, meta.StoragePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	img, info, err := imaging.Decode(file)
+	if err != nil {
+		return "", "", err
+	}
+	blurHash, dominantColor := imaging.Placeholder(imaging.ApplyEdits(img, info.Orientation, meta.Edits), 1)
+	return blurHash, dominantColor, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// detectFaces enqueues face detection for images uploaded before faces were detected,
+// for the faces service to process. Images whose job is already queued, or was done
+// recently, are not enqueued twice, so the command can be re-run. With -all every image
+// is detected again, e.g. after the face detector changed.
+func detectFaces(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("detect-faces", flag.ExitOnError)
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	all := flags.Bool("all", false, "detect the faces of every image again, not only of those never detected")
+	flags.Parse(args)
+
+	queue := jobs.NewQueue(store)
+
+	// The key of an upload's job for images never detected, a key of this run otherwise
+	keyOf := func(img *models.ImageMetadata) string { return "detect-faces:" + img.ID }
+	if *all {
+		run := time.Now().UnixNano()
+		keyOf = func(img *models.ImageMetadata) string { return fmt.Sprintf("detect-faces:%s:all-%d", img.ID, run) }
+	}
+
+	var done, enqueued, failed int
+	afterID := ""
+	for {
+		var images []models.ImageMetadata
+		var err error
+		if *all {
+			images, err = store.ListAllImages(ctx, afterID, *batchSize)
+		} else {
+			images, err = store.ListImagesWithoutFaces(ctx, afterID, *batchSize)
+		}
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			payload := jobs.DetectFacesPayload{ImageID: img.ID, UserID: img.UserID}
+			_, created, err := jobs.DetectFaces.Enqueue(ctx, queue, payload, keyOf(&img))
+			if err != nil {
+				log.Printf("[%d] Failed to enqueue face detection for image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+			if created {
+				enqueued++
+			}
+		}
+		log.Printf("%d images processed, %d enqueued", done, enqueued)
+	}
+
+	log.Printf("%d images processed, %d enqueued, %d failed", done, enqueued, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be enqueued, re-run the command to retry them", failed)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/renditions"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// generateRenditions creates the thumbnails and previews of images uploaded before
+// renditions were generated, or whose generation failed. Images that have every
+// rendition are skipped, so the command can be re-run to retry failures. With -all
+// the renditions of every image are replaced, e.g. after the way they are made changed.
+func generateRenditions(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("generate-renditions", flag.ExitOnError)
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	all := flags.Bool("all", false, "regenerate the renditions of every image, not only missing ones")
+	flags.Parse(args)
+
+	blobStorage, err := storage.InitStorage(cfg, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	generator := renditions.NewGenerator(store, blobStorage, jobs.NewQueue(store))
+
+	var done, failed int
+	afterID := ""
+	for {
+		var images []models.ImageMetadata
+		if *all {
+			images, err = store.ListAllImages(ctx, afterID, *batchSize)
+		} else {
+			images, err = store.ListImagesMissingRenditions(ctx, len(renditions.Specs), afterID, *batchSize)
+		}
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			if _, err := generator.Generate(ctx, &img); err != nil {
+				log.Printf("[%d] Failed to generate renditions of image %s: %v", done, img.ID, err)
+				failed++
+				continue
+			}
+			log.Printf("[%d] Generated renditions of image %s", done, img.ID)
+		}
+	}
+
+	log.Printf("%d images processed, %d failed", done, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be processed, re-run the command to retry them", failed)
+	}
+	return nil
+}
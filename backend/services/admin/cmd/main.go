@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// command is an admin subcommand, run with the remaining command line arguments
+type command struct {
+	Description string
+	Run         func(ctx context.Context, cfg *config.Config, store db.Store, args []string) error
+}
+
+var commands = map[string]command{
+	"backfill-dimensions": {
+		Description: "Record the width and height of images uploaded without them",
+		Run:         backfillDimensions,
+	},
+	"backfill-exif": {
+		Description: "Read the capture metadata of images uploaded without it",
+		Run:         backfillExif,
+	},
+	"backfill-hashes": {
+		Description: "Compute the perceptual hash of images uploaded without one",
+		Run:         backfillHashes,
+	},
+	"backfill-placeholders": {
+		Description: "Compute the loading placeholder of images uploaded without one",
+		Run:         backfillPlaceholders,
+	},
+	"detect-faces": {
+		Description: "Enqueue face detection for images whose faces were never detected",
+		Run:         detectFaces,
+	},
+	"generate-renditions": {
+		Description: "Create missing thumbnails and previews",
+		Run:         generateRenditions,
+	},
+	"migrate-storage": {
+		Description: "Copy every image to another storage backend",
+		Run:         migrateStorage,
+	},
+	"rotate-keys": {
+		Description: "Re-wrap every data key with the current ENCRYPTION_MASTER_KEY",
+		Run:         rotateKeys,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: admin <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].Description)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	// 1. Load Configuration
+	cfg, err := config.LoadConfig("./")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// 2. Initialize Database Connection
+	store, err := db.NewPostgresStore(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer store.Close()
+
+	// 3. Run the command
+	if err := cmd.Run(context.Background(), cfg, store, os.Args[2:]); err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+}
+
+// rotateKeys re-wraps data keys still wrapped by one of ENCRYPTION_PREVIOUS_MASTER_KEYS.
+// Once it succeeds the previous master keys can be removed from the configuration.
+func rotateKeys(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	flags.Parse(args)
+
+	if cfg.EncryptionMasterKey == "" {
+		return fmt.Errorf("ENCRYPTION_MASTER_KEY is not set")
+	}
+
+	keyring, err := storage.NewKeyring(cfg.EncryptionMasterKey, cfg.EncryptionPreviousMasterKeys)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := storage.RotateDataKeys(ctx, keyring, store)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Re-wrapped %d data keys with master key %s", rotated, keyring.CurrentID())
+	return nil
+}
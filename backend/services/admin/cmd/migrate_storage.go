@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// migrateStorage copies every image stored in one backend to another, followed by the
+// previous versions of replaced originals. Each file is verified by checksum before its
+// row is switched to the target backend, so the command can be stopped at any time and
+// re-run to continue where it left off. Source files are never deleted.
+func migrateStorage(ctx context.Context, cfg *config.Config, store db.Store, args []string) error {
+	// While migrating the services run with the target as BLOB_STORAGE_TYPE and the
+	// source as BLOB_STORAGE_FALLBACK_TYPE, which makes for sensible defaults
+	defaultFrom := cfg.BlobStorageFallbackType
+	if defaultFrom == "" {
+		defaultFrom = cfg.BlobStorageType
+	}
+
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	from := flags.String("from", defaultFrom, "storage backend to copy images from")
+	to := flags.String("to", cfg.BlobStorageType, "storage backend to copy images to")
+	dryRun := flags.Bool("dry-run", false, "only report what would be copied")
+	batchSize := flags.Int("batch", 100, "number of images to load at a time")
+	flags.Parse(args)
+
+	if *from == *to {
+		return fmt.Errorf("source and target backend are both %q, set -from and -to", *from)
+	}
+
+	source, err := storage.InitBackend(cfg, *from, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize source storage: %w", err)
+	}
+	target, err := storage.InitBackend(cfg, *to, store)
+	if err != nil {
+		return fmt.Errorf("failed to initialize target storage: %w", err)
+	}
+
+	m := &migration{Store: store, Source: source, Target: target, From: *from, To: *to, DryRun: *dryRun, BatchSize: *batchSize}
+	return m.run(ctx)
+}
+
+// migration copies the files of the images and versions recorded in one storage backend to another
+type migration struct {
+	Store          db.Store
+	Source, Target storage.BlobStorage
+	From, To       string // Backend names, as recorded in the rows
+	DryRun         bool
+	BatchSize      int
+}
+
+func (m *migration) run(ctx context.Context) error {
+	total, err := m.Store.CountImagesInStorageBackend(ctx, m.From)
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrating %d images from %s to %s storage (dry run: %v)", total, m.From, m.To, m.DryRun)
+
+	// Content-addressed images share files, which only need to be copied once
+	copied := map[string]string{} // Storage path -> checksum
+
+	var done, failed int
+	var bytesCopied int64
+	afterID := ""
+	for {
+		images, err := m.Store.ListImagesInStorageBackend(ctx, m.From, afterID, m.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			done++
+
+			if m.DryRun {
+				info, err := m.Source.Stat(ctx, img.StoragePath)
+				if err != nil {
+					log.Printf("[%d/%d] Image %s: %v", done, total, img.ID, err)
+					failed++
+					continue
+				}
+				log.Printf("[%d/%d] Would copy image %s (%s, %d bytes)", done, total, img.ID, img.StoragePath, info.Size)
+				bytesCopied += info.Size
+				continue
+			}
+
+			if _, ok := copied[img.StoragePath]; !ok {
+				checksum, size, err := copyBlob(ctx, m.Source, m.Target, &img)
+				if err != nil {
+					log.Printf("[%d/%d] Failed to copy image %s: %v", done, total, img.ID, err)
+					failed++
+					continue
+				}
+				copied[img.StoragePath] = checksum
+				bytesCopied += size
+			}
+
+			// Only switch images whose file did not change while it was being copied
+			err := m.Store.SetImageStorageBackend(ctx, img.ID, img.StoragePath, m.To)
+			if err != nil && err.Error() != "image not found" {
+				log.Printf("[%d/%d] Failed to update image %s: %v", done, total, img.ID, err)
+				failed++
+				continue
+			}
+
+			log.Printf("[%d/%d] Migrated image %s (%s)", done, total, img.ID, img.StoragePath)
+		}
+	}
+
+	// Previous versions of replaced originals, which share files with images in the same way
+	var versionsDone int
+	after := models.ImageVersion{}
+	for {
+		versions, err := m.Store.ListImageVersionsInStorageBackend(ctx, m.From, after, m.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			break
+		}
+
+		for _, v := range versions {
+			after = v
+			versionsDone++
+			file := &models.ImageMetadata{ID: v.ImageID, UserID: v.UserID, StoragePath: v.StoragePath, BlobHash: v.BlobHash, Checksum: v.Checksum}
+
+			if m.DryRun {
+				info, err := m.Source.Stat(ctx, v.StoragePath)
+				if err != nil {
+					log.Printf("[version %d] Image %s version %d: %v", versionsDone, v.ImageID, v.Version, err)
+					failed++
+					continue
+				}
+				log.Printf("[version %d] Would copy image %s version %d (%s, %d bytes)", versionsDone, v.ImageID, v.Version, v.StoragePath, info.Size)
+				bytesCopied += info.Size
+				continue
+			}
+
+			if _, ok := copied[v.StoragePath]; !ok {
+				checksum, size, err := copyBlob(ctx, m.Source, m.Target, file)
+				if err != nil {
+					log.Printf("[version %d] Failed to copy image %s version %d: %v", versionsDone, v.ImageID, v.Version, err)
+					failed++
+					continue
+				}
+				copied[v.StoragePath] = checksum
+				bytesCopied += size
+			}
+
+			// Versions are never modified, only restored or deleted with their image
+			err := m.Store.SetImageVersionStorageBackend(ctx, v.ImageID, v.Version, m.To)
+			if err != nil && err.Error() != "version not found" {
+				log.Printf("[version %d] Failed to update image %s version %d: %v", versionsDone, v.ImageID, v.Version, err)
+				failed++
+				continue
+			}
+
+			log.Printf("[version %d] Migrated image %s version %d (%s)", versionsDone, v.ImageID, v.Version, v.StoragePath)
+		}
+	}
+
+	verb := "Copied"
+	if m.DryRun {
+		verb = "Would copy"
+	}
+	log.Printf("%s %d bytes; %d of %d images and %d versions processed, %d failed", verb, bytesCopied, done, total, versionsDone, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images or versions could not be migrated, re-run the command to retry them", failed)
+	}
+	return nil
+}
+
+// copyBlob copies an image's file from source to target. The file is spooled to a local
+// temporary file and checked against its content hash and recorded checksum before anything
+// is written, so a corrupt source never reaches the target. The copy is then read back, and
+// deleted again if it cannot be read or does not match. It returns the checksum and the
+// number of bytes copied.
+func copyBlob(ctx context.Context, source, target storage.BlobStorage, img *models.ImageMetadata) (string, int64, error) {
+	file, contentType, err := source.Download(ctx, img.StoragePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	spool, err := os.CreateTemp("", "migrate-storage-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read source: %w", err)
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	if img.BlobHash != nil && *img.BlobHash != checksum {
+		return "", 0, fmt.Errorf("source file does not match its content hash %s", *img.BlobHash)
+	}
+	if img.Checksum != nil && *img.Checksum != checksum {
+		return "", 0, fmt.Errorf("source file does not match its recorded checksum %s", *img.Checksum)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", 0, fmt.Errorf("failed to rewind temp file: %w", err)
+	}
+
+	// Re-encrypt with the owner's data key when encryption at rest is enabled. Content-addressed
+	// blobs are shared across users and use the system data key, like on upload.
+	owner := img.UserID
+	if img.BlobHash != nil {
+		owner = storage.SystemKeyOwner
+	}
+	ctx = storage.WithKeyOwner(ctx, owner)
+	if err := target.Put(ctx, img.StoragePath, spool, contentType); err != nil {
+		return "", 0, fmt.Errorf("failed to write to target: %w", err)
+	}
+
+	copiedChecksum, err := fileChecksum(ctx, target, img.StoragePath)
+	if err == nil && copiedChecksum != checksum {
+		err = fmt.Errorf("checksum mismatch after copy: source %s, target %s", checksum, copiedChecksum)
+	} else if err != nil {
+		err = fmt.Errorf("failed to read back copy: %w", err)
+	}
+	if err != nil {
+		// A bad copy must not be served; without it reads fall back to the source until the
+		// command is re-run
+		if deleteErr := target.Delete(ctx, img.StoragePath); deleteErr != nil {
+			log.Printf("Failed to delete bad copy of %s: %v", img.StoragePath, deleteErr)
+		}
+		return "", 0, err
+	}
+
+	return checksum, size, nil
+}
+
+// fileChecksum computes the hex encoded SHA-256 of a stored file
+func fileChecksum(ctx context.Context, blobStorage storage.BlobStorage, storagePath string) (string, error) {
+	file, _, err := blobStorage.Download(ctx, storagePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
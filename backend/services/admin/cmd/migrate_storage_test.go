@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// MockMigrationStore keeps images and versions in memory, ordered like the database lists them.
+// Methods the migration does not use are left to the embedded interface and panic.
+type MockMigrationStore struct {
+	db.Store
+	images   []models.ImageMetadata
+	versions []models.ImageVersion
+}
+
+func inBackend(recorded *string, backend string) bool {
+	return recorded == nil || *recorded == backend
+}
+
+func (m *MockMigrationStore) ListImagesInStorageBackend(ctx context.Context, backend string, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	var images []models.ImageMetadata
+	for _, img := range m.images {
+		if img.ID > afterID && inBackend(img.StorageBackend, backend) && len(images) < limit {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+func (m *MockMigrationStore) CountImagesInStorageBackend(ctx context.Context, backend string) (int, error) {
+	images, _ := m.ListImagesInStorageBackend(ctx, backend, "", len(m.images))
+	return len(images), nil
+}
+
+func (m *MockMigrationStore) SetImageStorageBackend(ctx context.Context, imageID models.ImageID, storagePath, backend string) error {
+	for i := range m.images {
+		if m.images[i].ID == imageID && m.images[i].StoragePath == storagePath {
+			m.images[i].StorageBackend = &backend
+			return nil
+		}
+	}
+	return errors.New("image not found")
+}
+
+func (m *MockMigrationStore) ListImageVersionsInStorageBackend(ctx context.Context, backend string, after models.ImageVersion, limit int) ([]models.ImageVersion, error) {
+	var versions []models.ImageVersion
+	for _, v := range m.versions {
+		later := v.ImageID > after.ImageID || (v.ImageID == after.ImageID && v.Version > after.Version)
+		if later && inBackend(v.StorageBackend, backend) && len(versions) < limit {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (m *MockMigrationStore) SetImageVersionStorageBackend(ctx context.Context, imageID models.ImageID, version int, backend string) error {
+	for i := range m.versions {
+		if m.versions[i].ImageID == imageID && m.versions[i].Version == version {
+			m.versions[i].StorageBackend = &backend
+			return nil
+		}
+	}
+	return errors.New("version not found")
+}
+
+// backends returns the backend recorded for every image and version, in order
+func (m *MockMigrationStore) backends() []string {
+	var backends []string
+	for _, img := range m.images {
+		backends = append(backends, *img.StorageBackend)
+	}
+	for _, v := range m.versions {
+		backends = append(backends, *v.StorageBackend)
+	}
+	return backends
+}
+
+func checksumOf(content string) *string {
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+	return &checksum
+}
+
+func localBackend() *string {
+	backend := "local"
+	return &backend
+}
+
+// newTestMigration returns a migration from one local storage to another, with a batch
+// size small enough for the listing to take several pages
+func newTestMigration(t *testing.T, store db.Store) (*migration, *storage.LocalStorage, *storage.LocalStorage) {
+	source, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+	target, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	m := &migration{Store: store, Source: source, Target: target, From: "local", To: "s3", BatchSize: 1}
+	return m, source, target
+}
+
+func putFile(t *testing.T, s storage.BlobStorage, storagePath, content string) {
+	require.NoError(t, s.Put(context.Background(), storagePath, strings.NewReader(content), "image/jpeg"))
+}
+
+func readFile(t *testing.T, s storage.BlobStorage, storagePath string) string {
+	file, _, err := s.Download(context.Background(), storagePath)
+	require.NoError(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func storedFiles(t *testing.T, s storage.BlobStorage) []string {
+	var paths []string
+	err := s.Walk(context.Background(), "", func(storagePath string, info *storage.ObjectInfo) error {
+		paths = append(paths, storagePath)
+		return nil
+	})
+	require.NoError(t, err)
+	return paths
+}
+
+// corruptingStorage flips the first byte of every file read back from it
+type corruptingStorage struct {
+	storage.BlobStorage
+}
+
+func (s corruptingStorage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
+	file, contentType, err := s.BlobStorage.Download(ctx, storagePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > 0 {
+		content[0] ^= 0xff
+	}
+	return io.NopCloser(bytes.NewReader(content)), contentType, nil
+}
+
+// countingPutStorage counts the files written to it
+type countingPutStorage struct {
+	storage.BlobStorage
+	puts int
+}
+
+func (s *countingPutStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	s.puts++
+	return s.BlobStorage.Put(ctx, storagePath, content, contentType)
+}
+
+func TestMigrateStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("copies files and switches rows", func(t *testing.T) {
+		blob := "shared blob"
+		blobPath := "blobs/" + *checksumOf(blob)
+		store := &MockMigrationStore{
+			images: []models.ImageMetadata{
+				{ID: "1", UserID: "alice", StoragePath: "alice/1.jpg", Checksum: checksumOf("first")},
+				{ID: "2", UserID: "alice", StoragePath: blobPath, StorageBackend: localBackend(), BlobHash: checksumOf(blob), Checksum: checksumOf(blob)},
+				{ID: "3", UserID: "bob", StoragePath: blobPath, StorageBackend: localBackend(), BlobHash: checksumOf(blob), Checksum: checksumOf(blob)},
+			},
+			versions: []models.ImageVersion{
+				{ImageID: "1", UserID: "alice", Version: 1, StoragePath: "alice/1-v1.jpg", Checksum: checksumOf("replaced")},
+			},
+		}
+		m, source, target := newTestMigration(t, store)
+		putFile(t, source, "alice/1.jpg", "first")
+		putFile(t, source, blobPath, blob)
+		putFile(t, source, "alice/1-v1.jpg", "replaced")
+
+		require.NoError(t, m.run(ctx))
+
+		assert.Equal(t, "first", readFile(t, target, "alice/1.jpg"))
+		assert.Equal(t, blob, readFile(t, target, blobPath))
+		assert.Equal(t, "replaced", readFile(t, target, "alice/1-v1.jpg"))
+		assert.Equal(t, []string{"s3", "s3", "s3", "s3"}, store.backends())
+
+		// Source files are kept
+		assert.Equal(t, "first", readFile(t, source, "alice/1.jpg"))
+	})
+
+	t.Run("corrupt source is not written", func(t *testing.T) {
+		store := &MockMigrationStore{
+			images: []models.ImageMetadata{
+				{ID: "1", UserID: "alice", StoragePath: "alice/1.jpg", StorageBackend: localBackend(), Checksum: checksumOf("original")},
+			},
+		}
+		m, source, target := newTestMigration(t, store)
+		putFile(t, source, "alice/1.jpg", "bit rot")
+
+		assert.Error(t, m.run(ctx))
+
+		assert.Empty(t, storedFiles(t, target))
+		assert.Equal(t, []string{"local"}, store.backends())
+	})
+
+	t.Run("bad copy is deleted", func(t *testing.T) {
+		store := &MockMigrationStore{
+			images: []models.ImageMetadata{
+				{ID: "1", UserID: "alice", StoragePath: "alice/1.jpg", StorageBackend: localBackend(), Checksum: checksumOf("original")},
+			},
+		}
+		m, source, target := newTestMigration(t, store)
+		m.Target = corruptingStorage{target}
+		putFile(t, source, "alice/1.jpg", "original")
+
+		assert.Error(t, m.run(ctx))
+
+		assert.Empty(t, storedFiles(t, target))
+		assert.Equal(t, []string{"local"}, store.backends())
+	})
+
+	t.Run("dry run writes nothing", func(t *testing.T) {
+		store := &MockMigrationStore{
+			images: []models.ImageMetadata{
+				{ID: "1", UserID: "alice", StoragePath: "alice/1.jpg", StorageBackend: localBackend(), Checksum: checksumOf("first")},
+				{ID: "2", UserID: "alice", StoragePath: "alice/2.jpg", StorageBackend: localBackend(), Checksum: checksumOf("second")},
+			},
+		}
+		m, source, target := newTestMigration(t, store)
+		m.DryRun = true
+		putFile(t, source, "alice/1.jpg", "first")
+		putFile(t, source, "alice/2.jpg", "second")
+
+		require.NoError(t, m.run(ctx))
+
+		assert.Empty(t, storedFiles(t, target))
+		assert.Equal(t, []string{"local", "local"}, store.backends())
+	})
+
+	t.Run("re-run resumes after failures", func(t *testing.T) {
+		store := &MockMigrationStore{
+			images: []models.ImageMetadata{
+				{ID: "1", UserID: "alice", StoragePath: "alice/1.jpg", StorageBackend: localBackend(), Checksum: checksumOf("first")},
+				{ID: "2", UserID: "alice", StoragePath: "alice/2.jpg", StorageBackend: localBackend(), Checksum: checksumOf("second")},
+			},
+		}
+		m, source, target := newTestMigration(t, store)
+		putFile(t, source, "alice/1.jpg", "first")
+		putFile(t, source, "alice/2.jpg", "truncat")
+
+		assert.Error(t, m.run(ctx))
+		assert.Equal(t, []string{"s3", "local"}, store.backends())
+
+		// Once the source is repaired only the image that failed is copied again
+		putFile(t, source, "alice/2.jpg", "second")
+		counting := &countingPutStorage{BlobStorage: target}
+		m.Target = counting
+
+		require.NoError(t, m.run(ctx))
+
+		assert.Equal(t, 1, counting.puts)
+		assert.Equal(t, "second", readFile(t, target, "alice/2.jpg"))
+		assert.Equal(t, []string{"s3", "s3"}, store.backends())
+	})
+}
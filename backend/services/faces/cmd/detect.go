@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/shivamkedia17/roshnii/services/faces/internal/faces"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+const (
+	// detectSize is the longest side images are scaled down to before detection
+	detectSize = 1024
+
+	// unassignedLimit bounds the faces without a person compared when clustering,
+	// only the most recent are kept
+	unassignedLimit = 2000
+)
+
+// detectFaces detects the faces in an image, replacing those of an earlier detection,
+// and clusters them with the other faces of the user
+func (s *service) detectFaces(ctx context.Context, job *models.Job, p jobs.DetectFacesPayload) error {
+	img, err := s.DB.GetImageByID(ctx, p.UserID, p.ImageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			log.Printf("Image %s was deleted, skipping face detection", p.ImageID)
+			return nil
+		}
+		return err
+	}
+
+	detections, err := s.detect(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	detected := make([]models.Face, len(detections))
+	for i, d := range detections {
+		detected[i] = models.Face{
+			ID:        uuid.New().String(),
+			ImageID:   img.ID,
+			UserID:    img.UserID,
+			Box:       d.Box,
+			Score:     d.Score,
+			Embedding: d.Embedding,
+			Model:     s.Detector.Model(),
+		}
+	}
+
+	if err := s.DB.ReplaceImageFaces(ctx, img.UserID, img.ID, detected); err != nil {
+		if err.Error() == "image not found" {
+			log.Printf("Image %s was deleted, skipping face detection", p.ImageID)
+			return nil
+		}
+		return fmt.Errorf("failed to record faces: %w", err)
+	}
+	log.Printf("Detected %d faces in image %s", len(detected), img.ID)
+
+	if len(detected) == 0 {
+		return nil
+	}
+	return s.cluster(ctx, img.UserID)
+}
+
+// detect decodes an image as uploaded, turns it upright and finds its faces
+func (s *service) detect(ctx context.Context, img *models.ImageMetadata) ([]faces.Detection, error) {
+	file, _, err := s.Storage.Open(storage.WithKeyOwner(ctx, img.UserID), img.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	src, info, err := imaging.Decode(file)
+	file.Close()
+	if err != nil {
+		// Retrying cannot make the file decodable
+		if errors.Is(err, imaging.ErrUndecodable) || errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrTooLarge) {
+			return nil, jobs.Permanent(err)
+		}
+		return nil, err
+	}
+
+	upright := imaging.Orient(imaging.Fit(src, detectSize, detectSize), info.Orientation)
+	return s.Detector.Detect(ctx, upright)
+}
+
+// cluster adds the faces of a user that belong to no person to the most similar
+// people, and creates people from groups of similar faces
+func (s *service) cluster(ctx context.Context, userID models.UserID) error {
+	created, err := s.DB.ClusterFaces(ctx, userID, s.Detector.Model(), unassignedLimit,
+		func(people []models.Person, unassigned []models.Face) (map[models.PersonID][]models.FaceID, [][]models.FaceID) {
+			// Oldest first, so people form in the order their faces were found
+			slices.Reverse(unassigned)
+			plan := faces.Cluster(people, unassigned, s.Detector.Threshold())
+			return plan.Assign, plan.Create
+		})
+	if err != nil {
+		return fmt.Errorf("failed to cluster faces: %w", err)
+	}
+
+	for _, personID := range created {
+		log.Printf("Created person %s from faces of user %s", personID, userID)
+	}
+	return nil
+}
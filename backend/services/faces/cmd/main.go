@@ -1,10 +1,104 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/services/faces/internal/faces"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
 )
 
+// The worker is reported down when it could not read the queue for this long
+const unhealthyAfter = 30 * time.Second
+
 func main() {
-	fmt.Println("Starting faces microservice...")
-	// Initialize your service here
+	log.Println("Starting faces microservice...")
+
+	// 1. Load Configuration
+	cfg, err := config.LoadConfig("./")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// 2. Initialize Database Connection
+	store, err := db.NewPostgresStore(cfg.PostgresURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer store.Close()
+
+	// 3. Initialize Blob Storage
+	storageService, err := storage.InitStorage(cfg, store)
+	if err != nil {
+		log.Fatalf("Failed to initialise Blob Store: %v", err)
+	}
+
+	// 4. Initialize the Face Detector
+	detector, err := faces.NewCPUDetector()
+	if err != nil {
+		log.Fatalf("Failed to initialise face detector: %v", err)
+	}
+
+	// 5. Initialize the Worker and its job handlers
+	worker := jobs.NewWorker(jobs.NewQueue(store), jobs.Faces, cfg.FacesWorkers)
+	svc := &service{Config: cfg, DB: store, Storage: storageService, Detector: detector}
+	svc.register(worker)
+
+	// 6. Start the Health Endpoint
+	healthAddr := fmt.Sprintf("%s:%s", cfg.ServerHost, cfg.FacesHealthPort)
+	healthServer := &http.Server{Addr: healthAddr, Handler: healthHandler(worker)}
+	go func() {
+		log.Printf("Serving health checks on %s", healthAddr)
+		if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Health endpoint failed to start: %v", err)
+		}
+	}()
+
+	// 7. Process Jobs until stopped, then let those in progress finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	worker.Run(ctx, cfg.FacesDrainTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop health endpoint: %v", err)
+	}
+	log.Println("faces microservice stopped")
+}
+
+// healthHandler reports whether the worker is processing jobs. It answers 503 while
+// the worker drains or cannot read the queue, so orchestrators stop relying on it.
+func healthHandler(worker *jobs.Worker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		stats := worker.Stats()
+
+		status, code := "UP", http.StatusOK
+		switch {
+		case stats.Draining:
+			status, code = "DRAINING", http.StatusServiceUnavailable
+		case time.Since(stats.LastPoll) > unhealthyAfter || stats.LastError != "":
+			status, code = "DOWN", http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Status string `json:"status"`
+			jobs.WorkerStats
+		}{status, stats})
+	})
+	return mux
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/shivamkedia17/roshnii/services/faces/internal/faces"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// service holds what the job handlers of the faces service work with
+type service struct {
+	Config   *config.Config
+	DB       db.Store
+	Storage  storage.BlobStorage
+	Detector faces.FaceDetector
+}
+
+// register adds the handler of every kind of job the service processes to the
+// worker. Jobs of kinds without a handler stay queued until a version that
+// handles them is deployed.
+func (s *service) register(worker *jobs.Worker) {
+	jobs.Handle(worker, jobs.DetectFaces, s.detectFaces)
+}
@@ -0,0 +1,78 @@
+package faces
+
+import "github.com/shivamkedia17/roshnii/shared/pkg/models"
+
+// MinPersonFaces is the number of similar faces from which a new person is created,
+// so a single stray detection does not become a person of its own
+const MinPersonFaces = 2
+
+// Plan groups the faces of a user that belong to no person
+type Plan struct {
+	Assign map[models.PersonID][]models.FaceID // Faces that join an existing person
+	Create [][]models.FaceID                   // Groups of faces that each form a new person
+}
+
+// cluster is a person, existing or to be created, and the faces planned to join it
+type cluster struct {
+	personID models.PersonID // Empty for new people
+	centroid []float32
+	sum      []float64 // Of the embeddings of new people, to update their centroid
+	faces    []models.FaceID
+}
+
+// Cluster plans how to group faces that belong to no person, with people of the same
+// model. Each face, in the order given, joins the most similar person or group of
+// faces if their similarity reaches threshold, and starts a group of its own
+// otherwise. Groups of at least MinPersonFaces faces become new people, the other
+// faces stay unassigned until similar faces are found.
+func Cluster(people []models.Person, faces []models.Face, threshold float64) Plan {
+	clusters := make([]*cluster, 0, len(people)+len(faces))
+	for _, p := range people {
+		clusters = append(clusters, &cluster{personID: p.ID, centroid: p.Centroid})
+	}
+
+	for _, f := range faces {
+		var best *cluster
+		bestSimilarity := threshold
+		for _, c := range clusters {
+			if s := Similarity(f.Embedding, c.centroid); s >= bestSimilarity {
+				best, bestSimilarity = c, s
+			}
+		}
+
+		if best == nil {
+			best = &cluster{sum: make([]float64, len(f.Embedding))}
+			clusters = append(clusters, best)
+		}
+		best.faces = append(best.faces, f.ID)
+
+		// Existing people keep their centroid, it changes little with a few faces
+		if best.personID == "" {
+			best.add(f.Embedding)
+		}
+	}
+
+	plan := Plan{Assign: map[models.PersonID][]models.FaceID{}}
+	for _, c := range clusters {
+		switch {
+		case c.personID != "" && len(c.faces) > 0:
+			plan.Assign[c.personID] = c.faces
+		case c.personID == "" && len(c.faces) >= MinPersonFaces:
+			plan.Create = append(plan.Create, c.faces)
+		}
+	}
+	return plan
+}
+
+// add updates the centroid of a new person with the embedding of one more face
+func (c *cluster) add(embedding []float32) {
+	if len(embedding) != len(c.sum) {
+		return
+	}
+
+	c.centroid = make([]float32, len(c.sum))
+	for i, x := range embedding {
+		c.sum[i] += float64(x)
+		c.centroid[i] = float32(c.sum[i] / float64(len(c.faces)))
+	}
+}
@@ -0,0 +1,71 @@
+package faces
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"testing"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+func solid(c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+// detect returns the face the fake detector finds in an image of a colour
+func detect(t *testing.T, id models.FaceID, c color.Color) models.Face {
+	t.Helper()
+
+	detections, err := FakeDetector{}.Detect(context.Background(), solid(c))
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if len(detections) != 1 {
+		t.Fatalf("Detect found %d faces, want 1", len(detections))
+	}
+	return models.Face{ID: id, Embedding: detections[0].Embedding}
+}
+
+func TestFakeDetectorSkipsDarkImages(t *testing.T) {
+	detections, err := FakeDetector{}.Detect(context.Background(), solid(color.Black))
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if len(detections) != 0 {
+		t.Errorf("Detect found %d faces in a black image, want 0", len(detections))
+	}
+}
+
+func TestCluster(t *testing.T) {
+	red, blue, green := color.RGBA{220, 30, 30, 255}, color.RGBA{30, 30, 220, 255}, color.RGBA{30, 220, 30, 255}
+	darkRed := color.RGBA{200, 40, 35, 255}
+
+	people := []models.Person{{ID: "reds", Centroid: detect(t, "", red).Embedding}}
+	faces := []models.Face{
+		detect(t, "red-1", darkRed),
+		detect(t, "blue-1", blue),
+		detect(t, "green-1", green),
+		detect(t, "red-2", red),
+		detect(t, "blue-2", blue),
+	}
+
+	plan := Cluster(people, faces, FakeDetector{}.Threshold())
+
+	if got := plan.Assign["reds"]; !slices.Equal(got, []models.FaceID{"red-1", "red-2"}) {
+		t.Errorf("faces assigned to reds = %v, want [red-1 red-2]", got)
+	}
+	if len(plan.Create) != 1 || !slices.Equal(plan.Create[0], []models.FaceID{"blue-1", "blue-2"}) {
+		t.Errorf("people created = %v, want [[blue-1 blue-2]]", plan.Create)
+	}
+	// A single green face is not enough for a person
+	for _, group := range plan.Create {
+		if slices.Contains(group, "green-1") {
+			t.Errorf("green-1 formed a person on its own")
+		}
+	}
+}
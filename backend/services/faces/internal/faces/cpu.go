@@ -0,0 +1,203 @@
+package faces
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	pigo "github.com/esimov/pigo/core"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// facefinder is the frontal face cascade of pigo (github.com/esimov/pigo, MIT License,
+// Copyright (c) 2018 Endre Simo, see facefinder.LICENSE)
+//
+//go:embed facefinder
+var facefinder []byte
+
+const (
+	// cpuModel changes whenever detections or embeddings of CPUDetector change, so
+	// faces found before are not compared with those found after
+	cpuModel = "pigo-lbp-v2"
+
+	minFaceSize = 20   // Smallest face, in pixels, looked for
+	minScore    = 5.0  // Cascade score from which a detection is taken to be a face
+	clusterIoU  = 0.2  // Overlap from which detections are merged into one face
+	cpuSameFace = 0.65 // See Threshold, between the similarities of different and of the same faces
+
+	// Faces are described by uniform local binary pattern histograms over a grid of
+	// cells of a fixed size crop of the face
+	embedCrop  = 64
+	embedGrid  = 4
+	embedBins  = 59
+	embedWidth = embedGrid * embedGrid * embedBins
+)
+
+// uniformBins maps each 8 bit local binary pattern to its histogram bin. The 58
+// patterns with at most two bit transitions get a bin each, the others share the last.
+var uniformBins = func() [256]uint8 {
+	var bins [256]uint8
+	next := uint8(0)
+	for code := range 256 {
+		rotated := code>>1 | (code&1)<<7
+		if transitions := bitCount(code ^ rotated); transitions <= 2 {
+			bins[code] = next
+			next++
+		} else {
+			bins[code] = embedBins - 1
+		}
+	}
+	return bins
+}()
+
+func bitCount(v int) int {
+	n := 0
+	for ; v != 0; v &= v - 1 {
+		n++
+	}
+	return n
+}
+
+// CPUDetector finds frontal faces with the pigo cascade and describes them by their
+// texture. It needs no model files or GPU, at the cost of telling people apart less
+// reliably than a neural network would.
+type CPUDetector struct {
+	classifier *pigo.Pigo
+}
+
+// NewCPUDetector unpacks the embedded cascade
+func NewCPUDetector() (*CPUDetector, error) {
+	classifier, err := pigo.NewPigo().Unpack(facefinder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack face cascade: %w", err)
+	}
+	return &CPUDetector{classifier: classifier}, nil
+}
+
+// Model implements FaceDetector
+func (d *CPUDetector) Model() string {
+	return cpuModel
+}
+
+// Threshold implements FaceDetector
+func (d *CPUDetector) Threshold() float64 {
+	return cpuSameFace
+}
+
+// Detect implements FaceDetector. It runs at the size of img, so callers should
+// scale large images down first.
+func (d *CPUDetector) Detect(ctx context.Context, img image.Image) ([]Detection, error) {
+	pixels, cols, rows := grayscale(img)
+	if min(cols, rows) < minFaceSize {
+		return nil, nil
+	}
+
+	params := pigo.CascadeParams{
+		MinSize:     minFaceSize,
+		MaxSize:     min(cols, rows),
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{Pixels: pixels, Rows: rows, Cols: cols, Dim: cols},
+	}
+	found := d.classifier.ClusterDetections(d.classifier.RunCascade(params, 0), clusterIoU)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	detections := []Detection{}
+	for _, f := range found {
+		if f.Q < minScore {
+			continue
+		}
+
+		half := f.Scale / 2
+		x0, y0 := max(0, f.Col-half), max(0, f.Row-half)
+		x1, y1 := min(cols, f.Col+half), min(rows, f.Row+half)
+		if x1-x0 < minFaceSize/2 || y1-y0 < minFaceSize/2 {
+			continue
+		}
+
+		detections = append(detections, Detection{
+			Box: models.CropBox{
+				X:      float64(x0) / float64(cols),
+				Y:      float64(y0) / float64(rows),
+				Width:  float64(x1-x0) / float64(cols),
+				Height: float64(y1-y0) / float64(rows),
+			},
+			Score:     float64(f.Q),
+			Embedding: embed(pixels, cols, image.Rect(x0, y0, x1, y1)),
+		})
+	}
+	return detections, nil
+}
+
+// grayscale returns the luma of img row by row, with its width and height
+func grayscale(img image.Image) ([]uint8, int, int) {
+	bounds := img.Bounds()
+	cols, rows := bounds.Dx(), bounds.Dy()
+	pixels := make([]uint8, cols*rows)
+
+	for y := range rows {
+		for x := range cols {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			pixels[y*cols+x] = gray.Y
+		}
+	}
+	return pixels, cols, rows
+}
+
+// embed describes the face in rect of a grayscale image by local binary pattern
+// histograms, which depend on its texture rather than on the lighting
+func embed(pixels []uint8, cols int, rect image.Rectangle) []float32 {
+	// Resample the face to a fixed size, so faces of any size compare
+	var crop [embedCrop * embedCrop]uint8
+	for y := range embedCrop {
+		sy := rect.Min.Y + y*rect.Dy()/embedCrop
+		for x := range embedCrop {
+			sx := rect.Min.X + x*rect.Dx()/embedCrop
+			crop[y*embedCrop+x] = pixels[sy*cols+sx]
+		}
+	}
+
+	// Neighbours in clockwise order, starting top left
+	neighbours := [8][2]int{{-1, -1}, {0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}}
+	cell := embedCrop / embedGrid
+	embedding := make([]float32, embedWidth)
+
+	for y := 1; y < embedCrop-1; y++ {
+		for x := 1; x < embedCrop-1; x++ {
+			centre := crop[y*embedCrop+x]
+			code := 0
+			for bit, n := range neighbours {
+				if crop[(y+n[1])*embedCrop+x+n[0]] >= centre {
+					code |= 1 << bit
+				}
+			}
+
+			grid := (y/cell)*embedGrid + x/cell
+			embedding[grid*embedBins+int(uniformBins[code])]++
+		}
+	}
+
+	// The square root evens out the weight of common and rare patterns
+	for i, count := range embedding {
+		embedding[i] = float32(math.Sqrt(float64(count)))
+	}
+
+	// Every face has mostly the same patterns, histograms alone point nearly the same
+	// way. Each cell is described by how it differs from the face's average cell instead.
+	for bin := range embedBins {
+		var mean float32
+		for grid := range embedGrid * embedGrid {
+			mean += embedding[grid*embedBins+bin]
+		}
+		mean /= embedGrid * embedGrid
+		for grid := range embedGrid * embedGrid {
+			embedding[grid*embedBins+bin] -= mean
+		}
+	}
+	return normalize(embedding)
+}
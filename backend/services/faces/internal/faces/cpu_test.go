@@ -0,0 +1,156 @@
+package faces
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// face is a drawn face: a textured oval with eyes, brows, a nose and a mouth, whose
+// positions and sizes vary from person to person
+type face struct {
+	eyeY, eyeSpacing, eyeSize, browGap float64
+	noseLength, mouthY, mouthWidth     float64
+	skin                               float64
+	texture                            [17][17]float64 // Skin texture, interpolated over the face
+}
+
+func randomFace(rng *rand.Rand) face {
+	f := face{
+		eyeY:       0.35 + rng.Float64()*0.1,
+		eyeSpacing: 0.15 + rng.Float64()*0.1,
+		eyeSize:    0.04 + rng.Float64()*0.04,
+		browGap:    0.05 + rng.Float64()*0.05,
+		noseLength: 0.1 + rng.Float64()*0.1,
+		mouthY:     0.7 + rng.Float64()*0.1,
+		mouthWidth: 0.15 + rng.Float64()*0.15,
+		skin:       120 + rng.Float64()*80,
+	}
+	for y := range f.texture {
+		for x := range f.texture[y] {
+			f.texture[y][x] = rng.Float64()*30 - 15
+		}
+	}
+	return f
+}
+
+// draw renders the face in a size x size image, moved by dx and dy and scaled, as
+// fractions of the image, under light of the given gain
+func (f face) draw(size int, dx, dy, scale, gain float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			u := (float64(x)/float64(size)-0.5-dx)/scale + 0.5
+			v := (float64(y)/float64(size)-0.5-dy)/scale + 0.5
+			img.SetGray(x, y, color.Gray{Y: uint8(max(0, min(255, f.shade(u, v)*gain)))})
+		}
+	}
+	return img
+}
+
+// shade returns the brightness of the face at u, v
+func (f face) shade(u, v float64) float64 {
+	if (u-0.5)*(u-0.5)/0.16+(v-0.5)*(v-0.5)/0.22 >= 1 {
+		return 60 // Background
+	}
+
+	tu, tv := max(0, min(15.99, u*16)), max(0, min(15.99, v*16))
+	x, y := int(tu), int(tv)
+	a, b := tu-float64(x), tv-float64(y)
+	brightness := f.skin + f.texture[y][x]*(1-a)*(1-b) + f.texture[y][x+1]*a*(1-b) + f.texture[y+1][x]*(1-a)*b + f.texture[y+1][x+1]*a*b
+
+	for _, eyeX := range []float64{0.5 - f.eyeSpacing, 0.5 + f.eyeSpacing} {
+		if math.Hypot(u-eyeX, v-f.eyeY) < f.eyeSize {
+			return 40
+		}
+		if math.Abs(v-(f.eyeY-f.browGap)) < 0.015 && math.Abs(u-eyeX) < f.eyeSize*1.5 {
+			return 50
+		}
+	}
+	if math.Abs(v-f.mouthY) < 0.02 && math.Abs(u-0.5) < f.mouthWidth {
+		return 70
+	}
+	if math.Abs(u-0.5) < 0.01 && v > 0.45 && v < 0.45+f.noseLength {
+		brightness -= 30
+	}
+	return brightness
+}
+
+func embedFace(img *image.Gray) []float32 {
+	return embed(img.Pix, img.Stride, img.Bounds())
+}
+
+func TestEmbedSameFace(t *testing.T) {
+	person := randomFace(rand.New(rand.NewSource(1)))
+	reference := embedFace(person.draw(96, 0, 0, 1, 1))
+
+	tests := []struct {
+		name string
+		img  *image.Gray
+	}{
+		{name: "darker", img: person.draw(96, 0, 0, 1, 0.7)},
+		{name: "brighter", img: person.draw(96, 0, 0, 1, 1.3)},
+		{name: "smaller", img: person.draw(60, 0, 0, 1, 1)},
+		{name: "larger", img: person.draw(150, 0, 0, 1, 1)},
+		{name: "off centre", img: person.draw(96, 0.03, -0.03, 1, 1)},
+		{name: "closer", img: person.draw(96, 0, 0, 1.07, 1)},
+		{name: "all at once", img: person.draw(120, -0.02, 0.03, 0.95, 0.8)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s := Similarity(reference, embedFace(tt.img)); s < cpuSameFace {
+				t.Errorf("similarity %.3f, want at least %.2f", s, cpuSameFace)
+			}
+		})
+	}
+}
+
+func TestEmbedDifferentFaces(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	embeddings := make([][]float32, 12)
+	for i := range embeddings {
+		embeddings[i] = embedFace(randomFace(rng).draw(96, 0, 0, 1, 1))
+	}
+
+	var total float64
+	pairs := 0
+	for i := range embeddings {
+		for j := range i {
+			s := Similarity(embeddings[i], embeddings[j])
+			if s >= cpuSameFace {
+				t.Errorf("faces %d and %d have similarity %.3f, want below %.2f", j, i, s, cpuSameFace)
+			}
+			total += s
+			pairs++
+		}
+	}
+
+	// Faces share their layout, but not so much that they look alike
+	if mean := total / float64(pairs); mean > cpuSameFace-0.1 {
+		t.Errorf("mean similarity of different faces %.3f, want below %.2f", mean, cpuSameFace-0.1)
+	}
+}
+
+func TestCPUDetectorFindsNoFaceInPlainImages(t *testing.T) {
+	d, err := NewCPUDetector()
+	if err != nil {
+		t.Fatalf("NewCPUDetector: %v", err)
+	}
+
+	for _, size := range []int{8, 200} {
+		plain := image.NewGray(image.Rect(0, 0, size, size))
+		draw.Draw(plain, plain.Bounds(), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+		detections, err := d.Detect(context.Background(), plain)
+		if err != nil {
+			t.Fatalf("Detect: %v", err)
+		}
+		if len(detections) != 0 {
+			t.Errorf("Detect found %d faces in a plain %dx%d image, want 0", len(detections), size, size)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package faces
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Detection is a face found in an image
+type Detection struct {
+	Box       models.CropBox // Fraction of the image given to Detect
+	Score     float64        // Detector confidence, only comparable between detections of the same model
+	Embedding []float32      // Unit vector, faces of the same person point roughly the same way
+}
+
+// FaceDetector finds the faces in an image and computes an embedding for each, so
+// faces of the same person can be grouped. Implementations must be safe for
+// concurrent use.
+type FaceDetector interface {
+	// Detect returns the faces in an upright image, none if it has no faces
+	Detect(ctx context.Context, img image.Image) ([]Detection, error)
+	// Model identifies the detector and the version of its embeddings. Embeddings of
+	// different models are never compared.
+	Model() string
+	// Threshold is the cosine similarity from which two embeddings are taken to be
+	// the same person
+	Threshold() float64
+}
+
+// Similarity returns the cosine similarity of two embeddings, 0 if their lengths differ
+func Similarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// normalize scales v in place to unit length
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return v
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+	return v
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	// Import CORS middleware
 
@@ -11,6 +13,7 @@ import (
 	"github.com/shivamkedia17/roshnii/services/server/internal/routes"
 	"github.com/shivamkedia17/roshnii/shared/pkg/config"
 	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
 	"github.com/shivamkedia17/roshnii/shared/pkg/jwt"
 	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
 )
@@ -31,7 +34,7 @@ func main() {
 	defer db.Close()
 
 	// 3. Initialize Blob Storage
-	storageService, err := storage.InitStorage(cfg)
+	storageService, err := storage.InitStorage(cfg, db)
 	if err != nil {
 		log.Fatalf("Failed to initialise Blob Store: %v", err)
 	}
@@ -43,6 +46,27 @@ func main() {
 	handlers := handlers.InitHandlers(cfg, db, storageService, jwtService)
 	authMiddleware := middleware.AuthMiddleware(jwtService)
 
+	// Periodically discard resumable uploads that were abandoned, and jobs done long ago
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			handlers.Upload.CleanupExpiredUploads(context.Background())
+			handlers.Admin.Jobs.Purge(context.Background())
+		}
+	}()
+
+	// Generate renditions, perceptual hashes and placeholders of uploaded and edited images.
+	// Jobs cut short when the server stops are claimed again once their timeout passes.
+	renditionWorker := jobs.NewWorker(handlers.Img.Jobs, jobs.Renditions, cfg.RenditionWorkers)
+	jobs.Handle(renditionWorker, jobs.GenerateRenditions, handlers.Img.Renditions.Process)
+	go renditionWorker.Run(context.Background(), 0)
+
+	// Periodically verify stored files against their checksums
+	if cfg.ScrubInterval > 0 {
+		go handlers.Admin.Scrubber.Schedule(context.Background(), cfg.ScrubInterval)
+	}
+
 	// 6. Setup Routing
 	router := routes.SetupRouter(cfg, &handlers, authMiddleware)
 
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/scrubber"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// AdminHandler manages maintenance operations restricted to ADMIN_EMAILS
+type AdminHandler struct {
+	Config   *config.Config
+	Storage  storage.BlobStorage
+	Scrubber *scrubber.Scrubber
+	Jobs     *jobs.Queue
+}
+
+// NewAdminHandler creates a new AdminHandler instance
+func NewAdminHandler(config *config.Config, blobStorage storage.BlobStorage, scrubber *scrubber.Scrubber, jobQueue *jobs.Queue) *AdminHandler {
+	return &AdminHandler{
+		Config:   config,
+		Storage:  blobStorage,
+		Scrubber: scrubber,
+		Jobs:     jobQueue,
+	}
+}
+
+// HandleGetCacheStats returns the hit/miss statistics of the blob storage read caches
+func (h *AdminHandler) HandleGetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"caches": storage.CacheStatsOf(h.Storage)})
+}
+
+// HandleGetScrubReport returns the report of the last integrity scrub
+func (h *AdminHandler) HandleGetScrubReport(c *gin.Context) {
+	running, report := h.Scrubber.Status()
+	c.JSON(http.StatusOK, gin.H{"running": running, "report": report})
+}
+
+// HandleStartScrub starts an integrity scrub in the background
+func (h *AdminHandler) HandleStartScrub(c *gin.Context) {
+	if running, _ := h.Scrubber.Status(); running {
+		c.JSON(http.StatusConflict, gin.H{"error": "A scrub is already running"})
+		return
+	}
+
+	// The scrub outlives the request, its report is fetched with GET
+	go func() {
+		if _, err := h.Scrubber.Run(context.Background()); err != nil && !errors.Is(err, scrubber.ErrAlreadyRunning) {
+			log.Printf("Scrub failed: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Scrub started"})
+}
+
+// HandleListJobs returns the number of background jobs by queue, kind and state, and
+// the most recently updated jobs in a state, by default the dead ones
+func (h *AdminHandler) HandleListJobs(c *gin.Context) {
+	status := c.DefaultQuery("status", models.JobDead)
+	switch status {
+	case models.JobPending, models.JobRunning, models.JobDone, models.JobDead:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, use pending, running, done or dead"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, use 1 to 500"})
+		return
+	}
+
+	counts, err := h.Jobs.DB.CountJobs(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count jobs"})
+		return
+	}
+	list, err := h.Jobs.DB.ListJobs(c.Request.Context(), status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"counts": counts, "jobs": list})
+}
+
+// HandleRetryJob gives a dead job every attempt again, e.g. once what made it fail is fixed
+func (h *AdminHandler) HandleRetryJob(c *gin.Context) {
+	job, err := h.Jobs.DB.RetryJob(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err.Error() == "job not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Hamming distances between perceptual hashes that count as near-duplicates
+const (
+	defaultDuplicateDistance = 8
+	maxDuplicateDistance     = 10 // Beyond this, photos that merely share a composition match
+)
+
+// DuplicateHandler finds a user's near-duplicate images and resolves them. Images are
+// deleted through the ImageHandler, so their files go with them.
+type DuplicateHandler struct {
+	Config *config.Config
+	DB     db.AlbumStore
+	Images *ImageHandler
+}
+
+// NewDuplicateHandler creates a new DuplicateHandler instance
+func NewDuplicateHandler(config *config.Config, db db.AlbumStore, imageHandler *ImageHandler) *DuplicateHandler {
+	return &DuplicateHandler{
+		Config: config,
+		DB:     db,
+		Images: imageHandler,
+	}
+}
+
+// duplicateGroup is a set of images that look alike
+type duplicateGroup struct {
+	// Largest first, the usual choice to keep
+	Images []models.ImageMetadata `json:"images"`
+}
+
+// ListDuplicates groups the user's images whose perceptual hashes are within ?distance
+// bits of the first image of their group. Images are hashed in the background after
+// upload, so the newest ones may be missing.
+func (h *DuplicateHandler) ListDuplicates(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	distance := defaultDuplicateDistance
+	if raw := c.Query("distance"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 || parsed > maxDuplicateDistance {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid distance, use 0 to " + strconv.Itoa(maxDuplicateDistance)})
+			return
+		}
+		distance = parsed
+	}
+
+	hashes, err := h.Images.DB.ListPerceptualHashes(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing perceptual hashes for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicates"})
+		return
+	}
+
+	values := make([]uint64, len(hashes))
+	for i, hash := range hashes {
+		values[i] = hash.Hash
+	}
+	indexGroups := imaging.GroupSimilar(values, distance)
+
+	groups := []duplicateGroup{}
+	if len(indexGroups) > 0 {
+		images, err := h.Images.DB.ListImagesByUserID(c.Request.Context(), userID, db.OrderByUploaded)
+		if err != nil {
+			log.Printf("Error listing images for user %s: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find duplicates"})
+			return
+		}
+		byID := map[models.ImageID]models.ImageMetadata{}
+		for _, img := range images {
+			byID[img.ID] = img
+		}
+
+		for _, indexes := range indexGroups {
+			var group duplicateGroup
+			for _, i := range indexes {
+				if img, ok := byID[hashes[i].ImageID]; ok {
+					group.Images = append(group.Images, img)
+				}
+			}
+			if len(group.Images) < 2 {
+				continue // Deleted in the meantime
+			}
+			slices.SortStableFunc(group.Images, func(a, b models.ImageMetadata) int {
+				return cmp.Or(cmp.Compare(b.Width*b.Height, a.Width*a.Height), cmp.Compare(b.Size, a.Size))
+			})
+			groups = append(groups, group)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"distance": distance, "groups": groups})
+}
+
+// ResolveDuplicates keeps one image of a group of duplicates and deletes the others.
+// The albums of the deleted images get the kept image in their place.
+func (h *DuplicateHandler) ResolveDuplicates(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	var req struct {
+		Keep   models.ImageID   `json:"keep" binding:"required"`
+		Remove []models.ImageID `json:"remove" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	slices.Sort(req.Remove)
+	req.Remove = slices.Compact(req.Remove)
+	if slices.Contains(req.Remove, req.Keep) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The kept image cannot be removed"})
+		return
+	}
+
+	// Check every image before changing anything
+	ctx := c.Request.Context()
+	if _, err := h.Images.DB.GetImageByID(ctx, userID, req.Keep); err != nil {
+		respondImageLookupError(c, req.Keep, err)
+		return
+	}
+	removed := make([]*models.ImageMetadata, 0, len(req.Remove))
+	for _, imageID := range req.Remove {
+		meta, err := h.Images.DB.GetImageByID(ctx, userID, imageID)
+		if err != nil {
+			respondImageLookupError(c, imageID, err)
+			return
+		}
+		removed = append(removed, meta)
+	}
+
+	deleted := []models.ImageID{}
+	albumsUpdated := 0
+	for _, meta := range removed {
+		// Memberships are moved first, so a failed delete leaves both images in the albums rather than neither
+		moved, err := h.DB.MoveImageAlbums(ctx, userID, meta.ID, req.Keep)
+		if err == nil {
+			albumsUpdated += moved
+			err = h.Images.deleteImage(ctx, meta)
+		}
+		if err != nil {
+			log.Printf("Error resolving duplicate image %s: %v", meta.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to delete image " + meta.ID,
+				"deleted": deleted,
+			})
+			return
+		}
+		deleted = append(deleted, meta.ID)
+	}
+
+	log.Printf("Resolved duplicates of image %s for user %s: deleted %d images, updated %d albums", req.Keep, userID, len(deleted), albumsUpdated)
+	c.JSON(http.StatusOK, gin.H{
+		"kept":           req.Keep,
+		"deleted":        deleted,
+		"albums_updated": albumsUpdated,
+	})
+}
+
+// respondImageLookupError writes the response for a failed GetImageByID
+func respondImageLookupError(c *gin.Context, imageID models.ImageID, err error) {
+	if err.Error() == "image not found" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found: " + imageID})
+		return
+	}
+	log.Printf("Error retrieving image %s: %v", imageID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+}
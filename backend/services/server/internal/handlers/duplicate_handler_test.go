@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// MockAlbumStore is an in-memory AlbumStore holding album memberships
+type MockAlbumStore struct {
+	db.AlbumStore
+
+	mu     sync.Mutex
+	albums map[models.AlbumID][]models.ImageID
+}
+
+func (m *MockAlbumStore) MoveImageAlbums(ctx context.Context, userID models.UserID, fromImageID, toImageID models.ImageID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	moved := 0
+	for albumID, images := range m.albums {
+		i := slices.Index(images, fromImageID)
+		if i < 0 {
+			continue
+		}
+		images = slices.Delete(images, i, i+1)
+		if !slices.Contains(images, toImageID) {
+			images = append(images, toImageID)
+		}
+		m.albums[albumID] = images
+		moved++
+	}
+	return moved, nil
+}
+
+func newTestDuplicateRouter(t *testing.T) (*gin.Engine, *DuplicateHandler, *MockImageStore, *MockAlbumStore) {
+	t.Helper()
+
+	store := NewMockImageStore()
+	images, _ := newTestImageHandler(t, store)
+	albums := &MockAlbumStore{albums: map[models.AlbumID][]models.ImageID{}}
+	h := NewDuplicateHandler(images.Config, albums, images)
+
+	router := newTestRouter()
+	router.GET("/api/images/duplicates", h.ListDuplicates)
+	router.POST("/api/images/duplicates/resolve", h.ResolveDuplicates)
+	return router, h, store, albums
+}
+
+// addHashedImage adds an image of the given pixel size with a perceptual hash
+func addHashedImage(store *MockImageStore, imageID models.ImageID, hash uint64, width, height int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.images[imageID] = &models.ImageMetadata{ID: imageID, UserID: MOCKUSERID, Width: width, Height: height, Size: int64(width * height)}
+	store.hashes = append(store.hashes, models.PerceptualHash{ImageID: imageID, Hash: hash})
+}
+
+func TestListDuplicates(t *testing.T) {
+	const photo = uint64(0x0123456789ABCDEF)
+
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantDistance int
+		wantGroups   [][]models.ImageID
+	}{
+		{
+			name:         "default distance",
+			wantStatus:   http.StatusOK,
+			wantDistance: defaultDuplicateDistance,
+			wantGroups:   [][]models.ImageID{{"large", "original", "small"}},
+		},
+		{
+			name:         "exact matches only",
+			query:        "?distance=0",
+			wantStatus:   http.StatusOK,
+			wantDistance: 0,
+			wantGroups:   [][]models.ImageID{{"original", "small"}},
+		},
+		{
+			name:         "largest distance",
+			query:        "?distance=10",
+			wantStatus:   http.StatusOK,
+			wantDistance: 10,
+			wantGroups:   [][]models.ImageID{{"large", "original", "cropped", "small"}},
+		},
+		{name: "distance too large", query: "?distance=11", wantStatus: http.StatusBadRequest},
+		{name: "negative distance", query: "?distance=-1", wantStatus: http.StatusBadRequest},
+		{name: "distance not a number", query: "?distance=near", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _, store, _ := newTestDuplicateRouter(t)
+			addHashedImage(store, "original", photo, 400, 300)
+			addHashedImage(store, "small", photo, 40, 30)
+			addHashedImage(store, "large", photo^0x0F, 800, 600)    // 4 bits off
+			addHashedImage(store, "cropped", photo^0x3FF, 300, 300) // 10 bits off
+			addHashedImage(store, "unrelated", ^photo, 400, 300)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/images/duplicates"+tt.query, nil))
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Distance int              `json:"distance"`
+				Groups   []duplicateGroup `json:"groups"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantDistance, resp.Distance)
+
+			var groups [][]models.ImageID
+			for _, group := range resp.Groups {
+				var ids []models.ImageID
+				for _, img := range group.Images {
+					ids = append(ids, img.ID)
+				}
+				groups = append(groups, ids)
+			}
+			assert.Equal(t, tt.wantGroups, groups, "largest image first")
+		})
+	}
+}
+
+func TestResolveDuplicates(t *testing.T) {
+	content := pngImage(t, 4, 4, color.RGBA{R: 90, A: 255})
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantDeleted []models.ImageID
+	}{
+		{name: "removes the others", body: `{"keep": "keep", "remove": ["copy-1", "copy-2", "copy-1"]}`, wantStatus: http.StatusOK, wantDeleted: []models.ImageID{"copy-1", "copy-2"}},
+		{name: "kept image removed", body: `{"keep": "keep", "remove": ["keep"]}`, wantStatus: http.StatusBadRequest},
+		{name: "nothing to remove", body: `{"keep": "keep", "remove": []}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown kept image", body: `{"keep": "missing", "remove": ["copy-1"]}`, wantStatus: http.StatusNotFound},
+		{name: "unknown removed image", body: `{"keep": "keep", "remove": ["copy-1", "missing"]}`, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, h, store, albums := newTestDuplicateRouter(t)
+			for _, imageID := range []models.ImageID{"keep", "copy-1", "copy-2"} {
+				addImage(t, h.Images, store, imageID, content, "image/png")
+			}
+			albums.albums["holiday"] = []models.ImageID{"copy-1"}
+			albums.albums["favourites"] = []models.ImageID{"keep", "copy-2"}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/images/duplicates/resolve", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				assert.Len(t, store.images, 3, "nothing may be deleted when a request is refused")
+				assert.Equal(t, []models.ImageID{"copy-1"}, albums.albums["holiday"])
+				return
+			}
+
+			var resp struct {
+				Deleted       []models.ImageID `json:"deleted"`
+				AlbumsUpdated int              `json:"albums_updated"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantDeleted, resp.Deleted)
+			assert.Equal(t, 2, resp.AlbumsUpdated)
+			for _, imageID := range tt.wantDeleted {
+				assert.NotContains(t, store.images, imageID)
+				assert.False(t, fileExists(t, h.Images, "user_"+MOCKUSERID+"/"+imageID))
+			}
+			assert.Equal(t, []models.ImageID{"keep"}, albums.albums["holiday"])
+			assert.Equal(t, []models.ImageID{"keep"}, albums.albums["favourites"])
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Longest edit stack an image can have, every rendition replays all of it
+const maxImageEdits = 50
+
+// EditHandler manages the non-destructive edits of images. The original is never
+// modified; renditions are regenerated from it through the ImageHandler's generator
+// whenever the edit stack changes.
+type EditHandler struct {
+	Config *config.Config
+	Images *ImageHandler
+}
+
+// NewEditHandler creates a new EditHandler instance
+func NewEditHandler(config *config.Config, imageHandler *ImageHandler) *EditHandler {
+	return &EditHandler{
+		Config: config,
+		Images: imageHandler,
+	}
+}
+
+// editedImage is an image together with its edit stack, and its size once edited
+type editedImage struct {
+	*models.ImageMetadata
+	Edits        []models.ImageEdit `json:"edits"`
+	EditedWidth  int                `json:"edited_width,omitempty"`
+	EditedHeight int                `json:"edited_height,omitempty"`
+}
+
+func newEditedImage(img *models.ImageMetadata) editedImage {
+	edited := editedImage{ImageMetadata: img, Edits: img.Edits}
+	if edited.Edits == nil {
+		edited.Edits = []models.ImageEdit{}
+	}
+	if img.Width > 0 && img.Height > 0 {
+		edited.EditedWidth, edited.EditedHeight = imaging.EditedSize(img.Width, img.Height, img.Edits)
+	}
+	return edited
+}
+
+// ListEdits returns an image with its edit stack, oldest edit first
+func (h *EditHandler) ListEdits(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	meta, err := h.Images.DB.GetImageByID(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newEditedImage(meta))
+}
+
+// ApplyEdit adds an edit, e.g. {"op": "rotate", "angle": 90}, on top of an image's edit stack
+func (h *EditHandler) ApplyEdit(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	var edit models.ImageEdit
+	if err := c.ShouldBindJSON(&edit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if err := imaging.ValidateEdit(edit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only the fields of the operation are kept
+	switch edit.Op {
+	case models.EditRotate:
+		edit = models.ImageEdit{Op: edit.Op, Angle: edit.Angle}
+	case models.EditFlip:
+		edit = models.ImageEdit{Op: edit.Op, Axis: edit.Axis}
+	case models.EditCrop:
+		edit = models.ImageEdit{Op: edit.Op, Crop: edit.Crop}
+	case models.EditExposure:
+		edit = models.ImageEdit{Op: edit.Op, Stops: edit.Stops}
+	}
+
+	imageID := c.Param("id")
+	updated, err := h.Images.DB.AppendImageEdit(c.Request.Context(), userID, imageID, edit, maxImageEdits)
+	if err != nil {
+		switch err.Error() {
+		case "image not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		case "too many edits":
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Images can have at most %d edits, undo or revert some first", maxImageEdits)})
+		default:
+			log.Printf("Error applying edit to image %s: %v", imageID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply edit"})
+		}
+		return
+	}
+
+	h.editsChanged(c, updated)
+	c.JSON(http.StatusOK, newEditedImage(updated))
+}
+
+// UndoEdit removes the most recent edit of an image
+func (h *EditHandler) UndoEdit(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	imageID := c.Param("id")
+	updated, err := h.Images.DB.UndoImageEdit(c.Request.Context(), userID, imageID)
+	if err != nil {
+		switch err.Error() {
+		case "image not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		case "no edits":
+			c.JSON(http.StatusConflict, gin.H{"error": "Image has no edits to undo"})
+		default:
+			log.Printf("Error undoing edit of image %s: %v", imageID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to undo edit"})
+		}
+		return
+	}
+
+	h.editsChanged(c, updated)
+	c.JSON(http.StatusOK, newEditedImage(updated))
+}
+
+// RevertEdits removes every edit of an image, so it is shown as originally uploaded
+func (h *EditHandler) RevertEdits(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	imageID := c.Param("id")
+	meta, err := h.Images.DB.GetImageByID(c.Request.Context(), userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+	if !meta.HasEdits {
+		c.JSON(http.StatusOK, newEditedImage(meta))
+		return
+	}
+
+	updated, err := h.Images.DB.ClearImageEdits(c.Request.Context(), userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		log.Printf("Error reverting edits of image %s: %v", imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert edits"})
+		return
+	}
+
+	h.editsChanged(c, updated)
+	c.JSON(http.StatusOK, newEditedImage(updated))
+}
+
+// editsChanged replaces what was derived from the previous edit stack. Until the new
+// renditions are ready the previous ones are served.
+func (h *EditHandler) editsChanged(c *gin.Context, img *models.ImageMetadata) {
+	h.Images.Renditions.Regenerate(c.Request.Context(), *img)
+	// Cached renders are keyed by their edits, those of other versions would only take space
+	h.Images.Derived.Delete(c.Request.Context(), img.ID)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// updateEdits replaces the edit stack of an image of userID with what change returns
+func (m *MockImageStore) updateEdits(userID models.UserID, imageID models.ImageID, change func(edits []models.ImageEdit) ([]models.ImageEdit, error)) (*models.ImageMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img, ok := m.images[imageID]
+	if !ok || img.UserID != userID {
+		return nil, errors.New("image not found")
+	}
+	edits, err := change(img.Edits)
+	if err != nil {
+		return nil, err
+	}
+	img.Edits = edits
+	img.HasEdits = len(edits) > 0
+	copied := *img
+	return &copied, nil
+}
+
+func (m *MockImageStore) AppendImageEdit(ctx context.Context, userID models.UserID, imageID models.ImageID, edit models.ImageEdit, maxEdits int) (*models.ImageMetadata, error) {
+	return m.updateEdits(userID, imageID, func(edits []models.ImageEdit) ([]models.ImageEdit, error) {
+		if len(edits) >= maxEdits {
+			return nil, errors.New("too many edits")
+		}
+		return append(append([]models.ImageEdit(nil), edits...), edit), nil
+	})
+}
+
+func (m *MockImageStore) UndoImageEdit(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error) {
+	return m.updateEdits(userID, imageID, func(edits []models.ImageEdit) ([]models.ImageEdit, error) {
+		if len(edits) == 0 {
+			return nil, errors.New("no edits")
+		}
+		return edits[:len(edits)-1], nil
+	})
+}
+
+func (m *MockImageStore) ClearImageEdits(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error) {
+	return m.updateEdits(userID, imageID, func(edits []models.ImageEdit) ([]models.ImageEdit, error) {
+		return nil, nil
+	})
+}
+
+func newTestEditRouter(t *testing.T) (*gin.Engine, *MockImageStore) {
+	t.Helper()
+
+	store := NewMockImageStore()
+	images, _ := newTestImageHandler(t, store)
+	h := NewEditHandler(images.Config, images)
+
+	router := newTestRouter()
+	router.GET("/api/images/:id/edits", h.ListEdits)
+	router.POST("/api/images/:id/edits", h.ApplyEdit)
+	router.POST("/api/images/:id/edits/undo", h.UndoEdit)
+	router.DELETE("/api/images/:id/edits", h.RevertEdits)
+	return router, store
+}
+
+// addEditedImage adds a 400x300 image of MOCKUSERID with an edit stack
+func addEditedImage(store *MockImageStore, imageID models.ImageID, edits []models.ImageEdit) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.images[imageID] = &models.ImageMetadata{
+		ID:          imageID,
+		UserID:      MOCKUSERID,
+		StoragePath: "user_" + MOCKUSERID + "/" + imageID,
+		Width:       400,
+		Height:      300,
+		Edits:       edits,
+		HasEdits:    len(edits) > 0,
+		Version:     1,
+	}
+}
+
+func TestEditStack(t *testing.T) {
+	rotate := models.ImageEdit{Op: models.EditRotate, Angle: 90}
+	halfCrop := models.ImageEdit{Op: models.EditCrop, Crop: &models.CropBox{X: 0, Y: 0, Width: 0.5, Height: 1}}
+	full := make([]models.ImageEdit, maxImageEdits)
+	for i := range full {
+		full[i] = rotate
+	}
+
+	tests := []struct {
+		name       string
+		edits      []models.ImageEdit // Stack of the image before the request
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantEdits  []models.ImageEdit
+		wantSize   [2]int
+	}{
+		{name: "list", edits: []models.ImageEdit{rotate}, method: http.MethodGet, path: "/edits", wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{rotate}, wantSize: [2]int{300, 400}},
+		{name: "list without edits", method: http.MethodGet, path: "/edits", wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{}, wantSize: [2]int{400, 300}},
+		{name: "apply", edits: []models.ImageEdit{rotate}, method: http.MethodPost, path: "/edits", body: `{"op": "crop", "crop": {"x": 0, "y": 0, "width": 0.5, "height": 1}}`, wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{rotate, halfCrop}, wantSize: [2]int{150, 400}},
+		{name: "apply keeps only the fields of the op", method: http.MethodPost, path: "/edits", body: `{"op": "rotate", "angle": 90, "axis": "vertical", "stops": 1}`, wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{rotate}, wantSize: [2]int{300, 400}},
+		{name: "apply invalid edit", method: http.MethodPost, path: "/edits", body: `{"op": "rotate", "angle": 45}`, wantStatus: http.StatusBadRequest, wantEdits: nil},
+		{name: "apply not json", method: http.MethodPost, path: "/edits", body: `rotate`, wantStatus: http.StatusBadRequest, wantEdits: nil},
+		{name: "apply to a full stack", edits: full, method: http.MethodPost, path: "/edits", body: `{"op": "rotate", "angle": 90}`, wantStatus: http.StatusConflict, wantEdits: full},
+		{name: "undo", edits: []models.ImageEdit{rotate, halfCrop}, method: http.MethodPost, path: "/edits/undo", wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{rotate}, wantSize: [2]int{300, 400}},
+		{name: "undo without edits", method: http.MethodPost, path: "/edits/undo", wantStatus: http.StatusConflict, wantEdits: nil},
+		{name: "revert", edits: []models.ImageEdit{rotate, halfCrop}, method: http.MethodDelete, path: "/edits", wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{}, wantSize: [2]int{400, 300}},
+		{name: "revert without edits", method: http.MethodDelete, path: "/edits", wantStatus: http.StatusOK, wantEdits: []models.ImageEdit{}, wantSize: [2]int{400, 300}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, store := newTestEditRouter(t)
+			addEditedImage(store, "img-1", tt.edits)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/api/images/img-1"+tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, tt.edits, store.images["img-1"].Edits, "a refused edit must not change the stack")
+				return
+			}
+
+			var resp struct {
+				Edits        []models.ImageEdit `json:"edits"`
+				EditedWidth  int                `json:"edited_width"`
+				EditedHeight int                `json:"edited_height"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantEdits, resp.Edits)
+			assert.Equal(t, tt.wantSize, [2]int{resp.EditedWidth, resp.EditedHeight})
+			assert.Len(t, store.images["img-1"].Edits, len(tt.wantEdits))
+		})
+	}
+
+	t.Run("unknown image", func(t *testing.T) {
+		router, _ := newTestEditRouter(t)
+
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/api/images/missing/edits", nil),
+			httptest.NewRequest(http.MethodPost, "/api/images/missing/edits", bytes.NewBufferString(`{"op": "rotate", "angle": 90}`)),
+			httptest.NewRequest(http.MethodPost, "/api/images/missing/edits/undo", nil),
+			httptest.NewRequest(http.MethodDelete, "/api/images/missing/edits", nil),
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNotFound, w.Code, "%s %s", req.Method, req.URL)
+		}
+	})
+}
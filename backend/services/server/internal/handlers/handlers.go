@@ -3,29 +3,53 @@ package handlers
 import (
 	"github.com/shivamkedia17/roshnii/shared/pkg/config"
 	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/derived"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
 	"github.com/shivamkedia17/roshnii/shared/pkg/jwt"
+	"github.com/shivamkedia17/roshnii/shared/pkg/renditions"
+	"github.com/shivamkedia17/roshnii/shared/pkg/scrubber"
 	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
 )
 
 type Handlers struct {
-	OAuth GoogleOAuthService
-	Img   ImageHandler
-	Album AlbumHandler
-	User  UserHandler
+	OAuth  GoogleOAuthService
+	Img    ImageHandler
+	Upload UploadHandler
+	Dup    DuplicateHandler
+	Edit   EditHandler
+	Ver    VersionHandler
+	Album  AlbumHandler
+	People PeopleHandler
+	User   UserHandler
+	Admin  AdminHandler
 	// TODO Search
 }
 
 func InitHandlers(config *config.Config, db db.Store, storage storage.BlobStorage, jwt jwt.JWTService) Handlers {
 	googleOAuthService := NewGoogleOAuthService(config, db, jwt)
-	imageHandler := NewImageHandler(config, db, storage)
+	jobQueue := jobs.NewQueue(db)
+	imageHandler := NewImageHandler(config, db, storage,
+		renditions.NewGenerator(db, storage, jobQueue), derived.NewCache(storage, config.RenditionWorkers), jobQueue)
+	uploadHandler := NewUploadHandler(config, db, imageHandler)
+	duplicateHandler := NewDuplicateHandler(config, db, imageHandler)
+	editHandler := NewEditHandler(config, imageHandler)
+	versionHandler := NewVersionHandler(config, imageHandler)
 	albumHandler := NewAlbumHandler(config, db)
+	peopleHandler := NewPeopleHandler(config, db, imageHandler)
 	userHandler := NewUserHandler(config, db)
+	adminHandler := NewAdminHandler(config, storage, scrubber.NewScrubber(db, storage), jobQueue)
 	// TODO search
 
 	return Handlers{
-		OAuth: *googleOAuthService,
-		Img:   *imageHandler,
-		Album: *albumHandler,
-		User:  *userHandler,
+		OAuth:  *googleOAuthService,
+		Img:    *imageHandler,
+		Upload: *uploadHandler,
+		Dup:    *duplicateHandler,
+		Edit:   *editHandler,
+		Ver:    *versionHandler,
+		Album:  *albumHandler,
+		People: *peopleHandler,
+		User:   *userHandler,
+		Admin:  *adminHandler,
 	}
 }
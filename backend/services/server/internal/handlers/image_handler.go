@@ -1,35 +1,66 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid" // For generating unique image IDs/paths
 
-	"io"
-
 	"github.com/shivamkedia17/roshnii/services/server/internal/middleware" // Adjust import paths
 	"github.com/shivamkedia17/roshnii/shared/pkg/config"
 	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/derived"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
 	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/renditions"
 	"github.com/shivamkedia17/roshnii/shared/pkg/storage" // Add this import
 )
 
+// Upper bound for the lifetime of generated image URLs
+const maxSignedURLExpiry = 7 * 24 * time.Hour
+
+// JPEG quality of downloads re-encoded to turn them upright, high as they replace the original
+const bakedJPEGQuality = 92
+
+// Largest file accepted by multipart uploads
+const maxUploadSize = 20 * 1024 * 1024
+
+// declaredTypeAllowed reports whether a client-declared type may be uploaded. Generic
+// types are accepted, the real type is sniffed from the content in storeImage.
+func declaredTypeAllowed(contentType string) bool {
+	return contentType == "" || contentType == "application/octet-stream" || imaging.IsSupported(contentType)
+}
+
 // Requires Access to Blob Storage
 type ImageHandler struct {
-	Config  *config.Config
-	DB      db.ImageStore
-	Storage storage.BlobStorage
+	Config     *config.Config
+	DB         db.ImageStore
+	Storage    storage.BlobStorage
+	Signer     *storage.URLSigner
+	Renditions *renditions.Generator
+	Derived    *derived.Cache
+	Jobs       *jobs.Queue // Work for the other services, e.g. face detection
 }
 
-func NewImageHandler(config *config.Config, db db.ImageStore, storage storage.BlobStorage) *ImageHandler {
+func NewImageHandler(config *config.Config, db db.ImageStore, blobStorage storage.BlobStorage, generator *renditions.Generator, derivedCache *derived.Cache, jobQueue *jobs.Queue) *ImageHandler {
 	return &ImageHandler{
-		Config:  config,
-		DB:      db,
-		Storage: storage,
+		Config:     config,
+		DB:         db,
+		Storage:    blobStorage,
+		Signer:     storage.NewURLSigner(config),
+		Renditions: generator,
+		Derived:    derivedCache,
+		Jobs:       jobQueue,
 	}
 }
 
@@ -56,24 +87,27 @@ func (h *ImageHandler) HandleUploadImage(c *gin.Context) {
 	}
 
 	// Limit file size (e.g., 20MB)
-	const maxUploadSize = 20 * 1024 * 1024
 	if fileHeader.Size > maxUploadSize {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File size exceeds limit of %d MB", maxUploadSize/1024/1024)})
 		return
 	}
 
 	contentType := fileHeader.Header.Get("Content-Type")
-	allowedTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/gif":  true,
-		"image/webp": true,
-	}
-	if !allowedTypes[contentType] {
+	if !declaredTypeAllowed(contentType) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported file type: %s", contentType)})
 		return
 	}
 
+	// Re-uploading an image the user already has returns it instead, unless a copy is asked for
+	allowDuplicate := false
+	if raw := c.Query("allow_duplicate"); raw != "" {
+		allowDuplicate, err = strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allow_duplicate, use true or false"})
+			return
+		}
+	}
+
 	// Open the file
 	file, err := fileHeader.Open()
 	if err != nil {
@@ -83,46 +117,207 @@ func (h *ImageHandler) HandleUploadImage(c *gin.Context) {
 	}
 	defer file.Close()
 
-	// Generate a unique ID for the image
-	imageID := uuid.New().String()
-
-	// Upload the file to storage
-	storagePath, err := h.Storage.Upload(c.Request.Context(), fileHeader.Filename, userID, file, contentType)
+	metadata, duplicate, err := h.storeImage(c.Request.Context(), userID, fileHeader.Filename, contentType, file, fileHeader.Size, allowDuplicate)
 	if err != nil {
-		log.Printf("Error uploading file to storage: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store uploaded file"})
+		respondUploadError(c, err)
+		return
+	}
+
+	if duplicate {
+		c.JSON(http.StatusOK, duplicateUpload{ImageMetadata: metadata, Duplicate: true})
+		return
+	}
+	c.JSON(http.StatusCreated, metadata)
+}
+
+// duplicateUpload is the response to an upload of content the user already has: the
+// existing image, marked as a duplicate
+type duplicateUpload struct {
+	*models.ImageMetadata
+	Duplicate bool `json:"duplicate"`
+}
+
+// uploadError is a failed upload together with the status and message reported to the client
+type uploadError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *uploadError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.Err
+}
+
+// respondUploadError writes the client-facing response for an error returned by storeImage
+func respondUploadError(c *gin.Context, err error) {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		c.JSON(uploadErr.Status, gin.H{"error": uploadErr.Message})
 		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process uploaded file"})
+}
 
-	// Create metadata in database
-	metadata := &models.ImageMetadata{
-		ID:          imageID,
-		UserID:      userID,
-		Filename:    fileHeader.Filename,
-		StoragePath: storagePath,
-		ContentType: contentType,
-		Size:        fileHeader.Size,
-		Width:       0, // TODO: Extract dimensions if needed
-		Height:      0,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+// storeImage writes an uploaded image to blob storage and records its metadata.
+// It is shared by the multipart and resumable upload endpoints. Unless allowDuplicate
+// is set, content identical to one of the user's images is not stored again; the
+// existing image is returned instead and duplicate reports it.
+func (h *ImageHandler) storeImage(ctx context.Context, userID models.UserID, filename, contentType string, file io.ReadSeeker, size int64, allowDuplicate bool) (metadata *models.ImageMetadata, duplicate bool, err error) {
+	// Record a checksum, so the scrubber can detect corrupted or truncated files
+	checksum, err := storage.HashContent(file)
+	if err != nil {
+		log.Printf("Error hashing uploaded file: %v", err)
+		return nil, false, &uploadError{Status: http.StatusInternalServerError, Message: "Failed to process uploaded file", Err: err}
 	}
 
-	err = h.DB.CreateImageMetadata(c.Request.Context(), metadata)
+	if !allowDuplicate {
+		existing, err := h.DB.FindImageByChecksum(ctx, userID, checksum)
+		if err == nil {
+			log.Printf("Upload %s from user %s is identical to image %s, not storing it again", filename, userID, existing.ID)
+			return existing, true, nil
+		}
+		if err.Error() != "image not found" {
+			log.Printf("Error looking up duplicates of upload %s: %v", filename, err)
+			return nil, false, &uploadError{Status: http.StatusInternalServerError, Message: "Failed to process uploaded file", Err: err}
+		}
+	}
+
+	if err := h.checkQuota(ctx, userID, size); err != nil {
+		return nil, false, err
+	}
+
+	// Encrypt what is written with the uploader's data key when encryption at rest is enabled
+	ctx = storage.WithKeyOwner(ctx, userID)
+
+	metadata, err = h.writeUpload(ctx, userID, filename, contentType, file, size, checksum)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Generate a unique ID for the image
+	imageID := uuid.New().String()
+	metadata.ID = imageID
+	metadata.Version = 1
+	metadata.CreatedAt = time.Now()
+	metadata.UpdatedAt = time.Now()
+
+	err = h.DB.CreateImageMetadata(ctx, metadata, h.Config.DefaultUserQuotaBytes)
 	if err != nil {
 		// If DB storage fails, try to clean up the file we just uploaded
-		cleanupErr := h.Storage.Delete(c.Request.Context(), storagePath)
-		if cleanupErr != nil {
-			log.Printf("Warning: Failed to clean up file after DB error: %v", cleanupErr)
+		h.discardUpload(ctx, metadata)
+
+		if err.Error() == "storage quota exceeded" {
+			return nil, false, quotaExceededError(err)
 		}
 
 		log.Printf("Error saving image metadata to DB for image %s: %v", imageID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record image information"})
-		return
+		return nil, false, &uploadError{Status: http.StatusInternalServerError, Message: "Failed to record image information", Err: err}
 	}
 
 	log.Printf("Successfully uploaded and saved metadata for image ID: %s", imageID)
-	c.JSON(http.StatusCreated, metadata)
+
+	// Thumbnails and previews are generated in the background, the thumbnail endpoint serves the original until they are ready
+	h.Renditions.Enqueue(ctx, *metadata)
+
+	h.detectFaces(ctx, metadata, "detect-faces:"+imageID)
+
+	return metadata, false, nil
+}
+
+// detectFaces enqueues the detection of the faces of an image by the faces service,
+// once per idempotency key. The image stands without them, a job that could not be
+// enqueued is only logged.
+func (h *ImageHandler) detectFaces(ctx context.Context, img *models.ImageMetadata, key string) {
+	payload := jobs.DetectFacesPayload{ImageID: img.ID, UserID: img.UserID}
+	if _, _, err := jobs.DetectFaces.Enqueue(ctx, h.Jobs, payload, key); err != nil {
+		log.Printf("Warning: Failed to enqueue face detection for image %s: %v", img.ID, err)
+	}
+}
+
+// writeUpload checks that an upload is a supported image, reads its EXIF data and
+// writes it to blob storage. It returns the file fields of the image's metadata, the
+// caller records it. ctx carries the owner's data key.
+func (h *ImageHandler) writeUpload(ctx context.Context, userID models.UserID, filename, contentType string, file io.ReadSeeker, size int64, checksum string) (*models.ImageMetadata, error) {
+	// Only trust what the content actually is, not what the client declared
+	imageInfo, err := imaging.Inspect(file)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrUndecodable) {
+			log.Printf("Rejected upload %s from user %s: %v", filename, userID, err)
+			return nil, &uploadError{Status: http.StatusUnsupportedMediaType, Message: "File is not a supported image (JPEG, PNG, GIF or WebP)", Err: err}
+		}
+		return nil, &uploadError{Status: http.StatusInternalServerError, Message: "Failed to process uploaded file", Err: err}
+	}
+	if imaging.IsSupported(contentType) && contentType != imageInfo.ContentType {
+		return nil, &uploadError{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("File content is %s but was declared as %s", imageInfo.ContentType, contentType),
+		}
+	}
+	contentType = imageInfo.ContentType
+
+	// Capture metadata is best effort, images without readable EXIF are stored all the same
+	exifData, err := imaging.ReadExif(file, contentType)
+	if err != nil {
+		return nil, &uploadError{Status: http.StatusInternalServerError, Message: "Failed to process uploaded file", Err: err}
+	}
+	if exifData == nil {
+		exifData = &models.ImageExif{} // Recorded as checked, so the backfill skips it
+	}
+
+	// Upload the file to storage
+	var storagePath string
+	var blobHash *string
+	if h.Config.BlobContentAddressed {
+		storagePath, blobHash, err = h.storeContentAddressed(ctx, checksum, file, size, contentType)
+	} else {
+		storagePath, err = h.Storage.Upload(ctx, filename, userID, file, contentType)
+	}
+	if err != nil {
+		log.Printf("Error uploading file to storage: %v", err)
+		return nil, &uploadError{Status: http.StatusInternalServerError, Message: "Failed to store uploaded file", Err: err}
+	}
+
+	return &models.ImageMetadata{
+		UserID:         userID,
+		Filename:       filename,
+		StoragePath:    storagePath,
+		StorageBackend: &h.Config.BlobStorageType,
+		BlobHash:       blobHash,
+		Checksum:       &checksum,
+		ContentType:    contentType,
+		Size:           size,
+		Width:          imageInfo.Width,
+		Height:         imageInfo.Height,
+		TakenAt:        exifData.TakenAt,
+		Exif:           exifData,
+	}, nil
+}
+
+// discardUpload removes the file written by writeUpload when it could not be recorded.
+// Content-addressed uploads drop the blob reference they took instead, the blob is
+// only removed when nothing else references it.
+func (h *ImageHandler) discardUpload(ctx context.Context, file *models.ImageMetadata) {
+	if file.BlobHash != nil {
+		orphan, err := h.DB.ReleaseBlob(ctx, *file.BlobHash)
+		if err != nil {
+			log.Printf("Warning: Failed to release blob %s after DB error: %v", *file.BlobHash, err)
+			return
+		}
+		if orphan != nil {
+			h.deleteBlob(ctx, orphan)
+		}
+		return
+	}
+	if err := h.Storage.Delete(ctx, file.StoragePath); err != nil {
+		log.Printf("Warning: Failed to clean up file after DB error: %v", err)
+	}
 }
 
 // Implement HandleDownloadImage to serve the actual file
@@ -147,25 +342,417 @@ func (h *ImageHandler) HandleDownloadImage(c *gin.Context) {
 		return
 	}
 
-	// Get the file from storage
-	file, contentType, err := h.Storage.Download(c.Request.Context(), meta.StoragePath)
+	strip, ok := h.shouldStripMetadata(c, userID)
+	if !ok {
+		return
+	}
+	bake, err := strconv.ParseBool(c.DefaultQuery("bake_orientation", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bake_orientation, use true or false"})
+		return
+	}
+
+	c.Header("Content-Disposition", "inline; filename="+meta.Filename)
+	if err := h.serveFile(c, meta.StoragePath, meta.Filename, meta.ContentType, delivery{StripMetadata: strip, BakeOrientation: bake}); err != nil {
+		log.Printf("Error retrieving file from storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+	}
+}
+
+// HandleGetThumbnail serves a downscaled rendition of an image. Until the requested
+// size is generated the closest available one is served, or the original if there
+// is none yet; the X-Rendition header tells which was served.
+func (h *ImageHandler) HandleGetThumbnail(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	size := c.DefaultQuery("size", renditions.Small)
+	if _, ok := renditions.Lookup(size); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid size %q, use small, medium or large", size)})
+		return
+	}
+
+	// Renditions are re-encoded without metadata, this only applies when the original is served
+	strip, ok := h.shouldStripMetadata(c, userID)
+	if !ok {
+		return
+	}
+
+	imageID := c.Param("id")
+	meta, err := h.DB.GetImageByID(c.Request.Context(), userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	available, err := h.DB.ListRenditions(c.Request.Context(), imageID)
 	if err != nil {
+		log.Printf("Error listing renditions of image %s: %v", imageID, err)
+		available = nil // Serve the original rather than fail
+	}
+
+	rendition := renditions.Pick(available, size)
+	if rendition == nil || rendition.Size != size {
+		// Missing renditions of older images, or of failed generations, are created on demand
+		h.Renditions.Enqueue(c.Request.Context(), *meta)
+	}
+
+	if rendition != nil {
+		c.Header("X-Rendition", rendition.Size)
+		err := h.serveFile(c, rendition.StoragePath, rendition.Size+".jpg", rendition.ContentType, delivery{})
+		if err == nil {
+			return
+		}
+		if !strings.HasPrefix(err.Error(), "file not found") {
+			log.Printf("Error retrieving file from storage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+			return
+		}
+		// Recorded but lost, e.g. left behind in a previous storage backend
+		h.Renditions.Enqueue(c.Request.Context(), *meta)
+	}
+
+	c.Header("X-Rendition", "original")
+	if err := h.serveFile(c, meta.StoragePath, meta.Filename, meta.ContentType, delivery{StripMetadata: strip}); err != nil {
 		log.Printf("Error retrieving file from storage: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+	}
+}
+
+// HandleRenderImage serves an image resized, cropped and converted on demand, e.g.
+// ?w=640&h=480&fit=cover&format=jpeg&q=80. Results are cached in blob storage, and
+// sizes are restricted to RENDER_SIZES so the variants per image stay bounded.
+func (h *ImageHandler) HandleRenderImage(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	params, err := derived.ParseParams(c.Query("w"), c.Query("h"), c.Query("fit"), c.Query("format"), c.Query("q"), h.Config.RenderSizes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imageID := c.Param("id")
+	meta, err := h.DB.GetImageByID(c.Request.Context(), userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	file, info, err := h.Derived.Get(c.Request.Context(), meta, params)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUndecodable) || errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrTooLarge) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Image cannot be rendered"})
+			return
+		}
+		log.Printf("Error rendering image %s: %v", imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render image"})
 		return
 	}
 	defer file.Close()
 
-	// Set the response headers
-	c.Header("Content-Disposition", "inline; filename="+meta.Filename)
+	// Rendered images are re-encoded and carry no metadata, so they are safe to share as they are
+	c.Header("Content-Type", params.ContentType())
+	c.Header("ETag", info.ETag)
+	c.Header("Cache-Control", "private, no-cache")
+	http.ServeContent(c.Writer, c.Request, params.Key(), info.ModTime, file)
+}
+
+// delivery selects how a stored image is transformed on its way to the client
+type delivery struct {
+	StripMetadata   bool // Without embedded metadata, see imaging.StripMetadata
+	BakeOrientation bool // With the pixels turned upright, see imaging.WriteUpright
+}
+
+// serveFile writes a stored file, with support for Range and conditional requests.
+// Nothing is written if the file cannot be opened, the error is returned instead.
+func (h *ImageHandler) serveFile(c *gin.Context, storagePath, name, contentType string, d delivery) error {
+	file, info, err := h.Storage.Open(c.Request.Context(), storagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The type recorded in the database is authoritative, storage backends may only guess it
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if d.StripMetadata || d.BakeOrientation {
+		serveTransformed(c, file, info, contentType, d)
+		return nil
+	}
 	c.Header("Content-Type", contentType)
+	c.Header("ETag", info.ETag)
+	// Clients may keep the file but must revalidate, which is answered with a 304 when unchanged
+	c.Header("Cache-Control", "private, no-cache")
+
+	// ServeContent handles Range requests and If-None-Match / If-Modified-Since preconditions
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime, file)
+	return nil
+}
+
+// shouldStripMetadata decides whether an image is delivered without its embedded metadata:
+// as asked by the strip_metadata query parameter, otherwise as set in the user's settings.
+// It responds with an error and returns false if that cannot be decided.
+func (h *ImageHandler) shouldStripMetadata(c *gin.Context, userID models.UserID) (bool, bool) {
+	if raw := c.Query("strip_metadata"); raw != "" {
+		strip, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid strip_metadata, use true or false"})
+			return false, false
+		}
+		return strip, true
+	}
+
+	settings, err := h.DB.GetUserSettings(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving settings of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user settings"})
+		return false, false
+	}
+	return settings.StripMetadata, true
+}
+
+// serveTransformed writes a file transformed as selected by d. The copy is produced
+// while streaming, so Range requests are not supported; conditional requests are,
+// against an ETag distinct from the original's.
+func serveTransformed(c *gin.Context, file io.ReadSeeker, info *storage.ObjectInfo, contentType string, d delivery) {
+	if !imaging.IsSupported(contentType) {
+		sniffed, err := imaging.SniffReader(file)
+		if err != nil {
+			log.Printf("Error reading file to strip metadata: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+			return
+		}
+		contentType = sniffed
+	}
+	if d.StripMetadata && !imaging.IsSupported(contentType) {
+		// Serving it as it is could leak exactly what was asked to be removed
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Metadata cannot be removed from this file type"})
+		return
+	}
+
+	etag := ""
+	if info.ETag != "" {
+		etag = strings.TrimSuffix(info.ETag, `"`)
+		if d.BakeOrientation {
+			etag += "-upright"
+		}
+		if d.StripMetadata {
+			etag += "-stripped"
+		}
+		etag += `"`
+		c.Header("ETag", etag)
+	}
+	c.Header("Cache-Control", "private, no-cache")
+	if etag != "" && etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-	// Stream the file to the response
+	c.Header("Content-Type", contentType)
 	c.Status(http.StatusOK)
-	if _, err := io.Copy(c.Writer, file); err != nil {
-		log.Printf("Error streaming file to response: %v", err)
-		// Can't really do anything here as we've already started writing the response
+
+	var err error
+	if d.BakeOrientation {
+		err = imaging.WriteUpright(c.Writer, file, contentType, bakedJPEGQuality, d.StripMetadata)
+	} else {
+		err = imaging.StripMetadata(c.Writer, file, contentType)
+	}
+	if err != nil {
+		log.Printf("Error streaming transformed image: %v", err)
+		// Turning an image upright decodes it before writing anything, otherwise the client sees a truncated body
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("ETag")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+		}
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches an ETag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleGenerateURL returns a temporary URL for the image that works without the auth
+// cookie. ?size= selects a rendition instead of the original. The URL names the image,
+// so it serves the current file after the original is replaced or a version restored.
+func (h *ImageHandler) HandleGenerateURL(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	expiry := 15 * time.Minute
+	if raw := c.Query("expiry"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry, use a duration like 30m or 24h"})
+			return
+		}
+		expiry = parsed
+	}
+	if expiry > maxSignedURLExpiry {
+		expiry = maxSignedURLExpiry
+	}
+
+	variant := c.DefaultQuery("size", storage.OriginalVariant)
+	if _, ok := renditions.Lookup(variant); !ok && variant != storage.OriginalVariant {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid size %q, use original, small, medium or large", variant)})
+		return
+	}
+
+	imageID := c.Param("id")
+	_, err := h.DB.GetImageByID(c.Request.Context(), userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	// Renditions are re-encoded without metadata, stripping only applies to the original
+	strip := false
+	if variant == storage.OriginalVariant {
+		var ok bool
+		strip, ok = h.shouldStripMetadata(c, userID)
+		if !ok {
+			return
+		}
+	}
+
+	url, err := h.Signer.SignImage(storage.SignedImage{UserID: userID, ImageID: imageID, Variant: variant, StripMetadata: strip}, expiry)
+	if err != nil {
+		log.Printf("Error generating URL for image %s: %v", imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate image URL"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"expires_at": time.Now().Add(expiry),
+	})
+}
+
+// HandleSignedImage serves an image for a signed image URL, from the file it has now.
+// It is not behind the auth middleware; the signature and expiry in the URL are the
+// authorization.
+func (h *ImageHandler) HandleSignedImage(c *gin.Context) {
+	signed, err := h.Signer.VerifyImage(c.Param("token"), c.Query("expires"), c.Query("strip"), c.Query("sig"))
+	if err != nil {
+		if errors.Is(err, storage.ErrURLExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": "Link has expired"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid link"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	meta, err := h.DB.GetImageByID(ctx, signed.UserID, signed.ImageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	if signed.Variant != storage.OriginalVariant {
+		available, err := h.DB.ListRenditions(ctx, meta.ID)
+		if err != nil {
+			log.Printf("Error listing renditions of image %s: %v", meta.ID, err)
+			available = nil // Serve the original rather than fail
+		}
+		if rendition := renditions.Pick(available, signed.Variant); rendition != nil {
+			err := h.serveFile(c, rendition.StoragePath, rendition.Size+".jpg", rendition.ContentType, delivery{})
+			if err == nil {
+				return
+			}
+			if !strings.HasPrefix(err.Error(), "file not found") {
+				log.Printf("Error retrieving file from storage: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+				return
+			}
+		}
+		// Renditions of new images may not be generated yet
+		h.Renditions.Enqueue(c.Request.Context(), *meta)
+	}
+
+	if err := h.serveFile(c, meta.StoragePath, meta.Filename, meta.ContentType, delivery{StripMetadata: signed.StripMetadata}); err != nil {
+		if strings.HasPrefix(err.Error(), "file not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		log.Printf("Error retrieving file from storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image file"})
+	}
+}
+
+// HandleSignedDownload serves a stored file for a signed file URL, as returned by the
+// GenerateURL of the local and encrypted storage backends. It is not behind the auth
+// middleware; the signature and expiry in the URL are the authorization.
+func (h *ImageHandler) HandleSignedDownload(c *gin.Context) {
+	storagePath, err := h.Signer.Verify(c.Param("token"), c.Query("expires"), c.Query("strip"), c.Query("sig"))
+	if err != nil {
+		if errors.Is(err, storage.ErrURLExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": "Link has expired"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid link"})
+		return
+	}
+
+	file, info, err := h.Storage.Open(c.Request.Context(), storagePath)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "file not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		log.Printf("Error retrieving file from storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file"})
+		return
 	}
+	defer file.Close()
+
+	if c.Query("strip") != "" {
+		serveTransformed(c, file, info, info.ContentType, delivery{StripMetadata: true})
+		return
+	}
+
+	// Without a known type, ServeContent sniffs it from the content
+	if info.ContentType != "application/octet-stream" {
+		c.Header("Content-Type", info.ContentType)
+	}
+	c.Header("ETag", info.ETag)
+	c.Header("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, path.Base(storagePath), info.ModTime, file)
 }
 
 // HandleGetImage retrieves metadata for a single image.
@@ -185,6 +772,14 @@ func (h *ImageHandler) HandleGetImage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image"})
 		return
 	}
+
+	exifData, err := h.DB.GetImageExif(c.Request.Context(), imageID)
+	if err != nil && err.Error() != "exif not found" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image"})
+		return
+	}
+	meta.Exif = exifData
+
 	c.JSON(http.StatusOK, meta)
 }
 
@@ -209,22 +804,99 @@ func (h *ImageHandler) HandleDeleteImage(c *gin.Context) {
 		return
 	}
 
+	if err := h.deleteImage(c.Request.Context(), meta); err != nil {
+		if errors.Is(err, errDeleteImageFile) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image file"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image metadata"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+// Failures of deleteImage, the image is left as it was
+var (
+	errDeleteImageFile     = errors.New("failed to delete image file")
+	errDeleteImageMetadata = errors.New("failed to delete image metadata")
+)
+
+// deleteImage removes an image, its file, its previous versions and the files derived from it
+func (h *ImageHandler) deleteImage(ctx context.Context, meta *models.ImageMetadata) error {
+	// Versions would otherwise be deleted with the image row, leaving their blob references behind
+	versions, orphanVersionBlobs, err := h.DB.DeleteImageVersions(ctx, meta.ID)
+	if err != nil {
+		log.Printf("Error deleting versions of image %s: %v", meta.ID, err)
+		return fmt.Errorf("%w: %v", errDeleteImageMetadata, err)
+	}
+	h.deleteVersionFiles(ctx, versions, orphanVersionBlobs)
+
+	// Rendition rows go with the image row, their files are removed once it is deleted
+	imageRenditions, err := h.DB.ListRenditions(ctx, meta.ID)
+	if err != nil {
+		log.Printf("Warning: Failed to list renditions of image %s: %v", meta.ID, err)
+	}
+
+	// Content-addressed images share their blob, which is only removed with its last reference
+	if meta.BlobHash != nil {
+		orphan, err := h.DB.DeleteImageReleasingBlob(ctx, meta.UserID, meta.ID)
+		if err != nil {
+			log.Printf("Error deleting image metadata from database: %v", err)
+			return fmt.Errorf("%w: %v", errDeleteImageMetadata, err)
+		}
+		if orphan != nil {
+			h.deleteBlob(ctx, orphan)
+		}
+		h.Renditions.Delete(ctx, imageRenditions)
+		h.Derived.Delete(ctx, meta.ID)
+		return nil
+	}
+
 	// Delete the file from storage
-	if err := h.Storage.Delete(c.Request.Context(), meta.StoragePath); err != nil {
+	if err := h.Storage.Delete(ctx, meta.StoragePath); err != nil {
 		log.Printf("Error deleting file from storage: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image file"})
-		return
+		return fmt.Errorf("%w: %v", errDeleteImageFile, err)
 	}
 
 	// Delete the metadata from the database
-	// We need to implement this method in the PostgresStore
-	if err := h.DB.DeleteImageByID(c.Request.Context(), userID, imageID); err != nil {
+	if err := h.DB.DeleteImageByID(ctx, meta.UserID, meta.ID); err != nil {
 		log.Printf("Error deleting image metadata from database: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image metadata"})
-		return
+		return fmt.Errorf("%w: %v", errDeleteImageMetadata, err)
 	}
+	h.Renditions.Delete(ctx, imageRenditions)
+	h.Derived.Delete(ctx, meta.ID)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+	return nil
+}
+
+// deleteVersionFiles removes the files of deleted versions: their own files, and the
+// content-addressed blobs they held the last reference to. Failures are only logged,
+// the files are left behind as orphans.
+func (h *ImageHandler) deleteVersionFiles(ctx context.Context, versions []models.ImageVersion, orphans []models.Blob) {
+	for _, v := range versions {
+		if v.BlobHash != nil {
+			continue
+		}
+		if err := h.Storage.Delete(ctx, v.StoragePath); err != nil && !strings.HasPrefix(err.Error(), "file not found") {
+			log.Printf("Warning: Failed to delete version %d of image %s: %v", v.Version, v.ImageID, err)
+		}
+	}
+	for i := range orphans {
+		h.deleteBlob(ctx, &orphans[i])
+	}
+}
+
+// deleteBlob removes a blob whose last reference was released, unless an upload of the
+// same content took a new one meanwhile. A failure is only logged, the blob is kept
+// with no references.
+func (h *ImageHandler) deleteBlob(ctx context.Context, blob *models.Blob) {
+	err := h.DB.DeleteUnreferencedBlob(ctx, blob.Hash, func(storagePath string) error {
+		return h.Storage.Delete(ctx, storagePath)
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to delete unreferenced blob %s: %v", blob.Hash, err)
+	}
 }
 
 // HandleListImages retrieves images for the logged-in user.
@@ -235,8 +907,15 @@ func (h *ImageHandler) HandleListImages(c *gin.Context) {
 		return
 	}
 
+	// The timeline is ordered by when photos were taken unless asked otherwise
+	order := db.ImageOrder(c.DefaultQuery("sort", string(db.OrderByTaken)))
+	if order != db.OrderByTaken && order != db.OrderByUploaded {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, use taken or uploaded"})
+		return
+	}
+
 	// Use the actual DB store method
-	images, err := h.DB.ListImagesByUserID(c.Request.Context(), userID)
+	images, err := h.DB.ListImagesByUserID(c.Request.Context(), userID, order)
 	if err != nil {
 		log.Printf("Error listing images for user %s: %v", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
@@ -249,3 +928,78 @@ func (h *ImageHandler) HandleListImages(c *gin.Context) {
 	log.Printf("Retrieved %d images for user %s", len(images), userID)
 	c.JSON(http.StatusOK, images)
 }
+
+// checkQuota fails with a 413 uploadError if storing size more bytes would exceed the user's quota
+func (h *ImageHandler) checkQuota(ctx context.Context, userID models.UserID, size int64) error {
+	return h.checkQuotaReserving(ctx, userID, size, 0)
+}
+
+// checkQuotaReserving is checkQuota when reserved bytes of the quota are already
+// promised elsewhere, e.g. to the user's unfinished resumable uploads
+func (h *ImageHandler) checkQuotaReserving(ctx context.Context, userID models.UserID, size, reserved int64) error {
+	usage, err := h.DB.GetStorageUsage(ctx, userID, h.Config.DefaultUserQuotaBytes)
+	if err != nil {
+		log.Printf("Error checking storage quota for user %s: %v", userID, err)
+		return &uploadError{Status: http.StatusInternalServerError, Message: "Failed to check storage quota", Err: err}
+	}
+
+	if usage.BytesRemaining == nil {
+		return nil
+	}
+	if reserved > 0 && size+reserved > *usage.BytesRemaining {
+		return &uploadError{
+			Status: http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Storage quota exceeded: file is %d bytes but only %d of %d bytes remain, %d of them held for unfinished uploads",
+				size, *usage.BytesRemaining, *usage.QuotaBytes, min(reserved, *usage.BytesRemaining)),
+		}
+	}
+	if size > *usage.BytesRemaining {
+		return &uploadError{
+			Status: http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Storage quota exceeded: file is %d bytes but only %d of %d bytes remain",
+				size, *usage.BytesRemaining, *usage.QuotaBytes),
+		}
+	}
+
+	return nil
+}
+
+// quotaExceededError is the 413 uploadError for a file the database refused to record
+// because the quota was used up after checkQuota, e.g. by a concurrent upload
+func quotaExceededError(err error) *uploadError {
+	return &uploadError{Status: http.StatusRequestEntityTooLarge, Message: "Storage quota exceeded", Err: err}
+}
+
+// storeContentAddressed stores an upload under its SHA-256 hash, skipping the write
+// when an identical blob is already stored. It returns the storage path and hash, and
+// holds a reference to the blob that the image recorded with it takes over.
+func (h *ImageHandler) storeContentAddressed(ctx context.Context, hash string, file io.ReadSeeker, size int64, contentType string) (string, *string, error) {
+	// The reference is taken first, so the blob cannot be deleted while it is reused
+	blob, created, err := h.DB.AcquireBlob(ctx, hash, storage.ContentAddressedPath(hash), size)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !created {
+		// Another upload of the same content may still be writing the file, or have failed to.
+		// Writes replace files atomically, so writing it again is harmless.
+		_, err := h.Storage.Stat(ctx, blob.StoragePath)
+		if err == nil {
+			log.Printf("Blob %s already stored, reusing it", hash)
+			return blob.StoragePath, &hash, nil
+		}
+		if !strings.HasPrefix(err.Error(), "file not found") {
+			h.discardUpload(ctx, &models.ImageMetadata{BlobHash: &hash})
+			return "", nil, err
+		}
+	}
+
+	// Blobs are shared by every user who uploads the same content, so they are encrypted
+	// with the system data key rather than the key of whoever uploaded them first
+	if err := h.Storage.Put(storage.WithKeyOwner(ctx, storage.SystemKeyOwner), blob.StoragePath, file, contentType); err != nil {
+		h.discardUpload(ctx, &models.ImageMetadata{BlobHash: &hash})
+		return "", nil, err
+	}
+
+	return blob.StoragePath, &hash, nil
+}
@@ -0,0 +1,1071 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/derived"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jwt"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/renditions"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// MockImageStore is an in-memory ImageStore. Only the methods the tests use are
+// implemented, the others panic through the nil embedded interface.
+type MockImageStore struct {
+	db.ImageStore
+
+	mu         sync.Mutex
+	blobs      map[string]*models.Blob
+	images     map[models.ImageID]*models.ImageMetadata
+	renditions map[models.ImageID][]models.Rendition
+	versions   map[models.ImageID][]models.ImageVersion
+	hashes     []models.PerceptualHash
+	quota      int64 // Bytes, 0 is unlimited
+
+	// beforeRecord runs when an image file is about to be recorded, e.g. to record
+	// a concurrent upload first
+	beforeRecord func()
+}
+
+func NewMockImageStore() *MockImageStore {
+	return &MockImageStore{
+		blobs:      make(map[string]*models.Blob),
+		images:     make(map[models.ImageID]*models.ImageMetadata),
+		renditions: make(map[models.ImageID][]models.Rendition),
+		versions:   make(map[models.ImageID][]models.ImageVersion),
+	}
+}
+
+func (m *MockImageStore) CreateImageMetadata(ctx context.Context, meta *models.ImageMetadata, defaultQuota int64) error {
+	if m.beforeRecord != nil {
+		m.beforeRecord()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.reserveQuota(meta.UserID, meta.Size); err != nil {
+		return err
+	}
+	copied := *meta
+	m.images[meta.ID] = &copied
+	return nil
+}
+
+func (m *MockImageStore) FindImageByChecksum(ctx context.Context, userID models.UserID, checksum string) (*models.ImageMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, img := range m.images {
+		if img.UserID == userID && img.Checksum != nil && *img.Checksum == checksum {
+			copied := *img
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("image not found")
+}
+
+func (m *MockImageStore) ReplaceImageFile(ctx context.Context, userID models.UserID, imageID models.ImageID, file *models.ImageMetadata, defaultQuota int64) (*models.ImageMetadata, error) {
+	if m.beforeRecord != nil {
+		m.beforeRecord()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img, ok := m.images[imageID]
+	if !ok || img.UserID != userID {
+		return nil, errors.New("image not found")
+	}
+	if err := m.reserveQuota(userID, file.Size); err != nil {
+		return nil, err
+	}
+
+	m.versions[imageID] = append(m.versions[imageID], models.ImageVersion{
+		ImageID: imageID, UserID: userID, Version: img.Version, Filename: img.Filename, StoragePath: img.StoragePath,
+		BlobHash: img.BlobHash, Checksum: img.Checksum, ContentType: img.ContentType, Size: img.Size, Edits: img.Edits,
+		ReplacedAt: time.Now(),
+	})
+	version := img.Version
+	for _, v := range m.versions[imageID] {
+		version = max(version, v.Version)
+	}
+
+	replaced := *file
+	replaced.ID, replaced.UserID, replaced.Version, replaced.Edits = imageID, userID, version+1, nil
+	replaced.CreatedAt, replaced.UpdatedAt = img.CreatedAt, time.Now()
+	m.images[imageID] = &replaced
+	copied := replaced
+	return &copied, nil
+}
+
+// reserveQuota fails like the database does if size more bytes don't fit in the quota, m.mu must be held
+func (m *MockImageStore) reserveQuota(userID models.UserID, size int64) error {
+	if m.quota > 0 && m.usedBytes(userID)+size > m.quota {
+		return errors.New("storage quota exceeded")
+	}
+	return nil
+}
+
+// usedBytes sums the size of a user's images and their versions, m.mu must be held
+func (m *MockImageStore) usedBytes(userID models.UserID) int64 {
+	var used int64
+	for _, img := range m.images {
+		if img.UserID == userID {
+			used += img.Size
+			for _, v := range m.versions[img.ID] {
+				used += v.Size
+			}
+		}
+	}
+	return used
+}
+
+func (m *MockImageStore) ListRenditions(ctx context.Context, imageID models.ImageID) ([]models.Rendition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.renditions[imageID], nil
+}
+
+func (m *MockImageStore) GetImageByID(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img, ok := m.images[imageID]
+	if !ok || img.UserID != userID {
+		return nil, errors.New("image not found")
+	}
+	copied := *img
+	return &copied, nil
+}
+
+func (m *MockImageStore) ListImagesByUserID(ctx context.Context, userID models.UserID, order db.ImageOrder) ([]models.ImageMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	images := []models.ImageMetadata{}
+	for _, img := range m.images {
+		if img.UserID == userID {
+			images = append(images, *img)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].ID < images[j].ID })
+	return images, nil
+}
+
+func (m *MockImageStore) ListPerceptualHashes(ctx context.Context, userID models.UserID) ([]models.PerceptualHash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hashes := []models.PerceptualHash{}
+	for _, hash := range m.hashes {
+		if img, ok := m.images[hash.ImageID]; ok && img.UserID == userID {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+func (m *MockImageStore) DeleteImageVersions(ctx context.Context, imageID models.ImageID) ([]models.ImageVersion, []models.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions := m.versions[imageID]
+	delete(m.versions, imageID)
+	var orphans []models.Blob
+	for _, v := range versions {
+		if v.BlobHash != nil {
+			if blob := m.releaseBlobLocked(*v.BlobHash); blob != nil {
+				orphans = append(orphans, *blob)
+			}
+		}
+	}
+	return versions, orphans, nil
+}
+
+func (m *MockImageStore) DeleteImageByID(ctx context.Context, userID models.UserID, imageID models.ImageID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img, ok := m.images[imageID]
+	if !ok || img.UserID != userID {
+		return errors.New("image not found")
+	}
+	delete(m.images, imageID)
+	return nil
+}
+
+func (m *MockImageStore) AcquireBlob(ctx context.Context, hash, storagePath string, size int64) (*models.Blob, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if blob, ok := m.blobs[hash]; ok {
+		blob.RefCount++
+		copied := *blob
+		return &copied, false, nil
+	}
+	blob := &models.Blob{Hash: hash, StoragePath: storagePath, Size: size, RefCount: 1, CreatedAt: time.Now()}
+	m.blobs[hash] = blob
+	copied := *blob
+	return &copied, true, nil
+}
+
+func (m *MockImageStore) ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.releaseBlobLocked(hash), nil
+}
+
+// releaseBlobLocked drops a reference to a blob and returns it if that was the last one
+func (m *MockImageStore) releaseBlobLocked(hash string) *models.Blob {
+	blob, ok := m.blobs[hash]
+	if !ok {
+		return nil
+	}
+	blob.RefCount--
+	if blob.RefCount > 0 {
+		return nil
+	}
+	copied := *blob
+	return &copied
+}
+
+func (m *MockImageStore) DeleteUnreferencedBlob(ctx context.Context, hash string, deleteFile func(storagePath string) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blob, ok := m.blobs[hash]
+	if !ok || blob.RefCount > 0 {
+		return nil
+	}
+	if err := deleteFile(blob.StoragePath); err != nil {
+		return err
+	}
+	delete(m.blobs, hash)
+	return nil
+}
+
+func (m *MockImageStore) GetStorageUsage(ctx context.Context, userID models.UserID, defaultQuota int64) (*models.StorageUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := models.StorageUsage{BytesUsed: m.usedBytes(userID)}
+	for _, img := range m.images {
+		if img.UserID == userID {
+			usage.ImageCount++
+		}
+	}
+	if m.quota > 0 {
+		remaining := max(m.quota-usage.BytesUsed, 0)
+		usage.QuotaBytes = &m.quota
+		usage.BytesRemaining = &remaining
+	}
+	return &usage, nil
+}
+
+// refCount returns the reference count of a blob, -1 if it has no row
+func (m *MockImageStore) refCount(hash string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if blob, ok := m.blobs[hash]; ok {
+		return blob.RefCount
+	}
+	return -1
+}
+
+// countingStorage counts the files written to the storage it wraps
+type countingStorage struct {
+	storage.BlobStorage
+	puts int
+}
+
+func (s *countingStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	s.puts++
+	return s.BlobStorage.Put(ctx, storagePath, content, contentType)
+}
+
+func newTestImageHandler(t *testing.T, store db.ImageStore) (*ImageHandler, *countingStorage) {
+	t.Helper()
+
+	local, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+	blobStorage := &countingStorage{BlobStorage: local}
+
+	cfg := &config.Config{BlobContentAddressed: true, BlobStorageType: storage.Local}
+	signer := &storage.URLSigner{Key: []byte("test-signing-secret"), BaseURL: "https://photos.example.com"}
+	// Renditions are only queued in these tests, no worker generates them
+	queue := jobs.NewQueue(&MockJobStore{})
+	return &ImageHandler{
+		Config:     cfg,
+		DB:         store,
+		Storage:    blobStorage,
+		Signer:     signer,
+		Renditions: renditions.NewGenerator(store, blobStorage, queue),
+		Derived:    derived.NewCache(blobStorage, 1),
+		Jobs:       queue,
+	}, blobStorage
+}
+
+// MockJobStore records enqueued jobs
+type MockJobStore struct {
+	db.JobStore
+
+	mu   sync.Mutex
+	jobs []models.Job
+}
+
+func (m *MockJobStore) EnqueueJob(ctx context.Context, job *models.Job) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs = append(m.jobs, *job)
+	return true, nil
+}
+
+// pngImage encodes a w×h PNG of a single colour
+func pngImage(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// multipartFile returns a multipart form with content in its file field
+func multipartFile(t *testing.T, filename, contentType string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+	return &body, form.FormDataContentType()
+}
+
+const MOCKUSERID = "mocktestuseridvvunique"
+
+// newTestRouter returns a router whose requests are authenticated as MOCKUSERID
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.UserContextKey, &jwt.Claims{UserID: MOCKUSERID, Email: "test@example.com"})
+		c.Next()
+	})
+	return router
+}
+
+// addImage stores content as an image of MOCKUSERID
+func addImage(t *testing.T, h *ImageHandler, store *MockImageStore, imageID models.ImageID, content []byte, contentType string) *models.ImageMetadata {
+	t.Helper()
+
+	storagePath := "user_" + MOCKUSERID + "/" + imageID
+	require.NoError(t, h.Storage.Put(context.Background(), storagePath, bytes.NewReader(content), contentType))
+
+	img := &models.ImageMetadata{
+		ID:          imageID,
+		UserID:      MOCKUSERID,
+		Filename:    imageID + ".png",
+		StoragePath: storagePath,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Version:     1,
+	}
+	store.mu.Lock()
+	store.images[imageID] = img
+	store.mu.Unlock()
+	return img
+}
+
+// storeBlob stores content as a content-addressed upload would
+func storeBlob(t *testing.T, h *ImageHandler, content []byte) *models.ImageMetadata {
+	t.Helper()
+
+	file := bytes.NewReader(content)
+	hash, err := storage.HashContent(file)
+	require.NoError(t, err)
+
+	storagePath, blobHash, err := h.storeContentAddressed(context.Background(), hash, file, int64(len(content)), "image/png")
+	require.NoError(t, err)
+	return &models.ImageMetadata{StoragePath: storagePath, BlobHash: blobHash}
+}
+
+func fileExists(t *testing.T, h *ImageHandler, storagePath string) bool {
+	t.Helper()
+
+	_, err := h.Storage.Stat(context.Background(), storagePath)
+	if err == nil {
+		return true
+	}
+	require.Contains(t, err.Error(), "file not found")
+	return false
+}
+
+func TestContentAddressedBlobReferences(t *testing.T) {
+	content := []byte("the same photo, uploaded more than once")
+
+	tests := []struct {
+		name         string
+		run          func(t *testing.T, h *ImageHandler, store *MockImageStore) *models.ImageMetadata
+		wantRefCount int // -1 when the blob row must be gone
+		wantFile     bool
+		wantPuts     int
+	}{
+		{
+			name: "identical uploads share one file",
+			run: func(t *testing.T, h *ImageHandler, store *MockImageStore) *models.ImageMetadata {
+				first := storeBlob(t, h, content)
+				second := storeBlob(t, h, content)
+				assert.Equal(t, first.StoragePath, second.StoragePath)
+				return second
+			},
+			wantRefCount: 2,
+			wantFile:     true,
+			wantPuts:     1,
+		},
+		{
+			name: "discarding the only reference deletes the blob",
+			run: func(t *testing.T, h *ImageHandler, store *MockImageStore) *models.ImageMetadata {
+				upload := storeBlob(t, h, content)
+				h.discardUpload(context.Background(), upload)
+				return upload
+			},
+			wantRefCount: -1,
+			wantFile:     false,
+			wantPuts:     1,
+		},
+		{
+			name: "discarding a duplicate keeps the shared blob",
+			run: func(t *testing.T, h *ImageHandler, store *MockImageStore) *models.ImageMetadata {
+				storeBlob(t, h, content)
+				duplicate := storeBlob(t, h, content)
+				h.discardUpload(context.Background(), duplicate)
+				return duplicate
+			},
+			wantRefCount: 1,
+			wantFile:     true,
+			wantPuts:     1,
+		},
+		{
+			name: "missing file of a referenced blob is written again",
+			run: func(t *testing.T, h *ImageHandler, store *MockImageStore) *models.ImageMetadata {
+				// Another upload of the content took the reference but has not written the file yet
+				hash, err := storage.HashContent(bytes.NewReader(content))
+				require.NoError(t, err)
+				_, created, err := store.AcquireBlob(context.Background(), hash, storage.ContentAddressedPath(hash), int64(len(content)))
+				require.NoError(t, err)
+				require.True(t, created)
+
+				return storeBlob(t, h, content)
+			},
+			wantRefCount: 2,
+			wantFile:     true,
+			wantPuts:     1,
+		},
+		{
+			name: "blob referenced again before deletion is kept",
+			run: func(t *testing.T, h *ImageHandler, store *MockImageStore) *models.ImageMetadata {
+				upload := storeBlob(t, h, content)
+				orphan, err := store.ReleaseBlob(context.Background(), *upload.BlobHash)
+				require.NoError(t, err)
+				require.NotNil(t, orphan)
+
+				// A new upload of the content reuses the blob before its file is deleted
+				reupload := storeBlob(t, h, content)
+				h.deleteBlob(context.Background(), orphan)
+				return reupload
+			},
+			wantRefCount: 1,
+			wantFile:     true,
+			wantPuts:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockImageStore()
+			h, blobStorage := newTestImageHandler(t, store)
+
+			upload := tt.run(t, h, store)
+
+			assert.Equal(t, tt.wantRefCount, store.refCount(*upload.BlobHash))
+			assert.Equal(t, tt.wantFile, fileExists(t, h, upload.StoragePath))
+			assert.Equal(t, tt.wantPuts, blobStorage.puts)
+		})
+	}
+}
+
+// failingPutStorage fails every write
+type failingPutStorage struct {
+	storage.BlobStorage
+}
+
+func (s failingPutStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	return errors.New("disk full")
+}
+
+func TestContentAddressedFailedWriteReleasesBlob(t *testing.T) {
+	store := NewMockImageStore()
+	h, _ := newTestImageHandler(t, store)
+	h.Storage = failingPutStorage{BlobStorage: h.Storage}
+
+	content := []byte("never stored")
+	hash, err := storage.HashContent(bytes.NewReader(content))
+	require.NoError(t, err)
+
+	_, _, err = h.storeContentAddressed(context.Background(), hash, bytes.NewReader(content), int64(len(content)), "image/png")
+	require.Error(t, err)
+	assert.Equal(t, -1, store.refCount(hash), "the reference taken for the failed write must be released")
+}
+
+func TestHandleDownloadImageRangeAndETag(t *testing.T) {
+	content := []byte("0123456789")
+
+	store := NewMockImageStore()
+	h, _ := newTestImageHandler(t, store)
+	addImage(t, h, store, "img-1", content, "image/png")
+
+	router := newTestRouter()
+	router.GET("/api/images/:id/download", h.HandleDownloadImage)
+
+	// The ETag the file is served with, for the conditional requests
+	info, err := h.Storage.Stat(context.Background(), "user_"+MOCKUSERID+"/img-1")
+	require.NoError(t, err)
+	etag := info.ETag
+
+	tests := []struct {
+		name             string
+		imageID          string
+		headers          map[string]string
+		wantStatus       int
+		wantBody         string
+		wantContentRange string
+	}{
+		{name: "whole file", imageID: "img-1", wantStatus: http.StatusOK, wantBody: "0123456789"},
+		{name: "byte range", imageID: "img-1", headers: map[string]string{"Range": "bytes=2-5"}, wantStatus: http.StatusPartialContent, wantBody: "2345", wantContentRange: "bytes 2-5/10"},
+		{name: "open range", imageID: "img-1", headers: map[string]string{"Range": "bytes=7-"}, wantStatus: http.StatusPartialContent, wantBody: "789", wantContentRange: "bytes 7-9/10"},
+		{name: "suffix range", imageID: "img-1", headers: map[string]string{"Range": "bytes=-3"}, wantStatus: http.StatusPartialContent, wantBody: "789", wantContentRange: "bytes 7-9/10"},
+		{name: "unsatisfiable range", imageID: "img-1", headers: map[string]string{"Range": "bytes=20-"}, wantStatus: http.StatusRequestedRangeNotSatisfiable, wantContentRange: "bytes */10"},
+		{name: "matching If-None-Match", imageID: "img-1", headers: map[string]string{"If-None-Match": etag}, wantStatus: http.StatusNotModified},
+		{name: "stale If-None-Match", imageID: "img-1", headers: map[string]string{"If-None-Match": `"stale"`}, wantStatus: http.StatusOK, wantBody: "0123456789"},
+		{name: "matching If-Range", imageID: "img-1", headers: map[string]string{"Range": "bytes=0-1", "If-Range": etag}, wantStatus: http.StatusPartialContent, wantBody: "01", wantContentRange: "bytes 0-1/10"},
+		{name: "stale If-Range", imageID: "img-1", headers: map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`}, wantStatus: http.StatusOK, wantBody: "0123456789"},
+		{name: "unknown image", imageID: "img-2", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/api/images/"+tt.imageID+"/download?strip_metadata=false", nil)
+			require.NoError(t, err)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+			assert.Equal(t, tt.wantContentRange, w.Header().Get("Content-Range"))
+			if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusPartialContent || tt.wantStatus == http.StatusNotModified {
+				assert.Equal(t, etag, w.Header().Get("ETag"))
+				assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestSignedImageURLs(t *testing.T) {
+	store := NewMockImageStore()
+	h, _ := newTestImageHandler(t, store)
+	img := addImage(t, h, store, "img-1", []byte("original file"), "image/png")
+
+	require.NoError(t, h.Storage.Put(context.Background(), "renditions/img-1/small.jpg", bytes.NewReader([]byte("small rendition")), "image/jpeg"))
+	store.renditions["img-1"] = []models.Rendition{{ImageID: "img-1", Size: "small", StoragePath: "renditions/img-1/small.jpg", ContentType: "image/jpeg"}}
+
+	router := newTestRouter()
+	router.GET("/api/images/:id/url", h.HandleGenerateURL)
+	public := gin.New()
+	public.GET("/api/shared/:token", h.HandleSignedImage)
+
+	// generate returns the path and query of a signed URL for the image
+	generate := func(t *testing.T, query string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/api/images/img-1/url?strip_metadata=false"+query, nil)
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			URL string `json:"url"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.True(t, strings.HasPrefix(resp.URL, "https://photos.example.com/api/shared/"), resp.URL)
+		return strings.TrimPrefix(resp.URL, "https://photos.example.com")
+	}
+	fetch := func(t *testing.T, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		require.NoError(t, err)
+		public.ServeHTTP(w, req)
+		return w
+	}
+
+	originalURL := generate(t, "")
+	smallURL := generate(t, "&size=small")
+
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T)
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "original", target: originalURL, wantStatus: http.StatusOK, wantBody: "original file"},
+		{name: "rendition", target: smallURL, wantStatus: http.StatusOK, wantBody: "small rendition"},
+		{
+			name: "original after replacement",
+			setup: func(t *testing.T) {
+				// What ReplaceImageFile records when the original is replaced
+				require.NoError(t, h.Storage.Put(context.Background(), "user_"+MOCKUSERID+"/img-1-v2", bytes.NewReader([]byte("replacement file")), "image/png"))
+				store.mu.Lock()
+				store.images["img-1"].StoragePath = "user_" + MOCKUSERID + "/img-1-v2"
+				store.images["img-1"].Version = 2
+				store.mu.Unlock()
+			},
+			target:     originalURL,
+			wantStatus: http.StatusOK,
+			wantBody:   "replacement file",
+		},
+		{name: "tampered token", target: strings.Replace(originalURL, "/api/shared/", "/api/shared/x", 1), wantStatus: http.StatusForbidden},
+		{name: "missing signature", target: strings.Split(originalURL, "&sig=")[0], wantStatus: http.StatusForbidden},
+		{
+			name: "deleted image",
+			setup: func(t *testing.T) {
+				store.mu.Lock()
+				delete(store.images, img.ID)
+				store.mu.Unlock()
+			},
+			target:     originalURL,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+
+			w := fetch(t, tt.target)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleGenerateURLInvalidSize(t *testing.T) {
+	store := NewMockImageStore()
+	h, _ := newTestImageHandler(t, store)
+	addImage(t, h, store, "img-1", []byte("original file"), "image/png")
+
+	router := newTestRouter()
+	router.GET("/api/images/:id/url", h.HandleGenerateURL)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/api/images/img-1/url?size=huge", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleUploadImageQuota(t *testing.T) {
+	content := pngImage(t, 8, 8, color.RGBA{R: 200, A: 255})
+	size := int64(len(content))
+
+	tests := []struct {
+		name       string
+		quota      int64
+		used       int64
+		concurrent int64 // Bytes recorded by another upload after the quota was checked
+		wantStatus int
+	}{
+		{name: "unlimited", used: 1 << 30, wantStatus: http.StatusCreated},
+		{name: "fits exactly", quota: 1000 + size, used: 1000, wantStatus: http.StatusCreated},
+		{name: "exceeds", quota: 1000 + size - 1, used: 1000, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "taken by a concurrent upload", quota: 1000 + size, used: 1000, concurrent: 1, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockImageStore()
+			h, blobStorage := newTestImageHandler(t, store)
+			store.quota = tt.quota
+			store.images["stored"] = &models.ImageMetadata{ID: "stored", UserID: MOCKUSERID, Size: tt.used}
+			if tt.concurrent > 0 {
+				store.beforeRecord = func() {
+					store.mu.Lock()
+					store.images["concurrent"] = &models.ImageMetadata{ID: "concurrent", UserID: MOCKUSERID, Size: tt.concurrent}
+					store.mu.Unlock()
+				}
+			}
+
+			router := newTestRouter()
+			router.POST("/api/images/upload", h.HandleUploadImage)
+
+			body, contentType := multipartFile(t, "photo.png", "image/png", content)
+			req := httptest.NewRequest(http.MethodPost, "/api/images/upload", body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+
+			hash, err := storage.HashContent(bytes.NewReader(content))
+			require.NoError(t, err)
+			if tt.wantStatus == http.StatusCreated {
+				assert.Equal(t, 1, store.refCount(hash))
+				assert.True(t, fileExists(t, h, storage.ContentAddressedPath(hash)))
+				return
+			}
+			// Nothing of a refused upload is kept
+			assert.Equal(t, -1, store.refCount(hash))
+			assert.False(t, fileExists(t, h, storage.ContentAddressedPath(hash)))
+			if tt.concurrent == 0 {
+				assert.Zero(t, blobStorage.puts, "uploads over the quota must be refused before they are written")
+			}
+		})
+	}
+}
+
+func TestHandleUploadImageDuplicates(t *testing.T) {
+	content := pngImage(t, 8, 8, color.RGBA{B: 200, A: 255})
+
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantImages   int
+		wantRefCount int
+	}{
+		{name: "identical content returns the existing image", wantStatus: http.StatusOK, wantImages: 1, wantRefCount: 1},
+		{name: "allow_duplicate stores a copy", query: "?allow_duplicate=true", wantStatus: http.StatusCreated, wantImages: 2, wantRefCount: 2},
+		{name: "allow_duplicate false", query: "?allow_duplicate=false", wantStatus: http.StatusOK, wantImages: 1, wantRefCount: 1},
+		{name: "invalid allow_duplicate", query: "?allow_duplicate=maybe", wantStatus: http.StatusBadRequest, wantImages: 1, wantRefCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockImageStore()
+			h, blobStorage := newTestImageHandler(t, store)
+			router := newTestRouter()
+			router.POST("/api/images/upload", h.HandleUploadImage)
+
+			upload := func(query string) *httptest.ResponseRecorder {
+				body, contentType := multipartFile(t, "photo.png", "image/png", content)
+				req := httptest.NewRequest(http.MethodPost, "/api/images/upload"+query, body)
+				req.Header.Set("Content-Type", contentType)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w
+			}
+
+			first := upload("")
+			require.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+			var existing models.ImageMetadata
+			require.NoError(t, json.Unmarshal(first.Body.Bytes(), &existing))
+			puts := blobStorage.puts
+
+			w := upload(tt.query)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Len(t, store.images, tt.wantImages)
+			hash, err := storage.HashContent(bytes.NewReader(content))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRefCount, store.refCount(hash))
+			assert.Equal(t, puts, blobStorage.puts, "the stored blob is shared, not written again")
+
+			switch tt.wantStatus {
+			case http.StatusOK:
+				var duplicate struct {
+					models.ImageMetadata
+					Duplicate bool `json:"duplicate"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &duplicate))
+				assert.True(t, duplicate.Duplicate)
+				assert.Equal(t, existing.ID, duplicate.ID)
+			case http.StatusCreated:
+				var stored map[string]any
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stored))
+				assert.NotEqual(t, existing.ID, stored["id"])
+				assert.NotContains(t, stored, "duplicate")
+			}
+		})
+	}
+}
+
+// MockDataKeyStore is an in-memory DataKeyStore
+type MockDataKeyStore struct {
+	db.DataKeyStore
+
+	mu   sync.Mutex
+	keys map[string]models.DataKey
+}
+
+func (m *MockDataKeyStore) GetDataKey(ctx context.Context, ownerID string) (*models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[ownerID]
+	if !ok {
+		return nil, errors.New("data key not found")
+	}
+	return &key, nil
+}
+
+func (m *MockDataKeyStore) CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.keys[key.OwnerID]; ok {
+		return &existing, nil
+	}
+	m.keys[key.OwnerID] = *key
+	return key, nil
+}
+
+func TestContentAddressedBlobsUseSystemKey(t *testing.T) {
+	store := NewMockImageStore()
+	h, _ := newTestImageHandler(t, store)
+
+	local, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+	keyring, err := storage.NewKeyring(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)), "")
+	require.NoError(t, err)
+	keys := &MockDataKeyStore{keys: map[string]models.DataKey{}}
+	h.Storage = storage.NewEncryptedStorage(local, keys, keyring, nil)
+
+	content := []byte("the same photo, uploaded by two users")
+	hash, err := storage.HashContent(bytes.NewReader(content))
+	require.NoError(t, err)
+	for _, userID := range []models.UserID{"user-1", "user-2"} {
+		ctx := storage.WithKeyOwner(context.Background(), userID)
+		_, _, err := h.storeContentAddressed(ctx, hash, bytes.NewReader(content), int64(len(content)), "image/png")
+		require.NoError(t, err)
+	}
+
+	keys.mu.Lock()
+	_, userKey := keys.keys["user-1"]
+	keys.mu.Unlock()
+	assert.False(t, userKey, "shared blobs must not be encrypted with the first uploader's key")
+
+	// Readable by a fresh instance that only has the system key, e.g. after user-1 is deleted
+	fresh := storage.NewEncryptedStorage(local, keys, keyring, nil)
+	file, _, err := fresh.Open(storage.WithKeyOwner(context.Background(), "user-2"), storage.ContentAddressedPath(hash))
+	require.NoError(t, err)
+	defer file.Close()
+	got, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+func TestHandleUploadImageSniffing(t *testing.T) {
+	content := pngImage(t, 8, 8, color.RGBA{G: 200, A: 255})
+
+	tests := []struct {
+		name            string
+		declared        string
+		content         []byte
+		wantStatus      int
+		wantContentType string
+	}{
+		{name: "declared correctly", declared: "image/png", content: content, wantStatus: http.StatusCreated, wantContentType: "image/png"},
+		{name: "generic type is detected", declared: "application/octet-stream", content: content, wantStatus: http.StatusCreated, wantContentType: "image/png"},
+		{name: "declared as another image type", declared: "image/jpeg", content: content, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "not an image", declared: "image/png", content: []byte("<html>not a photo</html>"), wantStatus: http.StatusUnsupportedMediaType},
+		{name: "truncated image", declared: "image/png", content: content[:20], wantStatus: http.StatusUnsupportedMediaType},
+		{name: "unsupported declared type", declared: "text/html", content: content, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockImageStore()
+			h, blobStorage := newTestImageHandler(t, store)
+			router := newTestRouter()
+			router.POST("/api/images/upload", h.HandleUploadImage)
+
+			body, contentType := multipartFile(t, "photo.png", tt.declared, tt.content)
+			req := httptest.NewRequest(http.MethodPost, "/api/images/upload", body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusCreated {
+				assert.Zero(t, blobStorage.puts, "rejected files must not be written")
+				return
+			}
+			var img models.ImageMetadata
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &img))
+			assert.Equal(t, tt.wantContentType, img.ContentType)
+		})
+	}
+}
+
+func TestHandleUploadImageDimensions(t *testing.T) {
+	var jpegContent bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpegContent, image.NewGray(image.Rect(0, 0, 5, 9)), nil))
+
+	tests := []struct {
+		name        string
+		contentType string
+		content     []byte
+		wantWidth   int
+		wantHeight  int
+	}{
+		{name: "png", contentType: "image/png", content: pngImage(t, 8, 6, color.White), wantWidth: 8, wantHeight: 6},
+		{name: "jpeg", contentType: "image/jpeg", content: jpegContent.Bytes(), wantWidth: 5, wantHeight: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockImageStore()
+			h, _ := newTestImageHandler(t, store)
+			router := newTestRouter()
+			router.POST("/api/images/upload", h.HandleUploadImage)
+
+			body, contentType := multipartFile(t, "photo", tt.contentType, tt.content)
+			req := httptest.NewRequest(http.MethodPost, "/api/images/upload", body)
+			req.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			var img models.ImageMetadata
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &img))
+			assert.Equal(t, tt.wantWidth, img.Width)
+			assert.Equal(t, tt.wantHeight, img.Height)
+
+			recorded := store.images[img.ID]
+			require.NotNil(t, recorded)
+			assert.Equal(t, tt.wantWidth, recorded.Width)
+			assert.Equal(t, tt.wantHeight, recorded.Height)
+		})
+	}
+}
+
+// renditionJobs returns the generate-renditions jobs enqueued by a handler
+func renditionJobs(h *ImageHandler) []models.Job {
+	store := h.Jobs.DB.(*MockJobStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var queued []models.Job
+	for _, job := range store.jobs {
+		if job.Kind == jobs.GenerateRenditions.Name {
+			queued = append(queued, job)
+		}
+	}
+	return queued
+}
+
+func TestHandleGetThumbnail(t *testing.T) {
+	small := models.Rendition{ImageID: "img-1", Size: renditions.Small, StoragePath: renditions.StoragePath("img-1", renditions.Small), ContentType: "image/jpeg"}
+
+	tests := []struct {
+		name          string
+		size          string
+		renditions    []models.Rendition
+		stored        bool // The rendition files exist
+		wantRendition string
+		wantBody      string
+		wantQueued    bool
+	}{
+		{name: "not generated yet", size: renditions.Small, wantRendition: "original", wantBody: "original file", wantQueued: true},
+		{name: "requested size", size: renditions.Small, renditions: []models.Rendition{small}, stored: true, wantRendition: renditions.Small, wantBody: "small rendition"},
+		{name: "closest size while the others are missing", size: renditions.Large, renditions: []models.Rendition{small}, stored: true, wantRendition: renditions.Small, wantBody: "small rendition", wantQueued: true},
+		{name: "recorded but lost", size: renditions.Small, renditions: []models.Rendition{small}, wantRendition: "original", wantBody: "original file", wantQueued: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMockImageStore()
+			h, _ := newTestImageHandler(t, store)
+			addImage(t, h, store, "img-1", []byte("original file"), "image/png")
+			store.renditions["img-1"] = tt.renditions
+			if tt.stored {
+				require.NoError(t, h.Storage.Put(context.Background(), small.StoragePath, strings.NewReader("small rendition"), "image/jpeg"))
+			}
+
+			router := newTestRouter()
+			router.GET("/api/images/:id/thumbnail", h.HandleGetThumbnail)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/images/img-1/thumbnail?strip_metadata=false&size="+tt.size, nil)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, tt.wantRendition, w.Header().Get("X-Rendition"))
+			assert.Equal(t, tt.wantBody, w.Body.String())
+
+			queued := renditionJobs(h)
+			if !tt.wantQueued {
+				assert.Empty(t, queued)
+				return
+			}
+			require.NotEmpty(t, queued)
+			payload, err := jobs.GenerateRenditions.Decode(&queued[0])
+			require.NoError(t, err)
+			assert.Equal(t, jobs.GenerateRenditionsPayload{ImageID: "img-1", UserID: MOCKUSERID}, payload)
+			require.NotNil(t, queued[0].IdempotencyKey, "requests for missing renditions queue at most one job at a time")
+			assert.True(t, strings.HasPrefix(*queued[0].IdempotencyKey, "generate-renditions:img-1:"))
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+const maxPersonNameLength = 255
+
+// PeopleHandler serves the people recognised in a user's images. Faces are detected
+// and grouped into people by the faces service, users name them and correct the
+// grouping by merging and splitting people.
+type PeopleHandler struct {
+	Config *config.Config
+	DB     db.PeopleStore
+	Images *ImageHandler
+}
+
+// NewPeopleHandler creates a new PeopleHandler instance
+func NewPeopleHandler(config *config.Config, db db.PeopleStore, imageHandler *ImageHandler) *PeopleHandler {
+	return &PeopleHandler{
+		Config: config,
+		DB:     db,
+		Images: imageHandler,
+	}
+}
+
+// ListPeople returns the people in the user's images, those in the most images first
+func (h *PeopleHandler) ListPeople(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	people, err := h.DB.ListPeople(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing people for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve people"})
+		return
+	}
+
+	c.JSON(http.StatusOK, people)
+}
+
+// GetPerson returns a person with its face counts and cover face
+func (h *PeopleHandler) GetPerson(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	person, err := h.DB.GetPerson(c.Request.Context(), userID, personID)
+	if err != nil {
+		h.personError(c, err, "Failed to retrieve person")
+		return
+	}
+
+	c.JSON(http.StatusOK, person)
+}
+
+// RenamePerson names a person. An empty name makes it unnamed again.
+func (h *PeopleHandler) RenamePerson(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name *string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(*req.Name)
+	if len(name) > maxPersonNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is too long"})
+		return
+	}
+	var newName *string
+	if name != "" {
+		newName = &name
+	}
+
+	person, err := h.DB.RenamePerson(c.Request.Context(), userID, personID, newName)
+	if err != nil {
+		h.personError(c, err, "Failed to rename person")
+		return
+	}
+
+	c.JSON(http.StatusOK, person)
+}
+
+// MergePeople moves the faces of other people into a person, when the faces service
+// took one person for several. The person keeps its name, the others are deleted.
+func (h *PeopleHandler) MergePeople(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		People []models.PersonID `json:"people" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	others, ok := uniqueIDs(req.People)
+	if !ok || slices.Contains(others, personID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "People must be valid IDs of other people"})
+		return
+	}
+
+	person, err := h.DB.MergePeople(c.Request.Context(), userID, personID, others)
+	if err != nil {
+		h.personError(c, err, "Failed to merge people")
+		return
+	}
+
+	log.Printf("Merged %d people into person %s of user %s", len(others), personID, userID)
+	c.JSON(http.StatusOK, person)
+}
+
+// SplitPerson moves faces of a person into a new person, when the faces service took
+// several people for one. It returns the new person.
+func (h *PeopleHandler) SplitPerson(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Faces []models.FaceID `json:"faces" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	faceIDs, ok := uniqueIDs(req.Faces)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Faces must be valid face IDs"})
+		return
+	}
+
+	person, err := h.DB.SplitPerson(c.Request.Context(), userID, personID, faceIDs)
+	if err != nil {
+		switch err.Error() {
+		case "face not found":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Faces must belong to the person"})
+		case "split leaves person empty":
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A person cannot be split into only a new person"})
+		default:
+			h.personError(c, err, "Failed to split person")
+		}
+		return
+	}
+
+	log.Printf("Split %d faces of person %s into person %s", len(faceIDs), personID, person.ID)
+	c.JSON(http.StatusCreated, person)
+}
+
+// ListPersonImages returns the images a person appears in, in timeline order
+func (h *PeopleHandler) ListPersonImages(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	images, err := h.DB.ListPersonImages(c.Request.Context(), userID, personID)
+	if err != nil {
+		h.personError(c, err, "Failed to retrieve images")
+		return
+	}
+
+	c.JSON(http.StatusOK, images)
+}
+
+// ListPersonFaces returns the faces of a person, most confidently detected first, to
+// pick those to split off
+func (h *PeopleHandler) ListPersonFaces(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	faces, err := h.DB.ListPersonFaces(c.Request.Context(), userID, personID)
+	if err != nil {
+		h.personError(c, err, "Failed to retrieve faces")
+		return
+	}
+
+	c.JSON(http.StatusOK, faces)
+}
+
+// ListImageFaces returns the faces detected in an image, with the person of each
+func (h *PeopleHandler) ListImageFaces(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	imageID := c.Param("id")
+	if _, err := h.Images.DB.GetImageByID(ctx, userID, imageID); err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	faces, err := h.DB.ListImageFaces(ctx, userID, imageID)
+	if err != nil {
+		log.Printf("Error listing faces of image %s: %v", imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve faces"})
+		return
+	}
+
+	c.JSON(http.StatusOK, faces)
+}
+
+// personParam reads the person ID of the URL, answering 404 if it cannot be one
+func personParam(c *gin.Context) (models.PersonID, bool) {
+	personID := c.Param("id")
+	if uuid.Validate(personID) != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return "", false
+	}
+	return personID, true
+}
+
+// personError answers a failed person lookup or change
+func (h *PeopleHandler) personError(c *gin.Context, err error, message string) {
+	if err.Error() == "person not found" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	log.Printf("%s: %v", message, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// uniqueIDs drops repeated IDs, and reports whether all are UUIDs
+func uniqueIDs(ids []string) ([]string, bool) {
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if uuid.Validate(id) != nil {
+			return nil, false
+		}
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique, true
+}
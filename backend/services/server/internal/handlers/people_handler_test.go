@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// MockPeopleStore is an in-memory PeopleStore of the people of MOCKUSERID
+type MockPeopleStore struct {
+	db.PeopleStore
+
+	mu     sync.Mutex
+	people map[models.PersonID]*models.Person
+	faces  []models.Face
+}
+
+// person returns a copy of a person with its face count, m.mu must be held
+func (m *MockPeopleStore) person(userID models.UserID, personID models.PersonID) (*models.Person, error) {
+	p, ok := m.people[personID]
+	if !ok || p.UserID != userID {
+		return nil, errors.New("person not found")
+	}
+	copied := *p
+	copied.FaceCount = 0
+	for _, f := range m.faces {
+		if f.PersonID != nil && *f.PersonID == personID {
+			copied.FaceCount++
+		}
+	}
+	return &copied, nil
+}
+
+func (m *MockPeopleStore) GetPerson(ctx context.Context, userID models.UserID, personID models.PersonID) (*models.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.person(userID, personID)
+}
+
+func (m *MockPeopleStore) RenamePerson(ctx context.Context, userID models.UserID, personID models.PersonID, name *string) (*models.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.person(userID, personID); err != nil {
+		return nil, err
+	}
+	m.people[personID].Name = name
+	return m.person(userID, personID)
+}
+
+func (m *MockPeopleStore) MergePeople(ctx context.Context, userID models.UserID, personID models.PersonID, otherIDs []models.PersonID) (*models.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range append([]models.PersonID{personID}, otherIDs...) {
+		if _, err := m.person(userID, id); err != nil {
+			return nil, err
+		}
+	}
+	for i, f := range m.faces {
+		if f.PersonID != nil && slices.Contains(otherIDs, *f.PersonID) {
+			m.faces[i].PersonID = &personID
+		}
+	}
+	for _, id := range otherIDs {
+		delete(m.people, id)
+	}
+	return m.person(userID, personID)
+}
+
+func (m *MockPeopleStore) SplitPerson(ctx context.Context, userID models.UserID, personID models.PersonID, faceIDs []models.FaceID) (*models.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	person, err := m.person(userID, personID)
+	if err != nil {
+		return nil, err
+	}
+	var moved []int
+	for i, f := range m.faces {
+		if slices.Contains(faceIDs, f.ID) && f.PersonID != nil && *f.PersonID == personID {
+			moved = append(moved, i)
+		}
+	}
+	if len(moved) != len(faceIDs) {
+		return nil, errors.New("face not found")
+	}
+	if len(moved) == person.FaceCount {
+		return nil, errors.New("split leaves person empty")
+	}
+
+	newID := uuid.New().String()
+	m.people[newID] = &models.Person{ID: newID, UserID: userID}
+	for _, i := range moved {
+		m.faces[i].PersonID = &newID
+	}
+	return m.person(userID, newID)
+}
+
+func (m *MockPeopleStore) ListImageFaces(ctx context.Context, userID models.UserID, imageID models.ImageID) ([]models.Face, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	faces := []models.Face{}
+	for _, f := range m.faces {
+		if f.UserID == userID && f.ImageID == imageID {
+			faces = append(faces, f)
+		}
+	}
+	return faces, nil
+}
+
+const (
+	personAnna  = "0b6d8a52-6f0e-4b0a-9d0c-7a3f2e1c9a01"
+	personBen   = "0b6d8a52-6f0e-4b0a-9d0c-7a3f2e1c9a02"
+	personOther = "0b6d8a52-6f0e-4b0a-9d0c-7a3f2e1c9a03" // Of another user
+	personNone  = "0b6d8a52-6f0e-4b0a-9d0c-7a3f2e1c9a04"
+
+	faceAnna1 = "7c1e4f3a-2b5d-4e6f-8a9b-0c1d2e3f4a01"
+	faceAnna2 = "7c1e4f3a-2b5d-4e6f-8a9b-0c1d2e3f4a02"
+	faceBen1  = "7c1e4f3a-2b5d-4e6f-8a9b-0c1d2e3f4a03"
+)
+
+func newTestPeopleRouter(t *testing.T) (*gin.Engine, *MockPeopleStore, *MockImageStore) {
+	t.Helper()
+
+	store := NewMockImageStore()
+	images, _ := newTestImageHandler(t, store)
+
+	anna, ben := personAnna, personBen
+	people := &MockPeopleStore{
+		people: map[models.PersonID]*models.Person{
+			personAnna:  {ID: personAnna, UserID: MOCKUSERID},
+			personBen:   {ID: personBen, UserID: MOCKUSERID},
+			personOther: {ID: personOther, UserID: "someone-else"},
+		},
+		faces: []models.Face{
+			{ID: faceAnna1, ImageID: "img-1", UserID: MOCKUSERID, PersonID: &anna},
+			{ID: faceAnna2, ImageID: "img-2", UserID: MOCKUSERID, PersonID: &anna},
+			{ID: faceBen1, ImageID: "img-1", UserID: MOCKUSERID, PersonID: &ben},
+		},
+	}
+	h := NewPeopleHandler(images.Config, people, images)
+
+	router := newTestRouter()
+	router.PATCH("/api/people/:id", h.RenamePerson)
+	router.POST("/api/people/:id/merge", h.MergePeople)
+	router.POST("/api/people/:id/split", h.SplitPerson)
+	router.GET("/api/images/:id/faces", h.ListImageFaces)
+	return router, people, store
+}
+
+func TestPeopleChanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		check      func(t *testing.T, person models.Person, people *MockPeopleStore)
+	}{
+		{
+			name:       "rename",
+			method:     http.MethodPatch,
+			path:       "/api/people/" + personAnna,
+			body:       `{"name": "  Anna  "}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, person models.Person, people *MockPeopleStore) {
+				require.NotNil(t, person.Name)
+				assert.Equal(t, "Anna", *person.Name)
+			},
+		},
+		{
+			name:       "remove the name",
+			method:     http.MethodPatch,
+			path:       "/api/people/" + personAnna,
+			body:       `{"name": " "}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, person models.Person, people *MockPeopleStore) {
+				assert.Nil(t, person.Name)
+			},
+		},
+		{name: "rename without a name", method: http.MethodPatch, path: "/api/people/" + personAnna, body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "name too long", method: http.MethodPatch, path: "/api/people/" + personAnna, body: `{"name": "` + strings.Repeat("a", maxPersonNameLength+1) + `"}`, wantStatus: http.StatusBadRequest},
+		{name: "rename unknown person", method: http.MethodPatch, path: "/api/people/" + personNone, body: `{"name": "Anna"}`, wantStatus: http.StatusNotFound},
+		{name: "rename person of another user", method: http.MethodPatch, path: "/api/people/" + personOther, body: `{"name": "Anna"}`, wantStatus: http.StatusNotFound},
+		{name: "person ID not a UUID", method: http.MethodPatch, path: "/api/people/anna", body: `{"name": "Anna"}`, wantStatus: http.StatusNotFound},
+		{
+			name:       "merge",
+			method:     http.MethodPost,
+			path:       "/api/people/" + personAnna + "/merge",
+			body:       `{"people": ["` + personBen + `", "` + personBen + `"]}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, person models.Person, people *MockPeopleStore) {
+				assert.Equal(t, personAnna, person.ID)
+				assert.Equal(t, 3, person.FaceCount)
+				assert.NotContains(t, people.people, personBen)
+			},
+		},
+		{name: "merge with itself", method: http.MethodPost, path: "/api/people/" + personAnna + "/merge", body: `{"people": ["` + personAnna + `"]}`, wantStatus: http.StatusBadRequest},
+		{name: "merge nobody", method: http.MethodPost, path: "/api/people/" + personAnna + "/merge", body: `{"people": []}`, wantStatus: http.StatusBadRequest},
+		{name: "merge invalid ID", method: http.MethodPost, path: "/api/people/" + personAnna + "/merge", body: `{"people": ["ben"]}`, wantStatus: http.StatusBadRequest},
+		{name: "merge person of another user", method: http.MethodPost, path: "/api/people/" + personAnna + "/merge", body: `{"people": ["` + personOther + `"]}`, wantStatus: http.StatusNotFound},
+		{
+			name:       "split",
+			method:     http.MethodPost,
+			path:       "/api/people/" + personAnna + "/split",
+			body:       `{"faces": ["` + faceAnna2 + `"]}`,
+			wantStatus: http.StatusCreated,
+			check: func(t *testing.T, person models.Person, people *MockPeopleStore) {
+				assert.NotEqual(t, personAnna, person.ID)
+				assert.Equal(t, 1, person.FaceCount)
+				anna, err := people.GetPerson(context.Background(), MOCKUSERID, personAnna)
+				require.NoError(t, err)
+				assert.Equal(t, 1, anna.FaceCount)
+			},
+		},
+		{name: "split every face", method: http.MethodPost, path: "/api/people/" + personAnna + "/split", body: `{"faces": ["` + faceAnna1 + `", "` + faceAnna2 + `"]}`, wantStatus: http.StatusBadRequest},
+		{name: "split face of another person", method: http.MethodPost, path: "/api/people/" + personAnna + "/split", body: `{"faces": ["` + faceBen1 + `"]}`, wantStatus: http.StatusBadRequest},
+		{name: "split invalid face ID", method: http.MethodPost, path: "/api/people/" + personAnna + "/split", body: `{"faces": ["face"]}`, wantStatus: http.StatusBadRequest},
+		{name: "split unknown person", method: http.MethodPost, path: "/api/people/" + personNone + "/split", body: `{"faces": ["` + faceAnna1 + `"]}`, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, people, _ := newTestPeopleRouter(t)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.check == nil {
+				assert.Len(t, people.people, 3, "a refused change must not add or delete people")
+				assert.Nil(t, people.people[personAnna].Name)
+				return
+			}
+			var person models.Person
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+			tt.check(t, person, people)
+		})
+	}
+}
+
+func TestListImageFaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		imageID    models.ImageID
+		wantStatus int
+		wantFaces  []models.FaceID
+	}{
+		{name: "faces of the image", imageID: "img-1", wantStatus: http.StatusOK, wantFaces: []models.FaceID{faceAnna1, faceBen1}},
+		{name: "image without faces", imageID: "img-3", wantStatus: http.StatusOK, wantFaces: nil},
+		{name: "unknown image", imageID: "missing", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _, store := newTestPeopleRouter(t)
+			for _, imageID := range []models.ImageID{"img-1", "img-2", "img-3"} {
+				store.images[imageID] = &models.ImageMetadata{ID: imageID, UserID: MOCKUSERID}
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/images/"+tt.imageID+"/faces", nil))
+
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var faces []models.Face
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &faces))
+			var ids []models.FaceID
+			for _, f := range faces {
+				ids = append(ids, f.ID)
+			}
+			assert.Equal(t, tt.wantFaces, ids)
+		})
+	}
+}
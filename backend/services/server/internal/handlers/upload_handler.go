@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// Resumable uploads implement the tus 1.0 protocol (https://tus.io/protocols/resumable-upload)
+// with the creation, termination and expiration extensions.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+
+	// Largest file accepted through resumable uploads
+	maxResumableUploadSize = 500 * 1024 * 1024
+
+	// Uploads that receive no data for this long are discarded
+	uploadExpiry = 24 * time.Hour
+
+	// Staged chunks live under this prefix in blob storage
+	uploadChunkPrefix = "uploads"
+)
+
+// UploadHandler handles resumable (tus) uploads. Completed uploads are handed
+// to the ImageHandler, which stores them like any other image.
+type UploadHandler struct {
+	Config *config.Config
+	DB     db.UploadStore
+	Images *ImageHandler
+}
+
+// NewUploadHandler creates a new UploadHandler
+func NewUploadHandler(config *config.Config, db db.UploadStore, images *ImageHandler) *UploadHandler {
+	return &UploadHandler{
+		Config: config,
+		DB:     db,
+		Images: images,
+	}
+}
+
+// HandleOptions advertises the supported tus version and extensions
+func (h *UploadHandler) HandleOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxResumableUploadSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// HandleCreateUpload starts a new resumable upload (tus creation extension)
+func (h *UploadHandler) HandleCreateUpload(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+	if !checkTusResumable(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid Upload-Length header"})
+		return
+	}
+	if length == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded file is empty"})
+		return
+	}
+	if length > maxResumableUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File size exceeds limit of %d MB", maxResumableUploadSize/1024/1024)})
+		return
+	}
+
+	// Refuse uploads that cannot fit before the client sends any data. The user's other
+	// unfinished uploads hold on to the space they declared, so they can all complete.
+	reserved, err := h.DB.SumUploadLengths(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error summing unfinished uploads of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check storage quota"})
+		return
+	}
+	if err := h.Images.checkQuotaReserving(c.Request.Context(), userID, length, reserved); err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata header"})
+		return
+	}
+
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Metadata must include a filename"})
+		return
+	}
+
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = metadata["type"]
+	}
+	if !declaredTypeAllowed(contentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported file type: %s", contentType)})
+		return
+	}
+
+	// Like allow_duplicate on the multipart endpoint, stores identical content again
+	allowDuplicate := false
+	if raw, ok := metadata["allow_duplicate"]; ok {
+		allowDuplicate, err = strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allow_duplicate in Upload-Metadata, use true or false"})
+			return
+		}
+	}
+
+	upload := &models.Upload{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		Filename:       path.Base(filename),
+		ContentType:    contentType,
+		Length:         length,
+		AllowDuplicate: allowDuplicate,
+		ExpiresAt:      time.Now().Add(uploadExpiry),
+	}
+
+	if err := h.DB.CreateUpload(c.Request.Context(), upload); err != nil {
+		log.Printf("Error creating upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	log.Printf("Created resumable upload %s for user %s (%d bytes)", upload.ID, userID, length)
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Location", c.Request.URL.Path+"/"+upload.ID)
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// HandleUploadStatus reports how much of an upload has been received, so clients can resume
+func (h *UploadHandler) HandleUploadStatus(c *gin.Context) {
+	upload, ok := h.getUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// HandleUploadChunk appends a chunk at the current offset. Once all bytes are
+// received the upload is assembled and stored as an image.
+func (h *UploadHandler) HandleUploadChunk(c *gin.Context) {
+	upload, ok := h.getUpload(c)
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid Upload-Offset header"})
+		return
+	}
+	if offset != upload.Offset {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the current offset"})
+		return
+	}
+
+	// The request context ends with the connection, but what was received before it broke
+	// must still be recorded
+	ctx := storage.WithKeyOwner(context.WithoutCancel(c.Request.Context()), upload.UserID)
+
+	// A PATCH at the end of a complete upload retries a failed assembly
+	var readErr error
+	if upload.Offset < upload.Length {
+		// Each chunk gets a unique key, so a concurrent PATCH at the same offset cannot overwrite it
+		chunkPath := path.Join(uploadChunkPrefix, upload.ID, fmt.Sprintf("%016d_%s", offset, uuid.New().String()))
+		body := &chunkReader{r: io.LimitReader(c.Request.Body, upload.Length-offset)}
+
+		if err := h.Images.Storage.Put(ctx, chunkPath, body, "application/octet-stream"); err != nil {
+			// Nothing is recorded for a failed chunk, the client resumes from the current offset
+			log.Printf("Error staging chunk for upload %s: %v", upload.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store upload chunk"})
+			return
+		}
+
+		if body.n == 0 {
+			// Empty PATCH, or one interrupted before any data, nothing to record
+			if err := h.Images.Storage.Delete(ctx, chunkPath); err != nil {
+				log.Printf("Warning: Failed to clean up chunk %s: %v", chunkPath, err)
+			}
+			c.Header("Tus-Resumable", tusVersion)
+			c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			if body.err != nil {
+				log.Printf("Chunk for upload %s was interrupted before any data: %v", upload.ID, body.err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload chunk"})
+				return
+			}
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		updated, err := h.DB.AppendUploadChunk(ctx, upload.UserID, upload.ID, offset, body.n, chunkPath, time.Now().Add(uploadExpiry))
+		if err != nil {
+			if cleanupErr := h.Images.Storage.Delete(ctx, chunkPath); cleanupErr != nil {
+				log.Printf("Warning: Failed to clean up chunk %s: %v", chunkPath, cleanupErr)
+			}
+			if err.Error() == "upload offset mismatch" {
+				c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the current offset"})
+				return
+			}
+			log.Printf("Error recording chunk for upload %s: %v", upload.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record upload chunk"})
+			return
+		}
+		upload = updated
+		readErr = body.err
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	if readErr != nil {
+		// The received part is kept, the client resumes from the new offset
+		log.Printf("Chunk for upload %s was interrupted at offset %d: %v", upload.ID, upload.Offset, readErr)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload chunk"})
+		return
+	}
+
+	if upload.Offset < upload.Length {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	metadata, duplicate, err := h.completeUpload(ctx, upload)
+	if err != nil {
+		log.Printf("Error completing upload %s: %v", upload.ID, err)
+		respondUploadError(c, err)
+		return
+	}
+
+	// tus responses have no body, the new image is referenced by a header instead
+	c.Header("Image-Id", metadata.ID)
+	if duplicate {
+		c.Header("Image-Duplicate", "true")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleTerminateUpload aborts an upload and discards its chunks (tus termination extension)
+func (h *UploadHandler) HandleTerminateUpload(c *gin.Context) {
+	upload, ok := h.getUpload(c)
+	if !ok {
+		return
+	}
+
+	if err := h.discardUpload(c.Request.Context(), upload); err != nil {
+		log.Printf("Error terminating upload %s: %v", upload.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate upload"})
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusNoContent)
+}
+
+// CleanupExpiredUploads discards uploads that have not received data before their expiry
+func (h *UploadHandler) CleanupExpiredUploads(ctx context.Context) {
+	uploads, err := h.DB.ListExpiredUploads(ctx, time.Now())
+	if err != nil {
+		log.Printf("Error listing expired uploads: %v", err)
+		return
+	}
+
+	for i := range uploads {
+		if err := h.discardUpload(ctx, &uploads[i]); err != nil {
+			log.Printf("Error discarding expired upload %s: %v", uploads[i].ID, err)
+			continue
+		}
+		log.Printf("Discarded expired upload %s", uploads[i].ID)
+	}
+}
+
+// getUpload loads the upload named in the URL, writing the error response if it is not usable
+func (h *UploadHandler) getUpload(c *gin.Context) (*models.Upload, bool) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return nil, false
+	}
+	if !checkTusResumable(c) {
+		return nil, false
+	}
+
+	upload, err := h.DB.GetUpload(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if err.Error() == "upload not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+			return nil, false
+		}
+		log.Printf("Error retrieving upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve upload"})
+		return nil, false
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload has expired"})
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// completeUpload assembles the staged chunks, stores the result as an image and removes the upload.
+// When the user already has identical content, that image is returned and duplicate is set.
+func (h *UploadHandler) completeUpload(ctx context.Context, upload *models.Upload) (metadata *models.ImageMetadata, duplicate bool, err error) {
+	// Chunks are joined into a local temp file, which gives storeImage the seekable reader it needs
+	assembled, err := os.CreateTemp("", "roshnii-upload-*")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(assembled.Name())
+	defer assembled.Close()
+
+	for _, chunkPath := range upload.ChunkPaths {
+		if err := h.appendChunk(ctx, assembled, chunkPath); err != nil {
+			return nil, false, err
+		}
+	}
+
+	size, err := assembled.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read assembled upload: %w", err)
+	}
+	if size != upload.Length {
+		return nil, false, fmt.Errorf("assembled upload has %d bytes, expected %d", size, upload.Length)
+	}
+	if _, err := assembled.Seek(0, io.SeekStart); err != nil {
+		return nil, false, fmt.Errorf("failed to rewind assembled upload: %w", err)
+	}
+
+	metadata, duplicate, err = h.Images.storeImage(ctx, upload.UserID, upload.Filename, upload.ContentType, assembled, size, upload.AllowDuplicate)
+	if err != nil {
+		// Resuming cannot fix content that is not an acceptable image, so there is nothing worth keeping
+		var uploadErr *uploadError
+		if errors.As(err, &uploadErr) && uploadErr.Status == http.StatusUnsupportedMediaType {
+			if discardErr := h.discardUpload(ctx, upload); discardErr != nil {
+				log.Printf("Warning: Failed to clean up rejected upload %s: %v", upload.ID, discardErr)
+			}
+		}
+		return nil, false, err
+	}
+
+	if err := h.discardUpload(ctx, upload); err != nil {
+		log.Printf("Warning: Failed to clean up completed upload %s: %v", upload.ID, err)
+	}
+
+	return metadata, duplicate, nil
+}
+
+func (h *UploadHandler) appendChunk(ctx context.Context, w io.Writer, chunkPath string) error {
+	chunk, _, err := h.Images.Storage.Download(ctx, chunkPath)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %s: %w", chunkPath, err)
+	}
+	defer chunk.Close()
+
+	if _, err := io.Copy(w, chunk); err != nil {
+		return fmt.Errorf("failed to copy chunk %s: %w", chunkPath, err)
+	}
+	return nil
+}
+
+// discardUpload deletes an upload's staged chunks and its record
+func (h *UploadHandler) discardUpload(ctx context.Context, upload *models.Upload) error {
+	for _, chunkPath := range upload.ChunkPaths {
+		if err := h.Images.Storage.Delete(ctx, chunkPath); err != nil {
+			return err
+		}
+	}
+	return h.DB.DeleteUpload(ctx, upload.UserID, upload.ID)
+}
+
+// checkTusResumable rejects requests for a protocol version other than the supported one
+func checkTusResumable(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported Tus-Resumable version"})
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header: comma separated
+// "key base64(value)" pairs, where the value may be omitted.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for metadata key %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// chunkReader counts the bytes read through it. A read error, e.g. from a dropped
+// connection, ends the chunk like EOF and is kept in err, so the bytes read before
+// it can still be stored.
+type chunkReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+		return n, io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// MockUploadStore is an in-memory UploadStore
+type MockUploadStore struct {
+	mu      sync.Mutex
+	uploads map[models.UploadID]*models.Upload
+}
+
+func NewMockUploadStore() *MockUploadStore {
+	return &MockUploadStore{uploads: make(map[models.UploadID]*models.Upload)}
+}
+
+func (m *MockUploadStore) CreateUpload(ctx context.Context, upload *models.Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload.CreatedAt = time.Now()
+	upload.UpdatedAt = upload.CreatedAt
+	copied := *upload
+	m.uploads[upload.ID] = &copied
+	return nil
+}
+
+func (m *MockUploadStore) GetUpload(ctx context.Context, userID models.UserID, uploadID models.UploadID) (*models.Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.UserID != userID {
+		return nil, errors.New("upload not found")
+	}
+	copied := *upload
+	copied.ChunkPaths = append([]string(nil), upload.ChunkPaths...)
+	return &copied, nil
+}
+
+func (m *MockUploadStore) AppendUploadChunk(ctx context.Context, userID models.UserID, uploadID models.UploadID, offset, size int64, chunkPath string, expiresAt time.Time) (*models.Upload, error) {
+	m.mu.Lock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.UserID != userID || upload.Offset != offset {
+		m.mu.Unlock()
+		return nil, errors.New("upload offset mismatch")
+	}
+	upload.Offset += size
+	upload.ChunkPaths = append(upload.ChunkPaths, chunkPath)
+	upload.ExpiresAt = expiresAt
+	m.mu.Unlock()
+
+	return m.GetUpload(ctx, userID, uploadID)
+}
+
+func (m *MockUploadStore) DeleteUpload(ctx context.Context, userID models.UserID, uploadID models.UploadID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.UserID != userID {
+		return errors.New("upload not found")
+	}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func (m *MockUploadStore) ListExpiredUploads(ctx context.Context, before time.Time) ([]models.Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var uploads []models.Upload
+	for _, upload := range m.uploads {
+		if upload.ExpiresAt.Before(before) {
+			uploads = append(uploads, *upload)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].ID < uploads[j].ID })
+	return uploads, nil
+}
+
+func (m *MockUploadStore) SumUploadLengths(ctx context.Context, userID models.UserID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total int64
+	for _, upload := range m.uploads {
+		if upload.UserID == userID && upload.ExpiresAt.After(time.Now()) {
+			total += upload.Length
+		}
+	}
+	return total, nil
+}
+
+func newTestUploadHandler(t *testing.T) (*UploadHandler, *MockUploadStore, *MockImageStore) {
+	t.Helper()
+
+	images := NewMockImageStore()
+	imageHandler, _ := newTestImageHandler(t, images)
+	uploads := NewMockUploadStore()
+	return NewUploadHandler(imageHandler.Config, uploads, imageHandler), uploads, images
+}
+
+func newTestUploadRouter(h *UploadHandler) *gin.Engine {
+	router := newTestRouter()
+	router.POST("/api/uploads", h.HandleCreateUpload)
+	router.HEAD("/api/uploads/:id", h.HandleUploadStatus)
+	router.PATCH("/api/uploads/:id", h.HandleUploadChunk)
+	router.DELETE("/api/uploads/:id", h.HandleTerminateUpload)
+	return router
+}
+
+// addUpload registers an upload of MOCKUSERID expecting length bytes
+func addUpload(t *testing.T, store *MockUploadStore, id models.UploadID, length int64, expiresAt time.Time) {
+	t.Helper()
+
+	require.NoError(t, store.CreateUpload(context.Background(), &models.Upload{
+		ID:             id,
+		UserID:         MOCKUSERID,
+		Filename:       "photo.png",
+		ContentType:    "image/png",
+		Length:         length,
+		AllowDuplicate: true,
+		ExpiresAt:      expiresAt,
+	}))
+}
+
+func patchRequest(id models.UploadID, offset int64, body io.Reader) *http.Request {
+	req := httptest.NewRequest(http.MethodPatch, "/api/uploads/"+id, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	return req
+}
+
+// staged reads back the chunks staged for an upload, in order
+func staged(t *testing.T, h *UploadHandler, upload *models.Upload) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, chunkPath := range upload.ChunkPaths {
+		require.NoError(t, h.appendChunk(context.Background(), &buf, chunkPath))
+	}
+	return buf.Bytes()
+}
+
+// brokenBody returns data and then fails, like a connection dropped mid-request
+type brokenBody struct {
+	data []byte
+}
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	if len(b.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func TestHandleUploadChunkOffsets(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []*http.Request // Sent in order, only the last response is checked
+		wantStatus int
+		wantOffset string
+		wantStaged string
+	}{
+		{
+			name:       "first chunk",
+			chunks:     []*http.Request{patchRequest("up-1", 0, bytes.NewReader([]byte("hello ")))},
+			wantStatus: http.StatusNoContent,
+			wantOffset: "6",
+			wantStaged: "hello ",
+		},
+		{
+			name: "resumed at the current offset",
+			chunks: []*http.Request{
+				patchRequest("up-1", 0, bytes.NewReader([]byte("hello "))),
+				patchRequest("up-1", 6, bytes.NewReader([]byte("wor"))),
+			},
+			wantStatus: http.StatusNoContent,
+			wantOffset: "9",
+			wantStaged: "hello wor",
+		},
+		{
+			name: "stale offset is a conflict",
+			chunks: []*http.Request{
+				patchRequest("up-1", 0, bytes.NewReader([]byte("hello "))),
+				patchRequest("up-1", 0, bytes.NewReader([]byte("hello "))),
+			},
+			wantStatus: http.StatusConflict,
+			wantOffset: "6",
+			wantStaged: "hello ",
+		},
+		{
+			name:       "bytes past the declared length are ignored",
+			chunks:     []*http.Request{patchRequest("up-1", 0, bytes.NewReader([]byte("hello world, and more")))},
+			wantStatus: http.StatusUnsupportedMediaType, // Complete, but not an image
+			wantOffset: "11",
+		},
+		{
+			name:       "empty chunk",
+			chunks:     []*http.Request{patchRequest("up-1", 0, bytes.NewReader(nil))},
+			wantStatus: http.StatusNoContent,
+			wantOffset: "0",
+			wantStaged: "",
+		},
+		{
+			name:       "interrupted chunk keeps what was received",
+			chunks:     []*http.Request{patchRequest("up-1", 0, &brokenBody{data: []byte("hel")})},
+			wantStatus: http.StatusBadRequest,
+			wantOffset: "3",
+			wantStaged: "hel",
+		},
+		{
+			name: "resumed after an interrupted chunk",
+			chunks: []*http.Request{
+				patchRequest("up-1", 0, &brokenBody{data: []byte("hel")}),
+				patchRequest("up-1", 3, bytes.NewReader([]byte("lo "))),
+			},
+			wantStatus: http.StatusNoContent,
+			wantOffset: "6",
+			wantStaged: "hello ",
+		},
+		{
+			name:       "interrupted before any data",
+			chunks:     []*http.Request{patchRequest("up-1", 0, &brokenBody{})},
+			wantStatus: http.StatusBadRequest,
+			wantOffset: "0",
+			wantStaged: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, uploads, _ := newTestUploadHandler(t)
+			router := newTestUploadRouter(h)
+			addUpload(t, uploads, "up-1", int64(len("hello world")), time.Now().Add(time.Hour))
+
+			var w *httptest.ResponseRecorder
+			for _, req := range tt.chunks {
+				w = httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+			}
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Equal(t, tt.wantOffset, w.Header().Get("Upload-Offset"))
+
+			upload, err := uploads.GetUpload(context.Background(), MOCKUSERID, "up-1")
+			if tt.wantStatus == http.StatusUnsupportedMediaType {
+				// Content that is not an image cannot be fixed by resuming, the upload is discarded
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOffset, strconv.FormatInt(upload.Offset, 10))
+			assert.Equal(t, tt.wantStaged, string(staged(t, h, upload)))
+		})
+	}
+}
+
+func TestHandleUploadStatus(t *testing.T) {
+	h, uploads, _ := newTestUploadHandler(t)
+	router := newTestUploadRouter(h)
+	addUpload(t, uploads, "up-1", 11, time.Now().Add(time.Hour))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, patchRequest("up-1", 0, bytes.NewReader([]byte("hello "))))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	req := httptest.NewRequest(http.MethodHead, "/api/uploads/up-1", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "11", w.Header().Get("Upload-Length"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestUploadExpiry(t *testing.T) {
+	h, uploads, _ := newTestUploadHandler(t)
+	router := newTestUploadRouter(h)
+	addUpload(t, uploads, "expired", 11, time.Now().Add(time.Hour))
+	addUpload(t, uploads, "active", 11, time.Now().Add(time.Hour))
+
+	for _, id := range []models.UploadID{"expired", "active"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, patchRequest(id, 0, bytes.NewReader([]byte("hello "))))
+		require.Equal(t, http.StatusNoContent, w.Code)
+	}
+	expired, err := uploads.GetUpload(context.Background(), MOCKUSERID, "expired")
+	require.NoError(t, err)
+	uploads.mu.Lock()
+	uploads.uploads["expired"].ExpiresAt = time.Now().Add(-time.Minute)
+	uploads.mu.Unlock()
+
+	// Expired uploads cannot be resumed
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, patchRequest("expired", 6, bytes.NewReader([]byte("world"))))
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	h.CleanupExpiredUploads(context.Background())
+
+	_, err = uploads.GetUpload(context.Background(), MOCKUSERID, "expired")
+	assert.Error(t, err, "expired upload must be removed")
+	for _, chunkPath := range expired.ChunkPaths {
+		assert.False(t, fileExists(t, h.Images, chunkPath), "chunks of expired uploads must be deleted")
+	}
+
+	active, err := uploads.GetUpload(context.Background(), MOCKUSERID, "active")
+	require.NoError(t, err, "unexpired upload must be kept")
+	assert.Equal(t, "hello ", string(staged(t, h, active)))
+}
+
+func TestHandleCreateUploadQuota(t *testing.T) {
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("photo.png")) +
+		",filetype " + base64.StdEncoding.EncodeToString([]byte("image/png"))
+
+	tests := []struct {
+		name       string
+		quota      int64
+		used       int64 // Bytes of stored images
+		pending    []int64
+		expired    []int64
+		length     int64
+		wantStatus int
+	}{
+		{name: "unlimited", length: 1000, pending: []int64{5000}, wantStatus: http.StatusCreated},
+		{name: "fits", quota: 1000, used: 200, length: 800, wantStatus: http.StatusCreated},
+		{name: "exceeds remaining", quota: 1000, used: 200, length: 801, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "fits with other uploads", quota: 1000, used: 200, pending: []int64{300, 200}, length: 300, wantStatus: http.StatusCreated},
+		{name: "space held by other uploads", quota: 1000, used: 200, pending: []int64{300, 200}, length: 301, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "expired uploads hold no space", quota: 1000, used: 200, expired: []int64{800}, length: 800, wantStatus: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, uploads, images := newTestUploadHandler(t)
+			router := newTestUploadRouter(h)
+			images.quota = tt.quota
+			if tt.used > 0 {
+				images.images["stored"] = &models.ImageMetadata{ID: "stored", UserID: MOCKUSERID, Size: tt.used}
+			}
+			for i, length := range tt.pending {
+				addUpload(t, uploads, "pending-"+strconv.Itoa(i), length, time.Now().Add(time.Hour))
+			}
+			for i, length := range tt.expired {
+				addUpload(t, uploads, "expired-"+strconv.Itoa(i), length, time.Now().Add(-time.Minute))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/uploads", nil)
+			req.Header.Set("Tus-Resumable", tusVersion)
+			req.Header.Set("Upload-Length", strconv.FormatInt(tt.length, 10))
+			req.Header.Set("Upload-Metadata", metadata)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus == http.StatusCreated {
+				assert.NotEmpty(t, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestUploadCompletionQuota(t *testing.T) {
+	content := pngImage(t, 8, 8, color.RGBA{G: 200, A: 255})
+	size := int64(len(content))
+
+	tests := []struct {
+		name       string
+		concurrent int64 // Bytes recorded by another upload while this one was sent
+		wantStatus int
+	}{
+		{name: "fits", wantStatus: http.StatusNoContent},
+		{name: "used up since the upload was created", concurrent: 1, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, uploads, images := newTestUploadHandler(t)
+			router := newTestUploadRouter(h)
+			images.quota = size
+			addUpload(t, uploads, "up-1", size, time.Now().Add(time.Hour))
+			if tt.concurrent > 0 {
+				images.images["concurrent"] = &models.ImageMetadata{ID: "concurrent", UserID: MOCKUSERID, Size: tt.concurrent}
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, patchRequest("up-1", 0, bytes.NewReader(content)))
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			_, err := uploads.GetUpload(context.Background(), MOCKUSERID, "up-1")
+			if tt.wantStatus == http.StatusNoContent {
+				assert.NotEmpty(t, w.Header().Get("Image-Id"))
+				assert.Error(t, err, "completed uploads are removed")
+				return
+			}
+			assert.Empty(t, w.Header().Get("Image-Id"))
+			assert.NoError(t, err, "the upload is kept, it can complete once space is freed")
+		})
+	}
+}
+
+func TestUploadCompletionDuplicates(t *testing.T) {
+	content := pngImage(t, 8, 8, color.RGBA{R: 200, B: 200, A: 255})
+	size := int64(len(content))
+
+	tests := []struct {
+		name          string
+		metadata      string // Extra Upload-Metadata
+		wantDuplicate bool
+		wantImages    int
+	}{
+		{name: "identical content returns the existing image", wantDuplicate: true, wantImages: 1},
+		{name: "allow_duplicate stores a copy", metadata: ",allow_duplicate " + base64.StdEncoding.EncodeToString([]byte("true")), wantImages: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, uploads, images := newTestUploadHandler(t)
+			router := newTestUploadRouter(h)
+			existing, _, err := h.Images.storeImage(context.Background(), MOCKUSERID, "photo.png", "image/png", bytes.NewReader(content), size, false)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/uploads", nil)
+			req.Header.Set("Tus-Resumable", tusVersion)
+			req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+			req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("again.png"))+
+				",filetype "+base64.StdEncoding.EncodeToString([]byte("image/png"))+tt.metadata)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			uploadID := path.Base(w.Header().Get("Location"))
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, patchRequest(uploadID, 0, bytes.NewReader(content)))
+
+			assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+			assert.Len(t, images.images, tt.wantImages)
+			hash, err := storage.HashContent(bytes.NewReader(content))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantImages, images.refCount(hash), "copies share the stored blob")
+			_, err = uploads.GetUpload(context.Background(), MOCKUSERID, uploadID)
+			assert.Error(t, err, "completed uploads are removed")
+			if tt.wantDuplicate {
+				assert.Equal(t, existing.ID, w.Header().Get("Image-Id"))
+				assert.Equal(t, "true", w.Header().Get("Image-Duplicate"))
+				return
+			}
+			assert.NotEqual(t, existing.ID, w.Header().Get("Image-Id"))
+			assert.Empty(t, w.Header().Get("Image-Duplicate"))
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
 	"github.com/shivamkedia17/roshnii/shared/pkg/config"
 	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
 )
 
 // UserHandler manages user-related API operations
@@ -39,8 +40,69 @@ func (h *UserHandler) GetCurrentUser(c *gin.Context) {
 		return
 	}
 
-	// Return the entire user struct as is
-	c.JSON(http.StatusOK, user)
+	usage, err := h.DB.GetStorageUsage(c.Request.Context(), userID, h.Config.DefaultUserQuotaBytes)
+	if err != nil {
+		log.Printf("Error retrieving storage usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve storage usage"})
+		return
+	}
+
+	// Return the user profile together with their storage usage
+	c.JSON(http.StatusOK, userProfile{User: user, Storage: usage})
+}
+
+// userProfile is the response of GetCurrentUser
+type userProfile struct {
+	*models.User
+	Storage *models.StorageUsage `json:"storage"`
+}
+
+// GetSettings returns the authenticated user's preferences
+func (h *UserHandler) GetSettings(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	settings, err := h.DB.GetUserSettings(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings changes the authenticated user's preferences
+func (h *UserHandler) UpdateSettings(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	var req struct {
+		StripMetadata *bool `json:"strip_metadata"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.StripMetadata == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strip_metadata is required"})
+		return
+	}
+
+	settings := &models.UserSettings{StripMetadata: *req.StripMetadata}
+	if err := h.DB.UpdateUserSettings(c.Request.Context(), userID, settings); err != nil {
+		log.Printf("Error updating user settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
 }
 
 // Example of additional method (optional)
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
+	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// VersionHandler replaces the original file of images and restores previous ones. The
+// image keeps its ID, so its albums and links stay attached whichever file is current.
+// Files are written and derived images regenerated through the ImageHandler.
+type VersionHandler struct {
+	Config *config.Config
+	Images *ImageHandler
+}
+
+// NewVersionHandler creates a new VersionHandler instance
+func NewVersionHandler(config *config.Config, imageHandler *ImageHandler) *VersionHandler {
+	return &VersionHandler{
+		Config: config,
+		Images: imageHandler,
+	}
+}
+
+// imageHistory is an image with the files it had before, newest first
+type imageHistory struct {
+	Current  *models.ImageMetadata `json:"current"`
+	Versions []models.ImageVersion `json:"versions"`
+}
+
+// ListVersions returns an image's current file and its previous versions
+func (h *VersionHandler) ListVersions(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	imageID := c.Param("id")
+	meta, err := h.Images.DB.GetImageByID(c.Request.Context(), userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	versions, err := h.Images.DB.ListImageVersions(c.Request.Context(), imageID)
+	if err != nil {
+		log.Printf("Error listing versions of image %s: %v", imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, imageHistory{Current: meta, Versions: versions})
+}
+
+// ReplaceOriginal uploads a new file for an existing image, e.g. a better export of the
+// same photo. The previous file is kept as a version, with the edits it had; the new
+// file starts without edits.
+func (h *VersionHandler) ReplaceOriginal(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid 'file' field in form data"})
+		return
+	}
+	if fileHeader.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded file is empty"})
+		return
+	}
+	if fileHeader.Size > maxUploadSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("File size exceeds limit of %d MB", maxUploadSize/1024/1024)})
+		return
+	}
+	contentType := fileHeader.Header.Get("Content-Type")
+	if !declaredTypeAllowed(contentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported file type: %s", contentType)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	imageID := c.Param("id")
+	meta, err := h.Images.DB.GetImageByID(ctx, userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Error opening uploaded file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	checksum, err := storage.HashContent(file)
+	if err != nil {
+		log.Printf("Error hashing uploaded file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process uploaded file"})
+		return
+	}
+	if meta.Checksum != nil && *meta.Checksum == checksum {
+		c.JSON(http.StatusConflict, gin.H{"error": "File is identical to the current original"})
+		return
+	}
+
+	// The previous file is kept, so the new one counts in full
+	if err := h.Images.checkQuota(ctx, userID, fileHeader.Size); err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	ctx = storage.WithKeyOwner(ctx, userID)
+	replacement, err := h.Images.writeUpload(ctx, userID, fileHeader.Filename, contentType, file, fileHeader.Size, checksum)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	updated, err := h.Images.DB.ReplaceImageFile(ctx, userID, imageID, replacement, h.Images.Config.DefaultUserQuotaBytes)
+	if err != nil {
+		h.Images.discardUpload(ctx, replacement)
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		if err.Error() == "storage quota exceeded" {
+			respondUploadError(c, quotaExceededError(err))
+			return
+		}
+		log.Printf("Error replacing original of image %s: %v", imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record image information"})
+		return
+	}
+
+	log.Printf("Replaced original of image %s with version %d", imageID, updated.Version)
+	h.fileChanged(c, updated)
+	c.JSON(http.StatusOK, updated)
+}
+
+// RestoreVersion makes a previous version the image's current file again, with the
+// edits it had. The file it replaces is kept as a version in turn.
+func (h *VersionHandler) RestoreVersion(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	imageID := c.Param("id")
+	meta, err := h.Images.DB.GetImageByID(ctx, userID, imageID)
+	if err != nil {
+		if err.Error() == "image not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image metadata"})
+		return
+	}
+	if meta.Version == version {
+		c.JSON(http.StatusConflict, gin.H{"error": "Version is the current original already"})
+		return
+	}
+
+	restored, err := h.Images.DB.GetImageVersion(ctx, imageID, version)
+	if err != nil {
+		if err.Error() == "version not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image version"})
+		return
+	}
+
+	// The capture metadata of the restored file is read again, like on upload
+	file, _, err := h.Images.Storage.Open(storage.WithKeyOwner(ctx, userID), restored.StoragePath)
+	if err != nil {
+		log.Printf("Error opening version %d of image %s: %v", version, imageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve image version"})
+		return
+	}
+	exifData, err := imaging.ReadExif(file, restored.ContentType)
+	file.Close()
+	if err != nil {
+		// Left to backfill-exif, like images uploaded before EXIF data was read
+		log.Printf("Warning: Failed to read EXIF data of version %d of image %s: %v", version, imageID, err)
+		exifData = nil
+	} else if exifData == nil {
+		exifData = &models.ImageExif{}
+	}
+
+	updated, err := h.Images.DB.RestoreImageVersion(ctx, userID, imageID, version, exifData)
+	if err != nil {
+		switch err.Error() {
+		case "image not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		case "version not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		default:
+			log.Printf("Error restoring version %d of image %s: %v", version, imageID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+		}
+		return
+	}
+
+	log.Printf("Restored version %d of image %s", version, imageID)
+	h.fileChanged(c, updated)
+	c.JSON(http.StatusOK, updated)
+}
+
+// fileChanged replaces what was derived from the previous file. Until the new
+// renditions are ready the previous ones are served, and the faces found in the
+// previous file until they are detected again.
+func (h *VersionHandler) fileChanged(c *gin.Context, img *models.ImageMetadata) {
+	h.Images.Renditions.Regenerate(c.Request.Context(), *img)
+	h.Images.Derived.Delete(c.Request.Context(), img.ID)
+	h.Images.detectFaces(c.Request.Context(), img, fmt.Sprintf("detect-faces:%s:%d", img.ID, img.UpdatedAt.UnixNano()))
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+func (m *MockImageStore) ListImageVersions(ctx context.Context, imageID models.ImageID) ([]models.ImageVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions := slices.Clone(m.versions[imageID])
+	slices.SortFunc(versions, func(a, b models.ImageVersion) int { return b.Version - a.Version })
+	return versions, nil
+}
+
+func (m *MockImageStore) GetImageVersion(ctx context.Context, imageID models.ImageID, version int) (*models.ImageVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range m.versions[imageID] {
+		if v.Version == version {
+			return &v, nil
+		}
+	}
+	return nil, errors.New("version not found")
+}
+
+func (m *MockImageStore) RestoreImageVersion(ctx context.Context, userID models.UserID, imageID models.ImageID, version int, exif *models.ImageExif) (*models.ImageMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img, ok := m.images[imageID]
+	if !ok || img.UserID != userID {
+		return nil, errors.New("image not found")
+	}
+	i := slices.IndexFunc(m.versions[imageID], func(v models.ImageVersion) bool { return v.Version == version })
+	if i < 0 {
+		return nil, errors.New("version not found")
+	}
+	v := m.versions[imageID][i]
+
+	m.versions[imageID][i] = models.ImageVersion{
+		ImageID: imageID, UserID: userID, Version: img.Version, Filename: img.Filename, StoragePath: img.StoragePath,
+		BlobHash: img.BlobHash, Checksum: img.Checksum, ContentType: img.ContentType, Size: img.Size, Edits: img.Edits,
+		ReplacedAt: time.Now(),
+	}
+	restored := *img
+	restored.Version, restored.Filename, restored.StoragePath, restored.BlobHash = v.Version, v.Filename, v.StoragePath, v.BlobHash
+	restored.Checksum, restored.ContentType, restored.Size, restored.Edits = v.Checksum, v.ContentType, v.Size, v.Edits
+	restored.HasEdits, restored.UpdatedAt = len(v.Edits) > 0, time.Now()
+	m.images[imageID] = &restored
+	copied := restored
+	return &copied, nil
+}
+
+func newTestVersionHandler(t *testing.T) (*VersionHandler, *MockImageStore) {
+	t.Helper()
+
+	store := NewMockImageStore()
+	images, _ := newTestImageHandler(t, store)
+	return NewVersionHandler(images.Config, images), store
+}
+
+func newTestVersionRouter(h *VersionHandler) *gin.Engine {
+	router := newTestRouter()
+	router.POST("/api/images/:id/replace", h.ReplaceOriginal)
+	router.GET("/api/images/:id/versions", h.ListVersions)
+	router.POST("/api/images/:id/versions/:version/restore", h.RestoreVersion)
+	return router
+}
+
+// replaceRequest uploads content as the new original of an image
+func replaceRequest(t *testing.T, imageID models.ImageID, content []byte) *http.Request {
+	t.Helper()
+
+	body, contentType := multipartFile(t, "replacement.png", "image/png", content)
+	req := httptest.NewRequest(http.MethodPost, "/api/images/"+imageID+"/replace", body)
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func TestReplaceOriginalQuota(t *testing.T) {
+	original := pngImage(t, 8, 8, color.RGBA{B: 200, A: 255})
+	replacement := pngImage(t, 8, 8, color.RGBA{R: 200, G: 200, A: 255})
+	used := int64(len(original))
+	size := int64(len(replacement))
+
+	tests := []struct {
+		name       string
+		quota      int64
+		concurrent int64 // Bytes recorded by another upload after the quota was checked
+		wantStatus int
+	}{
+		// The original is kept as a version, so the replacement counts in full
+		{name: "fits next to the original", quota: used + size, wantStatus: http.StatusOK},
+		{name: "only fits instead of the original", quota: used + size - 1, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "taken by a concurrent upload", quota: used + size, concurrent: 1, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store := newTestVersionHandler(t)
+			router := newTestVersionRouter(h)
+			addImage(t, h.Images, store, "img-1", original, "image/png")
+			store.quota = tt.quota
+			if tt.concurrent > 0 {
+				store.beforeRecord = func() {
+					store.mu.Lock()
+					store.images["concurrent"] = &models.ImageMetadata{ID: "concurrent", UserID: MOCKUSERID, Size: tt.concurrent}
+					store.mu.Unlock()
+				}
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, replaceRequest(t, "img-1", replacement))
+
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			img, err := store.GetImageByID(context.Background(), MOCKUSERID, "img-1")
+			require.NoError(t, err)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, 2, img.Version)
+				assert.Equal(t, size, img.Size)
+				return
+			}
+			assert.Equal(t, 1, img.Version, "a refused replacement must leave the original current")
+			assert.Empty(t, store.versions["img-1"])
+
+			hash, err := storage.HashContent(bytes.NewReader(replacement))
+			require.NoError(t, err)
+			assert.Equal(t, -1, store.refCount(hash), "the refused file must be released")
+		})
+	}
+}
+
+func TestVersionHistory(t *testing.T) {
+	original := pngImage(t, 8, 8, color.RGBA{B: 200, A: 255})
+	replacement := pngImage(t, 8, 8, color.RGBA{R: 200, G: 200, A: 255})
+	rotate := models.ImageEdit{Op: models.EditRotate, Angle: 90}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		wantStatus  int
+		wantCurrent int // Version of the current file afterwards
+		wantEdits   []models.ImageEdit
+		check       func(t *testing.T, body []byte, store *MockImageStore)
+	}{
+		{
+			name:        "list",
+			method:      http.MethodGet,
+			path:        "/api/images/img-1/versions",
+			wantStatus:  http.StatusOK,
+			wantCurrent: 2,
+			check: func(t *testing.T, body []byte, store *MockImageStore) {
+				var resp imageHistory
+				require.NoError(t, json.Unmarshal(body, &resp))
+				assert.Equal(t, 2, resp.Current.Version)
+				require.Len(t, resp.Versions, 1)
+				assert.Equal(t, 1, resp.Versions[0].Version)
+				assert.Equal(t, []models.ImageEdit{rotate}, resp.Versions[0].Edits, "versions keep the edits they had")
+			},
+		},
+		{
+			name:        "restore",
+			method:      http.MethodPost,
+			path:        "/api/images/img-1/versions/1/restore",
+			wantStatus:  http.StatusOK,
+			wantCurrent: 1,
+			wantEdits:   []models.ImageEdit{rotate},
+			check: func(t *testing.T, body []byte, store *MockImageStore) {
+				var resp models.ImageMetadata
+				require.NoError(t, json.Unmarshal(body, &resp))
+				assert.Equal(t, 1, resp.Version)
+				assert.Equal(t, int64(len(original)), resp.Size)
+
+				// The replacement is kept as a version in turn
+				versions, err := store.ListImageVersions(context.Background(), "img-1")
+				require.NoError(t, err)
+				require.Len(t, versions, 1)
+				assert.Equal(t, 2, versions[0].Version)
+				assert.Equal(t, int64(len(replacement)), versions[0].Size)
+			},
+		},
+		{name: "list unknown image", method: http.MethodGet, path: "/api/images/missing/versions", wantStatus: http.StatusNotFound, wantCurrent: 2},
+		{name: "restore the current version", method: http.MethodPost, path: "/api/images/img-1/versions/2/restore", wantStatus: http.StatusConflict, wantCurrent: 2},
+		{name: "restore unknown version", method: http.MethodPost, path: "/api/images/img-1/versions/7/restore", wantStatus: http.StatusNotFound, wantCurrent: 2},
+		{name: "restore invalid version", method: http.MethodPost, path: "/api/images/img-1/versions/first/restore", wantStatus: http.StatusBadRequest, wantCurrent: 2},
+		{name: "restore on unknown image", method: http.MethodPost, path: "/api/images/missing/versions/1/restore", wantStatus: http.StatusNotFound, wantCurrent: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store := newTestVersionHandler(t)
+			router := newTestVersionRouter(h)
+			addImage(t, h.Images, store, "img-1", original, "image/png")
+			store.images["img-1"].Edits = []models.ImageEdit{rotate}
+
+			// Version 1 is kept, with its edits, when the original is replaced
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, replaceRequest(t, "img-1", replacement))
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+
+			img, err := store.GetImageByID(context.Background(), MOCKUSERID, "img-1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCurrent, img.Version)
+			assert.Equal(t, tt.wantEdits, img.Edits)
+			if tt.check != nil {
+				tt.check(t, w.Body.Bytes(), store)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware creates a Gin middleware that only lets through users whose email
+// is in the comma separated adminEmails list. It must run after AuthMiddleware.
+func AdminMiddleware(adminEmails string) gin.HandlerFunc {
+	admins := map[string]bool{}
+	for _, email := range strings.Split(adminEmails, ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		claims := GetUserClaims(c)
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		if !admins[strings.ToLower(claims.Email)] {
+			log.Printf("Denied admin access to user %s", claims.UserID)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		c.Next()
+	}
+}
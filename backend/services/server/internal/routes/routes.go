@@ -7,6 +7,7 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/shivamkedia17/roshnii/services/server/internal/handlers"
+	"github.com/shivamkedia17/roshnii/services/server/internal/middleware"
 	"github.com/shivamkedia17/roshnii/shared/pkg/config"
 )
 
@@ -36,6 +37,27 @@ func RegisterAlbumRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.Handle
 	}
 }
 
+// RegisterPeopleRoutes connects the routes of the people recognised in images
+func RegisterPeopleRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.PeopleHandler) {
+	peopleRoutes := routerGroup.Group("/people")
+	peopleRoutes.Use(authMiddleware)
+	{
+		peopleRoutes.GET("", h.ListPeople)                  // People in the most images first
+		peopleRoutes.GET("/:id", h.GetPerson)               // Person with counts and cover face
+		peopleRoutes.PATCH("/:id", h.RenamePerson)          // Name or unname
+		peopleRoutes.POST("/:id/merge", h.MergePeople)      // Move the faces of other people in
+		peopleRoutes.POST("/:id/split", h.SplitPerson)      // Move faces out into a new person
+		peopleRoutes.GET("/:id/images", h.ListPersonImages) // Timeline of the person
+		peopleRoutes.GET("/:id/faces", h.ListPersonFaces)
+	}
+
+	imageFaceRoutes := routerGroup.Group("/images/:id")
+	imageFaceRoutes.Use(authMiddleware)
+	{
+		imageFaceRoutes.GET("/faces", h.ListImageFaces) // Faces detected in the image, with their person
+	}
+}
+
 func RegisterImageRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.ImageHandler) {
 	imageRoutes := routerGroup.Group("/images")
 	imageRoutes.Use(authMiddleware)
@@ -45,6 +67,61 @@ func RegisterImageRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.Handle
 		imageRoutes.GET("/:id", h.HandleGetImage)               // Single image metadata
 		imageRoutes.DELETE("/:id", h.HandleDeleteImage)         // Delete image
 		imageRoutes.GET("/:id/download", h.HandleDownloadImage) // Download image file
+		imageRoutes.GET("/:id/thumbnail", h.HandleGetThumbnail) // Downscaled rendition, ?size=small|medium|large
+		imageRoutes.GET("/:id/render", h.HandleRenderImage)     // Resized on demand, ?w=&h=&fit=&format=&q=
+		imageRoutes.GET("/:id/url", h.HandleGenerateURL)        // Temporary URL usable without the auth cookie
+	}
+
+	// Signed URLs carry their own authorization, so they are served without the auth middleware
+	routerGroup.GET("/files/:token", h.HandleSignedDownload)
+	routerGroup.GET("/shared/:token", h.HandleSignedImage)
+}
+
+// RegisterDuplicateRoutes connects the near-duplicate routes
+func RegisterDuplicateRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.DuplicateHandler) {
+	duplicateRoutes := routerGroup.Group("/images/duplicates")
+	duplicateRoutes.Use(authMiddleware)
+	{
+		duplicateRoutes.GET("", h.ListDuplicates)             // Groups of look-alike images, ?distance=
+		duplicateRoutes.POST("/resolve", h.ResolveDuplicates) // Keep one image of a group, delete the others
+	}
+}
+
+// RegisterEditRoutes connects the routes editing images without modifying their original
+func RegisterEditRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.EditHandler) {
+	editRoutes := routerGroup.Group("/images/:id/edits")
+	editRoutes.Use(authMiddleware)
+	{
+		editRoutes.GET("", h.ListEdits)      // Edit stack, oldest first
+		editRoutes.POST("", h.ApplyEdit)     // Add an edit on top
+		editRoutes.POST("/undo", h.UndoEdit) // Remove the most recent edit
+		editRoutes.DELETE("", h.RevertEdits) // Revert to the original
+	}
+}
+
+// RegisterVersionRoutes connects the routes replacing the original of images and restoring previous ones
+func RegisterVersionRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.VersionHandler) {
+	versionRoutes := routerGroup.Group("/images/:id")
+	versionRoutes.Use(authMiddleware)
+	{
+		versionRoutes.POST("/replace", h.ReplaceOriginal)                  // Upload a new original, keeping the previous one
+		versionRoutes.GET("/versions", h.ListVersions)                     // Current and previous files, newest first
+		versionRoutes.POST("/versions/:version/restore", h.RestoreVersion) // Make a previous file current again
+	}
+}
+
+// RegisterUploadRoutes connects the resumable (tus) upload routes
+func RegisterUploadRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.UploadHandler) {
+	// Protocol discovery needs no session
+	routerGroup.OPTIONS("/uploads", h.HandleOptions)
+
+	uploadRoutes := routerGroup.Group("/uploads")
+	uploadRoutes.Use(authMiddleware)
+	{
+		uploadRoutes.POST("", h.HandleCreateUpload)          // Create upload
+		uploadRoutes.HEAD("/:id", h.HandleUploadStatus)      // Current offset, for resuming
+		uploadRoutes.PATCH("/:id", h.HandleUploadChunk)      // Append chunk
+		uploadRoutes.DELETE("/:id", h.HandleTerminateUpload) // Abort upload
 	}
 }
 
@@ -53,6 +130,8 @@ func RegisterUserRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.Handler
 	userRoutes.Use(authMiddleware)
 	{
 		userRoutes.GET("", authMiddleware, h.GetCurrentUser) // User Profile Info Endpoint
+		userRoutes.GET("/settings", h.GetSettings)           // Preferences, e.g. metadata stripping
+		userRoutes.PUT("/settings", h.UpdateSettings)
 	}
 
 	// If you want to add more user-related endpoints:
@@ -60,6 +139,19 @@ func RegisterUserRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.Handler
 	// router.GET("/users/:id", authMiddleware, h.GetUserByID) // For public profiles, if needed
 }
 
+// RegisterAdminRoutes connects the maintenance routes, restricted to ADMIN_EMAILS
+func RegisterAdminRoutes(routerGroup *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc, h *handlers.AdminHandler) {
+	adminRoutes := routerGroup.Group("/admin")
+	adminRoutes.Use(authMiddleware, adminMiddleware)
+	{
+		adminRoutes.GET("/scrub", h.HandleGetScrubReport)     // Last integrity scrub report
+		adminRoutes.POST("/scrub", h.HandleStartScrub)        // Start a scrub now
+		adminRoutes.GET("/cache", h.HandleGetCacheStats)      // Blob cache hit/miss statistics
+		adminRoutes.GET("/jobs", h.HandleListJobs)            // Background job counts and jobs by state
+		adminRoutes.POST("/jobs/:id/retry", h.HandleRetryJob) // Retry a dead job
+	}
+}
+
 // TODO
 // func RegisterSearchRoutes(routerGroup *gin.RouterGroup, authMiddleware gin.HandlerFunc, h *handlers.SearchHandler) {
 // 	searchRoutes := routerGroup.Group("/search")
@@ -102,8 +194,15 @@ func SetupRouter(cfg *config.Config, handlers *handlers.Handlers, authMiddleware
 	// 	"X-Requested-With", "X-CSRF-Token", "Access-Control-Allow-Origin",
 	// }
 
+	// Allow resumable and conditional downloads
+	corsConfig.AddAllowHeaders("Range", "If-Range", "If-None-Match", "If-Modified-Since")
+
+	// Allow resumable (tus) uploads
+	corsConfig.AddAllowHeaders("Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata")
+
 	// Add Access-Control-Expose-Headers to expose custom headers to the frontend
-	corsConfig.ExposeHeaders = []string{"Content-Length", "Content-Type"}
+	corsConfig.ExposeHeaders = []string{"Content-Length", "Content-Type", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified",
+		"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires", "Image-Id"}
 
 	router.Use(cors.New(corsConfig))
 
@@ -119,8 +218,14 @@ func SetupRouter(cfg *config.Config, handlers *handlers.Handlers, authMiddleware
 
 	RegisterAuthRoutes(api, authMiddleware, &handlers.OAuth)
 	RegisterImageRoutes(api, authMiddleware, &handlers.Img)
+	RegisterDuplicateRoutes(api, authMiddleware, &handlers.Dup)
+	RegisterEditRoutes(api, authMiddleware, &handlers.Edit)
+	RegisterVersionRoutes(api, authMiddleware, &handlers.Ver)
+	RegisterUploadRoutes(api, authMiddleware, &handlers.Upload)
 	RegisterAlbumRoutes(api, authMiddleware, &handlers.Album)
+	RegisterPeopleRoutes(api, authMiddleware, &handlers.People)
 	RegisterUserRoutes(api, authMiddleware, &handlers.User)
+	RegisterAdminRoutes(api, authMiddleware, middleware.AdminMiddleware(cfg.AdminEmails), &handlers.Admin)
 	// RegisterSearchRoutes()
 
 	// Serve frontend static files
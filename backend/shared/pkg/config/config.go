@@ -3,9 +3,13 @@ package config
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +33,10 @@ type Config struct {
 	PublicHost string `mapstructure:"PUBLIC_HOST"`
 	PublicPort string `mapstructure:"PUBLIC_PORT"`
 
+	// URL clients reach the application at, e.g. "https://photos.example.com", used in
+	// links handed out to them. Defaults to http://PUBLIC_HOST:PUBLIC_PORT.
+	PublicBaseURL string `mapstructure:"PUBLIC_BASE_URL"`
+
 	// --- Databases ---
 	PostgresURL string `mapstructure:"POSTGRES_URL"`
 	QdrantURL   string `mapstructure:"QDRANT_URL"`
@@ -39,12 +47,61 @@ type Config struct {
 	AWSRegion        string `mapstructure:"AWS_REGION"`
 	LocalstoragePath string `mapstructure:"LOCAL_STORAGE_PATH"`
 
+	// Local disk cache for files read from remote backends, disabled when the path is empty
+	BlobCachePath     string `mapstructure:"BLOB_CACHE_PATH"`
+	BlobCacheMaxBytes int64  `mapstructure:"BLOB_CACHE_MAX_BYTES"`
+
+	// Backend to read files from that are missing from BLOB_STORAGE_TYPE, while migrating between backends
+	BlobStorageFallbackType string `mapstructure:"BLOB_STORAGE_FALLBACK_TYPE"`
+
+	// Store uploads keyed by their SHA-256 so identical files are only stored once
+	BlobContentAddressed bool `mapstructure:"BLOB_CONTENT_ADDRESSED"`
+
+	// Storage quota for users without an override in users.quota_bytes, 0 means unlimited
+	DefaultUserQuotaBytes int64 `mapstructure:"DEFAULT_USER_QUOTA_BYTES"`
+
+	// Encryption at rest, enabled when a master key is set. Keys are base64 encoded 32 byte AES keys.
+	EncryptionMasterKey          string `mapstructure:"ENCRYPTION_MASTER_KEY"`
+	EncryptionPreviousMasterKeys string `mapstructure:"ENCRYPTION_PREVIOUS_MASTER_KEYS"` // Comma separated, kept until keys are rotated
+
+	// Number of images whose thumbnails and previews are generated at the same time,
+	// and separately of images rendered on demand by the render endpoint
+	RenditionWorkers int `mapstructure:"RENDITION_WORKERS"`
+
+	// faces service: number of jobs processed at the same time, port of its health
+	// endpoint, and how long jobs in progress may finish when it is stopped
+	FacesWorkers         int           `mapstructure:"FACES_WORKERS"`
+	FacesHealthPort      string        `mapstructure:"FACES_HEALTH_PORT"`
+	FacesDrainTimeoutStr string        `mapstructure:"FACES_DRAIN_TIMEOUT"`
+	FacesDrainTimeout    time.Duration `mapstructure:"-"`
+
+	// Comma separated widths and heights the render endpoint accepts
+	RenderSizesStr string `mapstructure:"RENDER_SIZES"`
+	RenderSizes    []int  `mapstructure:"-"`
+
+	// How often stored files are re-hashed to detect corruption, 0 disables the scrubber
+	ScrubIntervalStr string        `mapstructure:"SCRUB_INTERVAL"`
+	ScrubInterval    time.Duration `mapstructure:"-"`
+
+	// S3-compatible object storage (AWS S3, MinIO, ...), used when BLOB_STORAGE_TYPE=s3
+	S3Endpoint        string `mapstructure:"S3_ENDPOINT"` // e.g. "localhost:9000" for MinIO
+	S3AccessKeyID     string `mapstructure:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `mapstructure:"S3_SECRET_ACCESS_KEY"`
+	S3UseSSL          bool   `mapstructure:"S3_USE_SSL"`
+	S3ForcePathStyle  bool   `mapstructure:"S3_FORCE_PATH_STYLE"` // Needed for MinIO
+
 	// Authentication
 	JWTSecret        string        `mapstructure:"JWT_SECRET"`
 	JWTRefreshSecret string        `mapstructure:"JWT_REFRESH_SECRET"` // Add this line
 	TokenDurationStr string        `mapstructure:"TOKEN_DURATION"`
 	TokenDuration    time.Duration `mapstructure:"-"`
 
+	// Comma separated emails of users allowed to use the /api/admin endpoints
+	AdminEmails string `mapstructure:"ADMIN_EMAILS"`
+
+	// Key for signing expiring download URLs
+	URLSigningSecret string `mapstructure:"URL_SIGNING_SECRET"`
+
 	GoogleClientID     string `mapstructure:"GOOGLE_CLIENT_ID"`
 	GoogleClientSecret string `mapstructure:"GOOGLE_CLIENT_SECRET"`
 	FrontendURL        string `mapstructure:"FRONTEND_URL"`        // To redirect back after OAuth
@@ -63,9 +120,32 @@ func LoadConfig(path string) (*Config, error) {
 	viper.SetDefault("SERVER_PORT", "8080")
 	viper.SetDefault("PUBLIC_HOST", "127.0.0.1") // Default host
 	viper.SetDefault("PUBLIC_PORT", "8080")
+	viper.SetDefault("PUBLIC_BASE_URL", "")
 	viper.SetDefault("TOKEN_DURATION", "24h")
+	viper.SetDefault("URL_SIGNING_SECRET", "")
+	viper.SetDefault("ADMIN_EMAILS", "")
 	viper.SetDefault("BLOB_STORAGE_TYPE", "local")
 	viper.SetDefault("LOCAL_STORAGE_PATH", "./uploads")
+	viper.SetDefault("BLOB_STORAGE_FALLBACK_TYPE", "")
+	viper.SetDefault("BLOB_CACHE_PATH", "")
+	viper.SetDefault("BLOB_CACHE_MAX_BYTES", 1024*1024*1024) // 1 GiB
+	viper.SetDefault("BLOB_CONTENT_ADDRESSED", false)
+	viper.SetDefault("DEFAULT_USER_QUOTA_BYTES", 10*1024*1024*1024) // 10 GiB
+	viper.SetDefault("ENCRYPTION_MASTER_KEY", "")
+	viper.SetDefault("ENCRYPTION_PREVIOUS_MASTER_KEYS", "")
+	viper.SetDefault("SCRUB_INTERVAL", "24h")
+	viper.SetDefault("RENDITION_WORKERS", 2)
+	viper.SetDefault("RENDER_SIZES", defaultRenderSizes)
+	viper.SetDefault("FACES_WORKERS", 2)
+	viper.SetDefault("FACES_HEALTH_PORT", "8081")
+	viper.SetDefault("FACES_DRAIN_TIMEOUT", "30s")
+	viper.SetDefault("BLOB_BUCKET", "")
+	viper.SetDefault("AWS_REGION", "us-east-1")
+	viper.SetDefault("S3_ENDPOINT", "s3.amazonaws.com")
+	viper.SetDefault("S3_ACCESS_KEY_ID", "")
+	viper.SetDefault("S3_SECRET_ACCESS_KEY", "")
+	viper.SetDefault("S3_USE_SSL", true)
+	viper.SetDefault("S3_FORCE_PATH_STYLE", false)
 	viper.SetDefault("FRONTEND_URL", "http://localhost:5173")  // Default frontend URL
 	viper.SetDefault("FRONTEND_BUILD_PATH", "./frontend/dist") // Default frontend build path
 	viper.SetDefault("COOKIE_DOMAIN", "")                      // Default frontend domain
@@ -102,6 +182,30 @@ func LoadConfig(path string) (*Config, error) {
 	}
 	config.TokenDuration = duration
 
+	// Calculate ScrubInterval from string
+	scrubInterval, err := time.ParseDuration(config.ScrubIntervalStr)
+	if err != nil {
+		log.Printf("Invalid SCRUB_INTERVAL format: %v. Using default 24h.", err)
+		scrubInterval = 24 * time.Hour
+	}
+	config.ScrubInterval = scrubInterval
+
+	// Calculate FacesDrainTimeout from string
+	drainTimeout, err := time.ParseDuration(config.FacesDrainTimeoutStr)
+	if err != nil || drainTimeout < 0 {
+		log.Printf("Invalid FACES_DRAIN_TIMEOUT format: %v. Using default 30s.", err)
+		drainTimeout = 30 * time.Second
+	}
+	config.FacesDrainTimeout = drainTimeout
+
+	// Parse RenderSizes from string
+	renderSizes, err := parseSizes(config.RenderSizesStr)
+	if err != nil {
+		log.Printf("Invalid RENDER_SIZES: %v. Using default %s.", err, defaultRenderSizes)
+		renderSizes, _ = parseSizes(defaultRenderSizes)
+	}
+	config.RenderSizes = renderSizes
+
 	// Basic validation
 	if config.JWTSecret == "" {
 		config.JWTSecret = os.Getenv("JWT_SECRET")
@@ -126,6 +230,27 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	// Links handed out to clients are built from it, so a wrong value is not guessed around
+	if config.PublicBaseURL == "" {
+		config.PublicBaseURL = fmt.Sprintf("http://%s:%s", config.PublicHost, config.PublicPort)
+	}
+	config.PublicBaseURL = strings.TrimSuffix(config.PublicBaseURL, "/")
+	if base, err := url.Parse(config.PublicBaseURL); err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid PUBLIC_BASE_URL %q, use e.g. https://photos.example.com", config.PublicBaseURL)
+	}
+
+	// Check URL signing secret
+	if config.URLSigningSecret == "" {
+		// Signed URLs stop working on restart unless the secret is set
+		signingBytes := make([]byte, 32)
+		_, err := rand.Read(signingBytes)
+		if err != nil {
+			log.Fatal("Failed to generate URL_SIGNING_SECRET")
+		}
+		config.URLSigningSecret = base64.StdEncoding.EncodeToString(signingBytes)
+		log.Println("Warning: URL_SIGNING_SECRET not set, using generated value")
+	}
+
 	if config.GoogleClientID == "" {
 		config.GoogleClientID = os.Getenv("GOOGLE_CLIENT_ID")
 		log.Printf("Attempting direct env read for GOOGLE_CLIENT_ID: found=%v", config.GoogleClientID != "")
@@ -155,3 +280,26 @@ func LoadConfig(path string) (*Config, error) {
 
 	return &config, nil
 }
+
+// defaultRenderSizes covers common thumbnail, grid and screen sizes
+const defaultRenderSizes = "64,128,256,320,480,640,800,1024,1280,1600,1920,2048"
+
+// parseSizes parses a comma separated list of positive pixel sizes
+func parseSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		size, err := strconv.Atoi(part)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", part)
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, errors.New("no sizes")
+	}
+	return sizes, nil
+}
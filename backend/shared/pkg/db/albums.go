@@ -22,6 +22,10 @@ type AlbumStore interface {
 	AddImageToAlbum(ctx context.Context, userID models.UserID, albumID models.AlbumID, imageID models.ImageID) error      // Changed from models.AlbumID
 	RemoveImageFromAlbum(ctx context.Context, userID models.UserID, albumID models.AlbumID, imageID models.ImageID) error // Changed from models.AlbumID
 	ListImagesInAlbum(ctx context.Context, userID models.UserID, albumID models.AlbumID) ([]models.ImageMetadata, error)  // Changed from models.AlbumID
+
+	// MoveImageAlbums replaces an image by another in every album of the user, keeping its
+	// position, and returns the number of albums changed
+	MoveImageAlbums(ctx context.Context, userID models.UserID, fromImageID, toImageID models.ImageID) (int, error)
 }
 
 // --- AlbumStore Implementation ---
@@ -351,8 +355,7 @@ func (s *PostgresStore) ListImagesInAlbum(ctx context.Context, userID models.Use
 
 	// Then get all images in the album
 	query := `
-		SELECT i.id, i.user_id, i.filename, i.storage_path, i.content_type,
-		       i.size, i.width, i.height, i.created_at, i.updated_at
+		SELECT ` + imageColumns + `
 		FROM images i
 		JOIN album_images ai ON i.id = ai.image_id
 		WHERE ai.album_id = $1 AND i.user_id = $2
@@ -369,10 +372,7 @@ func (s *PostgresStore) ListImagesInAlbum(ctx context.Context, userID models.Use
 	var images []models.ImageMetadata
 	for rows.Next() {
 		var img models.ImageMetadata
-		err := rows.Scan(
-			&img.ID, &img.UserID, &img.Filename, &img.StoragePath, &img.ContentType,
-			&img.Size, &img.Width, &img.Height, &img.CreatedAt, &img.UpdatedAt,
-		)
+		err := scanImage(rows, &img)
 		if err != nil {
 			log.Printf("Error scanning image row: %v", err)
 			return nil, err
@@ -392,3 +392,50 @@ func (s *PostgresStore) ListImagesInAlbum(ctx context.Context, userID models.Use
 	}
 	return images, nil
 }
+
+// MoveImageAlbums moves the album memberships of an image onto another image
+func (s *PostgresStore) MoveImageAlbums(ctx context.Context, userID models.UserID, fromImageID, toImageID models.ImageID) (int, error) {
+	log.Printf("DB: MoveImageAlbums called for UserID: %s, From: %s, To: %s", userID, fromImageID, toImageID)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Albums list images by when they were added, so the earlier of the two dates is kept
+	insertQuery := `
+		INSERT INTO album_images (album_id, image_id, added_at)
+		SELECT ai.album_id, $3, ai.added_at
+		FROM album_images ai
+		JOIN albums a ON a.id = ai.album_id
+		WHERE a.user_id = $1 AND ai.image_id = $2
+		ON CONFLICT (album_id, image_id) DO UPDATE
+		SET added_at = LEAST(album_images.added_at, EXCLUDED.added_at)
+	`
+	if _, err := tx.Exec(ctx, insertQuery, userID, fromImageID, toImageID); err != nil {
+		if isForeignKeyViolation(err) {
+			return 0, errors.New("image not found")
+		}
+		log.Printf("Error adding image to albums: %v", err)
+		return 0, err
+	}
+
+	deleteQuery := `
+		DELETE FROM album_images ai
+		USING albums a
+		WHERE a.id = ai.album_id AND a.user_id = $1 AND ai.image_id = $2
+	`
+	result, err := tx.Exec(ctx, deleteQuery, userID, fromImageID)
+	if err != nil {
+		log.Printf("Error removing image from albums: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return 0, err
+	}
+	return int(result.RowsAffected()), nil
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// BlobStore defines operations on content-addressed blobs and their reference counts.
+//
+// A reference is taken with AcquireBlob before the file is written, and handed over to
+// the image or version recorded with it. Blobs whose last reference is released keep
+// their row with a zero count until DeleteUnreferencedBlob removes them with their file,
+// so a new upload of the same content never takes a reference to a file being deleted.
+type BlobStore interface {
+	// AcquireBlob takes a reference to the blob with a content hash, creating its row on
+	// first use. It reports whether the row was created, in which case the caller must
+	// write the file to storagePath. The returned blob has the storage path to use.
+	AcquireBlob(ctx context.Context, hash, storagePath string, size int64) (*models.Blob, bool, error)
+
+	// ReleaseBlob drops a reference taken by AcquireBlob that was not recorded with an image.
+	// It returns the blob if this was the last reference, which must then be deleted with
+	// DeleteUnreferencedBlob.
+	ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error)
+
+	// DeleteImageReleasingBlob deletes an image and drops its blob reference.
+	// It returns the blob if this was the last reference, which must then be deleted
+	// with DeleteUnreferencedBlob.
+	DeleteImageReleasingBlob(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.Blob, error)
+
+	// DeleteUnreferencedBlob removes a blob nothing references any more. deleteFile is
+	// called to remove its file while the row is locked, so that no upload takes a new
+	// reference meanwhile. Blobs referenced again since they were released are kept.
+	DeleteUnreferencedBlob(ctx context.Context, hash string, deleteFile func(storagePath string) error) error
+}
+
+// --- BlobStore Implementation ---
+
+// AcquireBlob takes a reference to a blob, creating its row if it has none
+func (s *PostgresStore) AcquireBlob(ctx context.Context, hash, storagePath string, size int64) (*models.Blob, bool, error) {
+	log.Printf("DB: AcquireBlob called for Hash: %s", hash)
+
+	// The upsert locks the row, so it waits for a DeleteUnreferencedBlob in progress and
+	// then either counts on a blob that is kept or recreates one that was deleted.
+	// xmax is only zero for rows this statement inserted.
+	query := `
+		INSERT INTO blobs (hash, storage_path, size, ref_count)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1
+		RETURNING hash, storage_path, size, ref_count, created_at, xmax = 0
+	`
+
+	var blob models.Blob
+	var created bool
+	err := s.Pool.QueryRow(ctx, query, hash, storagePath, size).Scan(
+		&blob.Hash, &blob.StoragePath, &blob.Size, &blob.RefCount, &blob.CreatedAt, &created,
+	)
+	if err != nil {
+		log.Printf("Error acquiring blob %s: %v", hash, err)
+		return nil, false, err
+	}
+
+	return &blob, created, nil
+}
+
+// ReleaseBlob drops a reference to a blob outside of an image deletion
+func (s *PostgresStore) ReleaseBlob(ctx context.Context, hash string) (*models.Blob, error) {
+	log.Printf("DB: ReleaseBlob called for Hash: %s", hash)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	orphan, err := releaseBlob(ctx, tx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+	return orphan, nil
+}
+
+// DeleteImageReleasingBlob removes an image row and decrements its blob's reference count
+func (s *PostgresStore) DeleteImageReleasingBlob(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.Blob, error) {
+	log.Printf("DB: DeleteImageReleasingBlob called for UserID: %s, ImageID: %s", userID, imageID)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var blobHash *string
+	err = tx.QueryRow(ctx, `DELETE FROM images WHERE user_id = $1 AND id = $2 RETURNING blob_hash`, userID, imageID).Scan(&blobHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("image not found")
+		}
+		log.Printf("Error deleting image metadata: %v", err)
+		return nil, err
+	}
+
+	var orphan *models.Blob
+	if blobHash != nil {
+		orphan, err = releaseBlob(ctx, tx, *blobHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+
+	log.Printf("DB: Successfully deleted metadata for image ID: %s", imageID)
+	return orphan, nil
+}
+
+// DeleteUnreferencedBlob deletes a blob row and its file if its reference count is still zero
+func (s *PostgresStore) DeleteUnreferencedBlob(ctx context.Context, hash string, deleteFile func(storagePath string) error) error {
+	log.Printf("DB: DeleteUnreferencedBlob called for Hash: %s", hash)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var storagePath string
+	err = tx.QueryRow(ctx, `SELECT storage_path FROM blobs WHERE hash = $1 AND ref_count <= 0 FOR UPDATE`, hash).Scan(&storagePath)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Referenced again, or deleted already
+			return nil
+		}
+		log.Printf("Error locking blob %s: %v", hash, err)
+		return err
+	}
+
+	// The row stays when the file cannot be deleted, a later release retries
+	if err := deleteFile(storagePath); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM blobs WHERE hash = $1`, hash); err != nil {
+		log.Printf("Error deleting unreferenced blob %s: %v", hash, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// releaseBlob drops a reference to a blob. The blob is returned if nothing references
+// it any more, so it can be deleted; otherwise nil is returned.
+func releaseBlob(ctx context.Context, tx pgx.Tx, hash string) (*models.Blob, error) {
+	var blob models.Blob
+	err := tx.QueryRow(ctx, `
+		UPDATE blobs SET ref_count = ref_count - 1
+		WHERE hash = $1
+		RETURNING hash, storage_path, size, ref_count, created_at
+	`, hash).Scan(&blob.Hash, &blob.StoragePath, &blob.Size, &blob.RefCount, &blob.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Nothing tracked this blob; there is no reference to release
+			return nil, nil
+		}
+		log.Printf("Error releasing blob %s: %v", hash, err)
+		return nil, err
+	}
+
+	if blob.RefCount > 0 {
+		return nil, nil
+	}
+	return &blob, nil
+}
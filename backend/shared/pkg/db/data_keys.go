@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// DataKeyStore defines operations on the wrapped data keys used for encryption at rest.
+type DataKeyStore interface {
+	GetDataKey(ctx context.Context, ownerID string) (*models.DataKey, error)
+
+	// CreateDataKey stores a new data key for an owner. If the owner already has one
+	// (e.g. created concurrently), the existing key is returned instead.
+	CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error)
+	ListDataKeys(ctx context.Context) ([]models.DataKey, error)
+
+	// RewrapDataKey replaces a wrapped key, as long as it is still wrapped by previousMasterKeyID
+	RewrapDataKey(ctx context.Context, ownerID string, wrappedKey []byte, masterKeyID, previousMasterKeyID string) error
+}
+
+// dataKeyColumns lists the data_keys columns in the order scanDataKey expects.
+const dataKeyColumns = `owner_id, wrapped_key, master_key_id, created_at, updated_at`
+
+func scanDataKey(row pgx.Row, key *models.DataKey) error {
+	return row.Scan(&key.OwnerID, &key.WrappedKey, &key.MasterKeyID, &key.CreatedAt, &key.UpdatedAt)
+}
+
+// --- DataKeyStore Implementation ---
+
+// GetDataKey retrieves the wrapped data key of an owner
+func (s *PostgresStore) GetDataKey(ctx context.Context, ownerID string) (*models.DataKey, error) {
+	query := `SELECT ` + dataKeyColumns + ` FROM data_keys WHERE owner_id = $1`
+
+	var key models.DataKey
+	err := scanDataKey(s.Pool.QueryRow(ctx, query, ownerID), &key)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("data key not found")
+		}
+		log.Printf("Error getting data key: %v", err)
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// CreateDataKey inserts a data key unless the owner already has one, and returns the stored key
+func (s *PostgresStore) CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error) {
+	log.Printf("DB: CreateDataKey called for OwnerID: %s", key.OwnerID)
+
+	query := `
+		INSERT INTO data_keys (owner_id, wrapped_key, master_key_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (owner_id) DO NOTHING
+	`
+	if _, err := s.Pool.Exec(ctx, query, key.OwnerID, key.WrappedKey, key.MasterKeyID); err != nil {
+		log.Printf("Error creating data key: %v", err)
+		return nil, err
+	}
+
+	// Read back whichever key won a concurrent insert
+	return s.GetDataKey(ctx, key.OwnerID)
+}
+
+// ListDataKeys retrieves every wrapped data key
+func (s *PostgresStore) ListDataKeys(ctx context.Context) ([]models.DataKey, error) {
+	query := `SELECT ` + dataKeyColumns + ` FROM data_keys ORDER BY owner_id`
+
+	rows, err := s.Pool.Query(ctx, query)
+	if err != nil {
+		log.Printf("Error querying data keys: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.DataKey
+	for rows.Next() {
+		var key models.DataKey
+		if err := scanDataKey(rows, &key); err != nil {
+			log.Printf("Error scanning data key row: %v", err)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating data key rows: %v", err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RewrapDataKey stores a data key wrapped by a new master key
+func (s *PostgresStore) RewrapDataKey(ctx context.Context, ownerID string, wrappedKey []byte, masterKeyID, previousMasterKeyID string) error {
+	query := `
+		UPDATE data_keys
+		SET wrapped_key = $2, master_key_id = $3
+		WHERE owner_id = $1 AND master_key_id = $4
+	`
+
+	result, err := s.Pool.Exec(ctx, query, ownerID, wrappedKey, masterKeyID, previousMasterKeyID)
+	if err != nil {
+		log.Printf("Error rewrapping data key: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("data key was modified concurrently")
+	}
+
+	return nil
+}
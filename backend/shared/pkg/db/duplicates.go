@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// DuplicateStore defines operations used to find exact and near-duplicate images.
+type DuplicateStore interface {
+	// FindImageByChecksum retrieves the user's oldest image with the given content checksum
+	FindImageByChecksum(ctx context.Context, userID models.UserID, checksum string) (*models.ImageMetadata, error)
+
+	SetPerceptualHash(ctx context.Context, imageID models.ImageID, hash uint64) error
+	// ListPerceptualHashes retrieves the hashes of a user's images, skipping images not hashed yet
+	ListPerceptualHashes(ctx context.Context, userID models.UserID) ([]models.PerceptualHash, error)
+
+	// ListImagesWithoutPerceptualHash retrieves a page of images, across all users, that
+	// were never hashed, ordered by ID
+	ListImagesWithoutPerceptualHash(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+}
+
+// --- DuplicateStore Implementation ---
+
+// FindImageByChecksum retrieves a user's image with identical content. Images uploaded
+// before checksums were recorded are only found once the scrubber has recorded theirs.
+func (s *PostgresStore) FindImageByChecksum(ctx context.Context, userID models.UserID, checksum string) (*models.ImageMetadata, error) {
+	log.Printf("DB: FindImageByChecksum called for UserID: %s", userID)
+
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE i.user_id = $1 AND i.checksum = $2
+        ORDER BY i.created_at
+        LIMIT 1`
+
+	var img models.ImageMetadata
+	err := scanImage(s.Pool.QueryRow(ctx, query, userID, checksum), &img)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("image not found")
+		}
+		return nil, err
+	}
+	return &img, nil
+}
+
+// SetPerceptualHash records the perceptual hash of an image. Hashes are stored as the
+// signed BIGINT with the same bits.
+func (s *PostgresStore) SetPerceptualHash(ctx context.Context, imageID models.ImageID, hash uint64) error {
+	query := `UPDATE images SET phash = $2 WHERE id = $1`
+
+	result, err := s.Pool.Exec(ctx, query, imageID, int64(hash))
+	if err != nil {
+		log.Printf("Error setting perceptual hash: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+	return nil
+}
+
+// ListPerceptualHashes retrieves the perceptual hashes of a user's images
+func (s *PostgresStore) ListPerceptualHashes(ctx context.Context, userID models.UserID) ([]models.PerceptualHash, error) {
+	log.Printf("DB: ListPerceptualHashes called for UserID: %s", userID)
+
+	query := `
+		SELECT id, phash
+		FROM images
+		WHERE user_id = $1 AND phash IS NOT NULL
+		ORDER BY created_at
+	`
+
+	rows, err := s.Pool.Query(ctx, query, userID)
+	if err != nil {
+		log.Printf("Error querying perceptual hashes: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []models.PerceptualHash{}
+	for rows.Next() {
+		var h models.PerceptualHash
+		var stored int64
+		if err := rows.Scan(&h.ImageID, &stored); err != nil {
+			log.Printf("Error scanning perceptual hash row: %v", err)
+			return nil, err
+		}
+		h.Hash = uint64(stored)
+		hashes = append(hashes, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating perceptual hash rows: %v", err)
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// ListImagesWithoutPerceptualHash retrieves a page of images that were never hashed
+func (s *PostgresStore) ListImagesWithoutPerceptualHash(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE i.phash IS NULL AND i.id > $1
+        ORDER BY i.id
+        LIMIT $2`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, afterID, limit)
+}
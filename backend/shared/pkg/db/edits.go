@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// EditStore defines operations on the edit stacks of images. Each operation returns
+// the image as updated.
+type EditStore interface {
+	// AppendImageEdit adds an edit on top of an image's edit stack, failing with "too many
+	// edits" if the stack already holds maxEdits
+	AppendImageEdit(ctx context.Context, userID models.UserID, imageID models.ImageID, edit models.ImageEdit, maxEdits int) (*models.ImageMetadata, error)
+	// UndoImageEdit removes the most recent edit, failing with "no edits" if there is none
+	UndoImageEdit(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error)
+	// ClearImageEdits removes every edit, reverting the image to its original
+	ClearImageEdits(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error)
+}
+
+// --- EditStore Implementation ---
+
+// AppendImageEdit appends an edit to an image's edit stack. The limit is checked in the
+// update itself, so concurrent edits cannot push the stack past it.
+func (s *PostgresStore) AppendImageEdit(ctx context.Context, userID models.UserID, imageID models.ImageID, edit models.ImageEdit, maxEdits int) (*models.ImageMetadata, error) {
+	log.Printf("DB: AppendImageEdit called for UserID: %s, ImageID: %s, Op: %s", userID, imageID, edit.Op)
+
+	query := `
+        UPDATE images i
+        SET edits = i.edits || $3::jsonb
+        WHERE i.user_id = $1 AND i.id = $2 AND jsonb_array_length(i.edits) < $4
+        RETURNING ` + imageColumns
+
+	img, err := s.updateImageEdits(ctx, query, userID, imageID, []models.ImageEdit{edit}, maxEdits)
+	if err != nil && err.Error() == "image not found" {
+		// Either there is no such image or its edit stack is full
+		if _, getErr := s.GetImageByID(ctx, userID, imageID); getErr == nil {
+			return nil, errors.New("too many edits")
+		}
+	}
+	return img, err
+}
+
+// UndoImageEdit removes the last edit of an image's edit stack
+func (s *PostgresStore) UndoImageEdit(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error) {
+	log.Printf("DB: UndoImageEdit called for UserID: %s, ImageID: %s", userID, imageID)
+
+	query := `
+        UPDATE images i
+        SET edits = i.edits - (jsonb_array_length(i.edits) - 1)
+        WHERE i.user_id = $1 AND i.id = $2 AND jsonb_array_length(i.edits) > 0
+        RETURNING ` + imageColumns
+
+	img, err := s.updateImageEdits(ctx, query, userID, imageID)
+	if err != nil && err.Error() == "image not found" {
+		// Either there is no such image or it has nothing to undo
+		if _, getErr := s.GetImageByID(ctx, userID, imageID); getErr == nil {
+			return nil, errors.New("no edits")
+		}
+	}
+	return img, err
+}
+
+// ClearImageEdits empties an image's edit stack
+func (s *PostgresStore) ClearImageEdits(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error) {
+	log.Printf("DB: ClearImageEdits called for UserID: %s, ImageID: %s", userID, imageID)
+
+	query := `
+        UPDATE images i
+        SET edits = '[]'
+        WHERE i.user_id = $1 AND i.id = $2
+        RETURNING ` + imageColumns
+
+	return s.updateImageEdits(ctx, query, userID, imageID)
+}
+
+// updateImageEdits runs an update of a single image returning imageColumns
+func (s *PostgresStore) updateImageEdits(ctx context.Context, query string, args ...any) (*models.ImageMetadata, error) {
+	var img models.ImageMetadata
+	if err := scanImage(s.Pool.QueryRow(ctx, query, args...), &img); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("image not found")
+		}
+		log.Printf("Error updating image edits: %v", err)
+		return nil, err
+	}
+	return &img, nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// ExifStore defines operations on the capture metadata of images.
+type ExifStore interface {
+	GetImageExif(ctx context.Context, imageID models.ImageID) (*models.ImageExif, error)
+	// SaveImageExif records an image's EXIF data and its capture time, replacing previous data
+	SaveImageExif(ctx context.Context, meta *models.ImageExif) error
+
+	// ListImagesWithoutExif retrieves a page of images, across all users, whose EXIF
+	// data was never read, ordered by ID
+	ListImagesWithoutExif(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+}
+
+// exifColumns lists the image_exif columns in the order scanExif expects.
+const exifColumns = `image_id, taken_at, camera_make, camera_model, lens_model, focal_length, f_number,
+        exposure_time, iso, orientation, latitude, longitude, altitude`
+
+func scanExif(row pgx.Row, meta *models.ImageExif) error {
+	return row.Scan(
+		&meta.ImageID, &meta.TakenAt, &meta.CameraMake, &meta.CameraModel, &meta.LensModel, &meta.FocalLength, &meta.FNumber,
+		&meta.ExposureTime, &meta.ISO, &meta.Orientation, &meta.Latitude, &meta.Longitude, &meta.Altitude,
+	)
+}
+
+// saveExifQuery inserts or replaces a row of image_exif, with the arguments in exifColumns order
+const saveExifQuery = `
+	INSERT INTO image_exif (` + exifColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	ON CONFLICT (image_id) DO UPDATE
+	SET taken_at = EXCLUDED.taken_at, camera_make = EXCLUDED.camera_make, camera_model = EXCLUDED.camera_model,
+	    lens_model = EXCLUDED.lens_model, focal_length = EXCLUDED.focal_length, f_number = EXCLUDED.f_number,
+	    exposure_time = EXCLUDED.exposure_time, iso = EXCLUDED.iso, orientation = EXCLUDED.orientation,
+	    latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude, altitude = EXCLUDED.altitude
+`
+
+// insertExif writes an image's EXIF row as part of a transaction
+func insertExif(ctx context.Context, tx pgx.Tx, meta *models.ImageExif) error {
+	_, err := tx.Exec(ctx, saveExifQuery,
+		meta.ImageID, meta.TakenAt, meta.CameraMake, meta.CameraModel, meta.LensModel, meta.FocalLength, meta.FNumber,
+		meta.ExposureTime, meta.ISO, meta.Orientation, meta.Latitude, meta.Longitude, meta.Altitude,
+	)
+	return err
+}
+
+// --- ExifStore Implementation ---
+
+// GetImageExif retrieves the EXIF data of an image
+func (s *PostgresStore) GetImageExif(ctx context.Context, imageID models.ImageID) (*models.ImageExif, error) {
+	query := `SELECT ` + exifColumns + ` FROM image_exif WHERE image_id = $1`
+
+	var meta models.ImageExif
+	err := scanExif(s.Pool.QueryRow(ctx, query, imageID), &meta)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("exif not found")
+		}
+		log.Printf("Error getting image exif: %v", err)
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// SaveImageExif stores the EXIF data of an image and copies its capture time to the image for sorting
+func (s *PostgresStore) SaveImageExif(ctx context.Context, meta *models.ImageExif) error {
+	log.Printf("DB: SaveImageExif called for ImageID: %s", meta.ImageID)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `UPDATE images SET taken_at = $2 WHERE id = $1`, meta.ImageID, meta.TakenAt)
+	if err != nil {
+		log.Printf("Error setting image taken_at: %v", err)
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+
+	if err := insertExif(ctx, tx, meta); err != nil {
+		log.Printf("Error saving image exif: %v", err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListImagesWithoutExif retrieves a page of images that have no image_exif row
+func (s *PostgresStore) ListImagesWithoutExif(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE NOT EXISTS (SELECT 1 FROM image_exif e WHERE e.image_id = i.id)
+          AND i.id > $1
+        ORDER BY i.id
+        LIMIT $2`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, afterID, limit)
+}
@@ -12,43 +12,138 @@ import (
 
 // ImageStore defines operations specific to images.
 type ImageStore interface {
-	CreateImageMetadata(ctx context.Context, meta *models.ImageMetadata) error
-	ListImagesByUserID(ctx context.Context, userID models.UserID) ([]models.ImageMetadata, error)
+	// CreateImageMetadata records an uploaded image. A content-addressed image takes over
+	// the reference to its blob taken with AcquireBlob. It fails with "storage quota exceeded"
+	// if the image does not fit in the owner's quota, defaultQuota unless overridden (0 is unlimited).
+	CreateImageMetadata(ctx context.Context, meta *models.ImageMetadata, defaultQuota int64) error
+	ListImagesByUserID(ctx context.Context, userID models.UserID, order ImageOrder) ([]models.ImageMetadata, error)
 	GetImageByID(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error)
 	DeleteImageByID(ctx context.Context, userID models.UserID, imageID models.ImageID) error // Add this line
+
+	// Storage backend migration, across all users. Images whose backend is not
+	// tracked yet are included in the images of every backend.
+	ListImagesInStorageBackend(ctx context.Context, backend string, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+	CountImagesInStorageBackend(ctx context.Context, backend string) (int, error)
+	// SetImageStorageBackend records that an image was moved, as long as its storage path did not change meanwhile
+	SetImageStorageBackend(ctx context.Context, imageID models.ImageID, storagePath, backend string) error
+
+	// Integrity checks, across all users
+	ListAllImages(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+	// SetImageChecksum records the checksum of an image uploaded before checksums were recorded
+	SetImageChecksum(ctx context.Context, imageID models.ImageID, checksum string) error
+
+	// Dimensions backfill, across all users
+	ListImagesWithoutDimensions(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+	SetImageDimensions(ctx context.Context, imageID models.ImageID, width, height int) error
+
+	// Content-addressed blobs referenced by images
+	BlobStore
+
+	// Downscaled copies, removed from blob storage together with the image
+	RenditionStore
+
+	// Capture metadata, shown with single images
+	ExifStore
+
+	// Perceptual hashes, for finding near-duplicates
+	DuplicateStore
+
+	// Non-destructive edit stacks
+	EditStore
+
+	// Files replaced by a newer original
+	VersionStore
+
+	// Quota checks before accepting uploads
+	QuotaStore
+
+	// Privacy preferences applied when delivering images
+	SettingsStore
+}
+
+// ImageOrder is the order images are listed in
+type ImageOrder string
+
+const (
+	OrderByTaken    ImageOrder = "taken"    // Newest capture time first, images without one by upload time
+	OrderByUploaded ImageOrder = "uploaded" // Most recently uploaded first
+)
+
+// imageColumns lists the images columns (aliased as i) in the order scanImage expects.
+const imageColumns = `i.id, i.user_id, i.filename, i.storage_path, i.storage_backend, i.blob_hash, i.checksum, i.content_type,
+        i.size, i.width, i.height, i.taken_at, i.blurhash, i.dominant_color, i.edits, i.version, i.created_at, i.updated_at`
+
+// scanImage scans a row selected with imageColumns.
+func scanImage(row pgx.Row, img *models.ImageMetadata) error {
+	err := row.Scan(
+		&img.ID, &img.UserID, &img.Filename, &img.StoragePath, &img.StorageBackend, &img.BlobHash, &img.Checksum, &img.ContentType,
+		&img.Size, &img.Width, &img.Height, &img.TakenAt, &img.BlurHash, &img.DominantColor, &img.Edits, &img.Version, &img.CreatedAt, &img.UpdatedAt,
+	)
+	img.HasEdits = len(img.Edits) > 0
+	return err
 }
 
 // --- ImageStore Implementation ---
 
 // CreateImageMetadata inserts metadata about a newly uploaded image.
-func (s *PostgresStore) CreateImageMetadata(ctx context.Context, meta *models.ImageMetadata) error {
+func (s *PostgresStore) CreateImageMetadata(ctx context.Context, meta *models.ImageMetadata, defaultQuota int64) error {
 	log.Printf("DB: CreateImageMetadata called for UserID: %s, Filename: %s, ImageID: %s", meta.UserID, meta.Filename, meta.ID)
 
 	query := `
-        INSERT INTO images (id, user_id, filename, storage_path, content_type, size, width, height)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+        INSERT INTO images (id, user_id, filename, storage_path, storage_backend, blob_hash, checksum, content_type, size, width, height, taken_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
 
-	_, err := s.Pool.Exec(ctx, query,
-		meta.ID, meta.UserID, meta.Filename, meta.StoragePath, meta.ContentType,
+	if err := reserveQuota(ctx, tx, meta.UserID, meta.Size, defaultQuota); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query,
+		meta.ID, meta.UserID, meta.Filename, meta.StoragePath, meta.StorageBackend, meta.BlobHash, meta.Checksum, meta.ContentType,
 		meta.Size, meta.Width, meta.Height, // Width/Height can be null if not provided
+		meta.TakenAt,
 	)
 	if err != nil {
 		log.Printf("Error inserting image metadata: %v", err)
 		return err
 	}
+
+	if meta.Exif != nil {
+		meta.Exif.ImageID = meta.ID
+		if err := insertExif(ctx, tx, meta.Exif); err != nil {
+			log.Printf("Error inserting image exif: %v", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return err
+	}
 	log.Printf("DB: Successfully inserted metadata for image ID: %s", meta.ID)
 	return nil
 }
 
 // ListImagesByUserID retrieves all image metadata for a specific user.
-func (s *PostgresStore) ListImagesByUserID(ctx context.Context, userID models.UserID) ([]models.ImageMetadata, error) {
-	log.Printf("DB: ListImagesByUserID called for UserID: %s", userID)
+func (s *PostgresStore) ListImagesByUserID(ctx context.Context, userID models.UserID, order ImageOrder) ([]models.ImageMetadata, error) {
+	log.Printf("DB: ListImagesByUserID called for UserID: %s, Order: %s", userID, order)
+
+	orderBy := "COALESCE(i.taken_at, i.created_at) DESC, i.created_at DESC"
+	if order == OrderByUploaded {
+		orderBy = "i.created_at DESC"
+	}
 
 	query := `
-        SELECT id, user_id, filename, storage_path, content_type, size, width, height, created_at, updated_at
-        FROM images
-        WHERE user_id = $1
-        ORDER BY created_at DESC`
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE i.user_id = $1
+        ORDER BY ` + orderBy
 
 	rows, err := s.Pool.Query(ctx, query, userID)
 	if err != nil {
@@ -62,10 +157,7 @@ func (s *PostgresStore) ListImagesByUserID(ctx context.Context, userID models.Us
 		var img models.ImageMetadata
 		// Nullable columns (width, height, storage_path) need care, though pgx handles *sql.Null types well.
 		// Ensure Scan parameters match SELECT order.
-		err := rows.Scan(
-			&img.ID, &img.UserID, &img.Filename, &img.StoragePath, &img.ContentType,
-			&img.Size, &img.Width, &img.Height, &img.CreatedAt, &img.UpdatedAt,
-		)
+		err := scanImage(rows, &img)
 		if err != nil {
 			log.Printf("Error scanning image row: %v", err)
 			// Decide whether to return partial results or fail completely
@@ -93,15 +185,12 @@ func (s *PostgresStore) GetImageByID(ctx context.Context, userID models.UserID,
 	log.Printf("DB: GetImageByID called for UserID: %s ImageID: %s", userID, imageID)
 
 	query := `
-        SELECT id, user_id, filename, storage_path, content_type, size, width, height, created_at, updated_at
-        FROM images
-        WHERE user_id = $1 AND id = $2`
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE i.user_id = $1 AND i.id = $2`
 
 	var img models.ImageMetadata
-	err := s.Pool.QueryRow(ctx, query, userID, imageID).Scan(
-		&img.ID, &img.UserID, &img.Filename, &img.StoragePath, &img.ContentType,
-		&img.Size, &img.Width, &img.Height, &img.CreatedAt, &img.UpdatedAt,
-	)
+	err := scanImage(s.Pool.QueryRow(ctx, query, userID, imageID), &img)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("image not found")
@@ -135,3 +224,156 @@ func (s *PostgresStore) DeleteImageByID(ctx context.Context, userID models.UserI
 	log.Printf("DB: Successfully deleted metadata for image ID: %s", imageID)
 	return nil
 }
+
+// ListImagesInStorageBackend retrieves a page of images stored in a backend, ordered by ID
+func (s *PostgresStore) ListImagesInStorageBackend(ctx context.Context, backend string, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE (i.storage_backend = $1 OR i.storage_backend IS NULL)
+          AND i.id > $2
+        ORDER BY i.id
+        LIMIT $3`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, backend, afterID, limit)
+}
+
+// CountImagesInStorageBackend counts the images stored in a backend
+func (s *PostgresStore) CountImagesInStorageBackend(ctx context.Context, backend string) (int, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM images
+        WHERE storage_backend = $1 OR storage_backend IS NULL`
+
+	var count int
+	if err := s.Pool.QueryRow(ctx, query, backend).Scan(&count); err != nil {
+		log.Printf("Error counting images in storage backend %s: %v", backend, err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// SetImageStorageBackend updates the backend an image is stored in
+func (s *PostgresStore) SetImageStorageBackend(ctx context.Context, imageID models.ImageID, storagePath, backend string) error {
+	log.Printf("DB: SetImageStorageBackend called for ImageID: %s, Backend: %s", imageID, backend)
+
+	query := `
+		UPDATE images
+		SET storage_backend = $3
+		WHERE id = $1 AND storage_path = $2
+	`
+
+	result, err := s.Pool.Exec(ctx, query, imageID, storagePath, backend)
+	if err != nil {
+		log.Printf("Error updating image storage backend: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+
+	return nil
+}
+
+// firstImageID is the nil UUID, which sorts before every other image ID
+const firstImageID = "00000000-0000-0000-0000-000000000000"
+
+// ListAllImages retrieves a page of every user's images, ordered by ID
+func (s *PostgresStore) ListAllImages(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE i.id > $1
+        ORDER BY i.id
+        LIMIT $2`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, afterID, limit)
+}
+
+// SetImageChecksum stores an image's checksum unless one is already recorded
+func (s *PostgresStore) SetImageChecksum(ctx context.Context, imageID models.ImageID, checksum string) error {
+	query := `
+		UPDATE images
+		SET checksum = $2
+		WHERE id = $1 AND checksum IS NULL
+	`
+
+	if _, err := s.Pool.Exec(ctx, query, imageID, checksum); err != nil {
+		log.Printf("Error setting image checksum: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListImagesWithoutDimensions retrieves a page of images whose width or height is unknown, ordered by ID
+func (s *PostgresStore) ListImagesWithoutDimensions(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE (COALESCE(i.width, 0) = 0 OR COALESCE(i.height, 0) = 0)
+          AND i.id > $1
+        ORDER BY i.id
+        LIMIT $2`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, afterID, limit)
+}
+
+// SetImageDimensions stores the width and height of an image
+func (s *PostgresStore) SetImageDimensions(ctx context.Context, imageID models.ImageID, width, height int) error {
+	query := `
+		UPDATE images
+		SET width = $2, height = $3
+		WHERE id = $1
+	`
+
+	result, err := s.Pool.Exec(ctx, query, imageID, width, height)
+	if err != nil {
+		log.Printf("Error setting image dimensions: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+	return nil
+}
+
+// queryImages runs a query selecting imageColumns and scans every row
+func (s *PostgresStore) queryImages(ctx context.Context, query string, args ...any) ([]models.ImageMetadata, error) {
+	rows, err := s.Pool.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error querying images: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.ImageMetadata
+	for rows.Next() {
+		var img models.ImageMetadata
+		if err := scanImage(rows, &img); err != nil {
+			log.Printf("Error scanning image row: %v", err)
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating image rows: %v", err)
+		return nil, err
+	}
+
+	return images, nil
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// columnRow is a pgx.Row whose values are given by column name, and scanned in the order
+// the columns are listed in a query
+type columnRow struct {
+	columns []string
+	values  map[string]any
+}
+
+func (r columnRow) Scan(dest ...any) error {
+	if len(dest) != len(r.columns) {
+		return fmt.Errorf("%d columns scanned into %d values", len(r.columns), len(dest))
+	}
+	for i, column := range r.columns {
+		value, ok := r.values[column]
+		if !ok {
+			continue
+		}
+		target := reflect.ValueOf(dest[i]).Elem()
+		if !reflect.TypeOf(value).AssignableTo(target.Type()) {
+			return fmt.Errorf("column %s: cannot scan %T into %s", column, value, target.Type())
+		}
+		target.Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// selectedColumns returns the names of the columns of a SELECT list like imageColumns
+func selectedColumns(list string) []string {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		column = strings.TrimSpace(column)
+		columns = append(columns, column[strings.LastIndex(column, ".")+1:])
+	}
+	return columns
+}
+
+func TestScanImage(t *testing.T) {
+	blurHash := "LDTSUA_3fQ_3~qoffQoffQfQfQfQ"
+	dominantColor := "#ffffff"
+	row := columnRow{columns: selectedColumns(imageColumns), values: map[string]any{
+		"id":             "image-1",
+		"user_id":        "user-1",
+		"filename":       "photo.jpg",
+		"width":          640,
+		"height":         480,
+		"blurhash":       &blurHash,
+		"dominant_color": &dominantColor,
+		"edits":          []models.ImageEdit{{Op: models.EditRotate, Angle: 90}},
+		"version":        2,
+	}}
+
+	var img models.ImageMetadata
+	require.NoError(t, scanImage(row, &img))
+
+	assert.Equal(t, "image-1", img.ID)
+	assert.Equal(t, 640, img.Width)
+	assert.Equal(t, 480, img.Height)
+	require.NotNil(t, img.BlurHash)
+	assert.Equal(t, blurHash, *img.BlurHash)
+	require.NotNil(t, img.DominantColor)
+	assert.Equal(t, dominantColor, *img.DominantColor)
+	assert.True(t, img.HasEdits)
+	assert.Equal(t, 2, img.Version)
+}
+
+// TestListImagesPlaceholders runs against the database in POSTGRES_URL, like the
+// integration tests run by docker-compose.test.yml
+func TestListImagesPlaceholders(t *testing.T) {
+	url := os.Getenv("POSTGRES_URL")
+	if url == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+	ctx := context.Background()
+
+	store, err := NewPostgresStore(url)
+	require.NoError(t, err)
+	defer store.Close()
+
+	user, err := store.FindOrCreateUserByEmail(ctx, fmt.Sprintf("placeholders-%d@example.com", time.Now().UnixNano()), "Placeholder Test", "dev")
+	require.NoError(t, err)
+
+	img := &models.ImageMetadata{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		Filename:    "photo.jpg",
+		StoragePath: "user_" + user.ID + "/photo.jpg",
+		ContentType: "image/jpeg",
+		Size:        1,
+	}
+	require.NoError(t, store.CreateImageMetadata(ctx, img, 0))
+	defer store.DeleteImageByID(ctx, user.ID, img.ID)
+	require.NoError(t, store.SetImagePlaceholder(ctx, img.ID, "LDTSUA_3fQ_3~qoffQoffQfQfQfQ", "#ffffff"))
+
+	album, err := store.CreateAlbum(ctx, user.ID, "Placeholders", "")
+	require.NoError(t, err)
+	defer store.DeleteAlbum(ctx, user.ID, album.ID)
+	require.NoError(t, store.AddImageToAlbum(ctx, user.ID, album.ID, img.ID))
+
+	listed, err := store.ListImagesByUserID(ctx, user.ID, OrderByTaken)
+	require.NoError(t, err)
+	inAlbum, err := store.ListImagesInAlbum(ctx, user.ID, album.ID)
+	require.NoError(t, err)
+
+	for name, images := range map[string][]models.ImageMetadata{"ListImagesByUserID": listed, "ListImagesInAlbum": inAlbum} {
+		require.Len(t, images, 1, name)
+		require.NotNil(t, images[0].BlurHash, name)
+		assert.Equal(t, "LDTSUA_3fQ_3~qoffQoffQfQfQfQ", *images[0].BlurHash, name)
+		require.NotNil(t, images[0].DominantColor, name)
+		assert.Equal(t, "#ffffff", *images[0].DominantColor, name)
+	}
+}
@@ -0,0 +1,317 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// JobStore defines operations on the background job queue. A claimed job is identified
+// by its ID and attempt, so a worker whose claim expired and was taken over by another
+// can no longer complete or fail it.
+type JobStore interface {
+	// EnqueueJob adds a pending job. If its idempotency key is already in the queue, job is
+	// set to the existing job instead and created is false.
+	EnqueueJob(ctx context.Context, job *models.Job) (created bool, err error)
+	GetJob(ctx context.Context, jobID models.JobID) (*models.Job, error)
+
+	// ClaimJob marks the oldest due job of a queue, of one of the given kinds, as running
+	// until its timeout passes. Jobs whose claim expired are claimed again, or dead once
+	// they used every attempt. It fails with "no jobs" if none is due.
+	ClaimJob(ctx context.Context, queue string, kinds []string, workerID string) (*models.Job, error)
+	// ExtendJob restarts the timeout of a claimed job, for work that takes longer
+	ExtendJob(ctx context.Context, jobID models.JobID, attempt int) error
+	CompleteJob(ctx context.Context, jobID models.JobID, attempt int) error
+	// ReleaseJob makes a claimed job pending again without counting the attempt, e.g.
+	// because its worker is stopping
+	ReleaseJob(ctx context.Context, jobID models.JobID, attempt int) error
+	// FailJob records an error and makes a claimed job pending again from retryAt on.
+	// It becomes dead instead if retryAt is zero or it used every attempt.
+	FailJob(ctx context.Context, jobID models.JobID, attempt int, lastError string, retryAt time.Time) (*models.Job, error)
+
+	// ListJobs retrieves the jobs in a state, most recently updated first
+	ListJobs(ctx context.Context, status string, limit int) ([]models.Job, error)
+	CountJobs(ctx context.Context) ([]models.JobCount, error)
+	// RetryJob makes a dead job pending again, with every attempt available
+	RetryJob(ctx context.Context, jobID models.JobID) (*models.Job, error)
+	// PurgeJobs deletes jobs that were done before a time, forgetting their idempotency keys
+	PurgeJobs(ctx context.Context, before time.Time) (int64, error)
+}
+
+// jobColumns lists the jobs columns in the order scanJob expects.
+const jobColumns = `id, queue, kind, payload, idempotency_key, status, attempts, max_attempts, timeout_seconds,
+	run_at, locked_by, last_error, finished_at, created_at, updated_at`
+
+func scanJob(row pgx.Row, job *models.Job) error {
+	var timeoutSeconds int
+	err := row.Scan(
+		&job.ID, &job.Queue, &job.Kind, &job.Payload, &job.IdempotencyKey, &job.Status, &job.Attempts, &job.MaxAttempts, &timeoutSeconds,
+		&job.RunAt, &job.LockedBy, &job.LastError, &job.FinishedAt, &job.CreatedAt, &job.UpdatedAt,
+	)
+	job.Timeout = time.Duration(timeoutSeconds) * time.Second
+	return err
+}
+
+// --- JobStore Implementation ---
+
+// EnqueueJob inserts a job, unless its idempotency key is taken
+func (s *PostgresStore) EnqueueJob(ctx context.Context, job *models.Job) (bool, error) {
+	log.Printf("DB: EnqueueJob called for Queue: %s, Kind: %s, JobID: %s", job.Queue, job.Kind, job.ID)
+
+	query := `
+		INSERT INTO jobs (id, queue, kind, payload, idempotency_key, max_attempts, timeout_seconds, run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (queue, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+		RETURNING ` + jobColumns
+
+	err := scanJob(s.Pool.QueryRow(ctx, query,
+		job.ID, job.Queue, job.Kind, job.Payload, job.IdempotencyKey, job.MaxAttempts, int(job.Timeout.Seconds()), job.RunAt,
+	), job)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) || job.IdempotencyKey == nil {
+		log.Printf("Error enqueueing job: %v", err)
+		return false, err
+	}
+
+	query = `SELECT ` + jobColumns + ` FROM jobs WHERE queue = $1 AND idempotency_key = $2`
+	if err := scanJob(s.Pool.QueryRow(ctx, query, job.Queue, *job.IdempotencyKey), job); err != nil {
+		// Purged in between, which a retry resolves
+		log.Printf("Error getting job with idempotency key %s: %v", *job.IdempotencyKey, err)
+		return false, err
+	}
+	return false, nil
+}
+
+// GetJob retrieves a job
+func (s *PostgresStore) GetJob(ctx context.Context, jobID models.JobID) (*models.Job, error) {
+	var job models.Job
+	err := scanJob(s.Pool.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, jobID), &job)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("job not found")
+		}
+		log.Printf("Error getting job: %v", err)
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimJob locks the oldest due job of a queue, skipping jobs other workers are claiming
+func (s *PostgresStore) ClaimJob(ctx context.Context, queue string, kinds []string, workerID string) (*models.Job, error) {
+	// Jobs whose worker went away while they had no attempts left
+	result, err := s.Pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'dead', last_error = 'timed out', finished_at = NOW()
+		WHERE queue = $1 AND status = 'running' AND run_at <= NOW() AND attempts >= max_attempts
+	`, queue)
+	if err != nil {
+		log.Printf("Error burying timed out jobs: %v", err)
+		return nil, err
+	}
+	if result.RowsAffected() > 0 {
+		log.Printf("DB: %d timed out jobs of queue %s are dead", result.RowsAffected(), queue)
+	}
+
+	query := `
+		UPDATE jobs j
+		SET status = 'running', attempts = j.attempts + 1, locked_by = $2,
+		    run_at = NOW() + make_interval(secs => j.timeout_seconds)
+		FROM (
+			SELECT id AS claim_id
+			FROM jobs
+			WHERE queue = $1 AND kind = ANY($3) AND run_at <= NOW()
+			  AND (status = 'pending' OR (status = 'running' AND attempts < max_attempts))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) next
+		WHERE j.id = next.claim_id
+		RETURNING ` + jobColumns
+
+	var job models.Job
+	if err := scanJob(s.Pool.QueryRow(ctx, query, queue, workerID, kinds), &job); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("no jobs")
+		}
+		log.Printf("Error claiming job: %v", err)
+		return nil, err
+	}
+
+	log.Printf("DB: Job %s (%s) claimed by %s, attempt %d of %d", job.ID, job.Kind, workerID, job.Attempts, job.MaxAttempts)
+	return &job, nil
+}
+
+// ExtendJob moves the end of a claim to the job's timeout from now
+func (s *PostgresStore) ExtendJob(ctx context.Context, jobID models.JobID, attempt int) error {
+	query := `
+		UPDATE jobs SET run_at = NOW() + make_interval(secs => timeout_seconds)
+		WHERE id = $1 AND attempts = $2 AND status = 'running'
+	`
+
+	result, err := s.Pool.Exec(ctx, query, jobID, attempt)
+	if err != nil {
+		log.Printf("Error extending job: %v", err)
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errors.New("job claim lost")
+	}
+	return nil
+}
+
+// CompleteJob marks a claimed job as done
+func (s *PostgresStore) CompleteJob(ctx context.Context, jobID models.JobID, attempt int) error {
+	query := `
+		UPDATE jobs SET status = 'done', finished_at = NOW()
+		WHERE id = $1 AND attempts = $2 AND status = 'running'
+	`
+
+	result, err := s.Pool.Exec(ctx, query, jobID, attempt)
+	if err != nil {
+		log.Printf("Error completing job: %v", err)
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errors.New("job claim lost")
+	}
+	return nil
+}
+
+// ReleaseJob returns a claimed job to the queue, due right away
+func (s *PostgresStore) ReleaseJob(ctx context.Context, jobID models.JobID, attempt int) error {
+	query := `
+		UPDATE jobs SET status = 'pending', attempts = attempts - 1, run_at = NOW()
+		WHERE id = $1 AND attempts = $2 AND status = 'running'
+	`
+
+	result, err := s.Pool.Exec(ctx, query, jobID, attempt)
+	if err != nil {
+		log.Printf("Error releasing job: %v", err)
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errors.New("job claim lost")
+	}
+	return nil
+}
+
+// FailJob schedules the next attempt of a claimed job, or marks it dead
+func (s *PostgresStore) FailJob(ctx context.Context, jobID models.JobID, attempt int, lastError string, retryAt time.Time) (*models.Job, error) {
+	query := `
+		UPDATE jobs
+		SET last_error = $3,
+		    status = CASE WHEN $4::timestamptz IS NULL OR attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+		    run_at = COALESCE($4::timestamptz, run_at),
+		    finished_at = CASE WHEN $4::timestamptz IS NULL OR attempts >= max_attempts THEN NOW() END
+		WHERE id = $1 AND attempts = $2 AND status = 'running'
+		RETURNING ` + jobColumns
+
+	var next *time.Time
+	if !retryAt.IsZero() {
+		next = &retryAt
+	}
+
+	var job models.Job
+	if err := scanJob(s.Pool.QueryRow(ctx, query, jobID, attempt, lastError, next), &job); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("job claim lost")
+		}
+		log.Printf("Error failing job: %v", err)
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs retrieves the most recently updated jobs in a state
+func (s *PostgresStore) ListJobs(ctx context.Context, status string, limit int) ([]models.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE status = $1 ORDER BY updated_at DESC LIMIT $2`
+
+	rows, err := s.Pool.Query(ctx, query, status, limit)
+	if err != nil {
+		log.Printf("Error querying jobs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		var job models.Job
+		if err := scanJob(rows, &job); err != nil {
+			log.Printf("Error scanning job row: %v", err)
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating job rows: %v", err)
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// CountJobs counts the jobs of every queue and kind by state
+func (s *PostgresStore) CountJobs(ctx context.Context) ([]models.JobCount, error) {
+	query := `SELECT queue, kind, status, COUNT(*) FROM jobs GROUP BY queue, kind, status ORDER BY queue, kind, status`
+
+	rows, err := s.Pool.Query(ctx, query)
+	if err != nil {
+		log.Printf("Error counting jobs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.JobCount{}
+	for rows.Next() {
+		var count models.JobCount
+		if err := rows.Scan(&count.Queue, &count.Kind, &count.Status, &count.Count); err != nil {
+			log.Printf("Error scanning job count row: %v", err)
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating job count rows: %v", err)
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// RetryJob resets a dead job, keeping its last error until it runs again
+func (s *PostgresStore) RetryJob(ctx context.Context, jobID models.JobID) (*models.Job, error) {
+	log.Printf("DB: RetryJob called for JobID: %s", jobID)
+
+	query := `
+		UPDATE jobs SET status = 'pending', attempts = 0, run_at = NOW(), finished_at = NULL
+		WHERE id = $1 AND status = 'dead'
+		RETURNING ` + jobColumns
+
+	var job models.Job
+	if err := scanJob(s.Pool.QueryRow(ctx, query, jobID), &job); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("job not found")
+		}
+		log.Printf("Error retrying job: %v", err)
+		return nil, err
+	}
+	return &job, nil
+}
+
+// PurgeJobs deletes done jobs finished before a time
+func (s *PostgresStore) PurgeJobs(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.Pool.Exec(ctx, `DELETE FROM jobs WHERE status = 'done' AND finished_at < $1`, before)
+	if err != nil {
+		log.Printf("Error purging jobs: %v", err)
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,563 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// PeopleStore defines operations on detected faces and the people they are clustered into.
+type PeopleStore interface {
+	// ReplaceImageFaces records the faces detected in an image, replacing those of an
+	// earlier detection. People left without faces are deleted by a trigger.
+	ReplaceImageFaces(ctx context.Context, userID models.UserID, imageID models.ImageID, faces []models.Face) error
+	// ListImagesWithoutFaces retrieves a page of images, across all users, whose faces
+	// were never detected, ordered by ID
+	ListImagesWithoutFaces(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+
+	// ClusterFaces groups the most recent limit faces of a user, found by a model, that
+	// belong to no person. plan is given the centroids of the user's people of that model
+	// and the faces, most recent first, and returns the faces that join existing people
+	// and the groups of faces that form new ones. Listing and assigning run in one
+	// transaction holding a lock on the user, so the faces of a user are clustered one at
+	// a time across every instance of the faces service. It returns the people created.
+	ClusterFaces(ctx context.Context, userID models.UserID, model string, limit int, plan ClusterPlanner) ([]models.PersonID, error)
+
+	// ListPeople retrieves the people of a user that have faces, those in the most images first
+	ListPeople(ctx context.Context, userID models.UserID) ([]models.Person, error)
+	GetPerson(ctx context.Context, userID models.UserID, personID models.PersonID) (*models.Person, error)
+	// RenamePerson names a person, or removes its name if name is nil
+	RenamePerson(ctx context.Context, userID models.UserID, personID models.PersonID, name *string) (*models.Person, error)
+	// MergePeople moves the faces of other people into a person and deletes them
+	MergePeople(ctx context.Context, userID models.UserID, personID models.PersonID, otherIDs []models.PersonID) (*models.Person, error)
+	// SplitPerson moves some faces of a person into a new, unnamed person. It fails with
+	// "face not found" if a face is not one of the person's, and with "split leaves
+	// person empty" if every face would be moved.
+	SplitPerson(ctx context.Context, userID models.UserID, personID models.PersonID, faceIDs []models.FaceID) (*models.Person, error)
+
+	ListPersonFaces(ctx context.Context, userID models.UserID, personID models.PersonID) ([]models.Face, error)
+	ListImageFaces(ctx context.Context, userID models.UserID, imageID models.ImageID) ([]models.Face, error)
+	// ListPersonImages retrieves the images a person appears in, in timeline order
+	ListPersonImages(ctx context.Context, userID models.UserID, personID models.PersonID) ([]models.ImageMetadata, error)
+}
+
+// ClusterPlanner decides how faces that belong to no person are grouped, see ClusterFaces
+type ClusterPlanner func(people []models.Person, faces []models.Face) (assign map[models.PersonID][]models.FaceID, create [][]models.FaceID)
+
+// faceColumns lists the faces columns in the order scanFace expects. Embeddings are
+// only read where they are compared. Queries must select faces as f.
+const faceColumns = `f.id, f.image_id, f.user_id, f.person_id, f.box_x, f.box_y, f.box_width, f.box_height, f.score, f.model, f.created_at`
+
+func scanFace(row pgx.Row, f *models.Face) error {
+	return row.Scan(
+		&f.ID, &f.ImageID, &f.UserID, &f.PersonID, &f.Box.X, &f.Box.Y, &f.Box.Width, &f.Box.Height, &f.Score, &f.Model, &f.CreatedAt,
+	)
+}
+
+// personQuery selects people as p, with their face counts and cover face, in the order
+// scanPerson expects. Queries append their conditions.
+const personQuery = `
+	SELECT p.id, p.user_id, p.name, p.model, p.created_at, p.updated_at, n.face_count, n.image_count,
+	       c.id, c.image_id, c.box_x, c.box_y, c.box_width, c.box_height, c.score, c.created_at
+	FROM people p
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS face_count, COUNT(DISTINCT image_id) AS image_count FROM faces WHERE person_id = p.id
+	) n
+	LEFT JOIN LATERAL (
+		SELECT * FROM faces WHERE person_id = p.id ORDER BY score DESC, id LIMIT 1
+	) c ON TRUE`
+
+func scanPerson(row pgx.Row, p *models.Person) error {
+	var (
+		coverID, coverImageID      *string
+		x, y, width, height, score *float64
+		coverCreatedAt             *time.Time
+	)
+	err := row.Scan(
+		&p.ID, &p.UserID, &p.Name, &p.Model, &p.CreatedAt, &p.UpdatedAt, &p.FaceCount, &p.ImageCount,
+		&coverID, &coverImageID, &x, &y, &width, &height, &score, &coverCreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	p.Cover = nil
+	if coverID != nil {
+		p.Cover = &models.Face{
+			ID: *coverID, ImageID: *coverImageID, UserID: p.UserID, PersonID: &p.ID,
+			Box:   models.CropBox{X: *x, Y: *y, Width: *width, Height: *height},
+			Score: *score, Model: p.Model, CreatedAt: *coverCreatedAt,
+		}
+	}
+	return nil
+}
+
+// updateCentroids sets the centroid of people to the mean embedding of their faces.
+// People without faces keep theirs until they are deleted. Here and below, lists of
+// IDs are passed as text[] and cast, pgx cannot encode []string as a binary uuid[].
+func updateCentroids(ctx context.Context, tx pgx.Tx, personIDs ...models.PersonID) error {
+	query := `
+		UPDATE people p SET centroid = m.centroid
+		FROM (
+			SELECT person_id, array_agg(value ORDER BY dim) AS centroid
+			FROM (
+				SELECT f.person_id, e.dim, AVG(e.value)::real AS value
+				FROM faces f, unnest(f.embedding) WITH ORDINALITY AS e(value, dim)
+				WHERE f.person_id = ANY($1::text[]::uuid[])
+				GROUP BY f.person_id, e.dim
+			) dims
+			GROUP BY person_id
+		) m
+		WHERE p.id = m.person_id
+	`
+
+	_, err := tx.Exec(ctx, query, personIDs)
+	return err
+}
+
+// --- PeopleStore Implementation ---
+
+// ReplaceImageFaces deletes the faces of an image and inserts the newly detected ones
+func (s *PostgresStore) ReplaceImageFaces(ctx context.Context, userID models.UserID, imageID models.ImageID, faces []models.Face) error {
+	log.Printf("DB: ReplaceImageFaces called for UserID: %s, ImageID: %s, Faces: %d", userID, imageID, len(faces))
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Also locks the image, so it cannot be deleted meanwhile
+	result, err := tx.Exec(ctx, `UPDATE images SET faces_detected_at = NOW() WHERE id = $1 AND user_id = $2`, imageID, userID)
+	if err != nil {
+		log.Printf("Error recording face detection: %v", err)
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+
+	// People whose faces in the image are replaced, their centroids change
+	rows, err := tx.Query(ctx, `SELECT DISTINCT person_id FROM faces WHERE image_id = $1 AND person_id IS NOT NULL`, imageID)
+	if err != nil {
+		return err
+	}
+	affected, err := pgx.CollectRows(rows, pgx.RowTo[models.PersonID])
+	if err != nil {
+		log.Printf("Error listing people of image %s: %v", imageID, err)
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM faces WHERE image_id = $1`, imageID); err != nil {
+		log.Printf("Error deleting faces of image %s: %v", imageID, err)
+		return err
+	}
+
+	insert := `
+		INSERT INTO faces (id, image_id, user_id, box_x, box_y, box_width, box_height, score, embedding, model)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	for _, f := range faces {
+		_, err := tx.Exec(ctx, insert, f.ID, imageID, userID, f.Box.X, f.Box.Y, f.Box.Width, f.Box.Height, f.Score, f.Embedding, f.Model)
+		if err != nil {
+			log.Printf("Error inserting face: %v", err)
+			return err
+		}
+	}
+
+	if err := updateCentroids(ctx, tx, affected...); err != nil {
+		log.Printf("Error updating centroids: %v", err)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListImagesWithoutFaces retrieves a page of images that were never run through face detection
+func (s *PostgresStore) ListImagesWithoutFaces(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE i.faces_detected_at IS NULL AND i.id > $1
+        ORDER BY i.id
+        LIMIT $2`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, afterID, limit)
+}
+
+// ClusterFaces lists, plans and assigns the unassigned faces of a user under an advisory lock
+func (s *PostgresStore) ClusterFaces(ctx context.Context, userID models.UserID, model string, limit int, plan ClusterPlanner) ([]models.PersonID, error) {
+	log.Printf("DB: ClusterFaces called for UserID: %s, Model: %s", userID, model)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Held until the transaction ends. Faces of images deleted meanwhile no longer match
+	// the updates below and are skipped.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, userID); err != nil {
+		log.Printf("Error locking faces of user %s: %v", userID, err)
+		return nil, err
+	}
+
+	people, err := listPersonCentroids(ctx, tx, userID, model)
+	if err != nil {
+		return nil, err
+	}
+	faces, err := listUnassignedFaces(ctx, tx, userID, model, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(faces) == 0 {
+		return nil, nil
+	}
+
+	assign, create := plan(people, faces)
+
+	changed := make([]models.PersonID, 0, len(assign)+len(create))
+	assignQuery := `
+		UPDATE faces f SET person_id = p.id
+		FROM people p
+		WHERE p.id = $2 AND p.user_id = $1 AND f.user_id = $1 AND f.id = ANY($3::text[]::uuid[]) AND f.person_id IS NULL
+	`
+	for personID, faceIDs := range assign {
+		if _, err := tx.Exec(ctx, assignQuery, userID, personID, faceIDs); err != nil {
+			log.Printf("Error assigning faces to person %s: %v", personID, err)
+			return nil, err
+		}
+		changed = append(changed, personID)
+	}
+
+	var created []models.PersonID
+	for _, faceIDs := range create {
+		personID, err := createPerson(ctx, tx, userID, model)
+		if err != nil {
+			return nil, err
+		}
+
+		query := `UPDATE faces SET person_id = $2 WHERE user_id = $1 AND id = ANY($3::text[]::uuid[]) AND person_id IS NULL`
+		result, err := tx.Exec(ctx, query, userID, personID, faceIDs)
+		if err != nil {
+			log.Printf("Error assigning faces: %v", err)
+			return nil, err
+		}
+		// The faces were deleted meanwhile, no empty person is left behind
+		if result.RowsAffected() == 0 {
+			if _, err := tx.Exec(ctx, `DELETE FROM people WHERE id = $1`, personID); err != nil {
+				log.Printf("Error deleting empty person %s: %v", personID, err)
+				return nil, err
+			}
+			continue
+		}
+		changed = append(changed, personID)
+		created = append(created, personID)
+	}
+
+	if err := updateCentroids(ctx, tx, changed...); err != nil {
+		log.Printf("Error updating centroids: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// listPersonCentroids retrieves the centroids the faces of a user are compared with
+func listPersonCentroids(ctx context.Context, tx pgx.Tx, userID models.UserID, model string) ([]models.Person, error) {
+	rows, err := tx.Query(ctx, `SELECT id, centroid FROM people WHERE user_id = $1 AND model = $2`, userID, model)
+	if err != nil {
+		log.Printf("Error querying person centroids: %v", err)
+		return nil, err
+	}
+
+	people, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Person, error) {
+		p := models.Person{UserID: userID, Model: model}
+		err := row.Scan(&p.ID, &p.Centroid)
+		return p, err
+	})
+	if err != nil {
+		log.Printf("Error scanning person centroids: %v", err)
+		return nil, err
+	}
+	return people, nil
+}
+
+// listUnassignedFaces retrieves the embeddings of recent faces that belong to no person
+func listUnassignedFaces(ctx context.Context, tx pgx.Tx, userID models.UserID, model string, limit int) ([]models.Face, error) {
+	query := `
+		SELECT id, image_id, embedding FROM faces
+		WHERE user_id = $1 AND model = $2 AND person_id IS NULL
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+
+	rows, err := tx.Query(ctx, query, userID, model, limit)
+	if err != nil {
+		log.Printf("Error querying unassigned faces: %v", err)
+		return nil, err
+	}
+
+	faces, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Face, error) {
+		f := models.Face{UserID: userID, Model: model}
+		err := row.Scan(&f.ID, &f.ImageID, &f.Embedding)
+		return f, err
+	})
+	if err != nil {
+		log.Printf("Error scanning unassigned faces: %v", err)
+		return nil, err
+	}
+	return faces, nil
+}
+
+// createPerson inserts an unnamed person, its centroid is set once it has faces
+func createPerson(ctx context.Context, tx pgx.Tx, userID models.UserID, model string) (models.PersonID, error) {
+	personID := uuid.New().String()
+	_, err := tx.Exec(ctx, `INSERT INTO people (id, user_id, model, centroid) VALUES ($1, $2, $3, '{}')`, personID, userID, model)
+	if err != nil {
+		log.Printf("Error creating person: %v", err)
+		return "", err
+	}
+	return personID, nil
+}
+
+// ListPeople retrieves the people of a user that have faces
+func (s *PostgresStore) ListPeople(ctx context.Context, userID models.UserID) ([]models.Person, error) {
+	query := personQuery + `
+		WHERE p.user_id = $1 AND n.face_count > 0
+		ORDER BY n.image_count DESC, p.created_at, p.id`
+
+	rows, err := s.Pool.Query(ctx, query, userID)
+	if err != nil {
+		log.Printf("Error querying people: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	people := []models.Person{}
+	for rows.Next() {
+		var p models.Person
+		if err := scanPerson(rows, &p); err != nil {
+			log.Printf("Error scanning person row: %v", err)
+			return nil, err
+		}
+		people = append(people, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating person rows: %v", err)
+		return nil, err
+	}
+
+	return people, nil
+}
+
+// GetPerson retrieves a person of a user
+func (s *PostgresStore) GetPerson(ctx context.Context, userID models.UserID, personID models.PersonID) (*models.Person, error) {
+	query := personQuery + ` WHERE p.user_id = $1 AND p.id = $2`
+
+	var p models.Person
+	if err := scanPerson(s.Pool.QueryRow(ctx, query, userID, personID), &p); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("person not found")
+		}
+		log.Printf("Error getting person: %v", err)
+		return nil, err
+	}
+	return &p, nil
+}
+
+// RenamePerson sets or clears the name of a person
+func (s *PostgresStore) RenamePerson(ctx context.Context, userID models.UserID, personID models.PersonID, name *string) (*models.Person, error) {
+	log.Printf("DB: RenamePerson called for UserID: %s, PersonID: %s", userID, personID)
+
+	result, err := s.Pool.Exec(ctx, `UPDATE people SET name = $3 WHERE user_id = $1 AND id = $2`, userID, personID, name)
+	if err != nil {
+		log.Printf("Error renaming person: %v", err)
+		return nil, err
+	}
+	if result.RowsAffected() == 0 {
+		return nil, errors.New("person not found")
+	}
+
+	return s.GetPerson(ctx, userID, personID)
+}
+
+// MergePeople moves the faces of other people into a person, keeping the person's name
+func (s *PostgresStore) MergePeople(ctx context.Context, userID models.UserID, personID models.PersonID, otherIDs []models.PersonID) (*models.Person, error) {
+	log.Printf("DB: MergePeople called for UserID: %s, PersonID: %s, Others: %v", userID, personID, otherIDs)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock every person involved, of the same user and model
+	query := `
+		SELECT COUNT(*) FROM (
+			SELECT o.id FROM people o
+			JOIN people p ON p.id = $2 AND p.user_id = $1
+			WHERE o.user_id = $1 AND o.model = p.model AND (o.id = ANY($3::text[]::uuid[]) OR o.id = p.id)
+			FOR UPDATE OF o
+		) locked
+	`
+	var found int
+	if err := tx.QueryRow(ctx, query, userID, personID, otherIDs).Scan(&found); err != nil {
+		log.Printf("Error locking people: %v", err)
+		return nil, err
+	}
+	if found != len(otherIDs)+1 {
+		return nil, errors.New("person not found")
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE faces SET person_id = $2 WHERE user_id = $1 AND person_id = ANY($3::text[]::uuid[])`, userID, personID, otherIDs); err != nil {
+		log.Printf("Error moving faces: %v", err)
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM people WHERE user_id = $1 AND id = ANY($2::text[]::uuid[])`, userID, otherIDs); err != nil {
+		log.Printf("Error deleting merged people: %v", err)
+		return nil, err
+	}
+	if err := updateCentroids(ctx, tx, personID); err != nil {
+		log.Printf("Error updating centroid of person %s: %v", personID, err)
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetPerson(ctx, userID, personID)
+}
+
+// SplitPerson moves faces of a person into a new person
+func (s *PostgresStore) SplitPerson(ctx context.Context, userID models.UserID, personID models.PersonID, faceIDs []models.FaceID) (*models.Person, error) {
+	log.Printf("DB: SplitPerson called for UserID: %s, PersonID: %s, Faces: %d", userID, personID, len(faceIDs))
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var model string
+	err = tx.QueryRow(ctx, `SELECT model FROM people WHERE user_id = $1 AND id = $2 FOR UPDATE`, userID, personID).Scan(&model)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("person not found")
+		}
+		log.Printf("Error locking person: %v", err)
+		return nil, err
+	}
+
+	var total, selected int
+	query := `SELECT COUNT(*), COUNT(*) FILTER (WHERE id = ANY($2::text[]::uuid[])) FROM faces WHERE person_id = $1`
+	if err := tx.QueryRow(ctx, query, personID, faceIDs).Scan(&total, &selected); err != nil {
+		log.Printf("Error counting faces of person %s: %v", personID, err)
+		return nil, err
+	}
+	if selected != len(faceIDs) {
+		return nil, errors.New("face not found")
+	}
+	if selected == total {
+		return nil, errors.New("split leaves person empty")
+	}
+
+	newID, err := createPerson(ctx, tx, userID, model)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE faces SET person_id = $3 WHERE person_id = $1 AND id = ANY($2::text[]::uuid[])`, personID, faceIDs, newID); err != nil {
+		log.Printf("Error moving faces: %v", err)
+		return nil, err
+	}
+	if err := updateCentroids(ctx, tx, personID, newID); err != nil {
+		log.Printf("Error updating centroids: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetPerson(ctx, userID, newID)
+}
+
+// ListPersonFaces retrieves the faces of a person, most confidently detected first
+func (s *PostgresStore) ListPersonFaces(ctx context.Context, userID models.UserID, personID models.PersonID) ([]models.Face, error) {
+	if _, err := s.GetPerson(ctx, userID, personID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + faceColumns + ` FROM faces f WHERE f.user_id = $1 AND f.person_id = $2 ORDER BY f.score DESC, f.id`
+	return s.queryFaces(ctx, query, userID, personID)
+}
+
+// ListImageFaces retrieves the faces in an image, left to right
+func (s *PostgresStore) ListImageFaces(ctx context.Context, userID models.UserID, imageID models.ImageID) ([]models.Face, error) {
+	query := `SELECT ` + faceColumns + ` FROM faces f WHERE f.user_id = $1 AND f.image_id = $2 ORDER BY f.box_x, f.id`
+	return s.queryFaces(ctx, query, userID, imageID)
+}
+
+// ListPersonImages retrieves the images a person appears in
+func (s *PostgresStore) ListPersonImages(ctx context.Context, userID models.UserID, personID models.PersonID) ([]models.ImageMetadata, error) {
+	log.Printf("DB: ListPersonImages called for UserID: %s, PersonID: %s", userID, personID)
+
+	if _, err := s.GetPerson(ctx, userID, personID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images i
+		WHERE i.user_id = $1 AND EXISTS (SELECT 1 FROM faces f WHERE f.image_id = i.id AND f.person_id = $2)
+		ORDER BY COALESCE(i.taken_at, i.created_at) DESC, i.id
+	`
+
+	images, err := s.queryImages(ctx, query, userID, personID)
+	if err != nil {
+		return nil, err
+	}
+	if images == nil {
+		images = []models.ImageMetadata{}
+	}
+	return images, nil
+}
+
+// queryFaces runs a query selecting faceColumns
+func (s *PostgresStore) queryFaces(ctx context.Context, query string, args ...any) ([]models.Face, error) {
+	rows, err := s.Pool.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error querying faces: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	faces := []models.Face{}
+	for rows.Next() {
+		var f models.Face
+		if err := scanFace(rows, &f); err != nil {
+			log.Printf("Error scanning face row: %v", err)
+			return nil, err
+		}
+		faces = append(faces, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating face rows: %v", err)
+		return nil, err
+	}
+
+	return faces, nil
+}
@@ -17,6 +17,10 @@ type Store interface {
 	UserStore
 	ImageStore
 	AlbumStore
+	UploadStore
+	DataKeyStore
+	JobStore
+	PeopleStore
 	Close()
 }
 
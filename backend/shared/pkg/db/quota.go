@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// QuotaStore defines storage quota lookups, shared by user profiles and uploads.
+type QuotaStore interface {
+	// GetStorageUsage reports a user's usage against their quota override, or defaultQuota (0 is unlimited)
+	GetStorageUsage(ctx context.Context, userID models.UserID, defaultQuota int64) (*models.StorageUsage, error)
+}
+
+// --- QuotaStore Implementation ---
+
+// usageQuery selects a user's quota override, the size of their images including
+// replaced versions, and their image count
+const usageQuery = `
+		SELECT u.quota_bytes,
+		       (COALESCE((SELECT SUM(size) FROM images WHERE user_id = u.id), 0) +
+		        COALESCE((SELECT SUM(v.size) FROM image_versions v JOIN images i ON i.id = v.image_id WHERE i.user_id = u.id), 0))::BIGINT,
+		       (SELECT COUNT(*) FROM images WHERE user_id = u.id)
+		FROM users u
+		WHERE u.id = $1`
+
+// GetStorageUsage sums the size of a user's images, including replaced versions, and resolves their quota
+func (s *PostgresStore) GetStorageUsage(ctx context.Context, userID models.UserID, defaultQuota int64) (*models.StorageUsage, error) {
+	log.Printf("DB: GetStorageUsage called for ID: %s", userID)
+
+	return scanStorageUsage(s.Pool.QueryRow(ctx, usageQuery, userID), defaultQuota)
+}
+
+// reserveQuota checks, as part of a transaction, that size more bytes fit in a user's quota.
+// The user's row stays locked until the transaction ends, so concurrent uploads of the same
+// user are checked one after the other and cannot exceed the quota together.
+func reserveQuota(ctx context.Context, tx pgx.Tx, userID models.UserID, size, defaultQuota int64) error {
+	var id models.UserID
+	if err := tx.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
+		log.Printf("Error locking user for quota check: %v", err)
+		return err
+	}
+
+	usage, err := scanStorageUsage(tx.QueryRow(ctx, usageQuery, userID), defaultQuota)
+	if err != nil {
+		return err
+	}
+	if usage.BytesRemaining != nil && size > *usage.BytesRemaining {
+		return errors.New("storage quota exceeded")
+	}
+	return nil
+}
+
+// scanStorageUsage scans a row selected with usageQuery
+func scanStorageUsage(row pgx.Row, defaultQuota int64) (*models.StorageUsage, error) {
+	var quotaOverride *int64
+	var usage models.StorageUsage
+	err := row.Scan(&quotaOverride, &usage.BytesUsed, &usage.ImageCount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("user not found")
+		}
+		log.Printf("Error querying storage usage: %v", err)
+		return nil, err
+	}
+
+	quota := defaultQuota
+	if quotaOverride != nil {
+		quota = *quotaOverride
+	}
+
+	if quota > 0 {
+		remaining := max(quota-usage.BytesUsed, 0)
+		usage.QuotaBytes = &quota
+		usage.BytesRemaining = &remaining
+	}
+
+	return &usage, nil
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// RenditionStore defines operations on the downscaled copies of images.
+type RenditionStore interface {
+	// SaveRendition records a rendition, replacing any previous rendition of the same size
+	SaveRendition(ctx context.Context, rendition *models.Rendition) error
+	ListRenditions(ctx context.Context, imageID models.ImageID) ([]models.Rendition, error)
+
+	// ListImagesMissingRenditions retrieves a page of images, across all users, that
+	// have fewer than count renditions, ordered by ID
+	ListImagesMissingRenditions(ctx context.Context, count int, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+
+	// SetImagePlaceholder records the BlurHash and dominant colour shown while an image loads
+	SetImagePlaceholder(ctx context.Context, imageID models.ImageID, blurHash, dominantColor string) error
+	// ListImagesWithoutPlaceholder retrieves a page of images, across all users, that
+	// have no placeholder yet, ordered by ID
+	ListImagesWithoutPlaceholder(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error)
+}
+
+// renditionColumns lists the image_renditions columns in the order scanRendition expects.
+const renditionColumns = `image_id, size, storage_path, content_type, width, height, file_size, created_at, updated_at`
+
+func scanRendition(row pgx.Row, r *models.Rendition) error {
+	return row.Scan(&r.ImageID, &r.Size, &r.StoragePath, &r.ContentType, &r.Width, &r.Height, &r.FileSize, &r.CreatedAt, &r.UpdatedAt)
+}
+
+// --- RenditionStore Implementation ---
+
+// SaveRendition inserts or replaces a rendition
+func (s *PostgresStore) SaveRendition(ctx context.Context, r *models.Rendition) error {
+	log.Printf("DB: SaveRendition called for ImageID: %s, Size: %s", r.ImageID, r.Size)
+
+	query := `
+		INSERT INTO image_renditions (image_id, size, storage_path, content_type, width, height, file_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (image_id, size) DO UPDATE
+		SET storage_path = EXCLUDED.storage_path, content_type = EXCLUDED.content_type,
+		    width = EXCLUDED.width, height = EXCLUDED.height, file_size = EXCLUDED.file_size
+	`
+
+	_, err := s.Pool.Exec(ctx, query, r.ImageID, r.Size, r.StoragePath, r.ContentType, r.Width, r.Height, r.FileSize)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			// Deleted while its renditions were being generated
+			return errors.New("image not found")
+		}
+		log.Printf("Error saving rendition: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListRenditions retrieves the renditions of an image
+func (s *PostgresStore) ListRenditions(ctx context.Context, imageID models.ImageID) ([]models.Rendition, error) {
+	query := `SELECT ` + renditionColumns + ` FROM image_renditions WHERE image_id = $1`
+
+	rows, err := s.Pool.Query(ctx, query, imageID)
+	if err != nil {
+		log.Printf("Error querying renditions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []models.Rendition{}
+	for rows.Next() {
+		var r models.Rendition
+		if err := scanRendition(rows, &r); err != nil {
+			log.Printf("Error scanning rendition row: %v", err)
+			return nil, err
+		}
+		renditions = append(renditions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating rendition rows: %v", err)
+		return nil, err
+	}
+	return renditions, nil
+}
+
+// ListImagesMissingRenditions retrieves a page of images with fewer than count renditions
+func (s *PostgresStore) ListImagesMissingRenditions(ctx context.Context, count int, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE (SELECT COUNT(*) FROM image_renditions r WHERE r.image_id = i.id) < $1
+          AND i.id > $2
+        ORDER BY i.id
+        LIMIT $3`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, count, afterID, limit)
+}
+
+// SetImagePlaceholder stores the BlurHash and dominant colour of an image
+func (s *PostgresStore) SetImagePlaceholder(ctx context.Context, imageID models.ImageID, blurHash, dominantColor string) error {
+	query := `
+		UPDATE images
+		SET blurhash = $2, dominant_color = $3
+		WHERE id = $1
+	`
+
+	result, err := s.Pool.Exec(ctx, query, imageID, blurHash, dominantColor)
+	if err != nil {
+		log.Printf("Error setting image placeholder: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+	return nil
+}
+
+// ListImagesWithoutPlaceholder retrieves a page of images whose placeholder was never computed
+func (s *PostgresStore) ListImagesWithoutPlaceholder(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	query := `
+        SELECT ` + imageColumns + `
+        FROM images i
+        WHERE (i.blurhash IS NULL OR i.dominant_color IS NULL)
+          AND i.id > $1
+        ORDER BY i.id
+        LIMIT $2`
+
+	if afterID == "" {
+		afterID = firstImageID
+	}
+
+	return s.queryImages(ctx, query, afterID, limit)
+}
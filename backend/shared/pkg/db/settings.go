@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// SettingsStore defines operations on user preferences, shared by user profiles and image delivery.
+type SettingsStore interface {
+	// GetUserSettings retrieves a user's settings, the defaults if they never changed them
+	GetUserSettings(ctx context.Context, userID models.UserID) (*models.UserSettings, error)
+	UpdateUserSettings(ctx context.Context, userID models.UserID, settings *models.UserSettings) error
+}
+
+// --- SettingsStore Implementation ---
+
+// GetUserSettings retrieves the settings of a user
+func (s *PostgresStore) GetUserSettings(ctx context.Context, userID models.UserID) (*models.UserSettings, error) {
+	query := `SELECT strip_metadata FROM user_settings WHERE user_id = $1`
+
+	var settings models.UserSettings
+	err := s.Pool.QueryRow(ctx, query, userID).Scan(&settings.StripMetadata)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &models.UserSettings{}, nil
+		}
+		log.Printf("Error querying user settings: %v", err)
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateUserSettings inserts or replaces the settings of a user
+func (s *PostgresStore) UpdateUserSettings(ctx context.Context, userID models.UserID, settings *models.UserSettings) error {
+	log.Printf("DB: UpdateUserSettings called for ID: %s", userID)
+
+	query := `
+		INSERT INTO user_settings (user_id, strip_metadata)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET strip_metadata = EXCLUDED.strip_metadata
+	`
+
+	_, err := s.Pool.Exec(ctx, query, userID, settings.StripMetadata)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return errors.New("user not found")
+		}
+		log.Printf("Error saving user settings: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// UploadStore defines operations on in-progress resumable uploads.
+type UploadStore interface {
+	CreateUpload(ctx context.Context, upload *models.Upload) error
+	GetUpload(ctx context.Context, userID models.UserID, uploadID models.UploadID) (*models.Upload, error)
+
+	// AppendUploadChunk records a staged chunk of size bytes written at offset.
+	// It fails with "upload offset mismatch" if another chunk was appended concurrently.
+	AppendUploadChunk(ctx context.Context, userID models.UserID, uploadID models.UploadID, offset, size int64, chunkPath string, expiresAt time.Time) (*models.Upload, error)
+	DeleteUpload(ctx context.Context, userID models.UserID, uploadID models.UploadID) error
+	ListExpiredUploads(ctx context.Context, before time.Time) ([]models.Upload, error)
+
+	// SumUploadLengths totals the declared length of a user's unexpired uploads, the space they will need once complete
+	SumUploadLengths(ctx context.Context, userID models.UserID) (int64, error)
+}
+
+// uploadColumns lists the uploads columns in the order scanUpload expects.
+const uploadColumns = `id, user_id, filename, content_type, length, upload_offset, chunk_paths, allow_duplicate, expires_at, created_at, updated_at`
+
+func scanUpload(row pgx.Row, upload *models.Upload) error {
+	return row.Scan(
+		&upload.ID, &upload.UserID, &upload.Filename, &upload.ContentType, &upload.Length,
+		&upload.Offset, &upload.ChunkPaths, &upload.AllowDuplicate, &upload.ExpiresAt, &upload.CreatedAt, &upload.UpdatedAt,
+	)
+}
+
+// --- UploadStore Implementation ---
+
+// CreateUpload registers a new resumable upload
+func (s *PostgresStore) CreateUpload(ctx context.Context, upload *models.Upload) error {
+	log.Printf("DB: CreateUpload called for UserID: %s, Filename: %s, UploadID: %s", upload.UserID, upload.Filename, upload.ID)
+
+	query := `
+		INSERT INTO uploads (id, user_id, filename, content_type, length, allow_duplicate, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + uploadColumns
+
+	err := scanUpload(s.Pool.QueryRow(ctx, query,
+		upload.ID, upload.UserID, upload.Filename, upload.ContentType, upload.Length, upload.AllowDuplicate, upload.ExpiresAt,
+	), upload)
+	if err != nil {
+		log.Printf("Error creating upload: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetUpload retrieves an upload belonging to a user
+func (s *PostgresStore) GetUpload(ctx context.Context, userID models.UserID, uploadID models.UploadID) (*models.Upload, error) {
+	query := `
+		SELECT ` + uploadColumns + `
+		FROM uploads
+		WHERE user_id = $1 AND id = $2
+	`
+
+	var upload models.Upload
+	err := scanUpload(s.Pool.QueryRow(ctx, query, userID, uploadID), &upload)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("upload not found")
+		}
+		log.Printf("Error getting upload: %v", err)
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// AppendUploadChunk advances an upload's offset, as long as it is still at the expected offset
+func (s *PostgresStore) AppendUploadChunk(ctx context.Context, userID models.UserID, uploadID models.UploadID, offset, size int64, chunkPath string, expiresAt time.Time) (*models.Upload, error) {
+	query := `
+		UPDATE uploads
+		SET upload_offset = upload_offset + $4,
+		    chunk_paths = array_append(chunk_paths, $5),
+		    expires_at = $6
+		WHERE user_id = $1 AND id = $2 AND upload_offset = $3
+		RETURNING ` + uploadColumns
+
+	var upload models.Upload
+	err := scanUpload(s.Pool.QueryRow(ctx, query, userID, uploadID, offset, size, chunkPath, expiresAt), &upload)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("upload offset mismatch")
+		}
+		log.Printf("Error appending upload chunk: %v", err)
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// DeleteUpload removes an upload record. Staged chunks must be deleted from blob storage by the caller.
+func (s *PostgresStore) DeleteUpload(ctx context.Context, userID models.UserID, uploadID models.UploadID) error {
+	result, err := s.Pool.Exec(ctx, `DELETE FROM uploads WHERE user_id = $1 AND id = $2`, userID, uploadID)
+	if err != nil {
+		log.Printf("Error deleting upload: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("upload not found")
+	}
+
+	return nil
+}
+
+// ListExpiredUploads retrieves uploads that expired before the given time
+func (s *PostgresStore) ListExpiredUploads(ctx context.Context, before time.Time) ([]models.Upload, error) {
+	query := `
+		SELECT ` + uploadColumns + `
+		FROM uploads
+		WHERE expires_at < $1
+	`
+
+	rows, err := s.Pool.Query(ctx, query, before)
+	if err != nil {
+		log.Printf("Error querying expired uploads: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []models.Upload
+	for rows.Next() {
+		var upload models.Upload
+		if err := scanUpload(rows, &upload); err != nil {
+			log.Printf("Error scanning upload row: %v", err)
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating upload rows: %v", err)
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// SumUploadLengths totals the Upload-Length of a user's uploads that have not expired
+func (s *PostgresStore) SumUploadLengths(ctx context.Context, userID models.UserID) (int64, error) {
+	log.Printf("DB: SumUploadLengths called for ID: %s", userID)
+
+	var total int64
+	err := s.Pool.QueryRow(ctx, `SELECT COALESCE(SUM(length), 0)::BIGINT FROM uploads WHERE user_id = $1 AND expires_at > now()`, userID).Scan(&total)
+	if err != nil {
+		log.Printf("Error summing upload lengths: %v", err)
+		return 0, err
+	}
+
+	return total, nil
+}
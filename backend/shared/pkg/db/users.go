@@ -18,6 +18,12 @@ type UserStore interface {
 
 	// Added for dev login:
 	FindOrCreateUserByEmail(ctx context.Context, email string, name string, provider string) (*models.User, error)
+
+	// Storage usage reported on the user profile
+	QuotaStore
+
+	// Preferences, edited on the user profile
+	SettingsStore
 }
 
 func (s *PostgresStore) FindOrCreateUserByGoogleID(ctx context.Context, googleUser *models.GoogleUser) (*models.User, error) {
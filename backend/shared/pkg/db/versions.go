@@ -0,0 +1,361 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// VersionStore defines operations on the files images had before their original was replaced.
+type VersionStore interface {
+	// ListImageVersions retrieves the previous versions of an image, newest first
+	ListImageVersions(ctx context.Context, imageID models.ImageID) ([]models.ImageVersion, error)
+	GetImageVersion(ctx context.Context, imageID models.ImageID, version int) (*models.ImageVersion, error)
+
+	// ReplaceImageFile makes file the current file of an image, with a new version number
+	// and no edits, and keeps the previous file as a version. file holds the file fields
+	// of an image, as filled in for CreateImageMetadata, and its EXIF data. Like
+	// CreateImageMetadata it takes over the blob reference of a content-addressed file, and
+	// fails with "storage quota exceeded" if the file does not fit next to the kept one.
+	ReplaceImageFile(ctx context.Context, userID models.UserID, imageID models.ImageID, file *models.ImageMetadata, defaultQuota int64) (*models.ImageMetadata, error)
+	// RestoreImageVersion makes a previous version the current file of an image again,
+	// with the edits it had, and keeps the current file as a version. exif is the EXIF
+	// data of the restored file.
+	RestoreImageVersion(ctx context.Context, userID models.UserID, imageID models.ImageID, version int, exif *models.ImageExif) (*models.ImageMetadata, error)
+
+	// DeleteImageVersions removes every previous version of an image, releasing their
+	// blob references. It returns the deleted versions and the blobs no longer
+	// referenced, whose files the caller must remove.
+	DeleteImageVersions(ctx context.Context, imageID models.ImageID) ([]models.ImageVersion, []models.Blob, error)
+
+	// Storage backend migration, across all users, like ListImagesInStorageBackend
+	ListImageVersionsInStorageBackend(ctx context.Context, backend string, after models.ImageVersion, limit int) ([]models.ImageVersion, error)
+	SetImageVersionStorageBackend(ctx context.Context, imageID models.ImageID, version int, backend string) error
+
+	// Integrity checks, across all users, like ListAllImages
+	ListAllImageVersions(ctx context.Context, after models.ImageVersion, limit int) ([]models.ImageVersion, error)
+}
+
+// versionColumns lists the image_versions columns (aliased as v) and the owner of the
+// image in the order scanVersion expects. Queries must join images as i.
+const versionColumns = `v.image_id, i.user_id, v.version, v.filename, v.storage_path, v.storage_backend, v.blob_hash, v.checksum,
+        v.content_type, v.size, v.width, v.height, v.taken_at, v.edits, v.replaced_at`
+
+func scanVersion(row pgx.Row, v *models.ImageVersion) error {
+	return row.Scan(
+		&v.ImageID, &v.UserID, &v.Version, &v.Filename, &v.StoragePath, &v.StorageBackend, &v.BlobHash, &v.Checksum,
+		&v.ContentType, &v.Size, &v.Width, &v.Height, &v.TakenAt, &v.Edits, &v.ReplacedAt,
+	)
+}
+
+// --- VersionStore Implementation ---
+
+// ListImageVersions retrieves the previous versions of an image
+func (s *PostgresStore) ListImageVersions(ctx context.Context, imageID models.ImageID) ([]models.ImageVersion, error) {
+	query := `
+        SELECT ` + versionColumns + `
+        FROM image_versions v
+        JOIN images i ON i.id = v.image_id
+        WHERE v.image_id = $1
+        ORDER BY v.version DESC`
+
+	return s.queryVersions(ctx, query, imageID)
+}
+
+// GetImageVersion retrieves a previous version of an image
+func (s *PostgresStore) GetImageVersion(ctx context.Context, imageID models.ImageID, version int) (*models.ImageVersion, error) {
+	query := `
+        SELECT ` + versionColumns + `
+        FROM image_versions v
+        JOIN images i ON i.id = v.image_id
+        WHERE v.image_id = $1 AND v.version = $2`
+
+	var v models.ImageVersion
+	if err := scanVersion(s.Pool.QueryRow(ctx, query, imageID, version), &v); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("version not found")
+		}
+		log.Printf("Error getting image version: %v", err)
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ReplaceImageFile archives the current file of an image and records a new one
+func (s *PostgresStore) ReplaceImageFile(ctx context.Context, userID models.UserID, imageID models.ImageID, file *models.ImageMetadata, defaultQuota int64) (*models.ImageMetadata, error) {
+	log.Printf("DB: ReplaceImageFile called for UserID: %s, ImageID: %s", userID, imageID)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The previous file is kept, so the new one counts in full
+	if err := reserveQuota(ctx, tx, userID, file.Size, defaultQuota); err != nil {
+		return nil, err
+	}
+
+	if err := archiveCurrentFile(ctx, tx, userID, imageID); err != nil {
+		return nil, err
+	}
+
+	// Version numbers are never reused, also after restoring an older version
+	query := `
+        UPDATE images i
+        SET filename = $3, storage_path = $4, storage_backend = $5, blob_hash = $6, checksum = $7, content_type = $8,
+            size = $9, width = $10, height = $11, taken_at = $12, edits = '[]',
+            version = (SELECT MAX(v.version) FROM image_versions v WHERE v.image_id = i.id) + 1
+        WHERE i.user_id = $1 AND i.id = $2
+        RETURNING ` + imageColumns
+
+	var img models.ImageMetadata
+	err = scanImage(tx.QueryRow(ctx, query, userID, imageID,
+		file.Filename, file.StoragePath, file.StorageBackend, file.BlobHash, file.Checksum, file.ContentType,
+		file.Size, file.Width, file.Height, file.TakenAt,
+	), &img)
+	if err != nil {
+		log.Printf("Error replacing image file: %v", err)
+		return nil, err
+	}
+
+	if err := replaceExif(ctx, tx, imageID, file.Exif); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+	log.Printf("DB: Image %s replaced with version %d", imageID, img.Version)
+	return &img, nil
+}
+
+// RestoreImageVersion swaps a previous version with the current file of an image
+func (s *PostgresStore) RestoreImageVersion(ctx context.Context, userID models.UserID, imageID models.ImageID, version int, exif *models.ImageExif) (*models.ImageMetadata, error) {
+	log.Printf("DB: RestoreImageVersion called for UserID: %s, ImageID: %s, Version: %d", userID, imageID, version)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := archiveCurrentFile(ctx, tx, userID, imageID); err != nil {
+		return nil, err
+	}
+
+	// The version's blob reference moves back to the image together with its row
+	query := `
+        WITH restored AS (
+            DELETE FROM image_versions
+            WHERE image_id = $2 AND version = $3
+            RETURNING *
+        )
+        UPDATE images i
+        SET filename = r.filename, storage_path = r.storage_path, storage_backend = r.storage_backend,
+            blob_hash = r.blob_hash, checksum = r.checksum, content_type = r.content_type, size = r.size,
+            width = r.width, height = r.height, taken_at = r.taken_at, edits = r.edits, version = r.version
+        FROM restored r
+        WHERE i.user_id = $1 AND i.id = $2
+        RETURNING ` + imageColumns
+
+	var img models.ImageMetadata
+	if err := scanImage(tx.QueryRow(ctx, query, userID, imageID, version), &img); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("version not found")
+		}
+		log.Printf("Error restoring image version: %v", err)
+		return nil, err
+	}
+
+	if err := replaceExif(ctx, tx, imageID, exif); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, err
+	}
+	log.Printf("DB: Image %s restored to version %d", imageID, version)
+	return &img, nil
+}
+
+// archiveCurrentFile copies the current file of an image into its versions, as part of
+// a transaction that replaces it. The image row stays locked until the transaction ends.
+func archiveCurrentFile(ctx context.Context, tx pgx.Tx, userID models.UserID, imageID models.ImageID) error {
+	query := `
+        INSERT INTO image_versions (image_id, version, filename, storage_path, storage_backend, blob_hash, checksum,
+                                    content_type, size, width, height, taken_at, edits)
+        SELECT id, version, filename, storage_path, storage_backend, blob_hash, checksum,
+               content_type, size, width, height, taken_at, edits
+        FROM images
+        WHERE user_id = $1 AND id = $2
+        FOR UPDATE`
+
+	result, err := tx.Exec(ctx, query, userID, imageID)
+	if err != nil {
+		log.Printf("Error archiving image file: %v", err)
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errors.New("image not found")
+	}
+	return nil
+}
+
+// replaceExif replaces the EXIF row of an image as part of a transaction. A nil exif
+// removes the row, so the backfill reads the new file.
+func replaceExif(ctx context.Context, tx pgx.Tx, imageID models.ImageID, exif *models.ImageExif) error {
+	if exif == nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM image_exif WHERE image_id = $1`, imageID); err != nil {
+			log.Printf("Error removing image exif: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	exif.ImageID = imageID
+	if err := insertExif(ctx, tx, exif); err != nil {
+		log.Printf("Error saving image exif: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteImageVersions removes the previous versions of an image
+func (s *PostgresStore) DeleteImageVersions(ctx context.Context, imageID models.ImageID) ([]models.ImageVersion, []models.Blob, error) {
+	log.Printf("DB: DeleteImageVersions called for ImageID: %s", imageID)
+
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+        WITH v AS (
+            DELETE FROM image_versions WHERE image_id = $1 RETURNING *
+        )
+        SELECT `+versionColumns+`
+        FROM v
+        JOIN images i ON i.id = v.image_id`, imageID)
+	if err != nil {
+		log.Printf("Error deleting image versions: %v", err)
+		return nil, nil, err
+	}
+	versions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ImageVersion, error) {
+		var v models.ImageVersion
+		err := scanVersion(row, &v)
+		return v, err
+	})
+	if err != nil {
+		log.Printf("Error scanning deleted image versions: %v", err)
+		return nil, nil, err
+	}
+
+	var orphans []models.Blob
+	for _, v := range versions {
+		if v.BlobHash == nil {
+			continue
+		}
+		orphan, err := releaseBlob(ctx, tx, *v.BlobHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if orphan != nil {
+			orphans = append(orphans, *orphan)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return nil, nil, err
+	}
+	return versions, orphans, nil
+}
+
+// ListImageVersionsInStorageBackend retrieves a page of versions stored in a backend,
+// ordered by image ID and version, starting after the given one
+func (s *PostgresStore) ListImageVersionsInStorageBackend(ctx context.Context, backend string, after models.ImageVersion, limit int) ([]models.ImageVersion, error) {
+	query := `
+        SELECT ` + versionColumns + `
+        FROM image_versions v
+        JOIN images i ON i.id = v.image_id
+        WHERE (v.storage_backend = $1 OR v.storage_backend IS NULL)
+          AND (v.image_id, v.version) > ($2, $3)
+        ORDER BY v.image_id, v.version
+        LIMIT $4`
+
+	if after.ImageID == "" {
+		after.ImageID = firstImageID
+	}
+
+	return s.queryVersions(ctx, query, backend, after.ImageID, after.Version, limit)
+}
+
+// SetImageVersionStorageBackend records that a version's file was moved
+func (s *PostgresStore) SetImageVersionStorageBackend(ctx context.Context, imageID models.ImageID, version int, backend string) error {
+	query := `UPDATE image_versions SET storage_backend = $3 WHERE image_id = $1 AND version = $2`
+
+	result, err := s.Pool.Exec(ctx, query, imageID, version, backend)
+	if err != nil {
+		log.Printf("Error updating image version storage backend: %v", err)
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("version not found")
+	}
+	return nil
+}
+
+// ListAllImageVersions retrieves a page of every user's versions, ordered by image ID
+// and version, starting after the given one
+func (s *PostgresStore) ListAllImageVersions(ctx context.Context, after models.ImageVersion, limit int) ([]models.ImageVersion, error) {
+	query := `
+        SELECT ` + versionColumns + `
+        FROM image_versions v
+        JOIN images i ON i.id = v.image_id
+        WHERE (v.image_id, v.version) > ($1, $2)
+        ORDER BY v.image_id, v.version
+        LIMIT $3`
+
+	if after.ImageID == "" {
+		after.ImageID = firstImageID
+	}
+
+	return s.queryVersions(ctx, query, after.ImageID, after.Version, limit)
+}
+
+// queryVersions runs a query selecting versionColumns and scans every row
+func (s *PostgresStore) queryVersions(ctx context.Context, query string, args ...any) ([]models.ImageVersion, error) {
+	rows, err := s.Pool.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error querying image versions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.ImageVersion{}
+	for rows.Next() {
+		var v models.ImageVersion
+		if err := scanVersion(rows, &v); err != nil {
+			log.Printf("Error scanning image version row: %v", err)
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("Error after iterating image version rows: %v", err)
+		return nil, err
+	}
+	return versions, nil
+}
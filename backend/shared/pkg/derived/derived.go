@@ -0,0 +1,306 @@
+package derived
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// How derived images fill the requested box
+const (
+	Contain = "contain" // Fit within the box, keeping the whole image
+	Cover   = "cover"   // Fill the box, cropping the centre of the image
+)
+
+// Output formats
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+)
+
+// Qualities lists the accepted JPEG qualities; like sizes they are restricted so the
+// number of variants that can be cached per image stays bounded
+var Qualities = []int{50, 60, 70, 75, 80, 85, 90, 95}
+
+const defaultQuality = 85
+
+// Params are the normalized parameters of a derived image. Equal parameters always
+// produce the same image, so they key the cache.
+type Params struct {
+	Width   int // 0 leaves the width unconstrained, contain only
+	Height  int // 0 leaves the height unconstrained, contain only
+	Fit     string
+	Format  string
+	Quality int // 0 for lossless formats
+}
+
+// ParseParams validates and normalizes the query parameters of a render request.
+// Widths and heights must be one of sizes. The errors are meant for the client.
+func ParseParams(width, height, fit, format, quality string, sizes []int) (Params, error) {
+	var p Params
+	var err error
+
+	if p.Width, err = parseSize("w", width, sizes); err != nil {
+		return Params{}, err
+	}
+	if p.Height, err = parseSize("h", height, sizes); err != nil {
+		return Params{}, err
+	}
+
+	p.Fit = strings.ToLower(fit)
+	switch p.Fit {
+	case "":
+		p.Fit = Contain
+		fallthrough
+	case Contain:
+		if p.Width == 0 && p.Height == 0 {
+			return Params{}, errors.New("w or h is required")
+		}
+	case Cover:
+		if p.Width == 0 || p.Height == 0 {
+			return Params{}, errors.New("w and h are required with fit=cover")
+		}
+	default:
+		return Params{}, fmt.Errorf("invalid fit %q, use contain or cover", fit)
+	}
+
+	p.Format = strings.ToLower(format)
+	switch p.Format {
+	case "", "jpg", JPEG:
+		p.Format = JPEG
+		p.Quality = defaultQuality
+		if quality != "" {
+			q, err := strconv.Atoi(quality)
+			if err != nil || !slices.Contains(Qualities, q) {
+				return Params{}, fmt.Errorf("invalid q %q, use one of %s", quality, joinInts(Qualities))
+			}
+			p.Quality = q
+		}
+	case PNG:
+		// Lossless, the quality is ignored rather than multiplying identical cache entries
+	default:
+		return Params{}, fmt.Errorf("invalid format %q, use jpeg or png", format)
+	}
+
+	return p, nil
+}
+
+func parseSize(name, value string, sizes []int) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || !slices.Contains(sizes, size) {
+		return 0, fmt.Errorf("invalid %s %q, use one of %s", name, value, joinInts(sizes))
+	}
+	return size, nil
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ContentType returns the MIME type of the output format
+func (p Params) ContentType() string {
+	if p.Format == PNG {
+		return imaging.PNG
+	}
+	return imaging.JPEG
+}
+
+// Key returns the file name of the derived image within its image's cache
+func (p Params) Key() string {
+	return fmt.Sprintf("%dx%d-%s-q%d.%s", p.Width, p.Height, p.Fit, p.Quality, p.Format)
+}
+
+// StoragePath returns where a derived image is cached. Paths include the checksum of the
+// original and a hash of the edits, so a replaced original or a changed edit stack never
+// serves images derived from the previous one.
+func StoragePath(img *models.ImageMetadata, p Params) string {
+	version := "unversioned"
+	if img.Checksum != nil && len(*img.Checksum) >= 16 {
+		version = (*img.Checksum)[:16]
+	}
+	if len(img.Edits) > 0 {
+		encoded, _ := json.Marshal(img.Edits)
+		sum := sha256.Sum256(encoded)
+		version += "-" + hex.EncodeToString(sum[:8])
+	}
+	return path.Join(prefix(img.ID), version, p.Key())
+}
+
+func prefix(imageID models.ImageID) string {
+	return "derived/" + imageID + "/"
+}
+
+// Cache renders derived images on demand and keeps them in blob storage, a bounded
+// number at a time. Identical concurrent requests are rendered once.
+type Cache struct {
+	Storage storage.BlobStorage
+
+	sem   chan struct{} // Limits concurrent renders, decoding takes a lot of memory
+	group singleflight.Group
+}
+
+// NewCache creates a Cache that renders at most workers images at a time
+func NewCache(blobStorage storage.BlobStorage, workers int) *Cache {
+	return &Cache{
+		Storage: blobStorage,
+		sem:     make(chan struct{}, max(1, workers)),
+	}
+}
+
+// Get returns a derived image, rendering and caching it first if needed
+func (c *Cache) Get(ctx context.Context, img *models.ImageMetadata, p Params) (io.ReadSeekCloser, *storage.ObjectInfo, error) {
+	storagePath := StoragePath(img, p)
+
+	file, info, err := c.Storage.Open(ctx, storagePath)
+	if err == nil {
+		return file, info, nil
+	}
+	if !strings.HasPrefix(err.Error(), "file not found") {
+		return nil, nil, err
+	}
+
+	// Callers waiting on the same flight must not fail because the first one went away
+	result, err, _ := c.group.Do(storagePath, func() (any, error) {
+		return c.render(context.WithoutCancel(ctx), img, p, storagePath)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := result.(*rendered)
+	return nopCloser{bytes.NewReader(r.data)}, &r.info, nil
+}
+
+// rendered is a freshly rendered derived image, served from memory
+type rendered struct {
+	data []byte
+	info storage.ObjectInfo
+}
+
+func (c *Cache) render(ctx context.Context, img *models.ImageMetadata, p Params, storagePath string) (*rendered, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	file, _, err := c.Storage.Open(ctx, img.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	src, info, err := imaging.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := info.Orientation
+	if len(img.Edits) > 0 {
+		src, orientation = imaging.ApplyEdits(src, orientation, img.Edits), 1
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, transform(src, orientation, p), p); err != nil {
+		return nil, fmt.Errorf("failed to encode derived image: %w", err)
+	}
+	data := buf.Bytes()
+
+	// Encrypted with the owner's data key when encryption at rest is enabled
+	ctx = storage.WithKeyOwner(ctx, img.UserID)
+	sum := sha256.Sum256(data)
+	result := &rendered{data: data, info: storage.ObjectInfo{
+		Size:        int64(len(data)),
+		ModTime:     time.Now(),
+		ContentType: p.ContentType(),
+		ETag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+	}}
+
+	// A failure to cache only costs rendering again next time
+	if err := c.Storage.Put(ctx, storagePath, bytes.NewReader(data), p.ContentType()); err != nil {
+		log.Printf("Warning: Failed to cache derived image %s: %v", storagePath, err)
+		return result, nil
+	}
+	// Later requests are served from storage, so its ETag is the one to match
+	if stored, err := c.Storage.Stat(ctx, storagePath); err == nil && stored.ETag != "" {
+		result.info = *stored
+	}
+
+	log.Printf("Rendered derived image %s", storagePath)
+	return result, nil
+}
+
+// transform scales src as asked by p and turns it upright. The scaling is done in the
+// orientation src is stored in, so only the smaller result needs turning.
+func transform(src image.Image, orientation int, p Params) image.Image {
+	width, height := imaging.OrientedSize(p.Width, p.Height, orientation)
+
+	var scaled image.Image
+	if p.Fit == Cover {
+		scaled = imaging.Cover(src, width, height)
+	} else {
+		if width == 0 {
+			width = imaging.MaxPixels
+		}
+		if height == 0 {
+			height = imaging.MaxPixels
+		}
+		scaled = imaging.Fit(src, width, height)
+	}
+	return imaging.Orient(scaled, orientation)
+}
+
+func encode(w io.Writer, img image.Image, p Params) error {
+	if p.Format == PNG {
+		return png.Encode(w, img)
+	}
+	return imaging.EncodeJPEG(w, img, p.Quality)
+}
+
+// Delete removes every cached image derived from an image, when it is deleted, edited or
+// its original replaced. Failures are only logged, the files are left behind as orphans.
+func (c *Cache) Delete(ctx context.Context, imageID models.ImageID) {
+	var paths []string
+	err := c.Storage.Walk(ctx, prefix(imageID), func(storagePath string, _ *storage.ObjectInfo) error {
+		paths = append(paths, storagePath)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to list derived images of image %s: %v", imageID, err)
+	}
+
+	for _, storagePath := range paths {
+		if err := c.Storage.Delete(ctx, storagePath); err != nil && !strings.HasPrefix(err.Error(), "file not found") {
+			log.Printf("Warning: Failed to delete derived image %s: %v", storagePath, err)
+		}
+	}
+}
+
+// nopCloser adds a no-op Close to an in-memory reader
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,213 @@
+package derived
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+var testSizes = []int{16, 32, 64}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name                          string
+		width, height, fit, format, q string
+		want                          Params
+		wantErr                       string
+	}{
+		{name: "width only", width: "32", want: Params{Width: 32, Fit: Contain, Format: JPEG, Quality: 85}},
+		{name: "height only", height: "16", want: Params{Height: 16, Fit: Contain, Format: JPEG, Quality: 85}},
+		{name: "cover", width: "32", height: "16", fit: "cover", q: "90", want: Params{Width: 32, Height: 16, Fit: Cover, Format: JPEG, Quality: 90}},
+		{name: "names are case insensitive", width: "32", fit: "CONTAIN", format: "JPG", want: Params{Width: 32, Fit: Contain, Format: JPEG, Quality: 85}},
+		{name: "png ignores the quality", width: "32", format: "png", q: "90", want: Params{Width: 32, Fit: Contain, Format: PNG}},
+		{name: "no size", wantErr: "w or h is required"},
+		{name: "cover needs both sides", width: "32", fit: "cover", wantErr: "w and h are required with fit=cover"},
+		{name: "size not allowed", width: "33", wantErr: `invalid w "33", use one of 16, 32, 64`},
+		{name: "size not a number", height: "big", wantErr: `invalid h "big"`},
+		{name: "negative size", width: "-32", wantErr: `invalid w "-32"`},
+		{name: "unknown fit", width: "32", fit: "stretch", wantErr: `invalid fit "stretch"`},
+		{name: "unknown format", width: "32", format: "gif", wantErr: `invalid format "gif"`},
+		{name: "quality not allowed", width: "32", q: "42", wantErr: `invalid q "42"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseParams(tt.width, tt.height, tt.fit, tt.format, tt.q, testSizes)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStoragePath(t *testing.T) {
+	checksum := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	p := Params{Width: 32, Fit: Contain, Format: JPEG, Quality: 85}
+	rotate := []models.ImageEdit{{Op: models.EditRotate, Angle: 90}}
+
+	base := StoragePath(&models.ImageMetadata{ID: "img-1", Checksum: &checksum}, p)
+	assert.Equal(t, "derived/img-1/abababababababab/32x0-contain-q85.jpeg", base)
+
+	tests := []struct {
+		name string
+		img  *models.ImageMetadata
+		p    Params
+	}{
+		{name: "other parameters", img: &models.ImageMetadata{ID: "img-1", Checksum: &checksum}, p: Params{Width: 64, Fit: Contain, Format: JPEG, Quality: 85}},
+		{name: "replaced original", img: &models.ImageMetadata{ID: "img-1", Checksum: &other}, p: p},
+		{name: "edited", img: &models.ImageMetadata{ID: "img-1", Checksum: &checksum, Edits: rotate}, p: p},
+		{name: "no checksum", img: &models.ImageMetadata{ID: "img-1"}, p: p},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StoragePath(tt.img, tt.p)
+			assert.NotEqual(t, base, got)
+			assert.True(t, strings.HasPrefix(got, "derived/img-1/"), got)
+		})
+	}
+}
+
+// failingPutStorage cannot store new files
+type failingPutStorage struct {
+	storage.BlobStorage
+}
+
+func (failingPutStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	return errors.New("disk full")
+}
+
+// walkCountingStorage counts the listings of stored files
+type walkCountingStorage struct {
+	storage.BlobStorage
+	walks int
+}
+
+func (s *walkCountingStorage) Walk(ctx context.Context, prefix string, fn storage.WalkFunc) error {
+	s.walks++
+	return s.BlobStorage.Walk(ctx, prefix, fn)
+}
+
+// newTestCache returns a Cache over local storage holding a 64x32 PNG as the original of the returned image
+func newTestCache(t *testing.T) (*Cache, *storage.LocalStorage, *models.ImageMetadata) {
+	t.Helper()
+
+	local, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	src := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	draw.Draw(src, src.Bounds(), &image.Uniform{C: color.RGBA{R: 200, G: 100, A: 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	require.NoError(t, local.Put(context.Background(), "user_1/img-1.png", bytes.NewReader(buf.Bytes()), "image/png"))
+
+	checksum := strings.Repeat("ab", 32)
+	img := &models.ImageMetadata{ID: "img-1", UserID: "user-1", StoragePath: "user_1/img-1.png", Checksum: &checksum}
+	return NewCache(local, 1), local, img
+}
+
+func getDerived(t *testing.T, c *Cache, img *models.ImageMetadata, p Params) ([]byte, *storage.ObjectInfo) {
+	t.Helper()
+
+	file, info, err := c.Get(context.Background(), img, p)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return data, info
+}
+
+func listDerived(t *testing.T, s storage.BlobStorage) []string {
+	t.Helper()
+
+	var paths []string
+	require.NoError(t, s.Walk(context.Background(), "derived/", func(storagePath string, _ *storage.ObjectInfo) error {
+		paths = append(paths, storagePath)
+		return nil
+	}))
+	return paths
+}
+
+func TestCacheGet(t *testing.T) {
+	small := Params{Width: 32, Fit: Contain, Format: JPEG, Quality: 85}
+	square := Params{Width: 16, Height: 16, Fit: Cover, Format: PNG}
+
+	t.Run("rendered once, then served from storage", func(t *testing.T) {
+		c, local, img := newTestCache(t)
+
+		data, info := getDerived(t, c, img, small)
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, [2]int{32, 16}, [2]int{cfg.Width, cfg.Height})
+		assert.NotEmpty(t, info.ETag)
+		assert.Equal(t, []string{StoragePath(img, small)}, listDerived(t, local))
+
+		again, cachedInfo := getDerived(t, c, img, small)
+		assert.Equal(t, data, again)
+		assert.Equal(t, info.ETag, cachedInfo.ETag, "the ETag must not change once the image is cached")
+	})
+
+	t.Run("etag without a cached copy", func(t *testing.T) {
+		c, local, img := newTestCache(t)
+		c.Storage = failingPutStorage{BlobStorage: local}
+
+		data, info := getDerived(t, c, img, square)
+		sum := sha256.Sum256(data)
+		assert.Equal(t, `"`+hex.EncodeToString(sum[:])+`"`, info.ETag)
+
+		_, again := getDerived(t, c, img, square)
+		assert.Equal(t, info.ETag, again.ETag, "renders of the same variant must match")
+		assert.Empty(t, listDerived(t, local))
+	})
+
+	t.Run("renders do not list storage", func(t *testing.T) {
+		c, local, img := newTestCache(t)
+		walking := &walkCountingStorage{BlobStorage: local}
+		c.Storage = walking
+		getDerived(t, c, img, small)
+		getDerived(t, c, img, square)
+
+		replaced := *img
+		checksum := strings.Repeat("cd", 32)
+		replaced.Checksum = &checksum
+		getDerived(t, c, &replaced, small)
+
+		edited := replaced
+		edited.Edits = []models.ImageEdit{{Op: models.EditRotate, Angle: 90}}
+		_, info := getDerived(t, c, &edited, small)
+		assert.NotZero(t, info.Size)
+
+		assert.Zero(t, walking.walks)
+		assert.Len(t, listDerived(t, local), 4, "variants of other versions are left for Delete")
+	})
+
+	t.Run("delete removes every variant", func(t *testing.T) {
+		c, local, img := newTestCache(t)
+		getDerived(t, c, img, small)
+		getDerived(t, c, img, square)
+
+		c.Delete(context.Background(), img.ID)
+		assert.Empty(t, listDerived(t, local))
+	})
+}
@@ -0,0 +1,132 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Exposure changes are limited to what still looks like the same photo
+const maxExposureStops = 3
+
+// ValidateEdit checks that an edit can be applied. The errors are meant for the client.
+func ValidateEdit(edit models.ImageEdit) error {
+	switch edit.Op {
+	case models.EditRotate:
+		if edit.Angle != 90 && edit.Angle != 180 && edit.Angle != 270 {
+			return fmt.Errorf("invalid angle %d, use 90, 180 or 270", edit.Angle)
+		}
+	case models.EditFlip:
+		if edit.Axis != "horizontal" && edit.Axis != "vertical" {
+			return fmt.Errorf("invalid axis %q, use horizontal or vertical", edit.Axis)
+		}
+	case models.EditCrop:
+		box := edit.Crop
+		if box == nil {
+			return errors.New("crop is required")
+		}
+		if box.X < 0 || box.Y < 0 || box.Width <= 0 || box.Height <= 0 || box.X+box.Width > 1 || box.Y+box.Height > 1 {
+			return errors.New("invalid crop, x, y, width and height are fractions of the image and must lie within it")
+		}
+	case models.EditExposure:
+		if edit.Stops == 0 || math.Abs(edit.Stops) > maxExposureStops || math.IsNaN(edit.Stops) {
+			return fmt.Errorf("invalid stops %v, use a non-zero value from -%d to %d", edit.Stops, maxExposureStops, maxExposureStops)
+		}
+	default:
+		return fmt.Errorf("invalid op %q, use rotate, crop, flip or exposure", edit.Op)
+	}
+	return nil
+}
+
+// ApplyEdits turns an image upright according to its EXIF orientation and applies an
+// edit stack to it, in order. The result is full size, callers scale it afterwards so
+// crops keep their resolution.
+func ApplyEdits(src image.Image, orientation int, edits []models.ImageEdit) image.Image {
+	img := Orient(src, orientation)
+	for _, edit := range edits {
+		switch edit.Op {
+		case models.EditRotate:
+			// Turning is what EXIF orientations describe, clockwise 90° is orientation 6
+			img = Orient(img, map[int]int{90: 6, 180: 3, 270: 8}[edit.Angle])
+		case models.EditFlip:
+			if edit.Axis == "vertical" {
+				img = Orient(img, 4)
+			} else {
+				img = Orient(img, 2)
+			}
+		case models.EditCrop:
+			img = crop(img, *edit.Crop)
+		case models.EditExposure:
+			img = expose(img, edit.Stops)
+		}
+	}
+	return img
+}
+
+// EditedSize returns the size of a width x height image, already upright, once an
+// edit stack is applied
+func EditedSize(width, height int, edits []models.ImageEdit) (int, int) {
+	for _, edit := range edits {
+		switch edit.Op {
+		case models.EditRotate:
+			if edit.Angle != 180 {
+				width, height = height, width
+			}
+		case models.EditCrop:
+			r := cropRect(width, height, *edit.Crop)
+			width, height = r.Dx(), r.Dy()
+		}
+	}
+	return width, height
+}
+
+// cropRect converts a crop box to pixels of a width x height image, keeping at least one pixel
+func cropRect(width, height int, box models.CropBox) image.Rectangle {
+	x0 := int(math.Round(box.X * float64(width)))
+	y0 := int(math.Round(box.Y * float64(height)))
+	x1 := int(math.Round((box.X + box.Width) * float64(width)))
+	y1 := int(math.Round((box.Y + box.Height) * float64(height)))
+
+	x0, y0 = clamp(x0, 0, width-1), clamp(y0, 0, height-1)
+	x1, y1 = clamp(x1, x0+1, width), clamp(y1, y0+1, height)
+	return image.Rect(x0, y0, x1, y1)
+}
+
+func crop(src image.Image, box models.CropBox) image.Image {
+	bounds := src.Bounds()
+	r := cropRect(bounds.Dx(), bounds.Dy(), box).Add(bounds.Min)
+
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Rect, src, r.Min, draw.Src)
+	return dst
+}
+
+// expose scales the light of an image by 2^stops. The change is made in linear RGB,
+// as a camera would, so highlights clip instead of turning grey.
+func expose(src image.Image, stops float64) image.Image {
+	factor := math.Pow(2, stops)
+	var curve [256]uint8
+	for v := range curve {
+		curve[v] = uint8(linearToSRGB(srgbToLinear(uint8(v)) * factor))
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(dst, dst.Rect, src, src.Bounds().Min, draw.Src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		a := dst.Pix[i+3]
+		if a == 0 {
+			continue
+		}
+		// RGBA is premultiplied, the curve applies to the colour itself
+		for c := 0; c < 3; c++ {
+			v := curve[int(dst.Pix[i+c])*255/int(a)]
+			dst.Pix[i+c] = uint8(int(v) * int(a) / 255)
+		}
+	}
+	return dst
+}
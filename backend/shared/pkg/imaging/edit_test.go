@@ -0,0 +1,103 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+func TestValidateEdit(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    models.ImageEdit
+		wantErr string
+	}{
+		{name: "rotate", edit: models.ImageEdit{Op: models.EditRotate, Angle: 270}},
+		{name: "rotate by another angle", edit: models.ImageEdit{Op: models.EditRotate, Angle: 45}, wantErr: "invalid angle 45"},
+		{name: "flip", edit: models.ImageEdit{Op: models.EditFlip, Axis: "vertical"}},
+		{name: "flip without an axis", edit: models.ImageEdit{Op: models.EditFlip}, wantErr: `invalid axis ""`},
+		{name: "crop", edit: models.ImageEdit{Op: models.EditCrop, Crop: &models.CropBox{X: 0.25, Y: 0.5, Width: 0.75, Height: 0.5}}},
+		{name: "crop without a box", edit: models.ImageEdit{Op: models.EditCrop}, wantErr: "crop is required"},
+		{name: "crop past the edge", edit: models.ImageEdit{Op: models.EditCrop, Crop: &models.CropBox{X: 0.5, Width: 0.6, Height: 1}}, wantErr: "invalid crop"},
+		{name: "empty crop", edit: models.ImageEdit{Op: models.EditCrop, Crop: &models.CropBox{Width: 0, Height: 1}}, wantErr: "invalid crop"},
+		{name: "exposure", edit: models.ImageEdit{Op: models.EditExposure, Stops: -1.5}},
+		{name: "no exposure change", edit: models.ImageEdit{Op: models.EditExposure}, wantErr: "invalid stops 0"},
+		{name: "too much exposure", edit: models.ImageEdit{Op: models.EditExposure, Stops: 4}, wantErr: "invalid stops 4"},
+		{name: "exposure not a number", edit: models.ImageEdit{Op: models.EditExposure, Stops: math.NaN()}, wantErr: "invalid stops NaN"},
+		{name: "unknown op", edit: models.ImageEdit{Op: "blur"}, wantErr: `invalid op "blur"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEdit(tt.edit)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	// A 40x20 image, with its top-left pixel marked
+	src := solidImage(40, 20, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	marked := func(c color.Color) bool {
+		r, g, _, _ := c.RGBA()
+		return r>>8 == 255 && g == 0
+	}
+
+	tests := []struct {
+		name        string
+		orientation int
+		edits       []models.ImageEdit
+		wantSize    [2]int
+		wantMark    image.Point // Where the marked pixel ends up
+	}{
+		{name: "no edits", wantSize: [2]int{40, 20}, wantMark: image.Pt(0, 0)},
+		{name: "rotate", edits: []models.ImageEdit{{Op: models.EditRotate, Angle: 90}}, wantSize: [2]int{20, 40}, wantMark: image.Pt(19, 0)},
+		{name: "rotate after the orientation", orientation: 6, edits: []models.ImageEdit{{Op: models.EditRotate, Angle: 270}}, wantSize: [2]int{40, 20}, wantMark: image.Pt(0, 0)},
+		{name: "flip", edits: []models.ImageEdit{{Op: models.EditFlip, Axis: "horizontal"}}, wantSize: [2]int{40, 20}, wantMark: image.Pt(39, 0)},
+		{name: "flip vertically", edits: []models.ImageEdit{{Op: models.EditFlip, Axis: "vertical"}}, wantSize: [2]int{40, 20}, wantMark: image.Pt(0, 19)},
+		{
+			name:     "crop after a rotation",
+			edits:    []models.ImageEdit{{Op: models.EditRotate, Angle: 180}, {Op: models.EditCrop, Crop: &models.CropBox{X: 0.5, Y: 0.5, Width: 0.5, Height: 0.5}}},
+			wantSize: [2]int{20, 10},
+			wantMark: image.Pt(19, 9),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ApplyEdits(src, max(1, tt.orientation), tt.edits)
+			bounds := got.Bounds()
+			assert.Equal(t, tt.wantSize, [2]int{bounds.Dx(), bounds.Dy()})
+			assert.True(t, marked(got.At(bounds.Min.X+tt.wantMark.X, bounds.Min.Y+tt.wantMark.Y)), "marked pixel at %v", tt.wantMark)
+
+			// The size is known without applying the edits
+			upright := Orient(src, max(1, tt.orientation)).Bounds()
+			w, h := EditedSize(upright.Dx(), upright.Dy(), tt.edits)
+			assert.Equal(t, tt.wantSize, [2]int{w, h})
+		})
+	}
+
+	t.Run("exposure", func(t *testing.T) {
+		brighter := ApplyEdits(src, 1, []models.ImageEdit{{Op: models.EditExposure, Stops: 1}})
+		darker := ApplyEdits(src, 1, []models.ImageEdit{{Op: models.EditExposure, Stops: -1}})
+		grey := func(img image.Image) uint32 {
+			r, _, _, _ := img.At(20, 10).RGBA()
+			return r >> 8
+		}
+		assert.Greater(t, grey(brighter), uint32(100))
+		assert.Less(t, grey(darker), uint32(100))
+		assert.True(t, marked(brighter.At(0, 0)), "highlights clip instead of turning grey")
+	})
+}
@@ -0,0 +1,195 @@
+package imaging
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Fields of the EXIF sub-IFD that goexif does not know about
+const (
+	offsetTime         exif.FieldName = "OffsetTime"
+	offsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+)
+
+func init() {
+	exif.RegisterParsers(offsetTimeParser{})
+}
+
+// offsetTimeParser loads the UTC offsets of the capture time, which cameras have
+// recorded since EXIF 2.31 next to the otherwise zoneless DateTimeOriginal
+type offsetTimeParser struct{}
+
+func (offsetTimeParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil // Reported by the default parser
+	}
+
+	x.LoadTags(dir, map[uint16]exif.FieldName{0x9010: offsetTime, 0x9011: offsetTimeOriginal}, false)
+	return nil
+}
+
+// exifTimeLayout is the format of EXIF date/time fields
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// ReadExif extracts capture metadata from the EXIF block of a JPEG. It returns nil
+// for other formats and for images without EXIF data. The reader is left at the start.
+func ReadExif(r io.ReadSeeker, contentType string) (*models.ImageExif, error) {
+	if contentType != JPEG {
+		return nil, nil
+	}
+
+	x, err := exif.Decode(r)
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		// Most often there is no EXIF block at all. A damaged one is no reason to refuse the image either.
+		return nil, nil
+	}
+
+	meta := &models.ImageExif{
+		TakenAt:      takenAt(x),
+		CameraMake:   exifString(x, exif.Make),
+		CameraModel:  exifString(x, exif.Model),
+		LensModel:    exifString(x, exif.LensModel),
+		FocalLength:  exifFloat(x, exif.FocalLength),
+		FNumber:      exifFloat(x, exif.FNumber),
+		ExposureTime: exposureTime(x),
+		ISO:          exifInt(x, exif.ISOSpeedRatings),
+		Orientation:  exifInt(x, exif.Orientation),
+	}
+
+	if lat, long, err := x.LatLong(); err == nil && !math.IsNaN(lat) && !math.IsNaN(long) {
+		meta.Latitude, meta.Longitude = &lat, &long
+		meta.Altitude = altitude(x)
+	}
+
+	return meta, nil
+}
+
+// takenAt returns when the photo was taken. Without a recorded UTC offset the
+// camera's local time is returned as if it were UTC.
+func takenAt(x *exif.Exif) *time.Time {
+	value := exifString(x, exif.DateTimeOriginal)
+	if value == nil {
+		value = exifString(x, exif.DateTime)
+	}
+	if value == nil {
+		return nil
+	}
+
+	loc := time.UTC
+	for _, field := range []exif.FieldName{offsetTimeOriginal, offsetTime} {
+		if offset := exifString(x, field); offset != nil {
+			if t, err := time.Parse("-07:00", *offset); err == nil {
+				_, seconds := t.Zone()
+				loc = time.FixedZone(*offset, seconds)
+				break
+			}
+		}
+	}
+
+	t, err := time.ParseInLocation(exifTimeLayout, *value, loc)
+	if err != nil {
+		return nil // e.g. "0000:00:00 00:00:00" from cameras without a clock
+	}
+	return &t
+}
+
+// exposureTime formats the exposure time the way cameras show it, e.g. "1/250" or "2.5"
+func exposureTime(x *exif.Exif) *string {
+	tag, err := x.Get(exif.ExposureTime)
+	if err != nil {
+		return nil
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || num <= 0 || den <= 0 {
+		return nil
+	}
+
+	var value string
+	if num < den {
+		value = "1/" + strconv.FormatFloat(math.Round(float64(den)/float64(num)), 'f', -1, 64)
+	} else {
+		value = strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+	}
+	return &value
+}
+
+func altitude(x *exif.Exif) *float64 {
+	alt := exifFloat(x, exif.GPSAltitude)
+	if alt == nil {
+		return nil
+	}
+	// A reference of 1 means below sea level
+	if ref := exifInt(x, exif.GPSAltitudeRef); ref != nil && *ref == 1 {
+		*alt = -*alt
+	}
+	return alt
+}
+
+func exifString(x *exif.Exif, field exif.FieldName) *string {
+	tag, err := x.Get(field)
+	if err != nil {
+		return nil
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return nil
+	}
+
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func exifInt(x *exif.Exif, field exif.FieldName) *int {
+	tag, err := x.Get(field)
+	if err != nil {
+		return nil
+	}
+	value, err := tag.Int(0)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+func exifFloat(x *exif.Exif, field exif.FieldName) *float64 {
+	tag, err := x.Get(field)
+	if err != nil {
+		return nil
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return nil
+	}
+
+	value := float64(num) / float64(den)
+	return &value
+}
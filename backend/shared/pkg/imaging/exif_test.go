@@ -0,0 +1,252 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// tiffEntry is a field of a TIFF directory, with its value already encoded little endian
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiField(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func byteField(tag uint16, v byte) tiffEntry {
+	return tiffEntry{tag: tag, typ: 1, count: 1, value: []byte{v}}
+}
+
+func shortField(tag uint16, v uint16) tiffEntry {
+	return tiffEntry{tag: tag, typ: 3, count: 1, value: binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+// rationalField holds num/den pairs
+func rationalField(tag uint16, pairs ...uint32) tiffEntry {
+	var value []byte
+	for _, v := range pairs {
+		value = binary.LittleEndian.AppendUint32(value, v)
+	}
+	return tiffEntry{tag: tag, typ: 5, count: uint32(len(pairs) / 2), value: value}
+}
+
+// ifdSize is the size of a directory and the values that do not fit in its entries
+func ifdSize(entries []tiffEntry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += len(e.value) + len(e.value)%2
+		}
+	}
+	return size
+}
+
+// exifPayload builds the APP1 payload of a JPEG: an EXIF header followed by a TIFF
+// block with IFD0 and, if given, the EXIF and GPS directories
+func exifPayload(ifd0, exifIFD, gpsIFD []tiffEntry) []byte {
+	ifd0 = append([]tiffEntry(nil), ifd0...)
+	offset := 8 + ifdSize(ifd0)
+	if len(exifIFD) > 0 {
+		offset += 12
+	}
+	if len(gpsIFD) > 0 {
+		offset += 12
+	}
+	if len(exifIFD) > 0 {
+		ifd0 = append(ifd0, tiffEntry{tag: 0x8769, typ: 4, count: 1, value: binary.LittleEndian.AppendUint32(nil, uint32(offset))})
+		offset += ifdSize(exifIFD)
+	}
+	if len(gpsIFD) > 0 {
+		ifd0 = append(ifd0, tiffEntry{tag: 0x8825, typ: 4, count: 1, value: binary.LittleEndian.AppendUint32(nil, uint32(offset))})
+	}
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	for _, entries := range [][]tiffEntry{ifd0, exifIFD, gpsIFD} {
+		if len(entries) > 0 {
+			tiff = appendIFD(tiff, entries)
+		}
+	}
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// appendIFD writes a directory at the end of tiff, followed by its out-of-line values
+func appendIFD(tiff []byte, entries []tiffEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	dataOffset := len(tiff) + 2 + 12*len(entries) + 4
+
+	var data []byte
+	tiff = binary.LittleEndian.AppendUint16(tiff, uint16(len(entries)))
+	for _, e := range entries {
+		tiff = binary.LittleEndian.AppendUint16(tiff, e.tag)
+		tiff = binary.LittleEndian.AppendUint16(tiff, e.typ)
+		tiff = binary.LittleEndian.AppendUint32(tiff, e.count)
+		if len(e.value) <= 4 {
+			tiff = append(tiff, e.value...)
+			tiff = append(tiff, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		tiff = binary.LittleEndian.AppendUint32(tiff, uint32(dataOffset+len(data)))
+		data = append(data, e.value...)
+		if len(e.value)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0) // No further directory
+	return append(tiff, data...)
+}
+
+// withSegment inserts a marker segment right after the SOI marker of a JPEG
+func withSegment(jpegFile []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte(nil), jpegFile[:2]...)
+	out = append(out, segment...)
+	return append(out, jpegFile[2:]...)
+}
+
+// jpegWithExif encodes a small JPEG carrying the given EXIF fields
+func jpegWithExif(t *testing.T, ifd0, exifIFD, gpsIFD []tiffEntry) []byte {
+	t.Helper()
+	return withSegment(encode(t, JPEG, solidImage(8, 6, color.Gray{Y: 128})), markerAPP1, exifPayload(ifd0, exifIFD, gpsIFD))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestReadExif(t *testing.T) {
+	paris := []tiffEntry{
+		asciiField(0x0001, "N"), rationalField(0x0002, 48, 1, 51, 1, 295, 10),
+		asciiField(0x0003, "E"), rationalField(0x0004, 2, 1, 17, 1, 40, 1),
+		byteField(0x0005, 0), rationalField(0x0006, 35, 1),
+	}
+
+	tests := []struct {
+		name        string
+		content     []byte
+		contentType string
+		want        *models.ImageExif
+	}{
+		{
+			name: "camera metadata",
+			content: jpegWithExif(t,
+				[]tiffEntry{asciiField(0x010F, "Canon"), asciiField(0x0110, "Canon EOS R5 "), shortField(0x0112, 6)},
+				[]tiffEntry{
+					rationalField(0x829A, 1, 250), rationalField(0x829D, 28, 10), shortField(0x8827, 400),
+					asciiField(0x9003, "2023:06:01 14:30:00"), asciiField(0x9011, "+02:00"),
+					rationalField(0x920A, 50, 1), asciiField(0xA434, "RF50mm F1.8 STM"),
+				},
+				paris),
+			contentType: JPEG,
+			want: &models.ImageExif{
+				TakenAt:      ptr(time.Date(2023, 6, 1, 14, 30, 0, 0, time.FixedZone("+02:00", 2*60*60))),
+				CameraMake:   ptr("Canon"),
+				CameraModel:  ptr("Canon EOS R5"),
+				LensModel:    ptr("RF50mm F1.8 STM"),
+				FocalLength:  ptr(50.0),
+				FNumber:      ptr(2.8),
+				ExposureTime: ptr("1/250"),
+				ISO:          ptr(400),
+				Orientation:  ptr(6),
+				Latitude:     ptr(48 + 51.0/60 + 29.5/3600),
+				Longitude:    ptr(2 + 17.0/60 + 40.0/3600),
+				Altitude:     ptr(35.0),
+			},
+		},
+		{
+			name:        "capture time without an offset",
+			content:     jpegWithExif(t, nil, []tiffEntry{asciiField(0x9003, "2023:06:01 14:30:00")}, nil),
+			contentType: JPEG,
+			want:        &models.ImageExif{TakenAt: ptr(time.Date(2023, 6, 1, 14, 30, 0, 0, time.UTC))},
+		},
+		{
+			name:        "modification time when the capture time is missing",
+			content:     jpegWithExif(t, []tiffEntry{asciiField(0x0132, "2020:01:02 03:04:05")}, nil, nil),
+			contentType: JPEG,
+			want:        &models.ImageExif{TakenAt: ptr(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))},
+		},
+		{
+			name:        "camera without a clock",
+			content:     jpegWithExif(t, []tiffEntry{asciiField(0x010F, "Acme")}, []tiffEntry{asciiField(0x9003, "0000:00:00 00:00:00")}, nil),
+			contentType: JPEG,
+			want:        &models.ImageExif{CameraMake: ptr("Acme")},
+		},
+		{
+			name:        "long exposure",
+			content:     jpegWithExif(t, nil, []tiffEntry{rationalField(0x829A, 5, 2)}, nil),
+			contentType: JPEG,
+			want:        &models.ImageExif{ExposureTime: ptr("2.5")},
+		},
+		{
+			name: "below sea level",
+			content: jpegWithExif(t, nil, nil, []tiffEntry{
+				asciiField(0x0001, "S"), rationalField(0x0002, 31, 1, 30, 1, 0, 1),
+				asciiField(0x0003, "E"), rationalField(0x0004, 35, 1, 30, 1, 0, 1),
+				byteField(0x0005, 1), rationalField(0x0006, 430, 1),
+			}),
+			contentType: JPEG,
+			want:        &models.ImageExif{Latitude: ptr(-31.5), Longitude: ptr(35.5), Altitude: ptr(-430.0)},
+		},
+		{
+			name:        "no exif block",
+			content:     encode(t, JPEG, solidImage(8, 6, color.White)),
+			contentType: JPEG,
+		},
+		{
+			name:        "damaged exif block",
+			content:     withSegment(encode(t, JPEG, solidImage(8, 6, color.White)), markerAPP1, []byte("Exif\x00\x00II*\x00\xff\xff\xff\xff")),
+			contentType: JPEG,
+		},
+		{
+			name:        "not a jpeg",
+			content:     encode(t, PNG, solidImage(8, 6, color.White)),
+			contentType: PNG,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.content)
+			got, err := ReadExif(r, tt.contentType)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.content), r.Len(), "the reader must be left at the start")
+
+			if tt.want == nil {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			if tt.want.TakenAt != nil {
+				require.NotNil(t, got.TakenAt)
+				assert.True(t, tt.want.TakenAt.Equal(*got.TakenAt), "taken at %s, want %s", got.TakenAt, tt.want.TakenAt)
+				_, wantOffset := tt.want.TakenAt.Zone()
+				_, gotOffset := got.TakenAt.Zone()
+				assert.Equal(t, wantOffset, gotOffset)
+				got.TakenAt, tt.want.TakenAt = nil, nil
+			}
+			if tt.want.Latitude != nil {
+				require.NotNil(t, got.Latitude)
+				require.NotNil(t, got.Longitude)
+				assert.InDelta(t, *tt.want.Latitude, *got.Latitude, 1e-9)
+				assert.InDelta(t, *tt.want.Longitude, *got.Longitude, 1e-9)
+				got.Latitude, got.Longitude, tt.want.Latitude, tt.want.Longitude = nil, nil, nil, nil
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	// Register the decoders used by image.DecodeConfig and image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Supported image formats, by MIME type
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	GIF  = "image/gif"
+	WebP = "image/webp"
+)
+
+// formatNames maps the format names reported by the image package to MIME types
+var formatNames = map[string]string{
+	"jpeg": JPEG,
+	"png":  PNG,
+	"gif":  GIF,
+	"webp": WebP,
+}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrUndecodable       = errors.New("file is not a decodable image")
+)
+
+// sniffLen is the number of leading bytes needed to recognise every supported format
+const sniffLen = 12
+
+// IsSupported reports whether images of contentType are accepted
+func IsSupported(contentType string) bool {
+	switch contentType {
+	case JPEG, PNG, GIF, WebP:
+		return true
+	}
+	return false
+}
+
+// Sniff identifies a supported image format from the magic bytes at the start of a file.
+// It returns "" if the bytes do not start a supported image.
+func Sniff(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return GIF
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return WebP
+	}
+	return ""
+}
+
+// SniffReader identifies the format of a file from its first bytes and seeks back to the start
+func SniffReader(r io.ReadSeeker) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return Sniff(head[:n]), nil
+}
+
+// Info describes an image, as read from its header
+type Info struct {
+	ContentType string
+	// Width and Height are the size the image is displayed at, after turning it upright
+	Width  int
+	Height int
+	// Orientation is the EXIF orientation of JPEGs, 1 (upright) to 8, and 1 for other formats
+	Orientation int
+}
+
+// Inspect identifies the format of an image from its content and reads its dimensions
+// and orientation from the header, which also checks that it decodes as that format.
+// The bitmap itself is not decoded. The reader is left at the start.
+func Inspect(r io.ReadSeeker) (*Info, error) {
+	contentType, err := SniffReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	cfg, format, err := image.DecodeConfig(r)
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUndecodable, err)
+	}
+	if formatNames[format] != contentType {
+		return nil, fmt.Errorf("%w: %s file decodes as %s", ErrUndecodable, contentType, format)
+	}
+
+	info := &Info{ContentType: contentType, Width: cfg.Width, Height: cfg.Height, Orientation: 1}
+	if contentType == JPEG {
+		if info.Orientation, err = readJPEGOrientation(r); err != nil {
+			return nil, err
+		}
+		info.Width, info.Height = OrientedSize(cfg.Width, cfg.Height, info.Orientation)
+	}
+	return info, nil
+}
@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// solidImage returns a w×h image of a single colour
+func solidImage(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	return img
+}
+
+// encode writes img in the format of contentType. WebP files are a lossless header only,
+// which is all Inspect reads.
+func encode(t *testing.T, contentType string, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	switch contentType {
+	case JPEG:
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	case PNG:
+		require.NoError(t, png.Encode(&buf, img))
+	case GIF:
+		require.NoError(t, gif.Encode(&buf, img, nil))
+	case WebP:
+		w, h := img.Bounds().Dx()-1, img.Bounds().Dy()-1
+		bits := uint32(w) | uint32(h)<<14
+		buf.WriteString("RIFF\x12\x00\x00\x00WEBPVP8L\x05\x00\x00\x00\x2f")
+		buf.Write([]byte{byte(bits), byte(bits >> 8), byte(bits >> 16), byte(bits >> 24), 0})
+	default:
+		t.Fatalf("cannot encode %s", contentType)
+	}
+	return buf.Bytes()
+}
+
+func TestSniff(t *testing.T) {
+	img := solidImage(4, 4, color.White)
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{name: "jpeg", head: encode(t, JPEG, img), want: JPEG},
+		{name: "png", head: encode(t, PNG, img), want: PNG},
+		{name: "gif89a", head: encode(t, GIF, img), want: GIF},
+		{name: "gif87a", head: []byte("GIF87a\x04\x00\x04\x00"), want: GIF},
+		{name: "webp", head: encode(t, WebP, img), want: WebP},
+		{name: "riff but not webp", head: []byte("RIFF\x12\x00\x00\x00WAVEfmt "), want: ""},
+		{name: "text", head: []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), want: ""},
+		{name: "too short", head: []byte{0xFF, 0xD8}, want: ""},
+		{name: "empty", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Sniff(tt.head))
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	img := solidImage(6, 4, color.RGBA{R: 200, A: 255})
+	pngFile := encode(t, PNG, img)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    *Info
+		wantErr error
+	}{
+		{name: "jpeg", content: encode(t, JPEG, img), want: &Info{ContentType: JPEG, Width: 6, Height: 4, Orientation: 1}},
+		{name: "png", content: pngFile, want: &Info{ContentType: PNG, Width: 6, Height: 4, Orientation: 1}},
+		{name: "gif", content: encode(t, GIF, img), want: &Info{ContentType: GIF, Width: 6, Height: 4, Orientation: 1}},
+		{name: "webp", content: encode(t, WebP, img), want: &Info{ContentType: WebP, Width: 6, Height: 4, Orientation: 1}},
+		{name: "not an image", content: []byte("just some text, not a photo"), wantErr: ErrUnsupportedFormat},
+		{name: "empty", content: []byte{}, wantErr: ErrUnsupportedFormat},
+		{name: "truncated header", content: pngFile[:10], wantErr: ErrUndecodable},
+		{name: "magic bytes only", content: append([]byte{0xFF, 0xD8, 0xFF}, "garbage"...), wantErr: ErrUndecodable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.content)
+			info, err := Inspect(r)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, info)
+
+			// The caller reads the file from the start afterwards
+			pos, err := r.Seek(0, io.SeekCurrent)
+			require.NoError(t, err)
+			assert.Zero(t, pos)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Orient returns an image turned upright according to its EXIF orientation, 1 to 8.
+// Images that are upright already, or have an unknown orientation, are returned as they are.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	rgba, ok := src.(*image.RGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+		draw.Draw(rgba, rgba.Rect, src, src.Bounds().Min, draw.Src)
+	}
+
+	w, h := rgba.Rect.Dx(), rgba.Rect.Dy()
+	dw, dh := OrientedSize(w, h, orientation)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// The source pixel that ends up at x, y
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // Rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // Mirrored along the top-left to bottom-right diagonal
+				sx, sy = y, x
+			case 6: // Needs turning 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Mirrored along the top-right to bottom-left diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs turning 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], rgba.Pix[rgba.PixOffset(sx, sy):rgba.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// OrientedSize returns the size of a width x height image once turned upright,
+// which swaps the sides of orientations 5 to 8
+func OrientedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
+
+// readJPEGOrientation reads the EXIF orientation of a JPEG from the segments before
+// its image data, 1 if there is none. The reader is left at the start.
+func readJPEGOrientation(r io.ReadSeeker) (int, error) {
+	orientation := 1
+	_ = walkJPEGHeader(bufio.NewReader(r), func(marker byte, data []byte) {
+		if marker == markerAPP1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(data)
+		}
+	})
+
+	// A damaged header was reported by Inspect already, it only means no orientation here
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return orientation, nil
+}
+
+// walkJPEGHeader calls fn for every marker segment of a JPEG up to its first scan
+func walkJPEGHeader(br *bufio.Reader, fn func(marker byte, data []byte)) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return err
+	}
+	if soi != [2]byte{0xFF, markerSOI} {
+		return ErrUndecodable
+	}
+
+	for {
+		marker, err := nextJPEGMarker(br)
+		if err != nil {
+			return err
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return err
+		}
+		size := int(binary.BigEndian.Uint16(length[:]))
+		if size < 2 {
+			return ErrUndecodable
+		}
+		data := make([]byte, size-2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+		fn(marker, data)
+	}
+}
+
+// WriteUpright writes an image with its pixels turned upright, so it displays correctly
+// even where the EXIF orientation is ignored. Only JPEGs carry an orientation; those that
+// need turning are re-encoded at the given quality, keeping their EXIF data with the
+// orientation reset to upright, and their colour profile. Other images are copied as
+// they are. With stripMetadata, nothing but the colour profile is kept, as in StripMetadata.
+func WriteUpright(w io.Writer, r io.ReadSeeker, contentType string, quality int, stripMetadata bool) error {
+	orientation := 1
+	if contentType == JPEG {
+		var err error
+		if orientation, err = readJPEGOrientation(r); err != nil {
+			return err
+		}
+	}
+	if orientation <= 1 {
+		if stripMetadata {
+			return StripMetadata(w, r, contentType)
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	var segments bytes.Buffer
+	err := walkJPEGHeader(bufio.NewReader(r), func(marker byte, data []byte) {
+		if keep := uprightSegment(marker, data, stripMetadata); keep != nil {
+			segments.Write([]byte{0xFF, marker})
+			binary.Write(&segments, binary.BigEndian, uint16(len(keep)+2))
+			segments.Write(keep)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	src, _, err := Decode(r)
+	if err != nil {
+		return err
+	}
+	var encoded bytes.Buffer
+	if err := EncodeJPEG(&encoded, Orient(src, orientation), quality); err != nil {
+		return err
+	}
+
+	// The kept segments go right after the start of image marker of the new encoding
+	bw := bufio.NewWriter(w)
+	bw.Write(encoded.Bytes()[:2])
+	bw.Write(segments.Bytes())
+	bw.Write(encoded.Bytes()[2:])
+	return bw.Flush()
+}
+
+// uprightSegment returns the data of a header segment of a JPEG to copy to its
+// re-encoded upright version, or nil to drop it. Segments describing how the original
+// was encoded (JFIF, Adobe, MPF) are dropped, and so is XMP, which may repeat the orientation.
+func uprightSegment(marker byte, data []byte, stripMetadata bool) []byte {
+	switch {
+	case marker == markerAPP2 && bytes.HasPrefix(data, []byte("ICC_PROFILE\x00")):
+		return data
+	case stripMetadata:
+		return nil
+	case marker == markerAPP1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")):
+		return resetOrientation(data)
+	case marker == markerAPP0, marker == markerAPP1, marker == markerAPP2, marker == markerAPP14:
+		return nil
+	case marker > markerAPP0 && marker <= markerAPP15, marker == markerCOM:
+		return data
+	}
+	return nil
+}
+
+// resetOrientation returns a copy of the data of an EXIF APP1 segment with the
+// orientation set to upright, or nil if the segment cannot be parsed
+func resetOrientation(data []byte) []byte {
+	out := bytes.Clone(data)
+	tiff := out[len("Exif\x00\x00"):]
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return nil
+		}
+		// Orientation is a single SHORT, stored in the entry itself
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			order.PutUint16(tiff[entry+8:], 1)
+		}
+	}
+	return out
+}
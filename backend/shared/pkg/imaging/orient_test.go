@@ -0,0 +1,169 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image with two marked pixels along its top edge
+	red, green := color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}
+	src := solidImage(3, 2, color.Black)
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		red, green    image.Point // Where the marked pixels end up
+	}{
+		{orientation: 1, width: 3, height: 2, red: image.Pt(0, 0), green: image.Pt(1, 0)},
+		{orientation: 2, width: 3, height: 2, red: image.Pt(2, 0), green: image.Pt(1, 0)},
+		{orientation: 3, width: 3, height: 2, red: image.Pt(2, 1), green: image.Pt(1, 1)},
+		{orientation: 4, width: 3, height: 2, red: image.Pt(0, 1), green: image.Pt(1, 1)},
+		{orientation: 5, width: 2, height: 3, red: image.Pt(0, 0), green: image.Pt(0, 1)},
+		{orientation: 6, width: 2, height: 3, red: image.Pt(1, 0), green: image.Pt(1, 1)},
+		{orientation: 7, width: 2, height: 3, red: image.Pt(1, 2), green: image.Pt(1, 1)},
+		{orientation: 8, width: 2, height: 3, red: image.Pt(0, 2), green: image.Pt(0, 1)},
+		{orientation: 0, width: 3, height: 2, red: image.Pt(0, 0), green: image.Pt(1, 0)}, // Unknown
+		{orientation: 9, width: 3, height: 2, red: image.Pt(0, 0), green: image.Pt(1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.orientation), func(t *testing.T) {
+			got := Orient(src, tt.orientation)
+
+			assert.Equal(t, image.Rect(0, 0, tt.width, tt.height), got.Bounds())
+			w, h := OrientedSize(3, 2, tt.orientation)
+			assert.Equal(t, [2]int{tt.width, tt.height}, [2]int{w, h})
+			assert.Equal(t, red, color.RGBAModel.Convert(got.At(tt.red.X, tt.red.Y)))
+			assert.Equal(t, green, color.RGBAModel.Convert(got.At(tt.green.X, tt.green.Y)))
+		})
+	}
+}
+
+func TestInspectOrientation(t *testing.T) {
+	tests := []struct {
+		orientation   uint16
+		width, height int
+	}{
+		{orientation: 1, width: 8, height: 6},
+		{orientation: 3, width: 8, height: 6},
+		{orientation: 6, width: 6, height: 8},
+		{orientation: 8, width: 6, height: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.orientation)), func(t *testing.T) {
+			info, err := Inspect(bytes.NewReader(jpegWithExif(t, []tiffEntry{shortField(0x0112, tt.orientation)}, nil, nil)))
+			require.NoError(t, err)
+			assert.Equal(t, &Info{ContentType: JPEG, Width: tt.width, Height: tt.height, Orientation: int(tt.orientation)}, info)
+		})
+	}
+}
+
+// halfDarkJPEG encodes a 16x8 JPEG whose left half is black and right half white
+func halfDarkJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	img := solidImage(16, 8, color.White)
+	for y := range 8 {
+		for x := range 8 {
+			img.Set(x, y, color.Black)
+		}
+	}
+	return encode(t, JPEG, img)
+}
+
+func TestWriteUpright(t *testing.T) {
+	icc := append([]byte("ICC_PROFILE\x00\x01\x01"), "profile"...)
+	sideways := withSegment(halfDarkJPEG(t), markerAPP2, icc)
+	sideways = withSegment(sideways, markerAPP1, exifPayload([]tiffEntry{asciiField(0x010F, "Canon"), shortField(0x0112, 6)}, nil, nil))
+	upright := withSegment(halfDarkJPEG(t), markerAPP1, exifPayload([]tiffEntry{asciiField(0x010F, "Canon"), shortField(0x0112, 1)}, nil, nil))
+	pngFile := encode(t, PNG, solidImage(4, 4, color.White))
+
+	tests := []struct {
+		name          string
+		contentType   string
+		content       []byte
+		stripMetadata bool
+		check         func(t *testing.T, out []byte)
+	}{
+		{
+			name:        "sideways jpeg is turned",
+			contentType: JPEG,
+			content:     sideways,
+			check: func(t *testing.T, out []byte) {
+				img, _, err := image.Decode(bytes.NewReader(out))
+				require.NoError(t, err)
+				require.Equal(t, image.Rect(0, 0, 8, 16), img.Bounds())
+				// Turned clockwise, the dark left half is now at the top
+				top, _, _, _ := img.At(4, 2).RGBA()
+				bottom, _, _, _ := img.At(4, 13).RGBA()
+				assert.Less(t, top, uint32(0x2000))
+				assert.Greater(t, bottom, uint32(0xE000))
+
+				exifData, err := ReadExif(bytes.NewReader(out), JPEG)
+				require.NoError(t, err)
+				require.NotNil(t, exifData)
+				assert.Equal(t, ptr(1), exifData.Orientation, "the orientation must be reset")
+				assert.Equal(t, ptr("Canon"), exifData.CameraMake, "other EXIF data must be kept")
+				assert.Contains(t, string(out), "ICC_PROFILE")
+			},
+		},
+		{
+			name:          "sideways jpeg is turned and stripped",
+			contentType:   JPEG,
+			content:       sideways,
+			stripMetadata: true,
+			check: func(t *testing.T, out []byte) {
+				info, err := Inspect(bytes.NewReader(out))
+				require.NoError(t, err)
+				assert.Equal(t, &Info{ContentType: JPEG, Width: 8, Height: 16, Orientation: 1}, info)
+				assert.NotContains(t, string(out), "Canon")
+				assert.Contains(t, string(out), "ICC_PROFILE", "the colour profile is not metadata")
+			},
+		},
+		{
+			name:        "upright jpeg is copied",
+			contentType: JPEG,
+			content:     upright,
+			check: func(t *testing.T, out []byte) {
+				assert.Equal(t, upright, out)
+			},
+		},
+		{
+			name:          "upright jpeg is only stripped",
+			contentType:   JPEG,
+			content:       upright,
+			stripMetadata: true,
+			check: func(t *testing.T, out []byte) {
+				var stripped bytes.Buffer
+				require.NoError(t, StripMetadata(&stripped, bytes.NewReader(upright), JPEG))
+				assert.Equal(t, stripped.Bytes(), out)
+			},
+		},
+		{
+			name:        "other formats are copied",
+			contentType: PNG,
+			content:     pngFile,
+			check: func(t *testing.T, out []byte) {
+				assert.Equal(t, pngFile, out)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, WriteUpright(&out, bytes.NewReader(tt.content), tt.contentType, 92, tt.stripMetadata))
+			tt.check(t, out.Bytes())
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+)
+
+// PerceptualHash computes a 64 bit difference hash (dHash) of an image as displayed,
+// turned upright according to its EXIF orientation. Copies of a photo that were
+// resized, re-encoded or had their orientation baked in hash to the same or nearly the
+// same value, so the Hamming distance between hashes measures how alike images look.
+func PerceptualHash(img image.Image, orientation int) uint64 {
+	// Shrinking to a 9x8 grid discards size and compression differences. The grid is
+	// scaled in the orientation img is stored in, then turned like the image would be.
+	w, h := OrientedSize(9, 8, orientation)
+	small := Orient(scale(img, img.Bounds(), w, h), orientation)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luminance(small, x, y) < luminance(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func luminance(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y).RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+// HammingDistance returns the number of bits in which two hashes differ
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// GroupSimilar groups hashes around representatives: in order, each hash joins the group
+// of the nearest representative within maxDistance, or else represents a new group.
+// Every member is within maxDistance of its representative, so a series of slightly
+// different images does not chain unrelated ones together. It returns the indexes of
+// each group of two or more hashes, representative first, in the order of their representatives.
+func GroupSimilar(hashes []uint64, maxDistance int) [][]int {
+	index := newHashIndex(maxDistance)
+	var representatives []int
+	members := map[int][]int{}
+
+	for i, hash := range hashes {
+		nearest, nearestDistance := -1, maxDistance+1
+		index.lookup(hash, func(rep int) {
+			if d := HammingDistance(hash, hashes[rep]); d < nearestDistance || (d == nearestDistance && rep < nearest) {
+				nearest, nearestDistance = rep, d
+			}
+		})
+
+		if nearest < 0 {
+			representatives = append(representatives, i)
+			index.add(hash, i)
+			nearest = i
+		}
+		members[nearest] = append(members[nearest], i)
+	}
+
+	var groups [][]int
+	for _, rep := range representatives {
+		if len(members[rep]) > 1 {
+			groups = append(groups, members[rep])
+		}
+	}
+	return groups
+}
+
+// hashChunks is the number of 16 bit chunks the index splits hashes into
+const hashChunks = 4
+
+// maxIndexedFlips bounds the bit flips per chunk that a lookup enumerates, 137 variants
+// of each chunk at most. Larger distances fall back to comparing with every entry.
+const maxIndexedFlips = 2
+
+// hashIndex finds the hashes within a Hamming distance of another without comparing
+// it with each of them. Two hashes within maxDistance differ in at most
+// maxDistance/hashChunks bits in one of their chunks at least, so a lookup only needs
+// the entries sharing a chunk with one of the few values that close to the hash's own.
+type hashIndex struct {
+	flips   int
+	buckets [hashChunks]map[uint16][]int
+	all     []int // Used instead of the buckets when too many flips would need enumerating
+}
+
+func newHashIndex(maxDistance int) *hashIndex {
+	index := &hashIndex{flips: maxDistance / hashChunks}
+	for c := range index.buckets {
+		index.buckets[c] = map[uint16][]int{}
+	}
+	return index
+}
+
+func (x *hashIndex) add(hash uint64, value int) {
+	if x.flips > maxIndexedFlips {
+		x.all = append(x.all, value)
+		return
+	}
+	for c := range hashChunks {
+		chunk := uint16(hash >> (16 * c))
+		x.buckets[c][chunk] = append(x.buckets[c][chunk], value)
+	}
+}
+
+// lookup calls fn with every value added with a hash that may be within the index's
+// distance of hash, some of them more than once. The caller checks the actual distance.
+func (x *hashIndex) lookup(hash uint64, fn func(value int)) {
+	if x.flips > maxIndexedFlips {
+		for _, value := range x.all {
+			fn(value)
+		}
+		return
+	}
+	for c := range hashChunks {
+		forEachNearChunk(uint16(hash>>(16*c)), 0, x.flips, func(chunk uint16) {
+			for _, value := range x.buckets[c][chunk] {
+				fn(value)
+			}
+		})
+	}
+}
+
+// forEachNearChunk calls fn with chunk and every value that differs from it in at most
+// flips of the bits from position from up
+func forEachNearChunk(chunk uint16, from, flips int, fn func(uint16)) {
+	fn(chunk)
+	if flips == 0 {
+		return
+	}
+	for bit := from; bit < 16; bit++ {
+		forEachNearChunk(chunk^(1<<bit), bit+1, flips-1, fn)
+	}
+}
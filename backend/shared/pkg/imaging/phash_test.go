@@ -0,0 +1,166 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gradientImage draws a w×h scene with horizontal and vertical gradients and a dark
+// block, so its hash has both set and unset bits
+func gradientImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{R: uint8(255 * x / w), G: uint8(255 * y / h), B: 128, A: 255}
+			if x > w/2 && y > h/2 && x < 3*w/4 {
+				c = color.RGBA{A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func decodeImage(t *testing.T, content []byte) image.Image {
+	t.Helper()
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	require.NoError(t, err)
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := gradientImage(256, 192)
+	hash := PerceptualHash(original, 1)
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		orientation int
+		maxDistance int
+		minDistance int
+	}{
+		{name: "resized", img: Fit(original, 64, 48), maxDistance: 2},
+		{name: "re-encoded", img: decodeImage(t, encode(t, JPEG, original)), maxDistance: 2},
+		{name: "stored sideways", img: Orient(original, 8), orientation: 6, maxDistance: 0},
+		{name: "orientation baked in", img: Orient(Orient(original, 8), 6), maxDistance: 0},
+		{name: "mirrored", img: Orient(original, 2), minDistance: 16},
+		{name: "different photo", img: solidImage(256, 192, color.White), minDistance: 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance := HammingDistance(hash, PerceptualHash(tt.img, max(1, tt.orientation)))
+			if tt.minDistance > 0 {
+				assert.GreaterOrEqual(t, distance, tt.minDistance)
+				return
+			}
+			assert.LessOrEqual(t, distance, tt.maxDistance)
+		})
+	}
+}
+
+func TestGroupSimilar(t *testing.T) {
+	const (
+		a = uint64(0x0123456789ABCDEF)
+		b = uint64(0xFEDCBA9876543210)
+	)
+	// flip returns hash with its lowest n bits inverted
+	flip := func(hash uint64, n int) uint64 {
+		return hash ^ (1<<n - 1)
+	}
+
+	tests := []struct {
+		name        string
+		hashes      []uint64
+		maxDistance int
+		want        [][]int
+	}{
+		{name: "no hashes", maxDistance: 8},
+		{name: "identical", hashes: []uint64{a, b, a}, maxDistance: 0, want: [][]int{{0, 2}}},
+		{name: "within the distance", hashes: []uint64{a, flip(a, 8), b, flip(b, 3)}, maxDistance: 8, want: [][]int{{0, 1}, {2, 3}}},
+		{name: "just too far", hashes: []uint64{a, flip(a, 9)}, maxDistance: 8},
+		{
+			// Each differs from the next in 6 bits, but the last is 12 bits from the first
+			name:        "chains are not followed",
+			hashes:      []uint64{a, a ^ 0x3F, a ^ 0xFFF},
+			maxDistance: 8,
+			want:        [][]int{{0, 1}},
+		},
+		{
+			// The last is 4 bits from the first representative and 2 from the second
+			name:        "nearest representative",
+			hashes:      []uint64{a, a ^ 0x3F, a ^ 0x0F},
+			maxDistance: 4,
+			want:        [][]int{{1, 2}},
+		},
+		{name: "large distances are not indexed", hashes: []uint64{a, flip(a, 20), b}, maxDistance: 20, want: [][]int{{0, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, GroupSimilar(tt.hashes, tt.maxDistance))
+		})
+	}
+}
+
+// groupByScan is GroupSimilar without the index, comparing each hash with every representative
+func groupByScan(hashes []uint64, maxDistance int) [][]int {
+	var representatives []int
+	members := map[int][]int{}
+	for i, hash := range hashes {
+		nearest, nearestDistance := -1, maxDistance+1
+		for _, rep := range representatives {
+			if d := HammingDistance(hash, hashes[rep]); d < nearestDistance {
+				nearest, nearestDistance = rep, d
+			}
+		}
+		if nearest < 0 {
+			representatives = append(representatives, i)
+			nearest = i
+		}
+		members[nearest] = append(members[nearest], i)
+	}
+
+	var groups [][]int
+	for _, rep := range representatives {
+		if len(members[rep]) > 1 {
+			groups = append(groups, members[rep])
+		}
+	}
+	return groups
+}
+
+func TestGroupSimilarFindsEveryMatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Clusters of hashes a few random bits from a random centre, among random hashes
+	var hashes []uint64
+	for range 200 {
+		hashes = append(hashes, rng.Uint64())
+		centre := rng.Uint64()
+		for range rng.Intn(4) {
+			hash := centre
+			for range rng.Intn(12) {
+				hash ^= 1 << rng.Intn(64)
+			}
+			hashes = append(hashes, hash)
+		}
+	}
+
+	for _, maxDistance := range []int{0, 3, 4, 8, 10, 11} {
+		groups := GroupSimilar(hashes, maxDistance)
+		assert.Equal(t, groupByScan(hashes, maxDistance), groups, "distance %d", maxDistance)
+		for _, group := range groups {
+			for _, member := range group[1:] {
+				assert.LessOrEqual(t, HammingDistance(hashes[group[0]], hashes[member]), maxDistance)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Longest side of the copy placeholders are computed from. Both are blurry or averaged
+// anyway, so a tiny copy gives the same result as the full image at a fraction of the cost.
+const placeholderEdge = 32
+
+// Placeholder computes what a client can paint while an image loads: a BlurHash
+// (https://blurha.sh) of the image turned upright, and its dominant colour as "#rrggbb".
+// Transparent areas are flattened onto white, as in renditions.
+func Placeholder(img image.Image, orientation int) (blurHash string, dominantColor string) {
+	small := Orient(Fit(img, placeholderEdge, placeholderEdge), orientation)
+
+	flat := image.NewRGBA(image.Rect(0, 0, small.Bounds().Dx(), small.Bounds().Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), small, small.Bounds().Min, draw.Over)
+
+	// More components along the longer side keep the blur's detail even
+	xComponents, yComponents := 4, 3
+	if flat.Rect.Dy() > flat.Rect.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	return encodeBlurHash(flat, xComponents, yComponents), dominantColorOf(flat)
+}
+
+// encodeBlurHash encodes an image as a BlurHash with the given number of cosine
+// components, 1 to 9 in each direction
+func encodeBlurHash(img *image.RGBA, xComponents, yComponents int) string {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	// Each component is the image's colour, in linear RGB, weighted by a cosine basis function
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var factor [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					p := img.Pix[img.PixOffset(x, y):]
+					factor[0] += basis * srgbToLinear(p[0])
+					factor[1] += basis * srgbToLinear(p[1])
+					factor[2] += basis * srgbToLinear(p[2])
+				}
+			}
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			scale := normalisation / float64(w*h)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, f := range ac {
+			actualMaximum = max(actualMaximum, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMaximum := clamp(int(math.Floor(actualMaximum*166-0.5)), 0, 82)
+		maximum = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		quantise := func(v float64) int {
+			return clamp(int(math.Floor(signPow(v/maximum, 0.5)*9+9.5)), 0, 18)
+		}
+		hash.WriteString(encodeBase83(quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2))
+	}
+	return hash.String()
+}
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encodeBase83(value, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = base83Characters[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+func clamp(value, lo, hi int) int {
+	return max(lo, min(hi, value))
+}
+
+// dominantColorOf returns the most common colour of an image as "#rrggbb". Colours are
+// counted in buckets of similar shades, and the pixels of the largest bucket averaged.
+func dominantColorOf(img *image.RGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+	var largest *bucket
+
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			// 4 bits per channel, 4096 buckets
+			key := int(p[0]>>4)<<8 | int(p[1]>>4)<<4 | int(p[2]>>4)
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.count++
+			b.r += int(p[0])
+			b.g += int(p[1])
+			b.b += int(p[2])
+			if largest == nil || b.count > largest.count {
+				largest = b
+			}
+		}
+	}
+
+	if largest == nil {
+		return "#ffffff"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", largest.r/largest.count, largest.g/largest.count, largest.b/largest.count)
+}
@@ -0,0 +1,120 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeBase83 is the inverse of encodeBase83
+func decodeBase83(t *testing.T, digits string) int {
+	t.Helper()
+
+	value := 0
+	for _, c := range digits {
+		i := strings.IndexRune(base83Characters, c)
+		require.GreaterOrEqual(t, i, 0, "%q is not a base 83 digit", c)
+		value = value*83 + i
+	}
+	return value
+}
+
+// twoTone returns a w×h image whose left part, split at x, is left and the rest right
+func twoTone(w, h, x int, left, right color.Color) *image.RGBA {
+	img := solidImage(w, h, right)
+	draw.Draw(img, image.Rect(0, 0, x, h), &image.Uniform{C: left}, image.Point{}, draw.Src)
+	return img
+}
+
+// whiteBlurHash is the BlurHash of a white 4:3 image, with 4×3 components
+const whiteBlurHash = "LDTSUA_3fQ_3~qoffQoffQfQfQfQ"
+
+func TestEncodeBlurHash(t *testing.T) {
+	// Worked out by hand from the reference algorithm. Black has no detail: a zero maximum,
+	// and every AC component at the middle of its range, 9 of 18 per channel ("fQ"). The
+	// basis functions are sampled without a half-pixel offset, so for any other colour the
+	// odd components do not sum to zero, like in the reference encoder.
+	flat := strings.Repeat("fQ", 11)
+
+	tests := []struct {
+		name        string
+		img         *image.RGBA
+		xComponents int
+		yComponents int
+		want        string
+	}{
+		{name: "black", img: solidImage(32, 24, color.Black), xComponents: 4, yComponents: 3, want: "L00000" + flat},
+		{name: "white", img: solidImage(32, 24, color.White), xComponents: 4, yComponents: 3, want: whiteBlurHash},
+		{name: "grey", img: solidImage(32, 24, color.Gray{Y: 128}), xComponents: 4, yComponents: 3, want: "L2Eyb[_3fQ_3~qoffQoffQfQfQfQ"},
+		{name: "portrait", img: solidImage(24, 32, color.Black), xComponents: 3, yComponents: 4, want: "T00000" + flat},
+		{name: "dc only", img: solidImage(8, 8, color.White), xComponents: 1, yComponents: 1, want: "00TSUA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, encodeBlurHash(tt.img, tt.xComponents, tt.yComponents))
+		})
+	}
+
+	t.Run("horizontal edge", func(t *testing.T) {
+		hash := encodeBlurHash(twoTone(32, 24, 16, color.White, color.Black), 4, 3)
+		require.Len(t, hash, 28)
+
+		assert.NotEqual(t, "0", hash[1:2], "an edge has a non-zero maximum")
+		// The first horizontal component is brighter on the left, positive in every channel
+		first := decodeBase83(t, hash[6:8])
+		assert.Greater(t, first/(19*19), 9)
+		assert.Greater(t, first/19%19, 9)
+		assert.Greater(t, first%19, 9)
+		// Nothing changes from top to bottom, the vertical component is as grey as the image
+		vertical := decodeBase83(t, hash[12:14])
+		assert.Equal(t, vertical/(19*19), vertical/19%19)
+		assert.Equal(t, vertical/(19*19), vertical%19)
+	})
+}
+
+func TestDominantColorOf(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.RGBA
+		want string
+	}{
+		{name: "solid", img: solidImage(8, 8, color.RGBA{R: 30, G: 144, B: 255, A: 255}), want: "#1e90ff"},
+		{name: "larger of two tones", img: twoTone(8, 8, 6, color.RGBA{R: 200, A: 255}, color.RGBA{B: 200, A: 255}), want: "#c80000"},
+		{name: "smaller of two tones", img: twoTone(8, 8, 2, color.RGBA{R: 200, A: 255}, color.RGBA{B: 200, A: 255}), want: "#0000c8"},
+		{name: "similar shades averaged", img: twoTone(8, 8, 4, color.RGBA{R: 16, A: 255}, color.RGBA{R: 18, A: 255}), want: "#110000"},
+		{name: "empty", img: image.NewRGBA(image.Rect(0, 0, 0, 0)), want: "#ffffff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, dominantColorOf(tt.img))
+		})
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	t.Run("large images are scaled down first", func(t *testing.T) {
+		blurHash, dominantColor := Placeholder(solidImage(640, 480, color.White), 1)
+		assert.Equal(t, whiteBlurHash, blurHash)
+		assert.Equal(t, "#ffffff", dominantColor)
+	})
+
+	t.Run("turned upright", func(t *testing.T) {
+		// Orientation 6 turns a landscape file into a portrait image
+		blurHash, _ := Placeholder(solidImage(64, 48, color.Black), 6)
+		assert.Equal(t, "T", blurHash[:1])
+	})
+
+	t.Run("transparency flattened onto white", func(t *testing.T) {
+		blurHash, dominantColor := Placeholder(solidImage(16, 16, color.Transparent), 1)
+		// Square, so its odd components and maximum are larger than in whiteBlurHash
+		assert.Equal(t, "LKTSUA~qfQ~q~qoffQoffQfQfQfQ", blurHash)
+		assert.Equal(t, "#ffffff", dominantColor)
+	})
+}
@@ -0,0 +1,109 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxPixels is the largest image, in pixels, that is decoded into memory. Decoding
+// needs 4 bytes per pixel, so this bounds the memory a single image can take.
+const MaxPixels = 100_000_000
+
+// ErrTooLarge is returned for images with more than MaxPixels pixels
+var ErrTooLarge = fmt.Errorf("image has more than %d pixels", MaxPixels)
+
+// Decode reads a full image, after checking its header to refuse images too large to hold in memory.
+// The image is returned as stored, Orient turns it upright.
+func Decode(r io.ReadSeeker) (image.Image, *Info, error) {
+	info, err := Inspect(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Width*info.Height > MaxPixels {
+		return nil, nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrUndecodable, err)
+	}
+	return img, info, nil
+}
+
+// Fit scales an image down to fit within maxWidth x maxHeight, keeping its aspect ratio.
+// Images that already fit are returned as they are, they are never scaled up.
+func Fit(src image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return src
+	}
+
+	// Scale by the tighter of the two constraints
+	if w*maxHeight > h*maxWidth {
+		h = max(1, h*maxWidth/w)
+		w = maxWidth
+	} else {
+		w = max(1, w*maxHeight/h)
+		h = maxHeight
+	}
+
+	return scale(src, bounds, w, h)
+}
+
+// Square crops the centre square of an image and scales it down to size x size.
+// Images smaller than size are cropped but not scaled up.
+func Square(src image.Image, size int) image.Image {
+	return Cover(src, size, size)
+}
+
+// Cover crops the largest centred part of an image with the aspect ratio of width x height
+// and scales it down to width x height. Images smaller than that are cropped but not scaled up.
+func Cover(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	cropW, cropH := w, max(1, w*height/width)
+	if cropH > h {
+		cropW, cropH = max(1, h*width/height), h
+	}
+
+	crop := image.Rect(0, 0, cropW, cropH).Add(bounds.Min).Add(image.Pt((w-cropW)/2, (h-cropH)/2))
+	if cropW <= width {
+		return scale(src, crop, cropW, cropH)
+	}
+	return scale(src, crop, width, height)
+}
+
+// scale resamples the srcRect part of src into a new w x h image
+func scale(src image.Image, srcRect image.Rectangle, w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+	return dst
+}
+
+// EncodeJPEG writes an image as a JPEG. JPEG has no transparency, so transparent
+// areas are flattened onto a white background.
+func EncodeJPEG(w io.Writer, img image.Image, quality int) error {
+	if !opaque(img) {
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = flat
+	}
+
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// opaque reports whether an image is known to have no transparent pixels
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	// GIF headers of a w×h image without any image data, enough for Inspect
+	gifHeader := func(w, h int) []byte {
+		return []byte{'G', 'I', 'F', '8', '9', 'a', byte(w), byte(w >> 8), byte(h), byte(h >> 8), 0, 0, 0}
+	}
+
+	t.Run("small image", func(t *testing.T) {
+		img, info, err := Decode(bytes.NewReader(encode(t, PNG, solidImage(8, 6, color.White))))
+		require.NoError(t, err)
+		assert.Equal(t, 8, info.Width)
+		assert.Equal(t, 6, info.Height)
+		assert.Equal(t, 8, img.Bounds().Dx())
+		assert.Equal(t, 6, img.Bounds().Dy())
+	})
+
+	t.Run("over MaxPixels", func(t *testing.T) {
+		_, _, err := Decode(bytes.NewReader(gifHeader(20000, 20000)))
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("exactly MaxPixels", func(t *testing.T) {
+		// Decoded, and only then found to have no image data
+		_, _, err := Decode(bytes.NewReader(gifHeader(10000, 10000)))
+		assert.ErrorIs(t, err, ErrUndecodable)
+		assert.False(t, errors.Is(err, ErrTooLarge))
+	})
+}
@@ -0,0 +1,467 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// StripMetadata copies an image from r to w without the metadata that can reveal
+// where, when and with which device it was taken: EXIF (including GPS and camera
+// serial numbers), XMP, IPTC and comments. The pixel data is copied as it is, not
+// re-encoded. The only EXIF field kept is the orientation, without which JPEGs
+// would be shown sideways. GIFs lose their comments and application extensions,
+// which is where XMP is kept, except the one that makes animations loop.
+func StripMetadata(w io.Writer, r io.ReadSeeker, contentType string) error {
+	switch contentType {
+	case JPEG:
+		return stripJPEG(w, r)
+	case PNG:
+		return stripPNG(w, r)
+	case WebP:
+		return stripWebP(w, r)
+	case GIF:
+		return stripGIF(w, r)
+	}
+	return ErrUnsupportedFormat
+}
+
+// JPEG markers
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+	markerCOM   = 0xFE
+)
+
+// keepJPEGSegment reports whether a marker segment is needed to display the image.
+// Application segments are dropped, except JFIF, ICC colour profiles and the Adobe
+// colour transform; comments are dropped too.
+func keepJPEGSegment(marker byte, data []byte) bool {
+	switch {
+	case marker == markerAPP0:
+		return bytes.HasPrefix(data, []byte("JFIF\x00")) || bytes.HasPrefix(data, []byte("JFXX\x00"))
+	case marker == markerAPP2:
+		return bytes.HasPrefix(data, []byte("ICC_PROFILE\x00"))
+	case marker == markerAPP14:
+		return bytes.HasPrefix(data, []byte("Adobe"))
+	case marker >= markerAPP0 && marker <= markerAPP15, marker == markerCOM:
+		return false
+	}
+	return true
+}
+
+func stripJPEG(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return err
+	}
+	if soi != [2]byte{0xFF, markerSOI} {
+		return fmt.Errorf("%w: missing JPEG start of image", ErrUndecodable)
+	}
+	bw.Write(soi[:])
+
+	marker, err := nextJPEGMarker(br)
+	for ; err == nil; marker, err = nextJPEGMarker(br) {
+	segment:
+		switch {
+		case marker == markerEOI:
+			// Anything after the end of the image, e.g. the extra images of MPO files, is dropped
+			bw.Write([]byte{0xFF, markerEOI})
+			return bw.Flush()
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// TEM and restart markers have no length or data
+			bw.Write([]byte{0xFF, marker})
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return err
+		}
+		size := int(binary.BigEndian.Uint16(length[:]))
+		if size < 2 {
+			return fmt.Errorf("%w: invalid JPEG segment length", ErrUndecodable)
+		}
+		data := make([]byte, size-2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+
+		if marker == markerAPP1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			if orientation := exifOrientation(data); orientation > 1 {
+				bw.Write(orientationSegment(orientation))
+			}
+			continue
+		}
+		if !keepJPEGSegment(marker, data) {
+			continue
+		}
+
+		bw.Write([]byte{0xFF, marker})
+		bw.Write(length[:])
+		bw.Write(data)
+
+		if marker == markerSOS {
+			// The scan ends at the next marker, which copyEntropyCoded has already read
+			if marker, err = copyEntropyCoded(bw, br); err != nil {
+				return err
+			}
+			goto segment
+		}
+	}
+	return err
+}
+
+// nextJPEGMarker reads the next marker, skipping fill bytes
+func nextJPEGMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("%w: expected JPEG marker", ErrUndecodable)
+	}
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// copyEntropyCoded copies the compressed data following a start of scan and returns
+// the marker that ends it. Restart markers and stuffed 0xFF bytes are part of the data.
+func copyEntropyCoded(bw *bufio.Writer, br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != 0xFF {
+			bw.WriteByte(b)
+			continue
+		}
+
+		// Skip fill bytes
+		for b == 0xFF {
+			if b, err = br.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+		if b == 0x00 || (b >= 0xD0 && b <= 0xD7) {
+			bw.Write([]byte{0xFF, b})
+			continue
+		}
+		return b, nil
+	}
+}
+
+// exifOrientation reads the orientation from the data of an EXIF APP1 segment, 1 if there is none
+func exifOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// orientationSegment builds an EXIF APP1 segment holding nothing but the orientation
+func orientationSegment(orientation int) []byte {
+	var seg bytes.Buffer
+	seg.Write([]byte{0xFF, markerAPP1, 0x00, 34}) // Length includes itself
+	seg.WriteString("Exif\x00\x00")
+	seg.Write([]byte{'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08})   // Big endian TIFF, IFD0 at offset 8
+	seg.Write([]byte{0x00, 0x01})                                     // One entry
+	seg.Write([]byte{0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01}) // Orientation, SHORT, count 1
+	seg.Write([]byte{0x00, byte(orientation), 0x00, 0x00})
+	seg.Write([]byte{0x00, 0x00, 0x00, 0x00}) // No next IFD
+	return seg.Bytes()
+}
+
+// PNG chunks holding metadata rather than image data
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, // EXIF
+	"tEXt": true, // Text, including XMP and camera info written by some tools
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true, // Last modification time
+}
+
+func stripPNG(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(br, signature); err != nil {
+		return err
+	}
+	if string(signature) != "\x89PNG\r\n\x1a\n" {
+		return fmt.Errorf("%w: missing PNG signature", ErrUndecodable)
+	}
+	bw.Write(signature)
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		// Data and CRC
+		if pngMetadataChunks[chunkType] {
+			if _, err := br.Discard(int(length) + 4); err != nil {
+				return err
+			}
+			continue
+		}
+
+		bw.Write(header[:])
+		if _, err := io.CopyN(bw, br, length+4); err != nil {
+			return err
+		}
+
+		if chunkType == "IEND" {
+			return bw.Flush()
+		}
+	}
+}
+
+// VP8X flags announcing metadata chunks
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// webpChunk is a chunk of a WebP file, located by a first pass over the file
+type webpChunk struct {
+	fourCC string
+	offset int64 // Of the chunk header
+	size   int64 // Of the chunk header, data and padding
+}
+
+func stripWebP(w io.Writer, r io.ReadSeeker) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return fmt.Errorf("%w: missing WebP header", ErrUndecodable)
+	}
+	riffEnd := 8 + int64(binary.LittleEndian.Uint32(header[4:8]))
+
+	// The RIFF header holds the file size, so the chunks are located before anything is written
+	var chunks []webpChunk
+	offset := int64(len(header))
+	for offset+8 <= riffEnd {
+		var chunkHeader [8]byte
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+				break // Truncated file, keep what is there
+			}
+			return err
+		}
+
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		chunk := webpChunk{fourCC: string(chunkHeader[:4]), offset: offset, size: 8 + size + size%2}
+		chunks = append(chunks, chunk)
+		offset += chunk.size
+	}
+
+	riffSize := int64(4) // "WEBP"
+	for _, chunk := range chunks {
+		if chunk.fourCC != "EXIF" && chunk.fourCC != "XMP " {
+			riffSize += chunk.size
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("RIFF")
+	binary.Write(bw, binary.LittleEndian, uint32(riffSize))
+	bw.WriteString("WEBP")
+
+	for _, chunk := range chunks {
+		if chunk.fourCC == "EXIF" || chunk.fourCC == "XMP " {
+			continue
+		}
+		if _, err := r.Seek(chunk.offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		if chunk.fourCC == "VP8X" {
+			// Clear the flags of the dropped chunks
+			data := make([]byte, chunk.size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			if len(data) > 8 {
+				data[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			bw.Write(data)
+			continue
+		}
+
+		if _, err := io.CopyN(bw, r, chunk.size); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// GIF block introducers and extension labels
+const (
+	gifExtension        = 0x21
+	gifImageDescriptor  = 0x2C
+	gifTrailer          = 0x3B
+	gifCommentLabel     = 0xFE
+	gifApplicationLabel = 0xFF
+)
+
+// gifLoopingApplications identify the application extensions that make animations loop
+var gifLoopingApplications = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+func stripGIF(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	// Header and logical screen descriptor
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return err
+	}
+	if Sniff(header) != GIF {
+		return fmt.Errorf("%w: missing GIF header", ErrUndecodable)
+	}
+	bw.Write(header)
+	if err := copyGIFColorTable(bw, br, header[10]); err != nil {
+		return err
+	}
+
+	for {
+		introducer, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		switch introducer {
+		case gifTrailer:
+			bw.WriteByte(gifTrailer)
+			return bw.Flush()
+
+		case gifImageDescriptor:
+			// Position, size and flags, then the LZW minimum code size after any local colour table
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(br, descriptor); err != nil {
+				return err
+			}
+			bw.WriteByte(gifImageDescriptor)
+			bw.Write(descriptor)
+			if err := copyGIFColorTable(bw, br, descriptor[8]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(bw, br, 1); err != nil {
+				return err
+			}
+			if err := copyGIFSubBlocks(bw, br); err != nil {
+				return err
+			}
+
+		case gifExtension:
+			label, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			switch label {
+			case gifCommentLabel:
+				if err := copyGIFSubBlocks(io.Discard, br); err != nil {
+					return err
+				}
+			case gifApplicationLabel:
+				// The first sub-block identifies the application
+				size, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				identifier := make([]byte, size)
+				if _, err := io.ReadFull(br, identifier); err != nil {
+					return err
+				}
+				var dst io.Writer = io.Discard
+				if gifLoopingApplications[string(identifier)] {
+					bw.Write([]byte{gifExtension, label, size})
+					bw.Write(identifier)
+					dst = bw
+				}
+				if err := copyGIFSubBlocks(dst, br); err != nil {
+					return err
+				}
+			default:
+				// Graphic control and plain text extensions are part of the image
+				bw.Write([]byte{gifExtension, label})
+				if err := copyGIFSubBlocks(bw, br); err != nil {
+					return err
+				}
+			}
+
+		default:
+			return fmt.Errorf("%w: unexpected GIF block 0x%02x", ErrUndecodable, introducer)
+		}
+	}
+}
+
+// copyGIFColorTable copies the colour table announced by the flags of a screen or image descriptor
+func copyGIFColorTable(dst io.Writer, br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := io.CopyN(dst, br, 3<<(flags&0x07+1))
+	return err
+}
+
+// copyGIFSubBlocks copies a sequence of data sub-blocks, including the empty block that ends it
+func copyGIFSubBlocks(dst io.Writer, br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write([]byte{size}); err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := io.CopyN(dst, br, int64(size)); err != nil {
+			return err
+		}
+	}
+}
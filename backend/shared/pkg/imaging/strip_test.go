@@ -0,0 +1,226 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngWithChunk inserts a chunk right after the IHDR chunk of a PNG
+func pngWithChunk(pngFile []byte, chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := 8 + 8 + 13 + 4 // Signature, then IHDR header, data and CRC
+	out := append([]byte(nil), pngFile[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, pngFile[ihdrEnd:]...)
+}
+
+// gifExtensionBlock builds an extension holding data in a single sub-block. Application
+// extensions take their identifier as the first sub-block.
+func gifExtensionBlock(label byte, identifier string, data []byte) []byte {
+	block := []byte{gifExtension, label}
+	if identifier != "" {
+		block = append(block, byte(len(identifier)))
+		block = append(block, identifier...)
+	}
+	block = append(block, byte(len(data)))
+	block = append(block, data...)
+	return append(block, 0)
+}
+
+// animatedGIF encodes a looping two frame animation with the given blocks before its trailer
+func animatedGIF(t *testing.T, blocks ...[]byte) []byte {
+	t.Helper()
+
+	palette := color.Palette{color.Black, color.White}
+	frames := []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 4), palette), image.NewPaletted(image.Rect(0, 0, 4, 4), palette)}
+	frames[1].SetColorIndex(1, 1, 1)
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, &gif.GIF{Image: frames, Delay: []int{10, 10}, LoopCount: 0}))
+
+	encoded := buf.Bytes()
+	out := append([]byte(nil), encoded[:len(encoded)-1]...)
+	for _, block := range blocks {
+		out = append(out, block...)
+	}
+	return append(out, gifTrailer)
+}
+
+// riffChunk encodes a WebP chunk with its padding
+func riffChunk(fourCC string, data []byte) []byte {
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// extendedWebP builds a 1x1 WebP in the extended format, with EXIF and XMP chunks
+func extendedWebP(exifData, xmpData []byte) []byte {
+	vp8x := []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 0, 0, 0, 0, 0, 0} // Canvas 1x1
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, riffChunk("VP8L", []byte{0x2f, 0, 0, 0, 0})...)
+	body = append(body, riffChunk("EXIF", exifData)...)
+	body = append(body, riffChunk("XMP ", xmpData)...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">Secret Street 1</x:xmpmeta>`)
+	gps := []tiffEntry{asciiField(0x0001, "N"), rationalField(0x0002, 48, 1, 51, 1, 30, 1)}
+
+	jpegFile := jpegWithExif(t, []tiffEntry{asciiField(0x010F, "Canon"), shortField(0x0112, 6)}, nil, gps)
+	jpegFile = withSegment(jpegFile, markerCOM, []byte("Secret comment"))
+	jpegFile = withSegment(jpegFile, markerAPP1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...))
+
+	pngFile := encode(t, PNG, solidImage(4, 4, color.RGBA{R: 10, A: 255}))
+	pngFile = pngWithChunk(pngFile, "tEXt", []byte("Comment\x00Secret comment"))
+	pngFile = pngWithChunk(pngFile, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))
+	pngFile = pngWithChunk(pngFile, "eXIf", exifPayload([]tiffEntry{asciiField(0x010F, "Canon")}, nil, nil)[6:])
+
+	tests := []struct {
+		name        string
+		contentType string
+		content     []byte
+		removed     []string // Must not be found in the stripped file
+		kept        []string // Must still be found
+		samePixels  bool     // Decodes exactly as the original
+		check       func(t *testing.T, stripped []byte)
+	}{
+		{
+			name:        "jpeg",
+			contentType: JPEG,
+			content:     jpegFile,
+			removed:     []string{"Canon", "Secret comment", "Secret Street"},
+			samePixels:  true,
+			check: func(t *testing.T, stripped []byte) {
+				exifData, err := ReadExif(bytes.NewReader(stripped), JPEG)
+				require.NoError(t, err)
+				require.NotNil(t, exifData)
+				assert.Equal(t, ptr(6), exifData.Orientation, "the orientation must be kept")
+				assert.Nil(t, exifData.Latitude)
+			},
+		},
+		{
+			name:        "jpeg without an orientation",
+			contentType: JPEG,
+			content:     jpegWithExif(t, []tiffEntry{asciiField(0x010F, "Canon")}, nil, gps),
+			removed:     []string{"Canon", "Exif"},
+			samePixels:  true,
+		},
+		{
+			name:        "png",
+			contentType: PNG,
+			content:     pngFile,
+			removed:     []string{"tEXt", "iTXt", "eXIf", "Canon", "Secret"},
+			kept:        []string{"IHDR", "IDAT", "IEND"},
+			samePixels:  true,
+		},
+		{
+			name:        "webp",
+			contentType: WebP,
+			content:     extendedWebP(exifPayload([]tiffEntry{asciiField(0x010F, "Canon")}, nil, nil), xmp),
+			removed:     []string{"EXIF", "XMP ", "Canon", "Secret"},
+			kept:        []string{"VP8X", "VP8L"},
+			check: func(t *testing.T, stripped []byte) {
+				assert.Equal(t, uint32(len(stripped)-8), binary.LittleEndian.Uint32(stripped[4:8]), "RIFF size")
+				assert.Zero(t, stripped[20]&(webpFlagEXIF|webpFlagXMP), "VP8X must not announce the removed chunks")
+				_, format, err := image.DecodeConfig(bytes.NewReader(stripped))
+				require.NoError(t, err)
+				assert.Equal(t, "webp", format)
+			},
+		},
+		{
+			name:        "gif",
+			contentType: GIF,
+			content: animatedGIF(t,
+				gifExtensionBlock(gifCommentLabel, "", []byte("Secret comment")),
+				gifExtensionBlock(gifApplicationLabel, "XMP DataXMP", xmp),
+			),
+			removed:    []string{"Secret", "XMP DataXMP"},
+			kept:       []string{"NETSCAPE2.0"},
+			samePixels: true,
+			check: func(t *testing.T, stripped []byte) {
+				animation, err := gif.DecodeAll(bytes.NewReader(stripped))
+				require.NoError(t, err)
+				assert.Len(t, animation.Image, 2)
+				assert.Equal(t, 0, animation.LoopCount, "the animation must still loop forever")
+				assert.Equal(t, []int{10, 10}, animation.Delay)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, s := range tt.removed {
+				require.Contains(t, string(tt.content), s, "the fixture must hold %q", s)
+			}
+
+			var out bytes.Buffer
+			require.NoError(t, StripMetadata(&out, bytes.NewReader(tt.content), tt.contentType))
+			stripped := out.Bytes()
+
+			for _, s := range tt.removed {
+				assert.NotContains(t, string(stripped), s)
+			}
+			for _, s := range tt.kept {
+				assert.Contains(t, string(stripped), s)
+			}
+			if tt.samePixels {
+				original, _, err := image.Decode(bytes.NewReader(tt.content))
+				require.NoError(t, err)
+				got, _, err := image.Decode(bytes.NewReader(stripped))
+				require.NoError(t, err)
+				assert.Equal(t, original, got)
+			}
+			if tt.check != nil {
+				tt.check(t, stripped)
+			}
+		})
+	}
+}
+
+func TestStripMetadataErrors(t *testing.T) {
+	pngFile := encode(t, PNG, solidImage(4, 4, color.White))
+
+	tests := []struct {
+		name        string
+		contentType string
+		content     []byte
+		wantErr     error
+	}{
+		{name: "unsupported format", contentType: "image/tiff", content: []byte("II*\x00"), wantErr: ErrUnsupportedFormat},
+		{name: "jpeg that is not one", contentType: JPEG, content: pngFile, wantErr: ErrUndecodable},
+		{name: "png that is not one", contentType: PNG, content: []byte("GIF89a not a png"), wantErr: ErrUndecodable},
+		{name: "gif that is not one", contentType: GIF, content: pngFile, wantErr: ErrUndecodable},
+		{name: "webp that is not one", contentType: WebP, content: pngFile, wantErr: ErrUndecodable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := StripMetadata(&bytes.Buffer{}, bytes.NewReader(tt.content), tt.contentType)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		for _, contentType := range []string{JPEG, PNG, GIF} {
+			content := encode(t, contentType, solidImage(4, 4, color.White))
+			err := StripMetadata(&bytes.Buffer{}, bytes.NewReader(content[:len(content)/2]), contentType)
+			assert.Error(t, err, contentType)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Faces is the queue of the faces service
+const Faces = "faces"
+
+// DetectFacesPayload identifies an image whose faces are to be detected
+type DetectFacesPayload struct {
+	ImageID models.ImageID `json:"image_id"`
+	UserID  models.UserID  `json:"user_id"`
+}
+
+// DetectFaces is enqueued for every uploaded image, and again whenever its file is
+// replaced or restored
+var DetectFaces = Kind[DetectFacesPayload]{
+	Name:        "detect-faces",
+	Queue:       Faces,
+	MaxAttempts: 5,
+	Timeout:     10 * time.Minute,
+}
@@ -0,0 +1,195 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+const (
+	// Delay before the second attempt of a failed job, doubled for every further attempt
+	retryBase = 30 * time.Second
+	retryMax  = 6 * time.Hour
+
+	// Done jobs are kept this long, which is how long their idempotency keys are remembered
+	doneRetention = 7 * 24 * time.Hour
+)
+
+// Kind is a type of job whose payload is a P. Kinds are declared once, next to their
+// payload, and shared by the services that enqueue and process them.
+type Kind[P any] struct {
+	Name        string
+	Queue       string        // Service that processes it
+	MaxAttempts int           // Attempts before the job is dead
+	Timeout     time.Duration // Visibility timeout, the job is claimed again if not finished in time
+}
+
+// Enqueue adds a job of this kind to its queue. A non-empty key makes enqueueing
+// idempotent: while a job with the same key is kept, that job is returned instead
+// and created is false.
+func (k Kind[P]) Enqueue(ctx context.Context, q *Queue, payload P, key string) (job *models.Job, created bool, err error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false, fmt.Errorf("encoding %s payload: %w", k.Name, err)
+	}
+
+	job = &models.Job{
+		ID:          uuid.New().String(),
+		Queue:       k.Queue,
+		Kind:        k.Name,
+		Payload:     data,
+		MaxAttempts: max(1, k.MaxAttempts),
+		Timeout:     max(time.Second, k.Timeout),
+		RunAt:       time.Now(),
+	}
+	if key != "" {
+		job.IdempotencyKey = &key
+	}
+
+	created, err = q.DB.EnqueueJob(ctx, job)
+	if err != nil {
+		return nil, false, err
+	}
+	return job, created, nil
+}
+
+// Decode returns the payload of a job of this kind. Payloads that do not decode never
+// will, the error is permanent.
+func (k Kind[P]) Decode(job *models.Job) (P, error) {
+	var payload P
+	if job.Kind != k.Name {
+		return payload, Permanent(fmt.Errorf("job %s is a %s, not a %s", job.ID, job.Kind, k.Name))
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return payload, Permanent(fmt.Errorf("decoding %s payload: %w", k.Name, err))
+	}
+	return payload, nil
+}
+
+// permanentError is a job failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as not worth retrying, e.g. because the image the job is
+// about was deleted. Jobs failing with it are dead right away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether an error was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Backoff returns how long a job waits after failing its nth attempt: retryBase
+// doubled for every earlier attempt, up to retryMax, give or take a fifth so jobs
+// that failed together do not retry together.
+func Backoff(attempt int) time.Duration {
+	delay := retryMax
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt <= 20 {
+		delay = min(retryMax, retryBase<<(attempt-1))
+	}
+	return time.Duration(float64(delay) * (0.8 + 0.4*rand.Float64()))
+}
+
+// Queue is a durable job queue backed by the jobs table. Services enqueue work for each
+// other, e.g. the server enqueues face detection for the faces service, and workers
+// claim it with SELECT ... FOR UPDATE SKIP LOCKED, so any number of them can share a
+// queue. Failed jobs are retried with exponential backoff and are dead once they used
+// every attempt; a job whose worker went away is claimed again after its timeout.
+type Queue struct {
+	DB db.JobStore
+}
+
+// NewQueue creates a new Queue instance
+func NewQueue(store db.JobStore) *Queue {
+	return &Queue{DB: store}
+}
+
+// Claim takes the oldest due job of a queue, of one of the given kinds, for a worker,
+// or returns nil if there is none. The worker must Complete, Fail or Release it before
+// its kind's timeout, or Extend it.
+func (q *Queue) Claim(ctx context.Context, queue string, kinds []string, workerID string) (*models.Job, error) {
+	job, err := q.DB.ClaimJob(ctx, queue, kinds, workerID)
+	if err != nil {
+		if err.Error() == "no jobs" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// Extend restarts the timeout of a claimed job. It fails with "job claim lost" if the
+// job timed out and was claimed again, in which case the worker should stop.
+func (q *Queue) Extend(ctx context.Context, job *models.Job) error {
+	return q.DB.ExtendJob(ctx, job.ID, job.Attempts)
+}
+
+// Complete marks a claimed job as done
+func (q *Queue) Complete(ctx context.Context, job *models.Job) error {
+	if err := q.DB.CompleteJob(ctx, job.ID, job.Attempts); err != nil {
+		return err
+	}
+	log.Printf("Job %s (%s) done after %d attempts", job.ID, job.Kind, job.Attempts)
+	return nil
+}
+
+// Release returns a claimed job to the queue without counting the attempt, for jobs
+// interrupted through no fault of their own
+func (q *Queue) Release(ctx context.Context, job *models.Job) error {
+	if err := q.DB.ReleaseJob(ctx, job.ID, job.Attempts); err != nil {
+		return err
+	}
+	log.Printf("Job %s (%s) released", job.ID, job.Kind)
+	return nil
+}
+
+// Fail records why a claimed job failed and schedules its next attempt after a
+// backoff. Jobs that used every attempt, or failed with a Permanent error, are dead.
+func (q *Queue) Fail(ctx context.Context, job *models.Job, jobErr error) error {
+	var retryAt time.Time
+	if !IsPermanent(jobErr) {
+		retryAt = time.Now().Add(Backoff(job.Attempts))
+	}
+
+	failed, err := q.DB.FailJob(ctx, job.ID, job.Attempts, jobErr.Error(), retryAt)
+	if err != nil {
+		return err
+	}
+
+	if failed.Status == models.JobDead {
+		log.Printf("Job %s (%s) is dead after %d attempts: %v", job.ID, job.Kind, job.Attempts, jobErr)
+	} else {
+		log.Printf("Job %s (%s) failed attempt %d of %d, retrying at %s: %v", job.ID, job.Kind, job.Attempts, job.MaxAttempts, failed.RunAt.Format(time.RFC3339), jobErr)
+	}
+	return nil
+}
+
+// Purge deletes done jobs past their retention, pending, running and dead jobs are kept
+func (q *Queue) Purge(ctx context.Context) {
+	purged, err := q.DB.PurgeJobs(ctx, time.Now().Add(-doneRetention))
+	if err != nil {
+		log.Printf("Failed to purge done jobs: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d done jobs", purged)
+	}
+}
@@ -0,0 +1,436 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// MockJobStore is an in-memory JobStore that claims, retries and buries jobs like the
+// jobs table does. Its clock can be moved forward to make retries and timeouts due.
+type MockJobStore struct {
+	db.JobStore
+
+	mu   sync.Mutex
+	jobs []*models.Job
+	skew time.Duration // Added to the current time
+}
+
+func (m *MockJobStore) now() time.Time {
+	return time.Now().Add(m.skew)
+}
+
+// advance moves the clock of the store forward
+func (m *MockJobStore) advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skew += d
+}
+
+func (m *MockJobStore) EnqueueJob(ctx context.Context, job *models.Job) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.IdempotencyKey != nil {
+		for _, existing := range m.jobs {
+			if existing.Queue == job.Queue && existing.IdempotencyKey != nil && *existing.IdempotencyKey == *job.IdempotencyKey {
+				*job = *existing
+				return false, nil
+			}
+		}
+	}
+	job.Status = models.JobPending
+	job.CreatedAt, job.UpdatedAt = m.now(), m.now()
+	stored := *job
+	m.jobs = append(m.jobs, &stored)
+	return true, nil
+}
+
+func (m *MockJobStore) GetJob(ctx context.Context, jobID models.JobID) (*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID == jobID {
+			copied := *job
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("job not found")
+}
+
+func (m *MockJobStore) ClaimJob(ctx context.Context, queue string, kinds []string, workerID string) (*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var next *models.Job
+	for _, job := range m.jobs {
+		if job.Queue != queue || job.RunAt.After(now) || job.Status != models.JobRunning || job.Attempts < job.MaxAttempts {
+			continue
+		}
+		// Its worker went away while it had no attempts left
+		timedOut := "timed out"
+		job.Status, job.LastError, job.FinishedAt = models.JobDead, &timedOut, &now
+	}
+	for _, job := range m.jobs {
+		due := job.Queue == queue && slices.Contains(kinds, job.Kind) && !job.RunAt.After(now) &&
+			(job.Status == models.JobPending || job.Status == models.JobRunning && job.Attempts < job.MaxAttempts)
+		if due && (next == nil || job.RunAt.Before(next.RunAt)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, errors.New("no jobs")
+	}
+
+	next.Status, next.LockedBy = models.JobRunning, &workerID
+	next.Attempts++
+	next.RunAt = now.Add(next.Timeout)
+	copied := *next
+	return &copied, nil
+}
+
+// claimed returns the job if attempt is still its current claim, m.mu must be held
+func (m *MockJobStore) claimed(jobID models.JobID, attempt int) (*models.Job, error) {
+	for _, job := range m.jobs {
+		if job.ID == jobID && job.Attempts == attempt && job.Status == models.JobRunning {
+			return job, nil
+		}
+	}
+	return nil, errors.New("job claim lost")
+}
+
+func (m *MockJobStore) ExtendJob(ctx context.Context, jobID models.JobID, attempt int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.claimed(jobID, attempt)
+	if err != nil {
+		return err
+	}
+	job.RunAt = m.now().Add(job.Timeout)
+	return nil
+}
+
+func (m *MockJobStore) CompleteJob(ctx context.Context, jobID models.JobID, attempt int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.claimed(jobID, attempt)
+	if err != nil {
+		return err
+	}
+	now := m.now()
+	job.Status, job.FinishedAt = models.JobDone, &now
+	return nil
+}
+
+func (m *MockJobStore) ReleaseJob(ctx context.Context, jobID models.JobID, attempt int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.claimed(jobID, attempt)
+	if err != nil {
+		return err
+	}
+	job.Status, job.RunAt = models.JobPending, m.now()
+	job.Attempts--
+	return nil
+}
+
+func (m *MockJobStore) FailJob(ctx context.Context, jobID models.JobID, attempt int, lastError string, retryAt time.Time) (*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.claimed(jobID, attempt)
+	if err != nil {
+		return nil, err
+	}
+	job.LastError = &lastError
+	if retryAt.IsZero() || job.Attempts >= job.MaxAttempts {
+		now := m.now()
+		job.Status, job.FinishedAt = models.JobDead, &now
+	} else {
+		job.Status, job.RunAt = models.JobPending, retryAt
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (m *MockJobStore) RetryJob(ctx context.Context, jobID models.JobID) (*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID == jobID && job.Status == models.JobDead {
+			job.Status, job.Attempts, job.RunAt, job.FinishedAt = models.JobPending, 0, m.now(), nil
+			copied := *job
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("job not found")
+}
+
+// testPayload is the payload of testKind
+type testPayload struct {
+	ImageID models.ImageID `json:"image_id"`
+}
+
+var testKind = Kind[testPayload]{Name: "test", Queue: "tests", MaxAttempts: 3, Timeout: time.Minute}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 0, want: retryBase},
+		{attempt: 1, want: retryBase},
+		{attempt: 2, want: 2 * retryBase},
+		{attempt: 5, want: 16 * retryBase},
+		{attempt: 12, want: retryMax},
+		{attempt: 100, want: retryMax},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			got := Backoff(tt.attempt)
+			assert.GreaterOrEqual(t, got, tt.want*8/10, "attempt %d", tt.attempt)
+			assert.LessOrEqual(t, got, tt.want*12/10, "attempt %d", tt.attempt)
+		}
+	}
+}
+
+func TestKindEnqueue(t *testing.T) {
+	q := NewQueue(&MockJobStore{})
+	ctx := context.Background()
+
+	first, created, err := testKind.Enqueue(ctx, q, testPayload{ImageID: "img-1"}, "test:img-1")
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, models.JobPending, first.Status)
+	assert.Equal(t, 3, first.MaxAttempts)
+
+	again, created, err := testKind.Enqueue(ctx, q, testPayload{ImageID: "img-1"}, "test:img-1")
+	require.NoError(t, err)
+	assert.False(t, created, "the key is taken")
+	assert.Equal(t, first.ID, again.ID)
+
+	unkeyed, created, err := testKind.Enqueue(ctx, q, testPayload{ImageID: "img-1"}, "")
+	require.NoError(t, err)
+	assert.True(t, created, "jobs without a key are always created")
+	assert.NotEqual(t, first.ID, unkeyed.ID)
+
+	unlimited := Kind[testPayload]{Name: "test", Queue: "tests"}
+	job, _, err := unlimited.Enqueue(ctx, q, testPayload{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, job.MaxAttempts, "every job gets at least one attempt")
+	assert.Equal(t, time.Second, job.Timeout)
+}
+
+func TestKindDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     models.Job
+		want    testPayload
+		wantErr bool
+	}{
+		{name: "payload", job: models.Job{Kind: "test", Payload: []byte(`{"image_id": "img-1"}`)}, want: testPayload{ImageID: "img-1"}},
+		{name: "other kind", job: models.Job{Kind: "detect-faces", Payload: []byte(`{"image_id": "img-1"}`)}, wantErr: true},
+		{name: "invalid payload", job: models.Job{Kind: "test", Payload: []byte(`{"image_id": 1}`)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testKind.Decode(&tt.job)
+			if tt.wantErr {
+				assert.True(t, IsPermanent(err), "payloads that do not decode never will: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// step is an action on a queue holding a single testKind job, and the state of the
+// job afterwards
+type step struct {
+	do      string        // claim, extend, complete, release, fail, fail permanently or retry
+	worker  string        // Claiming worker, the job's claim it acts on for other actions
+	wait    time.Duration // Moves the clock forward before the action
+	wantErr string
+	none    bool // For claims, no job is due
+
+	wantStatus   string
+	wantAttempts int
+}
+
+func TestQueue(t *testing.T) {
+	failure := errors.New("faces service unavailable")
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "completed",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "claim", worker: "b", none: true, wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "complete", worker: "a", wantStatus: models.JobDone, wantAttempts: 1},
+				{do: "claim", worker: "b", wait: time.Hour, none: true, wantStatus: models.JobDone, wantAttempts: 1},
+			},
+		},
+		{
+			name: "retried after a backoff",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "fail", worker: "a", wantStatus: models.JobPending, wantAttempts: 1},
+				{do: "claim", worker: "a", none: true, wantStatus: models.JobPending, wantAttempts: 1},
+				{do: "claim", worker: "b", wait: 2 * retryBase, wantStatus: models.JobRunning, wantAttempts: 2},
+				{do: "complete", worker: "b", wantStatus: models.JobDone, wantAttempts: 2},
+			},
+		},
+		{
+			name: "dead letter after every attempt",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "fail", worker: "a", wantStatus: models.JobPending, wantAttempts: 1},
+				{do: "claim", worker: "a", wait: retryMax * 2, wantStatus: models.JobRunning, wantAttempts: 2},
+				{do: "fail", worker: "a", wantStatus: models.JobPending, wantAttempts: 2},
+				{do: "claim", worker: "a", wait: retryMax * 2, wantStatus: models.JobRunning, wantAttempts: 3},
+				{do: "fail", worker: "a", wantStatus: models.JobDead, wantAttempts: 3},
+				{do: "claim", worker: "a", wait: retryMax * 2, none: true, wantStatus: models.JobDead, wantAttempts: 3},
+				{do: "retry", wantStatus: models.JobPending, wantAttempts: 0},
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+			},
+		},
+		{
+			name: "permanent failure",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "fail permanently", worker: "a", wantStatus: models.JobDead, wantAttempts: 1},
+			},
+		},
+		{
+			name: "released without counting the attempt",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "release", worker: "a", wantStatus: models.JobPending, wantAttempts: 0},
+				{do: "claim", worker: "b", wantStatus: models.JobRunning, wantAttempts: 1},
+			},
+		},
+		{
+			name: "claimed again after a timeout",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "claim", worker: "b", wait: 2 * time.Minute, wantStatus: models.JobRunning, wantAttempts: 2},
+				{do: "complete", worker: "a", wantErr: "job claim lost", wantStatus: models.JobRunning, wantAttempts: 2},
+				{do: "extend", worker: "a", wantErr: "job claim lost", wantStatus: models.JobRunning, wantAttempts: 2},
+				{do: "complete", worker: "b", wantStatus: models.JobDone, wantAttempts: 2},
+			},
+		},
+		{
+			name: "extended claims are kept",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "extend", worker: "a", wait: 50 * time.Second, wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "claim", worker: "b", wait: 50 * time.Second, none: true, wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "complete", worker: "a", wantStatus: models.JobDone, wantAttempts: 1},
+			},
+		},
+		{
+			name: "dead letter after timing out on the last attempt",
+			steps: []step{
+				{do: "claim", worker: "a", wantStatus: models.JobRunning, wantAttempts: 1},
+				{do: "claim", worker: "a", wait: 2 * time.Minute, wantStatus: models.JobRunning, wantAttempts: 2},
+				{do: "claim", worker: "a", wait: 2 * time.Minute, wantStatus: models.JobRunning, wantAttempts: 3},
+				{do: "claim", worker: "a", wait: 2 * time.Minute, none: true, wantStatus: models.JobDead, wantAttempts: 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &MockJobStore{}
+			q := NewQueue(store)
+			job, _, err := testKind.Enqueue(ctx, q, testPayload{ImageID: "img-1"}, "")
+			require.NoError(t, err)
+
+			claims := map[string]*models.Job{} // Worker -> its claim
+			for i, s := range tt.steps {
+				store.advance(s.wait)
+
+				switch s.do {
+				case "claim":
+					claimed, err := q.Claim(ctx, testKind.Queue, []string{testKind.Name}, s.worker)
+					require.NoError(t, err, "step %d", i)
+					if s.none {
+						assert.Nil(t, claimed, "step %d: no job is due", i)
+						break
+					}
+					require.NotNil(t, claimed, "step %d: the job is due", i)
+					claims[s.worker] = claimed
+				case "extend":
+					err = q.Extend(ctx, claims[s.worker])
+				case "complete":
+					err = q.Complete(ctx, claims[s.worker])
+				case "release":
+					err = q.Release(ctx, claims[s.worker])
+				case "fail":
+					err = q.Fail(ctx, claims[s.worker], failure)
+				case "fail permanently":
+					err = q.Fail(ctx, claims[s.worker], Permanent(failure))
+				case "retry":
+					_, err = store.RetryJob(ctx, job.ID)
+				}
+				if s.do != "claim" {
+					if s.wantErr != "" {
+						assert.EqualError(t, err, s.wantErr, "step %d", i)
+					} else {
+						assert.NoError(t, err, "step %d", i)
+					}
+				}
+
+				stored, err := store.GetJob(ctx, job.ID)
+				require.NoError(t, err)
+				assert.Equal(t, s.wantStatus, stored.Status, "step %d: %s by %s", i, s.do, s.worker)
+				assert.Equal(t, s.wantAttempts, stored.Attempts, "step %d: %s by %s", i, s.do, s.worker)
+				if stored.Status == models.JobDead {
+					require.NotNil(t, stored.LastError, "dead jobs keep their last error")
+				}
+			}
+		})
+	}
+}
+
+func TestQueueClaimsOnlyItsKinds(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(&MockJobStore{})
+	other := Kind[testPayload]{Name: "other", Queue: "tests", MaxAttempts: 1, Timeout: time.Minute}
+
+	_, _, err := other.Enqueue(ctx, q, testPayload{ImageID: "img-1"}, "")
+	require.NoError(t, err)
+	job, _, err := testKind.Enqueue(ctx, q, testPayload{ImageID: "img-2"}, "")
+	require.NoError(t, err)
+
+	claimed, err := q.Claim(ctx, testKind.Queue, []string{testKind.Name}, "a")
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, job.ID, claimed.ID, "jobs of kinds without a handler stay pending")
+
+	claimed, err = q.Claim(ctx, Faces, []string{testKind.Name, other.Name}, "a")
+	require.NoError(t, err)
+	assert.Nil(t, claimed, "jobs of other queues are not claimed")
+}
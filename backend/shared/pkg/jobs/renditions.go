@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// Renditions is the queue of the server's rendition workers
+const Renditions = "renditions"
+
+// GenerateRenditionsPayload identifies an image whose renditions, perceptual hash and
+// placeholder are to be generated
+type GenerateRenditionsPayload struct {
+	ImageID models.ImageID `json:"image_id"`
+	UserID  models.UserID  `json:"user_id"`
+}
+
+// GenerateRenditions is enqueued for every uploaded image, again whenever it is edited
+// or its file replaced or restored, and for images served without their renditions
+var GenerateRenditions = Kind[GenerateRenditionsPayload]{
+	Name:        "generate-renditions",
+	Queue:       Renditions,
+	MaxAttempts: 5,
+	Timeout:     10 * time.Minute,
+}
@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+const (
+	// How often idle workers look for new jobs
+	pollInterval = 2 * time.Second
+
+	// Claims and results are recorded with their own timeout, so they are not lost
+	// to the cancellation of the worker or the job
+	recordTimeout = 10 * time.Second
+
+	// How long jobs cancelled at the end of a drain get to return
+	cancelGrace = 5 * time.Second
+)
+
+// Handler processes a job claimed by a Worker. Returning nil completes the job, an
+// error fails it, to be retried unless it is Permanent. ctx is cancelled shortly
+// before the job's timeout, or when the worker stops without it having finished.
+type Handler func(ctx context.Context, job *models.Job) error
+
+// WorkerStats reports what a Worker is doing, for health checks
+type WorkerStats struct {
+	Queue       string    `json:"queue"`
+	Kinds       []string  `json:"kinds"` // Kinds the worker has handlers for
+	Concurrency int       `json:"concurrency"`
+	Active      int       `json:"active"` // Jobs in progress
+	Completed   int64     `json:"completed"`
+	Failed      int64     `json:"failed"`
+	Draining    bool      `json:"draining"`
+	LastPoll    time.Time `json:"last_poll"`            // Last time the queue was read
+	LastError   string    `json:"last_error,omitempty"` // Of the last read, if it failed
+}
+
+// Worker processes the jobs of one queue, up to Concurrency at a time. Only jobs of
+// kinds with a registered Handler are claimed, those of other kinds stay pending.
+type Worker struct {
+	Queue       *Queue
+	Name        string // Queue the worker claims jobs from
+	Concurrency int
+
+	id       string // Recorded in jobs.locked_by
+	handlers map[string]Handler
+
+	mu    sync.Mutex
+	stats WorkerStats
+}
+
+// NewWorker creates a Worker for a queue. Handlers are registered with Handle before
+// it is started with Run.
+func NewWorker(queue *Queue, name string, concurrency int) *Worker {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	concurrency = max(1, concurrency)
+	return &Worker{
+		Queue:       queue,
+		Name:        name,
+		Concurrency: concurrency,
+		id:          fmt.Sprintf("%s-%d", host, os.Getpid()),
+		handlers:    map[string]Handler{},
+		stats:       WorkerStats{Queue: name, Kinds: []string{}, Concurrency: concurrency},
+	}
+}
+
+// Handle registers the handler of a kind of job, which receives the job's decoded payload
+func Handle[P any](w *Worker, kind Kind[P], fn func(ctx context.Context, job *models.Job, payload P) error) {
+	if kind.Queue != w.Name {
+		panic(fmt.Sprintf("jobs: %s jobs belong to queue %s, not %s", kind.Name, kind.Queue, w.Name))
+	}
+
+	w.handlers[kind.Name] = func(ctx context.Context, job *models.Job) error {
+		payload, err := kind.Decode(job)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, job, payload)
+	}
+
+	w.mu.Lock()
+	w.stats.Kinds = append(w.stats.Kinds, kind.Name)
+	slices.Sort(w.stats.Kinds)
+	w.mu.Unlock()
+}
+
+// Stats returns a snapshot of what the worker is doing
+func (w *Worker) Stats() WorkerStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Kinds = slices.Clone(w.stats.Kinds)
+	return stats
+}
+
+// Run processes jobs until ctx is cancelled. It then claims no more jobs and waits for
+// those in progress to finish, for at most drainTimeout. Jobs still running after that
+// are cancelled and released back to the queue, to be claimed again by another worker.
+func (w *Worker) Run(ctx context.Context, drainTimeout time.Duration) {
+	kinds := w.Stats().Kinds
+	if len(kinds) == 0 {
+		log.Printf("Worker %s has no handlers, no %s jobs will be processed", w.id, w.Name)
+	} else {
+		log.Printf("Worker %s processing %v jobs of queue %s, %d at a time", w.id, kinds, w.Name, w.Concurrency)
+	}
+
+	// Jobs are only cancelled once the drain timeout passes, not when ctx is
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
+	for i := range w.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx, jobCtx, fmt.Sprintf("%s-%d", w.id, i), kinds)
+		}()
+	}
+
+	<-ctx.Done()
+	w.mu.Lock()
+	w.stats.Draining = true
+	active := w.stats.Active
+	w.mu.Unlock()
+	log.Printf("Worker %s stopping, waiting for %d jobs in progress", w.id, active)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("Worker %s drained", w.id)
+		return
+	case <-time.After(drainTimeout):
+	}
+
+	log.Printf("Worker %s drain timed out, cancelling %d jobs in progress", w.id, w.Stats().Active)
+	cancelJobs()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+		// Handlers that ignore their context; their jobs are claimed again after their timeout
+		log.Printf("Worker %s stopped with %d jobs still running", w.id, w.Stats().Active)
+	}
+}
+
+// loop claims and processes jobs one at a time until ctx is cancelled
+func (w *Worker) loop(ctx, jobCtx context.Context, workerID string, kinds []string) {
+	for ctx.Err() == nil {
+		job, err := w.claim(workerID, kinds)
+		if err != nil {
+			log.Printf("Worker %s failed to claim a job: %v", workerID, err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		w.process(jobCtx, job)
+	}
+}
+
+// claim takes the next job for a worker goroutine, recording whether the queue could be read
+func (w *Worker) claim(workerID string, kinds []string) (*models.Job, error) {
+	// Not cancelled with the worker, a claim that was made but not received would
+	// hold the job until its timeout
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	job, err := w.Queue.Claim(ctx, w.Name, kinds, workerID)
+
+	w.mu.Lock()
+	w.stats.LastPoll = time.Now()
+	w.stats.LastError = ""
+	if err != nil {
+		w.stats.LastError = err.Error()
+	}
+	w.mu.Unlock()
+
+	return job, err
+}
+
+// process runs the handler of a claimed job and records its result
+func (w *Worker) process(jobCtx context.Context, job *models.Job) {
+	w.mu.Lock()
+	w.stats.Active++
+	w.mu.Unlock()
+
+	// The handler stops a little before the claim expires, so that its failure is
+	// recorded before another worker claims the job again
+	ctx, cancel := context.WithTimeout(jobCtx, job.Timeout*9/10)
+	jobErr := w.call(ctx, job)
+	cancel()
+
+	recordCtx, cancelRecord := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancelRecord()
+
+	var err error
+	switch {
+	case jobErr == nil:
+		err = w.Queue.Complete(recordCtx, job)
+	case jobCtx.Err() != nil:
+		// Cut short by the worker stopping, which is not the job's fault
+		err = w.Queue.Release(recordCtx, job)
+	default:
+		err = w.Queue.Fail(recordCtx, job, jobErr)
+	}
+	if err != nil {
+		// The job is claimed again once its timeout passes
+		log.Printf("Failed to record the result of job %s (%s): %v", job.ID, job.Kind, err)
+	}
+
+	w.mu.Lock()
+	w.stats.Active--
+	if jobErr == nil {
+		w.stats.Completed++
+	} else if jobCtx.Err() == nil {
+		w.stats.Failed++
+	}
+	w.mu.Unlock()
+}
+
+// call runs the handler of a job, turning panics into errors
+func (w *Worker) call(ctx context.Context, job *models.Job) (err error) {
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for %s jobs", job.Kind))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s (%s) panicked: %v\n%s", job.ID, job.Kind, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
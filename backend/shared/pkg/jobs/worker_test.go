@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+func TestWorkerDrain(t *testing.T) {
+	tests := []struct {
+		name         string
+		handle       func(ctx context.Context, finish <-chan struct{}) error // Runs once the job was claimed
+		drainTimeout time.Duration
+		wantStatus   string
+		wantAttempts int
+		wantError    string // Recorded as the job's last error
+		wantStats    [2]int64
+	}{
+		{
+			name: "finishes during the drain",
+			handle: func(ctx context.Context, finish <-chan struct{}) error {
+				<-finish
+				return nil
+			},
+			drainTimeout: 5 * time.Second,
+			wantStatus:   models.JobDone,
+			wantAttempts: 1,
+			wantStats:    [2]int64{1, 0},
+		},
+		{
+			name: "fails during the drain",
+			handle: func(ctx context.Context, finish <-chan struct{}) error {
+				<-finish
+				return errors.New("faces service unavailable")
+			},
+			drainTimeout: 5 * time.Second,
+			wantStatus:   models.JobPending,
+			wantAttempts: 1,
+			wantError:    "faces service unavailable",
+			wantStats:    [2]int64{0, 1},
+		},
+		{
+			name: "panics during the drain",
+			handle: func(ctx context.Context, finish <-chan struct{}) error {
+				<-finish
+				panic("nil map")
+			},
+			drainTimeout: 5 * time.Second,
+			wantStatus:   models.JobPending,
+			wantAttempts: 1,
+			wantError:    "panic: nil map",
+			wantStats:    [2]int64{0, 1},
+		},
+		{
+			name: "cancelled and released after the drain timeout",
+			handle: func(ctx context.Context, finish <-chan struct{}) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			drainTimeout: 20 * time.Millisecond,
+			wantStatus:   models.JobPending,
+			wantAttempts: 0,
+			wantStats:    [2]int64{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MockJobStore{}
+			q := NewQueue(store)
+			job, _, err := testKind.Enqueue(context.Background(), q, testPayload{ImageID: "img-1"}, "")
+			require.NoError(t, err)
+
+			started := make(chan struct{})
+			finish := make(chan struct{})
+			w := NewWorker(q, testKind.Queue, 2)
+			Handle(w, testKind, func(ctx context.Context, job *models.Job, payload testPayload) error {
+				assert.Equal(t, models.ImageID("img-1"), payload.ImageID)
+				close(started)
+				return tt.handle(ctx, finish)
+			})
+
+			ctx, stop := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				w.Run(ctx, tt.drainTimeout)
+				close(stopped)
+			}()
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the job was not claimed")
+			}
+			assert.Equal(t, 1, w.Stats().Active)
+			stop()
+
+			// The job in progress is only finished once the worker is draining
+			require.Eventually(t, func() bool { return w.Stats().Draining }, time.Second, time.Millisecond)
+			close(finish)
+
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the worker did not stop")
+			}
+
+			stored, err := store.GetJob(context.Background(), job.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, stored.Status)
+			assert.Equal(t, tt.wantAttempts, stored.Attempts)
+			if tt.wantError != "" {
+				require.NotNil(t, stored.LastError)
+				assert.Equal(t, tt.wantError, *stored.LastError)
+			}
+
+			stats := w.Stats()
+			assert.Equal(t, 0, stats.Active)
+			assert.Equal(t, tt.wantStats, [2]int64{stats.Completed, stats.Failed}, "completed and failed")
+		})
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	store := &MockJobStore{}
+	w := NewWorker(NewQueue(store), testKind.Queue, 0)
+	Handle(w, Kind[testPayload]{Name: "other", Queue: testKind.Queue}, func(ctx context.Context, job *models.Job, payload testPayload) error { return nil })
+	Handle(w, testKind, func(ctx context.Context, job *models.Job, payload testPayload) error { return nil })
+
+	stats := w.Stats()
+	assert.Equal(t, []string{"other", "test"}, stats.Kinds)
+	assert.Equal(t, 1, stats.Concurrency, "every worker processes at least one job at a time")
+
+	assert.Panics(t, func() {
+		Handle(w, DetectFaces, func(ctx context.Context, job *models.Job, payload DetectFacesPayload) error { return nil })
+	}, "kinds of other queues cannot be handled")
+}
@@ -1,11 +1,18 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type (
-	UserID  = string // UUID
-	ImageID = string // UUID
-	AlbumID = string // UUID
+	UserID   = string // UUID
+	ImageID  = string // UUID
+	AlbumID  = string // UUID
+	UploadID = string // UUID
+	JobID    = string // UUID
+	FaceID   = string // UUID
+	PersonID = string // UUID
 )
 
 // User represents a registered user in the system.
@@ -20,6 +27,27 @@ type User struct {
 	UpdatedAt    time.Time `json:"-" db:"updated_at"`
 }
 
+// UserSettings holds a user's preferences.
+type UserSettings struct {
+	// StripMetadata removes GPS coordinates, camera details and other embedded
+	// metadata from downloads and shared links of the user's images
+	StripMetadata bool `json:"strip_metadata" db:"strip_metadata"`
+}
+
+// PerceptualHash is the perceptual hash of an image, see imaging.PerceptualHash.
+type PerceptualHash struct {
+	ImageID ImageID
+	Hash    uint64
+}
+
+// StorageUsage summarizes how much of their quota a user has used.
+type StorageUsage struct {
+	BytesUsed      int64  `json:"bytes_used"`
+	BytesRemaining *int64 `json:"bytes_remaining"` // nil when the quota is unlimited
+	QuotaBytes     *int64 `json:"quota_bytes"`     // nil when the quota is unlimited
+	ImageCount     int    `json:"image_count"`
+}
+
 type GoogleUser struct {
 	ID            string `json:"sub"`            // Google's unique subject identifier
 	Email         string `json:"email"`          // User's email address
@@ -33,18 +61,142 @@ type GoogleUser struct {
 
 // ImageMetadata holds information about an uploaded image.
 type ImageMetadata struct {
-	ID          ImageID   `json:"id" db:"id"` // UUID or other unique ID
-	UserID      UserID    `json:"user_id" db:"user_id"`
-	Filename    string    `json:"filename" db:"filename"`         // Original filename
-	StoragePath string    `json:"-" db:"storage_path"`            // Path in blob storage
-	ContentType string    `json:"content_type" db:"content_type"` // MIME type
-	Size        int64     `json:"size" db:"size"`                 // Size in bytes
-	Width       int       `json:"width,omitempty" db:"width"`
-	Height      int       `json:"height,omitempty" db:"height"`
+	ID             ImageID     `json:"id" db:"id"` // UUID or other unique ID
+	UserID         UserID      `json:"user_id" db:"user_id"`
+	Filename       string      `json:"filename" db:"filename"`           // Original filename
+	StoragePath    string      `json:"-" db:"storage_path"`              // Path in blob storage
+	StorageBackend *string     `json:"-" db:"storage_backend"`           // Backend holding the file, nil for images uploaded before it was tracked
+	BlobHash       *string     `json:"-" db:"blob_hash"`                 // SHA-256 of the shared blob, set in content-addressed mode
+	Checksum       *string     `json:"checksum,omitempty" db:"checksum"` // Hex encoded SHA-256 of the content, recorded at upload
+	ContentType    string      `json:"content_type" db:"content_type"`   // MIME type
+	Size           int64       `json:"size" db:"size"`                   // Size in bytes
+	Width          int         `json:"width,omitempty" db:"width"`
+	Height         int         `json:"height,omitempty" db:"height"`
+	TakenAt        *time.Time  `json:"taken_at,omitempty" db:"taken_at"`             // Capture time from EXIF, nil if unknown
+	BlurHash       *string     `json:"blurhash,omitempty" db:"blurhash"`             // Placeholder painted while the image loads, nil until computed
+	DominantColor  *string     `json:"dominant_color,omitempty" db:"dominant_color"` // "#rrggbb", nil until computed
+	Edits          []ImageEdit `json:"-" db:"edits"`                                 // Applied on top of the original, oldest first
+	HasEdits       bool        `json:"has_edits" db:"-"`                             // Whether renditions show an edited version
+	Version        int         `json:"version" db:"version"`                         // Number of the current file, incremented when the original is replaced
+	CreatedAt      time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at" db:"updated_at"`
+
+	Exif *ImageExif `json:"exif,omitempty" db:"-"` // Only loaded for single images
+}
+
+// ImageVersion is a file an image had before its original was replaced. Its ID, albums
+// and links stay with the image; any version can be restored as the current file.
+type ImageVersion struct {
+	ImageID        ImageID     `json:"image_id" db:"image_id"`
+	UserID         UserID      `json:"-" db:"-"` // Owner of the image, for encryption keys
+	Version        int         `json:"version" db:"version"`
+	Filename       string      `json:"filename" db:"filename"`
+	StoragePath    string      `json:"-" db:"storage_path"`
+	StorageBackend *string     `json:"-" db:"storage_backend"`
+	BlobHash       *string     `json:"-" db:"blob_hash"`
+	Checksum       *string     `json:"checksum,omitempty" db:"checksum"`
+	ContentType    string      `json:"content_type" db:"content_type"`
+	Size           int64       `json:"size" db:"size"`
+	Width          int         `json:"width,omitempty" db:"width"`
+	Height         int         `json:"height,omitempty" db:"height"`
+	TakenAt        *time.Time  `json:"taken_at,omitempty" db:"taken_at"`
+	Edits          []ImageEdit `json:"edits" db:"edits"` // Edit stack the image had with this file, restored with it
+	ReplacedAt     time.Time   `json:"replaced_at" db:"replaced_at"`
+}
+
+// Edit operations
+const (
+	EditRotate   = "rotate"
+	EditCrop     = "crop"
+	EditFlip     = "flip"
+	EditExposure = "exposure"
+)
+
+// ImageEdit is one step of an image's edit stack. Edits never modify the original,
+// renditions are rendered from the original with every edit applied in order, each
+// to the upright result of the previous ones.
+type ImageEdit struct {
+	Op    string   `json:"op"`              // EditRotate, EditCrop, EditFlip or EditExposure
+	Angle int      `json:"angle,omitempty"` // Rotate: 90, 180 or 270 degrees clockwise
+	Axis  string   `json:"axis,omitempty"`  // Flip: "horizontal" (mirrored left to right) or "vertical"
+	Crop  *CropBox `json:"crop,omitempty"`  // Crop: the part of the image to keep
+	Stops float64  `json:"stops,omitempty"` // Exposure: change in EV, positive brightens
+}
+
+// CropBox is a rectangle given as fractions, 0 to 1, of the width and height of the
+// image it applies to, so it does not depend on the size that is rendered.
+type CropBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// ImageExif is the capture metadata read from an image's EXIF block. Every field is
+// nil when the camera did not record it; images without EXIF have no fields set.
+type ImageExif struct {
+	ImageID      ImageID    `json:"-" db:"image_id"`
+	TakenAt      *time.Time `json:"taken_at,omitempty" db:"taken_at"` // In UTC when the camera recorded its offset, otherwise the camera's local time
+	CameraMake   *string    `json:"camera_make,omitempty" db:"camera_make"`
+	CameraModel  *string    `json:"camera_model,omitempty" db:"camera_model"`
+	LensModel    *string    `json:"lens_model,omitempty" db:"lens_model"`
+	FocalLength  *float64   `json:"focal_length,omitempty" db:"focal_length"`   // Millimetres
+	FNumber      *float64   `json:"f_number,omitempty" db:"f_number"`           // Aperture, e.g. 2.8
+	ExposureTime *string    `json:"exposure_time,omitempty" db:"exposure_time"` // Seconds, e.g. "1/250"
+	ISO          *int       `json:"iso,omitempty" db:"iso"`
+	Orientation  *int       `json:"orientation,omitempty" db:"orientation"` // EXIF orientation, 1 (upright) to 8
+	Latitude     *float64   `json:"latitude,omitempty" db:"latitude"`
+	Longitude    *float64   `json:"longitude,omitempty" db:"longitude"`
+	Altitude     *float64   `json:"altitude,omitempty" db:"altitude"` // Metres above sea level
+}
+
+// Rendition is a downscaled copy of an image, stored next to the original in blob storage.
+type Rendition struct {
+	ImageID     ImageID   `json:"image_id" db:"image_id"`
+	Size        string    `json:"size" db:"size"` // "small", "medium" or "large"
+	StoragePath string    `json:"-" db:"storage_path"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Width       int       `json:"width" db:"width"`
+	Height      int       `json:"height" db:"height"`
+	FileSize    int64     `json:"file_size" db:"file_size"` // Size in bytes
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Blob is a content-addressed file in blob storage, shared by every image with identical bytes.
+type Blob struct {
+	Hash        string    `json:"hash" db:"hash"` // Hex encoded SHA-256 of the content
+	StoragePath string    `json:"-" db:"storage_path"`
+	Size        int64     `json:"size" db:"size"`
+	RefCount    int       `json:"ref_count" db:"ref_count"` // Number of images referencing this blob
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// DataKey is a per-owner key used to encrypt blobs at rest, stored wrapped (encrypted) by a master key.
+type DataKey struct {
+	OwnerID     string    `json:"owner_id" db:"owner_id"` // User ID, or "system" for files without an owner
+	WrappedKey  []byte    `json:"-" db:"wrapped_key"`
+	MasterKeyID string    `json:"master_key_id" db:"master_key_id"` // Fingerprint of the master key that wrapped it
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Upload is an in-progress resumable (tus) upload. Received chunks are staged
+// in blob storage until the upload is complete and turned into an image.
+type Upload struct {
+	ID             UploadID  `json:"id" db:"id"`
+	UserID         UserID    `json:"user_id" db:"user_id"`
+	Filename       string    `json:"filename" db:"filename"`
+	ContentType    string    `json:"content_type" db:"content_type"`
+	Length         int64     `json:"length" db:"length"`                   // Total size declared by the client
+	Offset         int64     `json:"offset" db:"upload_offset"`            // Bytes received so far
+	ChunkPaths     []string  `json:"-" db:"chunk_paths"`                   // Staged chunks in blob storage, in order
+	AllowDuplicate bool      `json:"allow_duplicate" db:"allow_duplicate"` // Store the image even if identical content exists
+	ExpiresAt      time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
 // Album represents a collection of images grouped by a user.
 type Album struct {
 	ID          AlbumID   `json:"id" db:"id"`
@@ -60,3 +212,67 @@ type AlbumImage struct {
 	AlbumID AlbumID `json:"album_id" db:"album_id"`
 	ImageID ImageID `json:"image_id" db:"image_id"`
 }
+
+// Job states
+const (
+	JobPending = "pending" // Waiting to be claimed, from run_at on
+	JobRunning = "running" // Claimed by a worker until run_at
+	JobDone    = "done"
+	JobDead    = "dead" // Failed every attempt, kept until retried
+)
+
+// Job is a unit of background work, processed by a worker of the service its queue
+// names. Its payload is decoded according to its kind.
+type Job struct {
+	ID             JobID           `json:"id" db:"id"`
+	Queue          string          `json:"queue" db:"queue"`
+	Kind           string          `json:"kind" db:"kind"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	IdempotencyKey *string         `json:"idempotency_key,omitempty" db:"idempotency_key"`
+	Status         string          `json:"status" db:"status"`     // JobPending, JobRunning, JobDone or JobDead
+	Attempts       int             `json:"attempts" db:"attempts"` // Claims so far, including the current one
+	MaxAttempts    int             `json:"max_attempts" db:"max_attempts"`
+	Timeout        time.Duration   `json:"-" db:"timeout_seconds"` // Visibility timeout of a claim
+	RunAt          time.Time       `json:"run_at" db:"run_at"`
+	LockedBy       *string         `json:"locked_by,omitempty" db:"locked_by"`
+	LastError      *string         `json:"last_error,omitempty" db:"last_error"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// JobCount is the number of jobs of a kind in a state
+type JobCount struct {
+	Queue  string `json:"queue"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// Face is a face detected in an image. Its box is a fraction of the image as uploaded,
+// turned upright, before any edits.
+type Face struct {
+	ID        FaceID    `json:"id" db:"id"`
+	ImageID   ImageID   `json:"image_id" db:"image_id"`
+	UserID    UserID    `json:"-" db:"user_id"`
+	PersonID  *PersonID `json:"person_id,omitempty" db:"person_id"` // nil until it is clustered with similar faces
+	Box       CropBox   `json:"box" db:"-"`
+	Score     float64   `json:"score" db:"score"` // Detector confidence
+	Embedding []float32 `json:"-" db:"embedding"` // Unit vector, similar faces point the same way
+	Model     string    `json:"-" db:"model"`     // Detector that computed the embedding
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Person is a cluster of similar faces in a user's images, which the user can name
+type Person struct {
+	ID         PersonID  `json:"id" db:"id"`
+	UserID     UserID    `json:"-" db:"user_id"`
+	Name       *string   `json:"name" db:"name"` // nil until named
+	Model      string    `json:"-" db:"model"`
+	Centroid   []float32 `json:"-" db:"centroid"` // Mean embedding of its faces
+	FaceCount  int       `json:"face_count" db:"-"`
+	ImageCount int       `json:"image_count" db:"-"`
+	Cover      *Face     `json:"cover,omitempty" db:"-"` // Its most confidently detected face, to show it by
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,273 @@
+package renditions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/imaging"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// Rendition sizes
+const (
+	Small  = "small"
+	Medium = "medium"
+	Large  = "large"
+)
+
+// Spec describes how a rendition is derived from the original
+type Spec struct {
+	Name   string
+	Edge   int  // Longest edge in pixels, or the side of square renditions
+	Square bool // Centre-cropped to a square, for grid thumbnails
+}
+
+// Specs lists the renditions generated for every image, smallest first
+var Specs = []Spec{
+	{Name: Small, Edge: 256, Square: true},
+	{Name: Medium, Edge: 1024},
+	{Name: Large, Edge: 2048},
+}
+
+// Lookup returns the spec of a rendition size
+func Lookup(name string) (Spec, bool) {
+	for _, spec := range Specs {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return Spec{}, false
+}
+
+const (
+	contentType = "image/jpeg"
+	jpegQuality = 85
+
+	// Enqueue adds at most one job per image this often, however often the image is served
+	// without its renditions, so that images whose renditions fail are not retried constantly
+	retryAfter = time.Hour
+)
+
+// StoragePath returns where a rendition of an image is stored
+func StoragePath(imageID models.ImageID, size string) string {
+	return path.Join("renditions", imageID, size+".jpg")
+}
+
+// Store is the part of the database the Generator reads images from and records its results in
+type Store interface {
+	db.RenditionStore
+	db.DuplicateStore
+	GetImageByID(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error)
+}
+
+// Generator creates the renditions of images. It also records their perceptual hash and
+// placeholder, which need the decoded image as well. Images are queued as GenerateRenditions
+// jobs, which the server's rendition workers process with Process, so that work pending
+// when the server stops is not lost.
+type Generator struct {
+	DB      Store
+	Storage storage.BlobStorage
+	Jobs    *jobs.Queue
+}
+
+// NewGenerator creates a new Generator instance
+func NewGenerator(store Store, blobStorage storage.BlobStorage, queue *jobs.Queue) *Generator {
+	return &Generator{
+		DB:      store,
+		Storage: blobStorage,
+		Jobs:    queue,
+	}
+}
+
+// Enqueue queues the generation of the renditions of an image, e.g. a new upload or one
+// served without its renditions. Images queued within the last retryAfter are skipped.
+// The image is served without renditions meanwhile, a job that could not be enqueued is
+// only logged.
+func (g *Generator) Enqueue(ctx context.Context, img models.ImageMetadata) {
+	key := fmt.Sprintf("generate-renditions:%s:%d", img.ID, time.Now().Truncate(retryAfter).Unix())
+	g.enqueue(ctx, img, key)
+}
+
+// Regenerate replaces the renditions of an image that changed, e.g. was edited. Unlike
+// Enqueue it is never skipped; jobs of the image that are running meanwhile generate
+// its renditions again once done.
+func (g *Generator) Regenerate(ctx context.Context, img models.ImageMetadata) {
+	g.enqueue(ctx, img, "")
+}
+
+func (g *Generator) enqueue(ctx context.Context, img models.ImageMetadata, key string) {
+	payload := jobs.GenerateRenditionsPayload{ImageID: img.ID, UserID: img.UserID}
+	if _, _, err := jobs.GenerateRenditions.Enqueue(ctx, g.Jobs, payload, key); err != nil {
+		log.Printf("Warning: Failed to enqueue renditions of image %s: %v", img.ID, err)
+	}
+}
+
+// Process is the handler of GenerateRenditions jobs. The image is read when the job runs,
+// and again once its renditions are generated: if it was edited or its file replaced
+// meanwhile, they are generated again, so that a slow job cannot leave stale renditions.
+func (g *Generator) Process(ctx context.Context, job *models.Job, payload jobs.GenerateRenditionsPayload) error {
+	img, err := g.DB.GetImageByID(ctx, payload.UserID, payload.ImageID)
+	for {
+		if err != nil {
+			if err.Error() == "image not found" {
+				log.Printf("Image %s was deleted, skipping its renditions", payload.ImageID)
+				return nil
+			}
+			return err
+		}
+
+		if _, err := g.Generate(ctx, img); err != nil {
+			if err.Error() == "image not found" {
+				log.Printf("Image %s was deleted, skipping its renditions", payload.ImageID)
+				return nil
+			}
+			// Retrying cannot make the file decodable
+			if errors.Is(err, imaging.ErrUndecodable) || errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrTooLarge) {
+				return jobs.Permanent(err)
+			}
+			return err
+		}
+
+		generated := img
+		img, err = g.DB.GetImageByID(ctx, payload.UserID, payload.ImageID)
+		if err == nil && img.StoragePath == generated.StoragePath && img.Version == generated.Version && reflect.DeepEqual(img.Edits, generated.Edits) {
+			return nil
+		}
+	}
+}
+
+// Generate creates and records every rendition of an image, replacing existing ones,
+// its perceptual hash and its placeholder
+func (g *Generator) Generate(ctx context.Context, img *models.ImageMetadata) ([]models.Rendition, error) {
+	file, _, err := g.Storage.Open(ctx, img.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	src, info, err := imaging.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Near-duplicates are found by how the original looks, edits don't make an image unique
+	if err := g.DB.SetPerceptualHash(ctx, img.ID, imaging.PerceptualHash(src, info.Orientation)); err != nil {
+		return nil, err
+	}
+
+	// Everything shown in galleries reflects the edits
+	orientation := info.Orientation
+	if len(img.Edits) > 0 {
+		src, orientation = imaging.ApplyEdits(src, orientation, img.Edits), 1
+	}
+
+	blurHash, dominantColor := imaging.Placeholder(src, orientation)
+	if err := g.DB.SetImagePlaceholder(ctx, img.ID, blurHash, dominantColor); err != nil {
+		return nil, err
+	}
+
+	// Renditions are encrypted with the owner's data key when encryption at rest is enabled
+	ctx = storage.WithKeyOwner(ctx, img.UserID)
+
+	var created []models.Rendition
+	for _, spec := range Specs {
+		rendition, err := g.render(ctx, img.ID, src, orientation, spec)
+		if err != nil {
+			if err.Error() == "image not found" {
+				// Deleted meanwhile, don't leave its renditions behind
+				g.Delete(ctx, append(created, models.Rendition{ImageID: img.ID, Size: spec.Name, StoragePath: StoragePath(img.ID, spec.Name)}))
+			}
+			return nil, err
+		}
+		created = append(created, *rendition)
+	}
+
+	log.Printf("Generated %d renditions of image %s", len(created), img.ID)
+	return created, nil
+}
+
+// render scales src to a rendition turned upright, stores it and records it in the database
+func (g *Generator) render(ctx context.Context, imageID models.ImageID, src image.Image, orientation int, spec Spec) (*models.Rendition, error) {
+	var scaled image.Image
+	if spec.Square {
+		scaled = imaging.Square(src, spec.Edge)
+	} else {
+		scaled = imaging.Fit(src, spec.Edge, spec.Edge)
+	}
+	// Renditions carry no EXIF data, so the pixels themselves must be upright. Both
+	// scalings are symmetric, turning the smaller scaled image is the cheaper order.
+	scaled = imaging.Orient(scaled, orientation)
+
+	var buf bytes.Buffer
+	if err := imaging.EncodeJPEG(&buf, scaled, jpegQuality); err != nil {
+		return nil, fmt.Errorf("failed to encode %s rendition: %w", spec.Name, err)
+	}
+
+	rendition := &models.Rendition{
+		ImageID:     imageID,
+		Size:        spec.Name,
+		StoragePath: StoragePath(imageID, spec.Name),
+		ContentType: contentType,
+		Width:       scaled.Bounds().Dx(),
+		Height:      scaled.Bounds().Dy(),
+		FileSize:    int64(buf.Len()),
+	}
+
+	if err := g.Storage.Put(ctx, rendition.StoragePath, &buf, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store %s rendition: %w", spec.Name, err)
+	}
+	if err := g.DB.SaveRendition(ctx, rendition); err != nil {
+		return nil, err
+	}
+
+	return rendition, nil
+}
+
+// Delete removes the files of renditions, whose rows are deleted together with their image.
+// Failures are only logged, the files are left behind as orphans.
+func (g *Generator) Delete(ctx context.Context, renditions []models.Rendition) {
+	for _, r := range renditions {
+		if err := g.Storage.Delete(ctx, r.StoragePath); err != nil && !strings.HasPrefix(err.Error(), "file not found") {
+			log.Printf("Warning: Failed to delete %s rendition of image %s: %v", r.Size, r.ImageID, err)
+		}
+	}
+}
+
+// Pick chooses the rendition to serve for a requested size: the requested one if it
+// exists, otherwise the closest larger one, otherwise the closest smaller one.
+// It returns nil if the image has no renditions yet.
+func Pick(renditions []models.Rendition, size string) *models.Rendition {
+	bySize := map[string]*models.Rendition{}
+	for i := range renditions {
+		bySize[renditions[i].Size] = &renditions[i]
+	}
+
+	requested := 0
+	for i, spec := range Specs {
+		if spec.Name == size {
+			requested = i
+		}
+	}
+
+	for i := requested; i < len(Specs); i++ {
+		if r, ok := bySize[Specs[i].Name]; ok {
+			return r
+		}
+	}
+	for i := requested - 1; i >= 0; i-- {
+		if r, ok := bySize[Specs[i].Name]; ok {
+			return r
+		}
+	}
+	return nil
+}
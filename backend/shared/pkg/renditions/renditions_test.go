@@ -0,0 +1,336 @@
+package renditions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/jobs"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// MockStore records what a Generator stores. Only the methods it uses are implemented,
+// the others panic through the nil embedded interface.
+type MockStore struct {
+	Store
+
+	mu           sync.Mutex
+	images       map[models.ImageID]*models.ImageMetadata
+	saved        []models.Rendition // Every rendition saved, in order
+	hashes       map[models.ImageID]uint64
+	placeholders map[models.ImageID][2]string
+
+	// afterGet runs after an image is read, e.g. to edit it while it is generated
+	afterGet func(reads int)
+	reads    int
+}
+
+func NewMockStore() *MockStore {
+	return &MockStore{
+		images:       map[models.ImageID]*models.ImageMetadata{},
+		hashes:       map[models.ImageID]uint64{},
+		placeholders: map[models.ImageID][2]string{},
+	}
+}
+
+func (m *MockStore) GetImageByID(ctx context.Context, userID models.UserID, imageID models.ImageID) (*models.ImageMetadata, error) {
+	m.mu.Lock()
+	img, ok := m.images[imageID]
+	var copied models.ImageMetadata
+	if ok && img.UserID == userID {
+		copied = *img
+	}
+	m.reads++
+	reads := m.reads
+	m.mu.Unlock()
+
+	if m.afterGet != nil {
+		m.afterGet(reads)
+	}
+	if !ok || copied.UserID != userID {
+		return nil, errors.New("image not found")
+	}
+	return &copied, nil
+}
+
+func (m *MockStore) SaveRendition(ctx context.Context, rendition *models.Rendition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.images[rendition.ImageID]; !ok {
+		return errors.New("image not found")
+	}
+	m.saved = append(m.saved, *rendition)
+	return nil
+}
+
+func (m *MockStore) SetPerceptualHash(ctx context.Context, imageID models.ImageID, hash uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hashes[imageID] = hash
+	return nil
+}
+
+func (m *MockStore) SetImagePlaceholder(ctx context.Context, imageID models.ImageID, blurHash, dominantColor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.placeholders[imageID] = [2]string{blurHash, dominantColor}
+	return nil
+}
+
+// MockJobStore records enqueued jobs, returning the existing job for a repeated idempotency key
+type MockJobStore struct {
+	db.JobStore
+
+	mu   sync.Mutex
+	jobs []models.Job
+}
+
+func (m *MockJobStore) EnqueueJob(ctx context.Context, job *models.Job) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.IdempotencyKey != nil {
+		for _, existing := range m.jobs {
+			if existing.IdempotencyKey != nil && *existing.IdempotencyKey == *job.IdempotencyKey {
+				*job = existing
+				return false, nil
+			}
+		}
+	}
+	m.jobs = append(m.jobs, *job)
+	return true, nil
+}
+
+// newTestGenerator returns a Generator over local storage, with a w×h PNG stored as the
+// original of the returned image
+func newTestGenerator(t *testing.T, w, h int) (*Generator, *MockStore, *MockJobStore, *models.ImageMetadata) {
+	t.Helper()
+
+	local, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			src.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	require.NoError(t, local.Put(context.Background(), "user-1/img-1.png", &buf, "image/png"))
+
+	store := NewMockStore()
+	img := &models.ImageMetadata{ID: "img-1", UserID: "user-1", StoragePath: "user-1/img-1.png", ContentType: "image/png", Version: 1}
+	copied := *img
+	store.images[img.ID] = &copied
+
+	jobStore := &MockJobStore{}
+	return NewGenerator(store, local, jobs.NewQueue(jobStore)), store, jobStore, img
+}
+
+// decodeRendition reads a stored rendition back
+func decodeRendition(t *testing.T, g *Generator, r models.Rendition) image.Image {
+	t.Helper()
+
+	file, _, err := g.Storage.Open(context.Background(), r.StoragePath)
+	require.NoError(t, err)
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	require.NoError(t, err)
+	return img
+}
+
+func TestGenerate(t *testing.T) {
+	g, store, _, img := newTestGenerator(t, 3000, 1500)
+
+	created, err := g.Generate(context.Background(), img)
+	require.NoError(t, err)
+
+	want := []struct {
+		size          string
+		width, height int
+	}{
+		{Small, 256, 256},
+		{Medium, 1024, 512},
+		{Large, 2048, 1024},
+	}
+	require.Len(t, created, len(want))
+	for i, w := range want {
+		r := created[i]
+		assert.Equal(t, w.size, r.Size)
+		assert.Equal(t, StoragePath(img.ID, w.size), r.StoragePath)
+		assert.Equal(t, "image/jpeg", r.ContentType)
+		assert.Equal(t, w.width, r.Width, w.size)
+		assert.Equal(t, w.height, r.Height, w.size)
+
+		decoded := decodeRendition(t, g, r)
+		assert.Equal(t, w.width, decoded.Bounds().Dx(), w.size)
+		assert.Equal(t, w.height, decoded.Bounds().Dy(), w.size)
+	}
+	assert.Equal(t, created, store.saved)
+
+	assert.Contains(t, store.hashes, img.ID)
+	require.Contains(t, store.placeholders, img.ID)
+	assert.NotEmpty(t, store.placeholders[img.ID][0])
+	assert.Regexp(t, `^#[0-9a-f]{6}$`, store.placeholders[img.ID][1])
+
+	t.Run("small images are not scaled up", func(t *testing.T) {
+		g, _, _, img := newTestGenerator(t, 600, 300)
+
+		created, err := g.Generate(context.Background(), img)
+		require.NoError(t, err)
+		require.Len(t, created, 3)
+		assert.Equal(t, [2]int{256, 256}, [2]int{created[0].Width, created[0].Height})
+		assert.Equal(t, [2]int{600, 300}, [2]int{created[1].Width, created[1].Height})
+		assert.Equal(t, [2]int{600, 300}, [2]int{created[2].Width, created[2].Height})
+	})
+
+	t.Run("edits are applied", func(t *testing.T) {
+		g, _, _, img := newTestGenerator(t, 600, 300)
+		img.Edits = []models.ImageEdit{{Op: models.EditRotate, Angle: 90}}
+
+		created, err := g.Generate(context.Background(), img)
+		require.NoError(t, err)
+		assert.Equal(t, [2]int{300, 600}, [2]int{created[1].Width, created[1].Height})
+	})
+
+	t.Run("deleted meanwhile", func(t *testing.T) {
+		g, store, _, img := newTestGenerator(t, 600, 300)
+		delete(store.images, img.ID)
+
+		_, err := g.Generate(context.Background(), img)
+		require.EqualError(t, err, "image not found")
+		for _, spec := range Specs {
+			_, err := g.Storage.Stat(context.Background(), StoragePath(img.ID, spec.Name))
+			assert.Error(t, err, "%s rendition of a deleted image is left behind", spec.Name)
+		}
+	})
+}
+
+func TestStoragePath(t *testing.T) {
+	assert.Equal(t, "renditions/img-1/small.jpg", StoragePath("img-1", Small))
+	assert.Equal(t, "renditions/img-1/large.jpg", StoragePath("img-1", Large))
+}
+
+func TestPick(t *testing.T) {
+	small := models.Rendition{Size: Small}
+	medium := models.Rendition{Size: Medium}
+	large := models.Rendition{Size: Large}
+
+	tests := []struct {
+		name       string
+		renditions []models.Rendition
+		size       string
+		want       string // Size picked, "" for none
+	}{
+		{name: "none yet", size: Small},
+		{name: "requested", renditions: []models.Rendition{small, medium, large}, size: Medium, want: Medium},
+		{name: "closest larger", renditions: []models.Rendition{small, large}, size: Medium, want: Large},
+		{name: "closest smaller", renditions: []models.Rendition{small, medium}, size: Large, want: Medium},
+		{name: "only smaller", renditions: []models.Rendition{small}, size: Large, want: Small},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picked := Pick(tt.renditions, tt.size)
+			if tt.want == "" {
+				assert.Nil(t, picked)
+				return
+			}
+			require.NotNil(t, picked)
+			assert.Equal(t, tt.want, picked.Size)
+		})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	g, _, jobStore, img := newTestGenerator(t, 8, 8)
+	ctx := context.Background()
+
+	// However often the image is served without renditions, it is queued once
+	g.Enqueue(ctx, *img)
+	g.Enqueue(ctx, *img)
+	require.Len(t, jobStore.jobs, 1)
+	assert.Equal(t, jobs.Renditions, jobStore.jobs[0].Queue)
+	payload, err := jobs.GenerateRenditions.Decode(&jobStore.jobs[0])
+	require.NoError(t, err)
+	assert.Equal(t, jobs.GenerateRenditionsPayload{ImageID: img.ID, UserID: img.UserID}, payload)
+
+	// Changes are always queued, even while the image is queued already
+	g.Regenerate(ctx, *img)
+	g.Regenerate(ctx, *img)
+	require.Len(t, jobStore.jobs, 3)
+	assert.Nil(t, jobStore.jobs[1].IdempotencyKey)
+	assert.Nil(t, jobStore.jobs[2].IdempotencyKey)
+}
+
+func TestProcess(t *testing.T) {
+	payload := jobs.GenerateRenditionsPayload{ImageID: "img-1", UserID: "user-1"}
+
+	t.Run("generates the current image", func(t *testing.T) {
+		g, store, _, img := newTestGenerator(t, 600, 300)
+		// Queued before the image was edited
+		store.images[img.ID].Edits = []models.ImageEdit{{Op: models.EditRotate, Angle: 90}}
+
+		require.NoError(t, g.Process(context.Background(), &models.Job{}, payload))
+		require.Len(t, store.saved, 3)
+		assert.Equal(t, [2]int{300, 600}, [2]int{store.saved[1].Width, store.saved[1].Height})
+	})
+
+	t.Run("changed while generating", func(t *testing.T) {
+		g, store, _, img := newTestGenerator(t, 600, 300)
+		store.afterGet = func(reads int) {
+			if reads == 1 {
+				store.mu.Lock()
+				store.images[img.ID].Edits = []models.ImageEdit{{Op: models.EditRotate, Angle: 90}}
+				store.mu.Unlock()
+			}
+		}
+
+		require.NoError(t, g.Process(context.Background(), &models.Job{}, payload))
+		require.Len(t, store.saved, 6, "generated again after the edit")
+		assert.Equal(t, [2]int{600, 300}, [2]int{store.saved[1].Width, store.saved[1].Height})
+		assert.Equal(t, [2]int{300, 600}, [2]int{store.saved[4].Width, store.saved[4].Height})
+	})
+
+	t.Run("deleted image", func(t *testing.T) {
+		g, store, _, img := newTestGenerator(t, 600, 300)
+		delete(store.images, img.ID)
+
+		require.NoError(t, g.Process(context.Background(), &models.Job{}, payload))
+		assert.Empty(t, store.saved)
+	})
+
+	t.Run("undecodable file", func(t *testing.T) {
+		g, store, _, img := newTestGenerator(t, 600, 300)
+		require.NoError(t, g.Storage.Put(context.Background(), img.StoragePath, bytes.NewReader([]byte("\x89PNG\r\n\x1a\ntruncated")), "image/png"))
+
+		err := g.Process(context.Background(), &models.Job{}, payload)
+		require.Error(t, err)
+		assert.True(t, jobs.IsPermanent(err), "retrying cannot make the file decodable")
+		assert.Empty(t, store.saved)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		g, _, _, img := newTestGenerator(t, 600, 300)
+		require.NoError(t, g.Storage.Delete(context.Background(), img.StoragePath))
+
+		err := g.Process(context.Background(), &models.Job{}, payload)
+		require.Error(t, err)
+		assert.False(t, jobs.IsPermanent(err), "the file may be restored, e.g. by migrating storage")
+	})
+}
@@ -0,0 +1,274 @@
+package scrubber
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+const (
+	// Images are loaded from the database in pages of this size
+	pageSize = 200
+
+	// Files younger than this are not reported as orphans, their image row may not be committed yet
+	orphanGracePeriod = time.Hour
+)
+
+// Prefixes of files that are managed outside the images table
+var ignoredPrefixes = []string{"uploads/", "renditions/", "derived/"}
+
+// Issue describes an image whose file failed verification
+type Issue struct {
+	ImageID          models.ImageID `json:"image_id"`
+	UserID           models.UserID  `json:"user_id"`
+	StoragePath      string         `json:"storage_path"`
+	ExpectedChecksum string         `json:"expected_checksum,omitempty"`
+	ActualChecksum   string         `json:"actual_checksum,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+// Orphan is a stored file that no image references
+type Orphan struct {
+	StoragePath string    `json:"storage_path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// Report is the outcome of a scrub
+type Report struct {
+	StartedAt         time.Time `json:"started_at"`
+	FinishedAt        time.Time `json:"finished_at"`
+	ImagesChecked     int       `json:"images_checked"`
+	FilesHashed       int       `json:"files_hashed"`
+	BytesHashed       int64     `json:"bytes_hashed"`
+	ChecksumsRecorded int       `json:"checksums_recorded"` // Images uploaded before checksums were recorded
+	FilesListed       int       `json:"files_listed"`
+
+	Mismatches []Issue  `json:"mismatches"` // Content differs from the recorded checksum
+	Missing    []Issue  `json:"missing"`    // File does not exist
+	Unreadable []Issue  `json:"unreadable"` // File could not be read, e.g. storage was unavailable
+	Orphans    []Orphan `json:"orphans"`    // File exists but no image references it
+
+	Error string `json:"error,omitempty"` // Set if the scrub was aborted
+}
+
+// Scrubber periodically re-hashes every stored image and compares it to the
+// checksum recorded at upload, and lists blob storage to find orphaned files.
+type Scrubber struct {
+	DB      db.ImageStore
+	Storage storage.BlobStorage
+
+	mu      sync.Mutex
+	running bool
+	last    *Report
+}
+
+// NewScrubber creates a Scrubber for the images in store and files in blobStorage
+func NewScrubber(store db.ImageStore, blobStorage storage.BlobStorage) *Scrubber {
+	return &Scrubber{DB: store, Storage: blobStorage}
+}
+
+// ErrAlreadyRunning is returned by Run while another scrub is in progress
+var ErrAlreadyRunning = errors.New("scrub already running")
+
+// Status returns whether a scrub is running and the report of the last finished scrub, if any
+func (s *Scrubber) Status() (bool, *Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.last
+}
+
+// Schedule runs a scrub every interval until ctx is cancelled
+func (s *Scrubber) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Run(ctx); err != nil && !errors.Is(err, ErrAlreadyRunning) {
+				log.Printf("Scrub failed: %v", err)
+			}
+		}
+	}
+}
+
+// Run performs a full scrub and returns its report
+func (s *Scrubber) Run(ctx context.Context) (*Report, error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil, ErrAlreadyRunning
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	report := &Report{StartedAt: time.Now(), Mismatches: []Issue{}, Missing: []Issue{}, Unreadable: []Issue{}, Orphans: []Orphan{}}
+	log.Println("Scrub started")
+
+	err := s.scrub(ctx, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now()
+
+	log.Printf("Scrub finished in %v: %d images, %d mismatches, %d missing, %d unreadable, %d orphans",
+		report.FinishedAt.Sub(report.StartedAt).Round(time.Second), report.ImagesChecked,
+		len(report.Mismatches), len(report.Missing), len(report.Unreadable), len(report.Orphans))
+
+	s.mu.Lock()
+	s.running = false
+	s.last = report
+	s.mu.Unlock()
+
+	return report, err
+}
+
+func (s *Scrubber) scrub(ctx context.Context, report *Report) error {
+	// Content-addressed images share files, which only need to be hashed once
+	checksums := map[string]string{} // Storage path -> actual checksum, "" if unreadable
+
+	afterID := ""
+	for {
+		images, err := s.DB.ListAllImages(ctx, afterID, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, img := range images {
+			afterID = img.ID
+			report.ImagesChecked++
+			s.verifyImage(ctx, &img, checksums, report)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	// Files of previous versions are referenced too, they are not verified
+	var after models.ImageVersion
+	for {
+		versions, err := s.DB.ListAllImageVersions(ctx, after, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			break
+		}
+
+		for _, v := range versions {
+			after = v
+			if _, ok := checksums[v.StoragePath]; !ok {
+				checksums[v.StoragePath] = ""
+			}
+		}
+	}
+
+	return s.findOrphans(ctx, checksums, report)
+}
+
+// verifyImage hashes an image's file and compares it with the recorded checksum
+func (s *Scrubber) verifyImage(ctx context.Context, img *models.ImageMetadata, checksums map[string]string, report *Report) {
+	issue := Issue{ImageID: img.ID, UserID: img.UserID, StoragePath: img.StoragePath}
+
+	actual, hashed := checksums[img.StoragePath]
+	if !hashed {
+		var size int64
+		var err error
+		actual, size, err = s.hashFile(ctx, img.StoragePath)
+		checksums[img.StoragePath] = actual
+
+		if err != nil {
+			issue.Error = err.Error()
+			if strings.HasPrefix(err.Error(), "file not found") {
+				report.Missing = append(report.Missing, issue)
+			} else {
+				report.Unreadable = append(report.Unreadable, issue)
+			}
+			return
+		}
+
+		report.FilesHashed++
+		report.BytesHashed += size
+	}
+	if actual == "" {
+		// Already reported for another image sharing the file
+		return
+	}
+
+	expected := img.Checksum
+	if expected == nil {
+		expected = img.BlobHash
+	}
+
+	if expected == nil {
+		// Trust the current content of images uploaded before checksums were recorded
+		if err := s.DB.SetImageChecksum(ctx, img.ID, actual); err != nil {
+			log.Printf("Scrub: failed to record checksum of image %s: %v", img.ID, err)
+			return
+		}
+		report.ChecksumsRecorded++
+		return
+	}
+
+	if *expected != actual {
+		issue.ExpectedChecksum = *expected
+		issue.ActualChecksum = actual
+		report.Mismatches = append(report.Mismatches, issue)
+		log.Printf("Scrub: checksum mismatch for image %s (%s)", img.ID, img.StoragePath)
+	}
+}
+
+// hashFile computes the SHA-256 of a stored file
+func (s *Scrubber) hashFile(ctx context.Context, storagePath string) (string, int64, error) {
+	file, _, err := s.Storage.Download(ctx, storagePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// findOrphans lists blob storage for files that are not referenced by any image
+func (s *Scrubber) findOrphans(ctx context.Context, referenced map[string]string, report *Report) error {
+	cutoff := report.StartedAt.Add(-orphanGracePeriod)
+
+	return s.Storage.Walk(ctx, "", func(storagePath string, info *storage.ObjectInfo) error {
+		report.FilesListed++
+
+		for _, prefix := range ignoredPrefixes {
+			if strings.HasPrefix(storagePath, prefix) {
+				return nil
+			}
+		}
+
+		if _, ok := referenced[storagePath]; ok || info.ModTime.After(cutoff) {
+			return nil
+		}
+
+		report.Orphans = append(report.Orphans, Orphan{StoragePath: storagePath, Size: info.Size, ModTime: info.ModTime})
+		return nil
+	})
+}
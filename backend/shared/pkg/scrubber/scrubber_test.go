@@ -0,0 +1,288 @@
+package scrubber
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+	"github.com/shivamkedia17/roshnii/shared/pkg/storage"
+)
+
+// MockImageStore is an in-memory ImageStore with the methods the scrubber uses
+type MockImageStore struct {
+	db.ImageStore
+
+	mu       sync.Mutex
+	images   []models.ImageMetadata
+	versions []models.ImageVersion
+}
+
+func (m *MockImageStore) ListAllImages(ctx context.Context, afterID models.ImageID, limit int) ([]models.ImageMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sort.Slice(m.images, func(i, j int) bool { return m.images[i].ID < m.images[j].ID })
+	var page []models.ImageMetadata
+	for _, img := range m.images {
+		if img.ID > afterID && len(page) < limit {
+			page = append(page, img)
+		}
+	}
+	return page, nil
+}
+
+func (m *MockImageStore) ListAllImageVersions(ctx context.Context, after models.ImageVersion, limit int) ([]models.ImageVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var page []models.ImageVersion
+	for _, v := range m.versions {
+		if (v.ImageID > after.ImageID || v.ImageID == after.ImageID && v.Version > after.Version) && len(page) < limit {
+			page = append(page, v)
+		}
+	}
+	return page, nil
+}
+
+func (m *MockImageStore) SetImageChecksum(ctx context.Context, imageID models.ImageID, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.images {
+		if m.images[i].ID == imageID {
+			m.images[i].Checksum = &checksum
+			return nil
+		}
+	}
+	return errors.New("image not found")
+}
+
+// unreadableStorage fails to read the files under a prefix
+type unreadableStorage struct {
+	storage.BlobStorage
+	prefix string
+}
+
+func (s unreadableStorage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
+	if filepath.Dir(storagePath) == s.prefix {
+		return nil, "", errors.New("connection reset")
+	}
+	return s.BlobStorage.Download(ctx, storagePath)
+}
+
+// fixture is a file in blob storage and, unless orphaned, the image referencing it
+type fixture struct {
+	id          models.ImageID
+	storagePath string
+	content     string // Not stored if empty
+	checksumOf  string // Recorded checksum is the hash of this, none if empty
+	blobHash    bool   // Record the checksum as the blob hash only
+	orphan      bool   // No image references the file
+	version     int    // A previous version of the image references the file instead
+	age         time.Duration
+}
+
+func hashOf(t *testing.T, content string) string {
+	t.Helper()
+
+	hash, err := storage.HashContent(bytes.NewReader([]byte(content)))
+	require.NoError(t, err)
+	return hash
+}
+
+func TestScrubberRun(t *testing.T) {
+	old := 2 * orphanGracePeriod
+
+	tests := []struct {
+		name       string
+		fixtures   []fixture
+		unreadable string // Files in this directory cannot be read
+		check      func(t *testing.T, report *Report, store *MockImageStore)
+	}{
+		{
+			name:     "intact file",
+			fixtures: []fixture{{id: "a", storagePath: "user_1/a.jpg", content: "photo", checksumOf: "photo"}},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				assert.Equal(t, 1, report.ImagesChecked)
+				assert.Equal(t, 1, report.FilesHashed)
+				assert.Equal(t, int64(len("photo")), report.BytesHashed)
+				assert.Empty(t, report.Mismatches)
+				assert.Empty(t, report.Missing)
+				assert.Empty(t, report.Unreadable)
+			},
+		},
+		{
+			name:     "corrupted file",
+			fixtures: []fixture{{id: "a", storagePath: "user_1/a.jpg", content: "phot0", checksumOf: "photo"}},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				require.Len(t, report.Mismatches, 1)
+				assert.Equal(t, "a", report.Mismatches[0].ImageID)
+				assert.Equal(t, hashOf(t, "photo"), report.Mismatches[0].ExpectedChecksum)
+				assert.Equal(t, hashOf(t, "phot0"), report.Mismatches[0].ActualChecksum)
+			},
+		},
+		{
+			name:     "corrupted blob without a checksum",
+			fixtures: []fixture{{id: "a", storagePath: "user_1/a.jpg", content: "phot0", checksumOf: "photo", blobHash: true}},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				require.Len(t, report.Mismatches, 1)
+				assert.Equal(t, hashOf(t, "photo"), report.Mismatches[0].ExpectedChecksum)
+			},
+		},
+		{
+			name:     "missing file",
+			fixtures: []fixture{{id: "a", storagePath: "user_1/a.jpg", checksumOf: "photo"}},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				require.Len(t, report.Missing, 1)
+				assert.Equal(t, "a", report.Missing[0].ImageID)
+				assert.Empty(t, report.Mismatches)
+			},
+		},
+		{
+			name:       "unreadable file",
+			fixtures:   []fixture{{id: "a", storagePath: "flaky/a.jpg", content: "photo", checksumOf: "photo"}},
+			unreadable: "flaky",
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				require.Len(t, report.Unreadable, 1)
+				assert.Contains(t, report.Unreadable[0].Error, "connection reset")
+				assert.Empty(t, report.Missing)
+			},
+		},
+		{
+			name:     "checksum recorded for older images",
+			fixtures: []fixture{{id: "a", storagePath: "user_1/a.jpg", content: "photo"}},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				assert.Equal(t, 1, report.ChecksumsRecorded)
+				require.NotNil(t, store.images[0].Checksum)
+				assert.Equal(t, hashOf(t, "photo"), *store.images[0].Checksum)
+			},
+		},
+		{
+			name: "shared file hashed once",
+			fixtures: []fixture{
+				{id: "a", storagePath: "sha256/shared", content: "photo", checksumOf: "photo"},
+				{id: "b", storagePath: "sha256/shared", checksumOf: "photo"},
+			},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				assert.Equal(t, 2, report.ImagesChecked)
+				assert.Equal(t, 1, report.FilesHashed)
+				assert.Empty(t, report.Orphans)
+			},
+		},
+		{
+			name: "shared missing file reported once",
+			fixtures: []fixture{
+				{id: "a", storagePath: "sha256/shared", checksumOf: "photo"},
+				{id: "b", storagePath: "sha256/shared", checksumOf: "photo"},
+			},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				assert.Len(t, report.Missing, 1)
+			},
+		},
+		{
+			name: "files of previous versions",
+			fixtures: []fixture{
+				{id: "a", storagePath: "user_1/a-v2.jpg", content: "edited photo", checksumOf: "edited photo", age: old},
+				{id: "a", storagePath: "user_1/a.jpg", content: "photo", version: 1, age: old},
+				{id: "b", storagePath: "sha256/shared", content: "photo", checksumOf: "photo", age: old},
+				{id: "b", storagePath: "sha256/shared", version: 1},
+			},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				assert.Equal(t, 2, report.ImagesChecked, "versions are not verified")
+				assert.Equal(t, 2, report.FilesHashed)
+				assert.Empty(t, report.Mismatches)
+				assert.Empty(t, report.Orphans)
+			},
+		},
+		{
+			name: "orphans",
+			fixtures: []fixture{
+				{id: "a", storagePath: "user_1/a.jpg", content: "photo", checksumOf: "photo", age: old},
+				{storagePath: "user_1/orphan.jpg", content: "left behind", orphan: true, age: old},
+				{storagePath: "user_1/new.jpg", content: "being uploaded", orphan: true},
+				{storagePath: "uploads/u-1/chunk", content: "staged", orphan: true, age: old},
+				{storagePath: "renditions/a/small.jpg", content: "thumbnail", orphan: true, age: old},
+				{storagePath: "derived/a/key.jpg", content: "resized", orphan: true, age: old},
+			},
+			check: func(t *testing.T, report *Report, store *MockImageStore) {
+				assert.Equal(t, 6, report.FilesListed)
+				require.Len(t, report.Orphans, 1)
+				assert.Equal(t, "user_1/orphan.jpg", report.Orphans[0].StoragePath)
+				assert.Equal(t, int64(len("left behind")), report.Orphans[0].Size)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, err := storage.NewLocalStorage(t.TempDir(), nil)
+			require.NoError(t, err)
+			store := &MockImageStore{}
+
+			for _, f := range tt.fixtures {
+				if f.content != "" {
+					require.NoError(t, local.Put(context.Background(), f.storagePath, bytes.NewReader([]byte(f.content)), "image/jpeg"))
+					modTime := time.Now().Add(-f.age)
+					require.NoError(t, os.Chtimes(filepath.Join(local.BasePath, f.storagePath), modTime, modTime))
+				}
+				if f.orphan {
+					continue
+				}
+				if f.version > 0 {
+					store.versions = append(store.versions, models.ImageVersion{ImageID: f.id, UserID: "user-1", Version: f.version, StoragePath: f.storagePath})
+					continue
+				}
+
+				img := models.ImageMetadata{ID: f.id, UserID: "user-1", StoragePath: f.storagePath}
+				if f.checksumOf != "" {
+					checksum := hashOf(t, f.checksumOf)
+					if f.blobHash {
+						img.BlobHash = &checksum
+					} else {
+						img.Checksum = &checksum
+					}
+				}
+				store.images = append(store.images, img)
+			}
+
+			var blobStorage storage.BlobStorage = local
+			if tt.unreadable != "" {
+				blobStorage = unreadableStorage{BlobStorage: local, prefix: tt.unreadable}
+			}
+
+			report, err := NewScrubber(store, blobStorage).Run(context.Background())
+			require.NoError(t, err)
+			tt.check(t, report, store)
+		})
+	}
+}
+
+func TestScrubberRunsOneAtATime(t *testing.T) {
+	local, err := storage.NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+	s := NewScrubber(&MockImageStore{}, local)
+
+	s.running = true
+	_, err = s.Run(context.Background())
+	assert.ErrorIs(t, err, ErrAlreadyRunning)
+
+	s.running = false
+	report, err := s.Run(context.Background())
+	require.NoError(t, err)
+
+	running, last := s.Status()
+	assert.False(t, running)
+	assert.Same(t, report, last)
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// CacheStats reports the effectiveness of a CachedStorage
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+}
+
+// cacheEntry is a file held in the cache directory
+type cacheEntry struct {
+	storagePath string
+	info        ObjectInfo
+}
+
+// pathGeneration counts the invalidations of a path while it is being fetched
+type pathGeneration struct {
+	value   uint64
+	fetches int
+}
+
+// CachedStorage is a BlobStorage decorator that keeps recently read files in a
+// size-bounded LRU cache on local disk, so repeated reads of files held by a remote
+// backend do not go over the network. Concurrent misses for the same file are
+// fetched from the backend only once. The cache is emptied on startup.
+type CachedStorage struct {
+	Inner    BlobStorage
+	Dir      string
+	MaxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Storage path -> element holding a *cacheEntry
+	lru     *list.List               // Most recently used at the front
+	bytes   int64
+
+	// Generations of the paths being fetched, bumped when a path is invalidated so that
+	// a fetch of the previous content is not cached
+	generations map[string]*pathGeneration
+
+	group                   singleflight.Group
+	hits, misses, evictions atomic.Int64
+}
+
+// NewCachedStorage caches reads from inner in dir, using at most maxBytes of disk
+func NewCachedStorage(inner BlobStorage, dir string, maxBytes int64) (*CachedStorage, error) {
+	// Entries are only tracked in memory, so files left over from a previous run are unknown
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear cache directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return &CachedStorage{
+		Inner:       inner,
+		Dir:         dir,
+		MaxBytes:    maxBytes,
+		entries:     map[string]*list.Element{},
+		lru:         list.New(),
+		generations: map[string]*pathGeneration{},
+	}, nil
+}
+
+// Stats returns the cache hit/miss statistics
+func (s *CachedStorage) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CacheStats{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Evictions: s.evictions.Load(),
+		Entries:   len(s.entries),
+		Bytes:     s.bytes,
+		MaxBytes:  s.MaxBytes,
+	}
+}
+
+// cacheFile returns where a storage path is cached. Paths are hashed so any key maps to a flat, safe name.
+func (s *CachedStorage) cacheFile(storagePath string) string {
+	sum := sha256.Sum256([]byte(storagePath))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:]))
+}
+
+// lookup returns the info of a cached file and marks it as recently used
+func (s *CachedStorage) lookup(storagePath string) (*ObjectInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[storagePath]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+
+	info := elem.Value.(*cacheEntry).info
+	return &info, true
+}
+
+// openCached opens a cached file, reporting false if it is not cached
+func (s *CachedStorage) openCached(storagePath string) (io.ReadSeekCloser, *ObjectInfo, bool) {
+	info, ok := s.lookup(storagePath)
+	if !ok {
+		return nil, nil, false
+	}
+
+	file, err := os.Open(s.cacheFile(storagePath))
+	if err != nil {
+		// Evicted between the lookup and the open
+		return nil, nil, false
+	}
+	return file, info, true
+}
+
+// startFetch registers a fetch of a path and returns the path's current generation.
+// Every startFetch is followed by an endFetch.
+func (s *CachedStorage) startFetch(storagePath string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.generations[storagePath]
+	if !ok {
+		g = &pathGeneration{}
+		s.generations[storagePath] = g
+	}
+	g.fetches++
+	return g.value
+}
+
+// endFetch forgets the generation of a path once no fetch of it is left
+func (s *CachedStorage) endFetch(storagePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.generations[storagePath]
+	g.fetches--
+	if g.fetches == 0 {
+		delete(s.generations, storagePath)
+	}
+}
+
+// add moves a fetched file into the cache directory and records it, unless the path was
+// invalidated since the fetch started at generation. It evicts the least recently used
+// files beyond MaxBytes, and reports whether the file was added.
+func (s *CachedStorage) add(storagePath, tmpFile string, info *ObjectInfo, generation uint64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replaced or deleted while it was read, what was read is stale
+	if s.generations[storagePath].value != generation {
+		return false, nil
+	}
+	if err := os.Rename(tmpFile, s.cacheFile(storagePath)); err != nil {
+		return false, fmt.Errorf("failed to move cache file into place: %w", err)
+	}
+
+	if elem, ok := s.entries[storagePath]; ok {
+		s.bytes -= elem.Value.(*cacheEntry).info.Size
+		s.lru.Remove(elem)
+	}
+	s.entries[storagePath] = s.lru.PushFront(&cacheEntry{storagePath: storagePath, info: *info})
+	s.bytes += info.Size
+
+	for s.bytes > s.MaxBytes && s.lru.Len() > 1 {
+		oldest := s.lru.Back()
+		s.removeLocked(oldest.Value.(*cacheEntry).storagePath)
+		s.evictions.Add(1)
+	}
+	return true, nil
+}
+
+// invalidate drops a file from the cache, and keeps fetches in progress from caching it again
+func (s *CachedStorage) invalidate(storagePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g, ok := s.generations[storagePath]; ok {
+		g.value++
+	}
+	s.removeLocked(storagePath)
+}
+
+func (s *CachedStorage) removeLocked(storagePath string) {
+	elem, ok := s.entries[storagePath]
+	if !ok {
+		return
+	}
+
+	s.bytes -= elem.Value.(*cacheEntry).info.Size
+	s.lru.Remove(elem)
+	delete(s.entries, storagePath)
+
+	// Readers holding the file open keep reading it after the removal
+	if err := os.Remove(s.cacheFile(storagePath)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Cache: failed to remove %s: %v", storagePath, err)
+	}
+}
+
+// fetch copies a file from the wrapped backend into the cache. It returns false if
+// the file is too large to be cached, or was replaced or deleted while it was copied.
+func (s *CachedStorage) fetch(ctx context.Context, storagePath string) (bool, error) {
+	if _, ok := s.lookup(storagePath); ok {
+		return true, nil // Fetched by a previous flight
+	}
+
+	generation := s.startFetch(storagePath)
+	defer s.endFetch(storagePath)
+
+	src, info, err := s.Inner.Open(ctx, storagePath)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	if info.Size > s.MaxBytes {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("failed to fill cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("failed to fill cache file: %w", err)
+	}
+
+	return s.add(storagePath, tmp.Name(), info, generation)
+}
+
+// Open serves a file from the cache, fetching it from the wrapped backend on a miss
+func (s *CachedStorage) Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	if file, info, ok := s.openCached(storagePath); ok {
+		s.hits.Add(1)
+		return file, info, nil
+	}
+	s.misses.Add(1)
+
+	// Callers waiting on the same flight must not fail because the first one went away
+	cached, err, _ := s.group.Do(storagePath, func() (any, error) {
+		return s.fetch(context.WithoutCancel(ctx), storagePath)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cached.(bool) {
+		if file, info, ok := s.openCached(storagePath); ok {
+			return file, info, nil
+		}
+	}
+
+	// Too large to cache, changed while fetched, or evicted right away by concurrent fetches
+	return s.Inner.Open(ctx, storagePath)
+}
+
+// Download serves a file from the cache, fetching it from the wrapped backend on a miss
+func (s *CachedStorage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
+	file, info, err := s.Open(ctx, storagePath)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, info.ContentType, nil
+}
+
+// Stat returns information about a file, from the cache if it is cached
+func (s *CachedStorage) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	if info, ok := s.lookup(storagePath); ok {
+		return info, nil
+	}
+	return s.Inner.Stat(ctx, storagePath)
+}
+
+// Upload stores a file in the wrapped backend. New files are only cached once read.
+func (s *CachedStorage) Upload(ctx context.Context, filename string, userId models.UserID, content io.Reader, contentType string) (string, error) {
+	return s.Inner.Upload(ctx, filename, userId, content, contentType)
+}
+
+// Put stores a file in the wrapped backend and drops any cached copy of the file it replaces
+func (s *CachedStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	s.invalidate(storagePath)
+	err := s.Inner.Put(ctx, storagePath, content, contentType)
+	// Fetches that started before the write completed may have read the previous content
+	s.invalidate(storagePath)
+	return err
+}
+
+// Delete removes a file from the cache and the wrapped backend
+func (s *CachedStorage) Delete(ctx context.Context, storagePath string) error {
+	s.invalidate(storagePath)
+	err := s.Inner.Delete(ctx, storagePath)
+	// Fetches that started before the delete completed may have read the file
+	s.invalidate(storagePath)
+	return err
+}
+
+// Walk lists the files of the wrapped backend
+func (s *CachedStorage) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return s.Inner.Walk(ctx, prefix, fn)
+}
+
+// GenerateURL creates a temporary URL on the wrapped backend
+func (s *CachedStorage) GenerateURL(ctx context.Context, storagePath string, expiry time.Duration) (string, error) {
+	return s.Inner.GenerateURL(ctx, storagePath, expiry)
+}
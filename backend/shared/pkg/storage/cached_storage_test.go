@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingOpenStorage counts the files opened in the storage it wraps. Opens wait
+// for gate while it is set, once the file is open.
+type countingOpenStorage struct {
+	BlobStorage
+	opens atomic.Int64
+	gate  chan struct{}
+}
+
+func (s *countingOpenStorage) Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	file, info, err := s.BlobStorage.Open(ctx, storagePath)
+	s.opens.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	return file, info, err
+}
+
+func newTestCachedStorage(t *testing.T, maxBytes int64, files map[string]string) (*CachedStorage, *countingOpenStorage) {
+	t.Helper()
+
+	local, err := NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+	for storagePath, content := range files {
+		require.NoError(t, local.Put(context.Background(), storagePath, strings.NewReader(content), "image/jpeg"))
+	}
+
+	inner := &countingOpenStorage{BlobStorage: local}
+	cached, err := NewCachedStorage(inner, t.TempDir(), maxBytes)
+	require.NoError(t, err)
+	return cached, inner
+}
+
+func readCached(t *testing.T, s *CachedStorage, storagePath string) string {
+	t.Helper()
+
+	file, _, err := s.Open(context.Background(), storagePath)
+	require.NoError(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestCachedStorageEviction(t *testing.T) {
+	files := map[string]string{"a": "aaaa", "b": "bbbb", "c": "cccc", "large": strings.Repeat("x", 20)}
+
+	tests := []struct {
+		name       string
+		reads      []string
+		wantOpens  int64 // Reads that went to the wrapped backend
+		wantCached []string
+		wantStats  CacheStats
+	}{
+		{
+			name:       "repeated reads are served from the cache",
+			reads:      []string{"a", "a", "a"},
+			wantOpens:  1,
+			wantCached: []string{"a"},
+			wantStats:  CacheStats{Hits: 2, Misses: 1, Entries: 1, Bytes: 4, MaxBytes: 10},
+		},
+		{
+			name:       "least recently read is evicted",
+			reads:      []string{"a", "b", "c"},
+			wantOpens:  3,
+			wantCached: []string{"b", "c"},
+			wantStats:  CacheStats{Misses: 3, Evictions: 1, Entries: 2, Bytes: 8, MaxBytes: 10},
+		},
+		{
+			name:       "reading a file keeps it",
+			reads:      []string{"a", "b", "a", "c"},
+			wantOpens:  3,
+			wantCached: []string{"a", "c"},
+			wantStats:  CacheStats{Hits: 1, Misses: 3, Evictions: 1, Entries: 2, Bytes: 8, MaxBytes: 10},
+		},
+		{
+			name:       "evicted files are fetched again",
+			reads:      []string{"a", "b", "c", "a"},
+			wantOpens:  4,
+			wantCached: []string{"c", "a"},
+			wantStats:  CacheStats{Misses: 4, Evictions: 2, Entries: 2, Bytes: 8, MaxBytes: 10},
+		},
+		{
+			name:       "files larger than the cache are not cached",
+			reads:      []string{"a", "large", "large"},
+			wantOpens:  5, // Opened to learn the size, then again to serve it
+			wantCached: []string{"a"},
+			wantStats:  CacheStats{Misses: 3, Entries: 1, Bytes: 4, MaxBytes: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, inner := newTestCachedStorage(t, 10, files)
+
+			for _, storagePath := range tt.reads {
+				assert.Equal(t, files[storagePath], readCached(t, s, storagePath))
+			}
+
+			assert.Equal(t, tt.wantOpens, inner.opens.Load())
+			assert.Equal(t, tt.wantStats, s.Stats())
+			for _, storagePath := range tt.wantCached {
+				_, ok := s.lookup(storagePath)
+				assert.True(t, ok, "%s should be cached", storagePath)
+			}
+		})
+	}
+}
+
+func TestCachedStorageInvalidation(t *testing.T) {
+	put := func(t *testing.T, s *CachedStorage) {
+		require.NoError(t, s.Put(context.Background(), "a", strings.NewReader("new"), "image/jpeg"))
+	}
+	del := func(t *testing.T, s *CachedStorage) {
+		require.NoError(t, s.Delete(context.Background(), "a"))
+	}
+
+	tests := []struct {
+		name        string
+		duringFetch bool // Changed while the previous content is being fetched, not once it is cached
+		change      func(t *testing.T, s *CachedStorage)
+		want        string // Content read afterwards, empty if it must be gone
+	}{
+		{name: "put replaces the cached copy", change: put, want: "new"},
+		{name: "delete drops the cached copy", change: del},
+		{name: "put during a fetch", duringFetch: true, change: put, want: "new"},
+		{name: "delete during a fetch", duringFetch: true, change: del},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, inner := newTestCachedStorage(t, 10, map[string]string{"a": "old"})
+
+			if tt.duringFetch {
+				// Hold the fetch once it opened the previous content
+				inner.gate = make(chan struct{})
+				fetched := make(chan struct{})
+				go func() {
+					defer close(fetched)
+					if file, _, err := s.Open(context.Background(), "a"); err == nil {
+						file.Close()
+					}
+				}()
+				require.Eventually(t, func() bool { return inner.opens.Load() == 1 }, time.Second, time.Millisecond)
+
+				tt.change(t, s)
+				close(inner.gate)
+				<-fetched
+			} else {
+				require.Equal(t, "old", readCached(t, s, "a"))
+				tt.change(t, s)
+			}
+
+			if tt.want == "" {
+				_, _, err := s.Open(context.Background(), "a")
+				assert.Error(t, err)
+				_, err = s.Stat(context.Background(), "a")
+				assert.Error(t, err)
+				assert.Zero(t, s.Stats().Entries)
+				return
+			}
+			assert.Equal(t, tt.want, readCached(t, s, "a"))
+			info, err := s.Stat(context.Background(), "a")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.want)), info.Size)
+			assert.Equal(t, int64(len(tt.want)), s.Stats().Bytes)
+		})
+	}
+}
+
+func TestCachedStorageConcurrentMissesFetchOnce(t *testing.T) {
+	s, inner := newTestCachedStorage(t, 10, map[string]string{"a": "aaaa"})
+	inner.gate = make(chan struct{})
+
+	const readers = 5
+	var wg sync.WaitGroup
+	contents := make([][]byte, readers)
+	for i := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, _, err := s.Open(context.Background(), "a")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer file.Close()
+			contents[i], _ = io.ReadAll(file)
+		}()
+	}
+
+	// Hold the fetch until every reader missed and joined it
+	require.Eventually(t, func() bool { return s.Stats().Misses == readers }, time.Second, time.Millisecond)
+	close(inner.gate)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), inner.opens.Load())
+	for _, content := range contents {
+		assert.True(t, bytes.Equal([]byte("aaaa"), content))
+	}
+}
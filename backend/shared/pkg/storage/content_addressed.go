@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// contentAddressedPrefix is the top-level directory for blobs keyed by their hash
+const contentAddressedPrefix = "sha256"
+
+// ContentAddressedPath returns the storage path of a blob keyed by its hex SHA-256.
+// Blobs are fanned out over two directory levels to keep directories small,
+// e.g. "sha256/ab/cd/abcd1234...".
+func ContentAddressedPath(hash string) string {
+	return path.Join(contentAddressedPrefix, hash[0:2], hash[2:4], hash)
+}
+
+// hashFromPath returns the hash a content-addressed storage path is keyed by
+func hashFromPath(storagePath string) (string, bool) {
+	if !strings.HasPrefix(storagePath, contentAddressedPrefix+"/") {
+		return "", false
+	}
+	hash := path.Base(storagePath)
+	if len(hash) != sha256.Size*2 {
+		return "", false
+	}
+	return hash, true
+}
+
+// HashContent returns the hex SHA-256 of content and rewinds it to the start,
+// so the same reader can be uploaded afterwards.
+func HashContent(content io.ReadSeeker) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, content); err != nil {
+		return "", fmt.Errorf("failed to hash content: %w", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind content: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
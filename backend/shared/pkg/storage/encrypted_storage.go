@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// SystemKeyOwner owns the data key for files written without a key owner in the context
+const SystemKeyOwner = "system"
+
+type keyOwnerContextKey struct{}
+
+// WithKeyOwner returns a context whose writes through EncryptedStorage.Put are
+// encrypted with the given user's data key
+func WithKeyOwner(ctx context.Context, userID models.UserID) context.Context {
+	return context.WithValue(ctx, keyOwnerContextKey{}, userID)
+}
+
+func keyOwnerFromContext(ctx context.Context) string {
+	if owner, ok := ctx.Value(keyOwnerContextKey{}).(string); ok && owner != "" {
+		return owner
+	}
+	return SystemKeyOwner
+}
+
+// EncryptedStorage is a BlobStorage decorator that encrypts files before they reach
+// the wrapped backend and decrypts them on the way out. Each owner has their own data
+// key, stored wrapped by the master key, so rotating the master key only re-wraps the
+// data keys. Files written before encryption was enabled are served as they are.
+type EncryptedStorage struct {
+	Inner   BlobStorage
+	Keys    db.DataKeyStore
+	Keyring *Keyring
+	Signer  *URLSigner // Temporary URLs must go through the app, the backend only holds ciphertext
+
+	dataKeys sync.Map // Owner ID -> unwrapped data key
+}
+
+// NewEncryptedStorage wraps inner so everything it stores is encrypted at rest
+func NewEncryptedStorage(inner BlobStorage, keys db.DataKeyStore, keyring *Keyring, signer *URLSigner) *EncryptedStorage {
+	return &EncryptedStorage{Inner: inner, Keys: keys, Keyring: keyring, Signer: signer}
+}
+
+// dataKey returns an owner's unwrapped data key, creating it on first use if create is set
+func (s *EncryptedStorage) dataKey(ctx context.Context, ownerID string, create bool) ([]byte, error) {
+	if key, ok := s.dataKeys.Load(ownerID); ok {
+		return key.([]byte), nil
+	}
+
+	stored, err := s.Keys.GetDataKey(ctx, ownerID)
+	if err != nil && (!create || err.Error() != "data key not found") {
+		return nil, fmt.Errorf("failed to load data key for %s: %w", ownerID, err)
+	}
+
+	if stored == nil {
+		dataKey := make([]byte, masterKeySize)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, err
+		}
+
+		wrapped, err := s.Keyring.Wrap(ownerID, dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap data key for %s: %w", ownerID, err)
+		}
+
+		stored, err = s.Keys.CreateDataKey(ctx, &models.DataKey{
+			OwnerID:     ownerID,
+			WrappedKey:  wrapped,
+			MasterKeyID: s.Keyring.CurrentID(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to store data key for %s: %w", ownerID, err)
+		}
+		log.Printf("Created data key for %s", ownerID)
+	}
+
+	// Unwrap what was stored, which may be a key created concurrently by another request
+	key, err := s.Keyring.Unwrap(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dataKeys.Store(ownerID, key)
+	return key, nil
+}
+
+// encrypt returns a reader producing the encrypted form of content.
+// The returned reader must be closed to stop the encrypting goroutine.
+func (s *EncryptedStorage) encrypt(ctx context.Context, ownerID string, content io.Reader) (*io.PipeReader, error) {
+	key, err := s.dataKey(ctx, ownerID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptStream(pw, content, ownerID, key))
+	}()
+
+	return pr, nil
+}
+
+// Upload encrypts a file with the uploading user's data key and stores it
+func (s *EncryptedStorage) Upload(ctx context.Context, filename string, userId models.UserID, content io.Reader, contentType string) (string, error) {
+	encrypted, err := s.encrypt(ctx, userId, content)
+	if err != nil {
+		return "", err
+	}
+	defer encrypted.Close()
+
+	return s.Inner.Upload(ctx, filename, userId, encrypted, contentType)
+}
+
+// Put encrypts a file with the data key of the context's key owner and stores it at storagePath
+func (s *EncryptedStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	encrypted, err := s.encrypt(ctx, keyOwnerFromContext(ctx), content)
+	if err != nil {
+		return err
+	}
+	defer encrypted.Close()
+
+	return s.Inner.Put(ctx, storagePath, encrypted, contentType)
+}
+
+// Download retrieves and decrypts a file
+func (s *EncryptedStorage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
+	file, info, err := s.Open(ctx, storagePath)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, info.ContentType, nil
+}
+
+// Open retrieves a file and decrypts it as it is read. Seeking only fetches the segments that are read.
+func (s *EncryptedStorage) Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	src, info, err := s.Inner.Open(ctx, storagePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := readEncryptedHeader(src)
+	if errors.Is(err, errNotEncrypted) {
+		// Stored before encryption was enabled
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			src.Close()
+			return nil, nil, err
+		}
+		return src, info, nil
+	}
+	if err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("failed to read encryption header of %s: %w", storagePath, err)
+	}
+
+	key, err := s.dataKey(ctx, header.OwnerID, false)
+	if err != nil {
+		src.Close()
+		return nil, nil, err
+	}
+
+	file, err := newDecryptingReader(src, header, key, info.Size)
+	if err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt %s: %w", storagePath, err)
+	}
+
+	plainInfo := *info
+	plainInfo.Size = file.size
+	return file, &plainInfo, nil
+}
+
+// Stat returns information about the decrypted file
+func (s *EncryptedStorage) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	// The plaintext size depends on the header, which has to be read
+	file, info, err := s.Open(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return info, nil
+}
+
+// Delete removes a file from the wrapped backend
+func (s *EncryptedStorage) Delete(ctx context.Context, storagePath string) error {
+	return s.Inner.Delete(ctx, storagePath)
+}
+
+// Walk lists the files of the wrapped backend. Sizes are those of the encrypted files.
+func (s *EncryptedStorage) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return s.Inner.Walk(ctx, prefix, fn)
+}
+
+// GenerateURL creates a signed URL served (and decrypted) by the application
+func (s *EncryptedStorage) GenerateURL(ctx context.Context, storagePath string, expiry time.Duration) (string, error) {
+	if s.Signer == nil {
+		return "", fmt.Errorf("signed URLs are not configured")
+	}
+	return s.Signer.Sign(storagePath, expiry)
+}
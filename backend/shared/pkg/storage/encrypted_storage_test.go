@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// memoryKeyStore is an in-memory DataKeyStore
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]models.DataKey
+}
+
+func (m *memoryKeyStore) GetDataKey(ctx context.Context, ownerID string) (*models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[ownerID]
+	if !ok {
+		return nil, errors.New("data key not found")
+	}
+	return &key, nil
+}
+
+func (m *memoryKeyStore) CreateDataKey(ctx context.Context, key *models.DataKey) (*models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.keys[key.OwnerID]; ok {
+		return &existing, nil
+	}
+	created := *key
+	created.CreatedAt = time.Now()
+	created.UpdatedAt = created.CreatedAt
+	m.keys[key.OwnerID] = created
+	return &created, nil
+}
+
+func (m *memoryKeyStore) ListDataKeys(ctx context.Context) ([]models.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []models.DataKey
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *memoryKeyStore) RewrapDataKey(ctx context.Context, ownerID string, wrappedKey []byte, masterKeyID, previousMasterKeyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[ownerID]
+	if !ok || key.MasterKeyID != previousMasterKeyID {
+		return errors.New("data key not found")
+	}
+	key.WrappedKey, key.MasterKeyID = wrappedKey, masterKeyID
+	m.keys[ownerID] = key
+	return nil
+}
+
+// newTestEncryptedStorage returns an EncryptedStorage over a LocalStorage, and the local storage
+func newTestEncryptedStorage(t *testing.T) (*EncryptedStorage, *LocalStorage) {
+	t.Helper()
+
+	master := make([]byte, masterKeySize)
+	_, err := rand.Read(master)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(base64.StdEncoding.EncodeToString(master), "")
+	require.NoError(t, err)
+
+	local, err := NewLocalStorage(t.TempDir(), nil)
+	require.NoError(t, err)
+	return NewEncryptedStorage(local, &memoryKeyStore{keys: map[string]models.DataKey{}}, keyring, nil), local
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	t.Helper()
+
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	return content
+}
+
+func readAll(t *testing.T, s BlobStorage, storagePath string) ([]byte, error) {
+	t.Helper()
+
+	file, _, err := s.Open(context.Background(), storagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func TestEncryptedStorageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "one byte", size: 1},
+		{name: "just under a segment", size: encryptedSegmentSize - 1},
+		{name: "one segment", size: encryptedSegmentSize},
+		{name: "just over a segment", size: encryptedSegmentSize + 1},
+		{name: "several segments", size: 3*encryptedSegmentSize + 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, local := newTestEncryptedStorage(t)
+			ctx := WithKeyOwner(context.Background(), "user-1")
+			content := randomContent(t, tt.size)
+
+			require.NoError(t, s.Put(ctx, "user_1/photo.jpg", bytes.NewReader(content), "image/jpeg"))
+
+			stored, err := readAll(t, local, "user_1/photo.jpg")
+			require.NoError(t, err)
+			if tt.size >= 16 { // Shorter content may turn up in random ciphertext by chance
+				assert.NotContains(t, string(stored), string(content), "the backend must only hold ciphertext")
+			}
+
+			got, err := readAll(t, s, "user_1/photo.jpg")
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+
+			info, err := s.Stat(ctx, "user_1/photo.jpg")
+			require.NoError(t, err)
+			assert.Equal(t, int64(tt.size), info.Size)
+		})
+	}
+}
+
+func TestEncryptedStorageSeek(t *testing.T) {
+	s, _ := newTestEncryptedStorage(t)
+	ctx := WithKeyOwner(context.Background(), "user-1")
+	content := randomContent(t, 3*encryptedSegmentSize+5)
+	require.NoError(t, s.Put(ctx, "user_1/photo.jpg", bytes.NewReader(content), "image/jpeg"))
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		length int
+		want   []byte
+	}{
+		{name: "start", offset: 0, whence: io.SeekStart, length: 10, want: content[:10]},
+		{name: "across a segment boundary", offset: encryptedSegmentSize - 3, whence: io.SeekStart, length: 6, want: content[encryptedSegmentSize-3 : encryptedSegmentSize+3]},
+		{name: "final segment", offset: -5, whence: io.SeekEnd, length: 5, want: content[len(content)-5:]},
+		{name: "past the end", offset: 10, whence: io.SeekEnd, length: 5, want: []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, _, err := s.Open(ctx, "user_1/photo.jpg")
+			require.NoError(t, err)
+			defer file.Close()
+
+			_, err = file.Seek(tt.offset, tt.whence)
+			require.NoError(t, err)
+			got, err := io.ReadAll(io.LimitReader(file, int64(tt.length)))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEncryptedStorageTampering(t *testing.T) {
+	sealedSegment := encryptedSegmentSize + encryptedTagSize
+
+	tests := []struct {
+		name   string
+		tamper func(ciphertext []byte, headerLen int) []byte
+	}{
+		{
+			name: "truncated at a segment boundary",
+			tamper: func(ciphertext []byte, headerLen int) []byte {
+				return ciphertext[:headerLen+2*sealedSegment]
+			},
+		},
+		{
+			name: "truncated inside a segment",
+			tamper: func(ciphertext []byte, headerLen int) []byte {
+				return ciphertext[:len(ciphertext)-3]
+			},
+		},
+		{
+			name: "segments reordered",
+			tamper: func(ciphertext []byte, headerLen int) []byte {
+				first := headerLen
+				second := headerLen + sealedSegment
+				reordered := append([]byte(nil), ciphertext[:first]...)
+				reordered = append(reordered, ciphertext[second:second+sealedSegment]...)
+				reordered = append(reordered, ciphertext[first:second]...)
+				return append(reordered, ciphertext[second+sealedSegment:]...)
+			},
+		},
+		{
+			name: "byte flipped",
+			tamper: func(ciphertext []byte, headerLen int) []byte {
+				ciphertext[headerLen+sealedSegment+10] ^= 1
+				return ciphertext
+			},
+		},
+		{
+			name: "salt changed",
+			tamper: func(ciphertext []byte, headerLen int) []byte {
+				ciphertext[headerLen-1] ^= 1
+				return ciphertext
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, local := newTestEncryptedStorage(t)
+			ctx := WithKeyOwner(context.Background(), "user-1")
+			content := randomContent(t, 3*encryptedSegmentSize+5)
+			require.NoError(t, s.Put(ctx, "user_1/photo.jpg", bytes.NewReader(content), "image/jpeg"))
+
+			filePath := filepath.Join(local.BasePath, "user_1/photo.jpg")
+			ciphertext, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			headerLen := int((&encryptedHeader{OwnerID: "user-1"}).size())
+			require.NoError(t, os.WriteFile(filePath, tt.tamper(ciphertext, headerLen), 0o600))
+
+			got, err := readAll(t, s, "user_1/photo.jpg")
+			assert.Error(t, err, "tampered files must fail to decrypt")
+			assert.NotEqual(t, content, got)
+		})
+	}
+}
+
+func TestEncryptedStorageKeyOwners(t *testing.T) {
+	s, local := newTestEncryptedStorage(t)
+	content := randomContent(t, 100)
+
+	require.NoError(t, s.Put(WithKeyOwner(context.Background(), "user-1"), "a", bytes.NewReader(content), "image/jpeg"))
+	require.NoError(t, s.Put(context.Background(), "b", bytes.NewReader(content), "image/jpeg"))
+
+	tests := []struct {
+		storagePath string
+		wantOwner   string
+	}{
+		{storagePath: "a", wantOwner: "user-1"},
+		{storagePath: "b", wantOwner: SystemKeyOwner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.storagePath, func(t *testing.T) {
+			raw, _, err := local.Open(context.Background(), tt.storagePath)
+			require.NoError(t, err)
+			header, err := readEncryptedHeader(raw)
+			raw.Close()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOwner, header.OwnerID)
+
+			// The key is found from the header, whoever reads the file
+			got, err := readAll(t, s, tt.storagePath)
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+		})
+	}
+}
+
+func TestEncryptedStorageServesUnencryptedFiles(t *testing.T) {
+	s, local := newTestEncryptedStorage(t)
+	content := []byte("stored before encryption was enabled")
+	require.NoError(t, local.Put(context.Background(), "old.jpg", bytes.NewReader(content), "image/jpeg"))
+
+	got, err := readAll(t, s, "old.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted files are written as a header followed by independently sealed segments,
+// so they can be encrypted and decrypted as streams and seeked without reading the
+// whole file:
+//
+//	magic "RSNE" | version | owner length | owner | salt
+//	segment 0 | segment 1 | ... | final segment
+//
+// Each segment holds up to encryptedSegmentSize bytes of plaintext sealed with AES-256-GCM
+// under a per-file key derived from the owner's data key and the salt. The nonce encodes
+// the segment index and whether it is the final segment, so segments cannot be reordered
+// and a truncated file fails to decrypt.
+const (
+	encryptedMagic       = "RSNE"
+	encryptedVersion     = 1
+	encryptedSaltSize    = 32
+	encryptedSegmentSize = 64 * 1024
+	encryptedTagSize     = 16
+)
+
+var errNotEncrypted = errors.New("file is not encrypted")
+
+// encryptedHeader is the plaintext header of an encrypted file
+type encryptedHeader struct {
+	OwnerID string // Owner of the data key the file is encrypted with
+	Salt    []byte
+}
+
+func (h *encryptedHeader) size() int64 {
+	return int64(len(encryptedMagic) + 2 + len(h.OwnerID) + encryptedSaltSize)
+}
+
+func (h *encryptedHeader) marshal() []byte {
+	buf := make([]byte, 0, h.size())
+	buf = append(buf, encryptedMagic...)
+	buf = append(buf, encryptedVersion, byte(len(h.OwnerID)))
+	buf = append(buf, h.OwnerID...)
+	return append(buf, h.Salt...)
+}
+
+// readEncryptedHeader parses the header at the start of r, returning errNotEncrypted
+// for files written before encryption was enabled
+func readEncryptedHeader(r io.Reader) (*encryptedHeader, error) {
+	prefix := make([]byte, len(encryptedMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errNotEncrypted
+		}
+		return nil, err
+	}
+	if string(prefix[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, errNotEncrypted
+	}
+	if version := prefix[len(encryptedMagic)]; version != encryptedVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", version)
+	}
+
+	rest := make([]byte, int(prefix[len(encryptedMagic)+1])+encryptedSaltSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("truncated encryption header: %w", err)
+	}
+
+	ownerLen := len(rest) - encryptedSaltSize
+	return &encryptedHeader{OwnerID: string(rest[:ownerLen]), Salt: rest[ownerLen:]}, nil
+}
+
+// fileCipher derives the per-file AEAD from an owner's data key and the file's salt
+func fileCipher(dataKey, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write(salt)
+	return newGCM(mac.Sum(nil))
+}
+
+func segmentNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptStream reads plaintext from r and writes the encrypted file to w
+func encryptStream(w io.Writer, r io.Reader, ownerID string, dataKey []byte) error {
+	if len(ownerID) > 255 {
+		return fmt.Errorf("key owner %q is too long", ownerID)
+	}
+
+	header := &encryptedHeader{OwnerID: ownerID, Salt: make([]byte, encryptedSaltSize)}
+	if _, err := rand.Read(header.Salt); err != nil {
+		return err
+	}
+
+	aead, err := fileCipher(dataKey, header.Salt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(header.marshal()); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, encryptedSegmentSize)
+	plain := make([]byte, encryptedSegmentSize)
+	sealed := make([]byte, 0, encryptedSegmentSize+encryptedTagSize)
+
+	for index := int64(0); ; index++ {
+		n, err := io.ReadFull(br, plain)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		// A full segment is only final if nothing follows it
+		final := n < encryptedSegmentSize
+		if !final {
+			if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+				final = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], segmentNonce(index, final), plain[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// decryptingReader decrypts an encrypted file one segment at a time. Seeking only
+// moves the position; the segment containing it is fetched on the next Read.
+type decryptingReader struct {
+	src       io.ReadSeekCloser
+	aead      cipher.AEAD
+	headerLen int64
+	size      int64 // Plaintext size
+	segments  int64
+
+	pos     int64 // Plaintext position
+	srcPos  int64 // Position of src, to skip redundant seeks while reading sequentially
+	segment int64 // Index of the segment held in plain, -1 if none
+	plain   []byte
+	sealed  []byte
+}
+
+// newDecryptingReader wraps src, which must be positioned right after the header
+func newDecryptingReader(src io.ReadSeekCloser, header *encryptedHeader, dataKey []byte, encryptedSize int64) (*decryptingReader, error) {
+	aead, err := fileCipher(dataKey, header.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	size, segments, err := plaintextSize(encryptedSize - header.size())
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptingReader{
+		src:       src,
+		aead:      aead,
+		headerLen: header.size(),
+		size:      size,
+		segments:  segments,
+		srcPos:    header.size(),
+		segment:   -1,
+		sealed:    make([]byte, encryptedSegmentSize+encryptedTagSize),
+	}, nil
+}
+
+// plaintextSize computes the plaintext size and segment count from the size of the segments
+func plaintextSize(body int64) (int64, int64, error) {
+	if body < encryptedTagSize {
+		return 0, 0, errors.New("encrypted file is truncated")
+	}
+	segments := (body + encryptedSegmentSize + encryptedTagSize - 1) / (encryptedSegmentSize + encryptedTagSize)
+	return body - segments*encryptedTagSize, segments, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+
+	index := d.pos / encryptedSegmentSize
+	if index != d.segment {
+		if err := d.loadSegment(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain[d.pos-index*encryptedSegmentSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *decryptingReader) loadSegment(index int64) error {
+	offset := d.headerLen + index*(encryptedSegmentSize+encryptedTagSize)
+	if offset != d.srcPos {
+		if _, err := d.src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		d.srcPos = offset
+	}
+
+	final := index == d.segments-1
+	length := int64(encryptedSegmentSize)
+	if final {
+		length = d.size - index*encryptedSegmentSize
+	}
+
+	sealed := d.sealed[:length+encryptedTagSize]
+	n, err := io.ReadFull(d.src, sealed)
+	d.srcPos += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted segment %d: %w", index, err)
+	}
+
+	d.plain, err = d.aead.Open(d.plain[:0], segmentNonce(index, final), sealed, nil)
+	if err != nil {
+		d.segment = -1
+		return fmt.Errorf("failed to decrypt segment %d: %w", index, err)
+	}
+	d.segment = index
+	return nil
+}
+
+func (d *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = d.pos + offset
+	case io.SeekEnd:
+		pos = d.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	d.pos = pos
+	return pos, nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.src.Close()
+}
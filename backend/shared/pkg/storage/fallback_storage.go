@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// FallbackStorage is a BlobStorage decorator used while migrating to a new backend.
+// New files are written to Primary, and files not found there are read from Fallback,
+// so the application keeps serving every image while the migration copies them over.
+type FallbackStorage struct {
+	Primary  BlobStorage
+	Fallback BlobStorage
+}
+
+// NewFallbackStorage reads from fallback whatever primary does not have yet
+func NewFallbackStorage(primary, fallback BlobStorage) *FallbackStorage {
+	return &FallbackStorage{Primary: primary, Fallback: fallback}
+}
+
+// isNotFound reports whether a backend failed because the file does not exist
+func isNotFound(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "file not found")
+}
+
+// Upload stores a file in the primary backend
+func (s *FallbackStorage) Upload(ctx context.Context, filename string, userId models.UserID, content io.Reader, contentType string) (string, error) {
+	return s.Primary.Upload(ctx, filename, userId, content, contentType)
+}
+
+// Put stores a file in the primary backend
+func (s *FallbackStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	return s.Primary.Put(ctx, storagePath, content, contentType)
+}
+
+// Download retrieves a file from the primary backend, or from the fallback if it has not been copied yet
+func (s *FallbackStorage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
+	file, contentType, err := s.Primary.Download(ctx, storagePath)
+	if isNotFound(err) {
+		return s.Fallback.Download(ctx, storagePath)
+	}
+	return file, contentType, err
+}
+
+// Open retrieves a seekable file from the primary backend, or from the fallback if it has not been copied yet
+func (s *FallbackStorage) Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	file, info, err := s.Primary.Open(ctx, storagePath)
+	if isNotFound(err) {
+		return s.Fallback.Open(ctx, storagePath)
+	}
+	return file, info, err
+}
+
+// Stat returns information about a file from whichever backend holds it
+func (s *FallbackStorage) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	info, err := s.Primary.Stat(ctx, storagePath)
+	if isNotFound(err) {
+		return s.Fallback.Stat(ctx, storagePath)
+	}
+	return info, err
+}
+
+// Delete removes a file from both backends, so deleted images are not resurrected by the fallback
+func (s *FallbackStorage) Delete(ctx context.Context, storagePath string) error {
+	primaryErr := s.Primary.Delete(ctx, storagePath)
+	fallbackErr := s.Fallback.Delete(ctx, storagePath)
+	if err := errors.Join(primaryErr, fallbackErr); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", storagePath, err)
+	}
+	return nil
+}
+
+// Walk lists the files of both backends, reporting files present in both only once
+func (s *FallbackStorage) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	seen := map[string]bool{}
+	err := s.Primary.Walk(ctx, prefix, func(storagePath string, info *ObjectInfo) error {
+		seen[storagePath] = true
+		return fn(storagePath, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Fallback.Walk(ctx, prefix, func(storagePath string, info *ObjectInfo) error {
+		if seen[storagePath] {
+			return nil
+		}
+		return fn(storagePath, info)
+	})
+}
+
+// GenerateURL creates a temporary URL on whichever backend holds the file
+func (s *FallbackStorage) GenerateURL(ctx context.Context, storagePath string, expiry time.Duration) (string, error) {
+	if _, err := s.Primary.Stat(ctx, storagePath); isNotFound(err) {
+		return s.Fallback.GenerateURL(ctx, storagePath, expiry)
+	}
+	return s.Primary.GenerateURL(ctx, storagePath, expiry)
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+// masterKeySize is the size of master and data keys (AES-256)
+const masterKeySize = 32
+
+// Keyring holds the master keys that wrap per-owner data keys. New data keys are
+// always wrapped by the current master key; previous keys are only used to unwrap
+// data keys that have not been rotated yet.
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte // Master key ID -> key
+}
+
+// NewKeyring parses the base64 encoded current master key and a comma separated list of previous ones
+func NewKeyring(currentKey, previousKeys string) (*Keyring, error) {
+	current, err := decodeMasterKey(currentKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	k := &Keyring{currentID: masterKeyID(current), keys: map[string][]byte{}}
+	k.keys[k.currentID] = current
+
+	for _, encoded := range strings.Split(previousKeys, ",") {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+		key, err := decodeMasterKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous master key: %w", err)
+		}
+		k.keys[masterKeyID(key)] = key
+	}
+
+	return k, nil
+}
+
+func decodeMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", masterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// masterKeyID fingerprints a master key so wrapped keys record which key wrapped them
+func masterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CurrentID returns the ID of the master key used for wrapping
+func (k *Keyring) CurrentID() string {
+	return k.currentID
+}
+
+// Wrap encrypts a data key with the current master key. The owner is bound to the
+// wrapped key, so it cannot be swapped to another owner's row.
+func (k *Keyring) Wrap(ownerID string, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(k.keys[k.currentID])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(ownerID)), nil
+}
+
+// Unwrap decrypts a stored data key with the master key that wrapped it
+func (k *Keyring) Unwrap(key *models.DataKey) ([]byte, error) {
+	master, ok := k.keys[key.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not configured", key.MasterKeyID)
+	}
+
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key.WrappedKey) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is truncated")
+	}
+	nonce, sealed := key.WrappedKey[:aead.NonceSize()], key.WrappedKey[aead.NonceSize():]
+
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(key.OwnerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key for %s: %w", key.OwnerID, err)
+	}
+	return dataKey, nil
+}
+
+// RotateDataKeys re-wraps every data key that is not wrapped by the current master key.
+// Blobs are not touched, since they are encrypted by the data keys themselves.
+// It returns the number of keys that were re-wrapped.
+func RotateDataKeys(ctx context.Context, keyring *Keyring, keys db.DataKeyStore) (int, error) {
+	stored, err := keys.ListDataKeys(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list data keys: %w", err)
+	}
+
+	rotated := 0
+	for _, key := range stored {
+		if key.MasterKeyID == keyring.CurrentID() {
+			continue
+		}
+
+		dataKey, err := keyring.Unwrap(&key)
+		if err != nil {
+			return rotated, err
+		}
+
+		wrapped, err := keyring.Wrap(key.OwnerID, dataKey)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to wrap data key for %s: %w", key.OwnerID, err)
+		}
+
+		err = keys.RewrapDataKey(ctx, key.OwnerID, wrapped, keyring.CurrentID(), key.MasterKeyID)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to store data key for %s: %w", key.OwnerID, err)
+		}
+
+		log.Printf("Re-wrapped data key for %s (master key %s -> %s)", key.OwnerID, key.MasterKeyID, keyring.CurrentID())
+		rotated++
+	}
+
+	return rotated, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
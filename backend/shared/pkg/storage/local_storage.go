@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/shivamkedia17/roshnii/shared/pkg/models"
@@ -16,10 +20,20 @@ const defaultPath = "./uploads"
 // LocalStorage implements BlobStorage using the local filesystem
 type LocalStorage struct {
 	BasePath string
+	Signer   *URLSigner // Signs the URLs returned by GenerateURL
+
+	etags sync.Map // storagePath -> localETag, so files are only hashed once
+}
+
+// localETag caches the content hash of a file, valid while its size and mtime are unchanged
+type localETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
 }
 
 // NewLocalStorage creates a new LocalStorage instance
-func NewLocalStorage(basePath string) (*LocalStorage, error) {
+func NewLocalStorage(basePath string, signer *URLSigner) (*LocalStorage, error) {
 	if basePath == "" {
 		basePath = defaultPath
 	}
@@ -29,7 +43,7 @@ func NewLocalStorage(basePath string) (*LocalStorage, error) {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
 
-	return &LocalStorage{BasePath: basePath}, nil
+	return &LocalStorage{BasePath: basePath, Signer: signer}, nil
 }
 
 // Upload stores a file on the local filesystem
@@ -61,6 +75,36 @@ func (s *LocalStorage) Upload(ctx context.Context, filename string, userId model
 	return storagePath, nil
 }
 
+// Put writes a file at the given storage path. The content is written to a
+// temporary file first and renamed into place, so readers never see a partial file.
+func (s *LocalStorage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	fullPath := filepath.Join(s.BasePath, storagePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	s.etags.Delete(storagePath)
+
+	return nil
+}
+
 // Download retrieves a file from the local filesystem
 func (s *LocalStorage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
 	fullPath := filepath.Join(s.BasePath, storagePath)
@@ -76,9 +120,95 @@ func (s *LocalStorage) Download(ctx context.Context, storagePath string) (io.Rea
 		return nil, "", fmt.Errorf("failed to open file: %w", err)
 	}
 
-	// Get the content type (simplistic approach - in production use a more robust method)
+	return file, contentTypeFromExt(fullPath), nil
+}
+
+// Open retrieves a seekable file and its info from the local filesystem
+func (s *LocalStorage) Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	fullPath := filepath.Join(s.BasePath, storagePath)
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("file not found: %s", storagePath)
+		}
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	info, err := s.objectInfo(storagePath, file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, info, nil
+}
+
+// Stat returns information about a file on the local filesystem
+func (s *LocalStorage) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	fullPath := filepath.Join(s.BasePath, storagePath)
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("file not found: %s", storagePath)
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.objectInfo(storagePath, file)
+}
+
+// objectInfo stats an open file and works out its ETag. The file offset is left at the start.
+func (s *LocalStorage) objectInfo(storagePath string, file *os.File) (*ObjectInfo, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	etag, err := s.etag(storagePath, file, fi)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: contentTypeFromExt(storagePath),
+		ETag:        etag,
+	}, nil
+}
+
+// etag returns a strong ETag for the file. Content-addressed paths already carry
+// their hash; other files are hashed once and cached until they change.
+func (s *LocalStorage) etag(storagePath string, file *os.File, fi os.FileInfo) (string, error) {
+	if hash, ok := hashFromPath(storagePath); ok {
+		return `"` + hash + `"`, nil
+	}
+
+	if cached, ok := s.etags.Load(storagePath); ok {
+		entry := cached.(localETag)
+		if entry.size == fi.Size() && entry.modTime.Equal(fi.ModTime()) {
+			return entry.etag, nil
+		}
+	}
+
+	hash, err := HashContent(file)
+	if err != nil {
+		return "", err
+	}
+
+	etag := `"` + hash + `"`
+	s.etags.Store(storagePath, localETag{size: fi.Size(), modTime: fi.ModTime(), etag: etag})
+	return etag, nil
+}
+
+// contentTypeFromExt guesses the MIME type of a file from its extension
+func contentTypeFromExt(path string) string {
+	// Simplistic approach - in production use a more robust method
 	contentType := "application/octet-stream" // default
-	ext := filepath.Ext(fullPath)
+	ext := filepath.Ext(path)
 	switch ext {
 	case ".jpg", ".jpeg":
 		contentType = "image/jpeg"
@@ -89,8 +219,7 @@ func (s *LocalStorage) Download(ctx context.Context, storagePath string) (io.Rea
 	case ".webp":
 		contentType = "image/webp"
 	}
-
-	return file, contentType, nil
+	return contentType
 }
 
 // Delete removes a file from the local filesystem
@@ -106,12 +235,52 @@ func (s *LocalStorage) Delete(ctx context.Context, storagePath string) error {
 	if err := os.Remove(fullPath); err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+	s.etags.Delete(storagePath)
 
 	return nil
 }
 
-// GenerateURL creates a URL for direct file access (for local storage, just return the path)
+// Walk lists the files under prefix, skipping temporary files of in-progress writes
+func (s *LocalStorage) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	root := filepath.Join(s.BasePath, prefix)
+
+	err := filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		storagePath, err := filepath.Rel(s.BasePath, fullPath)
+		if err != nil {
+			return err
+		}
+
+		return fn(storagePath, &ObjectInfo{
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+			ContentType: contentTypeFromExt(storagePath),
+		})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // Nothing stored under prefix yet
+	}
+	return err
+}
+
+// GenerateURL creates a signed URL for direct file access that stops working after expiry
 func (s *LocalStorage) GenerateURL(ctx context.Context, storagePath string, expiry time.Duration) (string, error) {
-	// For local storage, we'll return a local URL path
-	return "/api/image/" + filepath.Base(storagePath) + "/download", nil
+	if s.Signer == nil {
+		return "", fmt.Errorf("signed URLs are not configured for local storage")
+	}
+	return s.Signer.Sign(storagePath, expiry)
 }
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/shivamkedia17/roshnii/shared/pkg/models"
+)
+
+const (
+	defaultS3Endpoint = "s3.amazonaws.com"
+
+	// Parts are streamed to the object store in chunks of this size, so uploads of
+	// unknown length never have to be buffered in full.
+	s3PartSize = 16 * 1024 * 1024
+
+	// S3 refuses presigned URLs valid for longer than seven days
+	maxPresignExpiry     = 7 * 24 * time.Hour
+	defaultPresignExpiry = 15 * time.Minute
+)
+
+// S3Options configures a connection to an S3-compatible object store
+type S3Options struct {
+	Endpoint        string // e.g. "s3.amazonaws.com" or "localhost:9000" for MinIO
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	ForcePathStyle  bool // Required by MinIO and most fake S3 servers
+}
+
+// S3Storage implements BlobStorage on top of any S3-compatible object store (AWS S3, MinIO, ...)
+type S3Storage struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// NewS3Storage connects to the object store and makes sure the bucket exists
+func NewS3Storage(ctx context.Context, opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("bucket name is required for S3 storage")
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+
+	// Without static keys fall back to the usual AWS environment / instance role chain
+	var creds *credentials.Credentials
+	if opts.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	lookup := minio.BucketLookupAuto
+	if opts.ForcePathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", opts.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, opts.Bucket, minio.MakeBucketOptions{Region: opts.Region})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", opts.Bucket, err)
+		}
+	}
+
+	return &S3Storage{Client: client, Bucket: opts.Bucket}, nil
+}
+
+// Upload streams a file to the bucket using multipart uploads
+func (s *S3Storage) Upload(ctx context.Context, filename string, userId models.UserID, content io.Reader, contentType string) (string, error) {
+	timestamp := time.Now().UnixNano()
+	// Object keys always use forward slashes, regardless of the host OS
+	storagePath := fmt.Sprintf("user_%s/%d_%s", userId, timestamp, filepath.Base(filename))
+
+	if err := s.Put(ctx, storagePath, content, contentType); err != nil {
+		return "", err
+	}
+
+	return storagePath, nil
+}
+
+// Put streams a file to an explicit key in the bucket
+func (s *S3Storage) Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error {
+	// A size of -1 makes the client stream the body in s3PartSize parts
+	_, err := s.Client.PutObject(ctx, s.Bucket, storagePath, content, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    s3PartSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	return nil
+}
+
+// Download retrieves an object from the bucket
+func (s *S3Storage) Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error) {
+	object, err := s.Client.GetObject(ctx, s.Bucket, storagePath, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get object: %w", err)
+	}
+
+	// GetObject is lazy, Stat forces the request so missing objects surface here
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, "", s.statError(storagePath, err)
+	}
+
+	return object, s.objectInfo(storagePath, info).ContentType, nil
+}
+
+// Open retrieves a seekable object and its info from the bucket
+func (s *S3Storage) Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	object, err := s.Client.GetObject(ctx, s.Bucket, storagePath, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	// Seeking re-issues ranged GETs against the object, so only the requested bytes are fetched
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, nil, s.statError(storagePath, err)
+	}
+
+	return object, s.objectInfo(storagePath, info), nil
+}
+
+// Stat returns information about an object without downloading it
+func (s *S3Storage) Stat(ctx context.Context, storagePath string) (*ObjectInfo, error) {
+	info, err := s.Client.StatObject(ctx, s.Bucket, storagePath, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.statError(storagePath, err)
+	}
+	return s.objectInfo(storagePath, info), nil
+}
+
+func (s *S3Storage) objectInfo(storagePath string, info minio.ObjectInfo) *ObjectInfo {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// The object store ETag is an MD5 of the content (or of its parts for multipart
+	// uploads), so it already changes whenever the content does
+	etag := `"` + info.ETag + `"`
+	if hash, ok := hashFromPath(storagePath); ok {
+		etag = `"` + hash + `"`
+	}
+
+	return &ObjectInfo{
+		Size:        info.Size,
+		ModTime:     info.LastModified,
+		ContentType: contentType,
+		ETag:        etag,
+	}
+}
+
+func (s *S3Storage) statError(storagePath string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("file not found: %s", storagePath)
+	}
+	return fmt.Errorf("failed to stat object: %w", err)
+}
+
+// Delete removes an object from the bucket
+func (s *S3Storage) Delete(ctx context.Context, storagePath string) error {
+	// RemoveObject does not fail for missing keys, matching LocalStorage
+	if err := s.Client.RemoveObject(ctx, s.Bucket, storagePath, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// Walk lists the objects under prefix
+func (s *S3Storage) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	// The client lists in a goroutine that blocks until its results are read, cancelling
+	// stops it when fn ends the walk early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		if err := fn(object.Key, s.objectInfo(object.Key, object)); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// GenerateURL creates a presigned GET URL valid for expiry
+func (s *S3Storage) GenerateURL(ctx context.Context, storagePath string, expiry time.Duration) (string, error) {
+	if expiry <= 0 {
+		expiry = defaultPresignExpiry
+	}
+	if expiry > maxPresignExpiry {
+		expiry = maxPresignExpiry
+	}
+
+	presigned, err := s.Client.PresignedGetObject(ctx, s.Bucket, storagePath, expiry, url.Values{})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign URL: %w", err)
+	}
+
+	return presigned.String(), nil
+}
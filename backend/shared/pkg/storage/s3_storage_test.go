@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBucket = "roshnii-test"
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// fakeS3 is an in-process S3 server implementing the calls S3Storage makes: bucket
+// creation, single and multipart uploads, ranged reads, deletes and listings
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]bool
+	objects map[string]fakeObject
+	uploads map[string]*fakeUpload
+}
+
+type fakeUpload struct {
+	parts       map[int][]byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Storage) {
+	t.Helper()
+
+	fake := &fakeS3{
+		buckets: map[string]bool{},
+		objects: map[string]fakeObject{},
+		uploads: map[string]*fakeUpload{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3Storage(context.Background(), S3Options{
+		Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+		Region:          "us-east-1",
+		Bucket:          testBucket,
+		AccessKeyID:     "test",
+		SecretAccessKey: "testsecret",
+		ForcePathStyle:  true,
+	})
+	require.NoError(t, err)
+	return fake, s3
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == http.MethodPut:
+			f.buckets[bucket] = true
+		case r.Method == http.MethodGet && query.Has("list-type"):
+			f.list(w, query.Get("prefix"))
+		default:
+			http.Error(w, "unsupported bucket call", http.StatusNotImplemented)
+		}
+		return
+	}
+
+	body, err := readS3Body(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = &fakeUpload{parts: map[int][]byte{}, contentType: r.Header.Get("Content-Type")}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")].parts[part] = body
+		w.Header().Set("ETag", etagOf(body))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		upload := f.uploads[query.Get("uploadId")]
+		numbers := make([]int, 0, len(upload.parts))
+		for n := range upload.parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, upload.parts[n]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = fakeObject{data: data, contentType: upload.contentType, modTime: time.Now()}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: etagOf(data)})
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type"), modTime: time.Now()}
+		w.Header().Set("ETag", etagOf(body))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>%s</Key></Error>`, key)
+			}
+			return
+		}
+		w.Header().Set("ETag", etagOf(object.data))
+		w.Header().Set("Content-Type", object.contentType)
+		http.ServeContent(w, r, key, object.modTime, bytes.NewReader(object.data))
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unsupported object call", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: testBucket, Prefix: prefix, MaxKeys: 1000}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		object := f.objects[key]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: object.modTime.UTC().Format(time.RFC3339),
+			ETag:         etagOf(object.data),
+			Size:         int64(len(object.data)),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+// readS3Body reads a request body, decoding the aws-chunked encoding the client
+// uses to stream signed uploads over plain HTTP
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var body []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk header %q", header)
+		}
+		if size == 0 {
+			return body, nil
+		}
+		chunk := make([]byte, size+2) // Data and its CRLF
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestNewS3StorageCreatesBucket(t *testing.T) {
+	fake, _ := newFakeS3(t)
+	assert.True(t, fake.buckets[testBucket])
+}
+
+func TestS3StorageRoundTrip(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name        string
+		storagePath string
+		content     []byte
+		contentType string
+		wantETag    string
+	}{
+		{
+			name:        "small file",
+			storagePath: "user_1/1_photo.jpg",
+			content:     []byte("jpeg bytes"),
+			contentType: "image/jpeg",
+			wantETag:    etagOf([]byte("jpeg bytes")),
+		},
+		{
+			name:        "empty file",
+			storagePath: "user_1/2_empty.png",
+			content:     []byte{},
+			contentType: "image/png",
+			wantETag:    etagOf([]byte{}),
+		},
+		{
+			name:        "content addressed file",
+			storagePath: ContentAddressedPath(hash),
+			content:     []byte("shared bytes"),
+			contentType: "image/webp",
+			wantETag:    `"` + hash + `"`,
+		},
+		{
+			name:        "no content type",
+			storagePath: "user_1/3_unknown",
+			content:     []byte("??"),
+			wantETag:    etagOf([]byte("??")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, s3 := newFakeS3(t)
+			ctx := context.Background()
+
+			require.NoError(t, s3.Put(ctx, tt.storagePath, bytes.NewReader(tt.content), tt.contentType))
+
+			wantType := tt.contentType
+			if wantType == "" {
+				wantType = "application/octet-stream"
+			}
+
+			info, err := s3.Stat(ctx, tt.storagePath)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.content)), info.Size)
+			assert.Equal(t, wantType, info.ContentType)
+			assert.Equal(t, tt.wantETag, info.ETag)
+
+			reader, contentType, err := s3.Download(ctx, tt.storagePath)
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			require.NoError(t, err)
+			assert.Equal(t, tt.content, data)
+			assert.Equal(t, wantType, contentType)
+		})
+	}
+}
+
+func TestS3StorageUploadKeysByUser(t *testing.T) {
+	_, s3 := newFakeS3(t)
+
+	storagePath, err := s3.Upload(context.Background(), "../holiday.jpg", "user-42", strings.NewReader("x"), "image/jpeg")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(storagePath, "user_user-42/"), storagePath)
+	assert.True(t, strings.HasSuffix(storagePath, "_holiday.jpg"), storagePath)
+}
+
+func TestS3StorageOpenSeeks(t *testing.T) {
+	_, s3 := newFakeS3(t)
+	ctx := context.Background()
+	content := []byte("0123456789abcdef")
+	require.NoError(t, s3.Put(ctx, "user_1/range.bin", bytes.NewReader(content), "application/octet-stream"))
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		length int
+		want   string
+	}{
+		{name: "from start", offset: 0, whence: io.SeekStart, length: 4, want: "0123"},
+		{name: "middle", offset: 10, whence: io.SeekStart, length: 3, want: "abc"},
+		{name: "from end", offset: -2, whence: io.SeekEnd, length: 2, want: "ef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, info, err := s3.Open(ctx, "user_1/range.bin")
+			require.NoError(t, err)
+			defer file.Close()
+			assert.Equal(t, int64(len(content)), info.Size)
+
+			_, err = file.Seek(tt.offset, tt.whence)
+			require.NoError(t, err)
+			buf := make([]byte, tt.length)
+			_, err = io.ReadFull(file, buf)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(buf))
+		})
+	}
+}
+
+func TestS3StorageMissingObject(t *testing.T) {
+	_, s3 := newFakeS3(t)
+	ctx := context.Background()
+
+	_, err := s3.Stat(ctx, "user_1/missing.jpg")
+	require.Error(t, err)
+	assert.Equal(t, "file not found: user_1/missing.jpg", err.Error())
+
+	_, _, err = s3.Open(ctx, "user_1/missing.jpg")
+	require.Error(t, err)
+	assert.Equal(t, "file not found: user_1/missing.jpg", err.Error())
+
+	// Deleting a missing object succeeds, like LocalStorage
+	assert.NoError(t, s3.Delete(ctx, "user_1/missing.jpg"))
+}
+
+func TestS3StorageDelete(t *testing.T) {
+	_, s3 := newFakeS3(t)
+	ctx := context.Background()
+	require.NoError(t, s3.Put(ctx, "user_1/gone.jpg", strings.NewReader("x"), "image/jpeg"))
+
+	require.NoError(t, s3.Delete(ctx, "user_1/gone.jpg"))
+
+	_, err := s3.Stat(ctx, "user_1/gone.jpg")
+	require.Error(t, err)
+	assert.Equal(t, "file not found: user_1/gone.jpg", err.Error())
+}
+
+func TestS3StorageWalk(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		name    string
+		prefix  string
+		stopAt  int // Number of files after which fn fails, 0 to never fail
+		want    []string
+		wantErr error
+	}{
+		{name: "all files", prefix: "", want: []string{"user_1/a.jpg", "user_1/b.jpg", "user_2/c.jpg"}},
+		{name: "under prefix", prefix: "user_1/", want: []string{"user_1/a.jpg", "user_1/b.jpg"}},
+		{name: "no match", prefix: "user_3/", want: nil},
+		{name: "stopped early", prefix: "", stopAt: 1, want: []string{"user_1/a.jpg"}, wantErr: errStop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, s3 := newFakeS3(t)
+			ctx := context.Background()
+			for _, key := range []string{"user_1/a.jpg", "user_1/b.jpg", "user_2/c.jpg"} {
+				require.NoError(t, s3.Put(ctx, key, strings.NewReader(key), "image/jpeg"))
+			}
+
+			var walked []string
+			err := s3.Walk(ctx, tt.prefix, func(storagePath string, info *ObjectInfo) error {
+				walked = append(walked, storagePath)
+				assert.Equal(t, int64(len(storagePath)), info.Size)
+				if tt.stopAt > 0 && len(walked) == tt.stopAt {
+					return errStop
+				}
+				return nil
+			})
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, walked)
+		})
+	}
+}
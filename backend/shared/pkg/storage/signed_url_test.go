@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signedParts splits a signed URL into its token and query
+func signedParts(t *testing.T, signedURL string) (string, url.Values) {
+	t.Helper()
+
+	parsed, err := url.Parse(signedURL)
+	require.NoError(t, err)
+	return path.Base(parsed.Path), parsed.Query()
+}
+
+func TestURLSignerSignedURLs(t *testing.T) {
+	signer := &URLSigner{Key: []byte("secret"), BaseURL: "https://photos.example.com"}
+
+	fileURL, err := signer.Sign("user_1/photo.jpg", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(fileURL, "https://photos.example.com/api/files/"), fileURL)
+
+	imageURL, err := signer.SignImage(SignedImage{UserID: "user-1", ImageID: "image-1", Variant: OriginalVariant}, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(imageURL, "https://photos.example.com/api/shared/"), imageURL)
+
+	token, query := signedParts(t, fileURL)
+	storagePath, err := signer.Verify(token, query.Get("expires"), query.Get("strip"), query.Get("sig"))
+	require.NoError(t, err)
+	assert.Equal(t, "user_1/photo.jpg", storagePath)
+
+	token, query = signedParts(t, imageURL)
+	img, err := signer.VerifyImage(token, query.Get("expires"), query.Get("strip"), query.Get("sig"))
+	require.NoError(t, err)
+	assert.Equal(t, &SignedImage{UserID: "user-1", ImageID: "image-1", Variant: OriginalVariant}, img)
+}
+
+func TestURLSignerVerifyImage(t *testing.T) {
+	signer := &URLSigner{Key: []byte("secret"), BaseURL: "http://127.0.0.1:8080"}
+	image := SignedImage{UserID: "user-1", ImageID: "image-1", Variant: "small"}
+
+	sign := func(t *testing.T, img SignedImage, expiry time.Duration) (string, url.Values) {
+		t.Helper()
+		signedURL, err := signer.SignImage(img, expiry)
+		require.NoError(t, err)
+		token, query := signedParts(t, signedURL)
+		return token, query
+	}
+
+	tests := []struct {
+		name    string
+		request func(t *testing.T) (token, expires, strip, sig string)
+		want    *SignedImage
+		wantErr error
+	}{
+		{
+			name: "valid",
+			request: func(t *testing.T) (string, string, string, string) {
+				token, query := sign(t, image, time.Hour)
+				return token, query.Get("expires"), query.Get("strip"), query.Get("sig")
+			},
+			want: &image,
+		},
+		{
+			name: "valid stripped",
+			request: func(t *testing.T) (string, string, string, string) {
+				token, query := sign(t, SignedImage{UserID: "user-1", ImageID: "image-1", Variant: OriginalVariant, StripMetadata: true}, time.Hour)
+				return token, query.Get("expires"), query.Get("strip"), query.Get("sig")
+			},
+			want: &SignedImage{UserID: "user-1", ImageID: "image-1", Variant: OriginalVariant, StripMetadata: true},
+		},
+		{
+			name: "expired",
+			request: func(t *testing.T) (string, string, string, string) {
+				// Signed URLs only expire at second granularity, so sign one that expired already
+				token, _ := sign(t, image, time.Hour)
+				expires := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+				return token, expires, "", signer.signature(signedImageKind, token, expires, "")
+			},
+			wantErr: ErrURLExpired,
+		},
+		{
+			name: "extended expiry",
+			request: func(t *testing.T) (string, string, string, string) {
+				token, query := sign(t, image, time.Hour)
+				expires := strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10)
+				return token, expires, "", query.Get("sig")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "other image",
+			request: func(t *testing.T) (string, string, string, string) {
+				_, query := sign(t, image, time.Hour)
+				other, _ := sign(t, SignedImage{UserID: "user-1", ImageID: "image-2", Variant: "small"}, time.Hour)
+				return other, query.Get("expires"), "", query.Get("sig")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "strip flag removed",
+			request: func(t *testing.T) (string, string, string, string) {
+				token, query := sign(t, SignedImage{UserID: "user-1", ImageID: "image-1", Variant: OriginalVariant, StripMetadata: true}, time.Hour)
+				return token, query.Get("expires"), "", query.Get("sig")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "tampered signature",
+			request: func(t *testing.T) (string, string, string, string) {
+				token, query := sign(t, image, time.Hour)
+				sig := []byte(query.Get("sig"))
+				sig[0] ^= 1
+				return token, query.Get("expires"), "", string(sig)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "file URL used as image URL",
+			request: func(t *testing.T) (string, string, string, string) {
+				fileURL, err := signer.Sign("user-1/image-1/small", time.Hour)
+				require.NoError(t, err)
+				token, query := signedParts(t, fileURL)
+				return token, query.Get("expires"), "", query.Get("sig")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "signed with another key",
+			request: func(t *testing.T) (string, string, string, string) {
+				other := &URLSigner{Key: []byte("other"), BaseURL: signer.BaseURL}
+				signedURL, err := other.SignImage(image, time.Hour)
+				require.NoError(t, err)
+				token, query := signedParts(t, signedURL)
+				return token, query.Get("expires"), "", query.Get("sig")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, expires, strip, sig := tt.request(t)
+			got, err := signer.VerifyImage(token, expires, strip, sig)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestURLSignerExpiryIsCapped(t *testing.T) {
+	signer := &URLSigner{Key: []byte("secret")}
+
+	signedURL, err := signer.Sign("user_1/photo.jpg", 30*24*time.Hour)
+	require.NoError(t, err)
+
+	_, query := signedParts(t, signedURL)
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, expires, time.Now().Add(maxPresignExpiry).Unix())
+}
+
+func TestURLSignerWithoutKey(t *testing.T) {
+	signer := &URLSigner{}
+
+	_, err := signer.SignImage(SignedImage{UserID: "user-1", ImageID: "image-1", Variant: OriginalVariant}, time.Hour)
+	assert.Error(t, err)
+	_, err = signer.VerifyImage("token", "0", "", "sig")
+	assert.Error(t, err)
+}
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"path/filepath"
 	"time"
 
 	"github.com/shivamkedia17/roshnii/shared/pkg/config"
+	"github.com/shivamkedia17/roshnii/shared/pkg/db"
 	"github.com/shivamkedia17/roshnii/shared/pkg/models"
 )
 
@@ -15,44 +18,151 @@ type BlobStorage interface {
 	// Upload stores a file and returns its storage path
 	Upload(ctx context.Context, filename string, userId models.UserID, content io.Reader, contentType string) (string, error)
 
+	// Put stores a file at an explicit storage path, replacing any existing file there
+	Put(ctx context.Context, storagePath string, content io.Reader, contentType string) error
+
 	// Download retrieves a file by its storage path
 	Download(ctx context.Context, storagePath string) (io.ReadCloser, string, error)
 
+	// Open retrieves a seekable file by its storage path, suitable for http.ServeContent
+	Open(ctx context.Context, storagePath string) (io.ReadSeekCloser, *ObjectInfo, error)
+
+	// Stat returns information about a stored file without reading it
+	Stat(ctx context.Context, storagePath string) (*ObjectInfo, error)
+
 	// Delete removes a file from storage
 	Delete(ctx context.Context, storagePath string) error
 
-	// GenerateURL creates a temporary URL for accessing the file (optional)
+	// Walk calls fn for every file stored under prefix ("" for all files), stopping at the first error.
+	// The ETag of the reported files may be empty.
+	Walk(ctx context.Context, prefix string, fn WalkFunc) error
+
+	// GenerateURL creates a temporary URL for accessing the file without authentication
 	GenerateURL(ctx context.Context, storagePath string, expiry time.Duration) (string, error)
 }
 
+// ObjectInfo describes a stored file
+type ObjectInfo struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	ETag        string // Strong, quoted entity tag derived from the file content
+}
+
+// WalkFunc is called by BlobStorage.Walk for each stored file
+type WalkFunc func(storagePath string, info *ObjectInfo) error
+
 type StorageType string
 
 const (
 	Local = "local"
-	// S3,
+	S3    = "s3"
 	// etc.
 )
 
-func InitStorage(cfg *config.Config) (BlobStorage, error) {
+// InitStorage creates the configured storage backend. When encryption at rest is
+// enabled it is wrapped in an EncryptedStorage that keeps its data keys in keys.
+// While a migration is in progress, files missing from the backend are read from
+// BLOB_STORAGE_FALLBACK_TYPE.
+func InitStorage(cfg *config.Config, keys db.DataKeyStore) (BlobStorage, error) {
+	storageService, err := InitBackend(cfg, cfg.BlobStorageType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.BlobStorageFallbackType != "" && cfg.BlobStorageFallbackType != cfg.BlobStorageType {
+		fallback, err := InitBackend(cfg, cfg.BlobStorageFallbackType, keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize fallback storage: %w", err)
+		}
+		storageService = NewFallbackStorage(storageService, fallback)
+		log.Printf("Reading files missing from %s storage from %s storage", cfg.BlobStorageType, cfg.BlobStorageFallbackType)
+	}
+
+	return storageService, nil
+}
+
+// InitBackend creates the storage backend of the given type, wrapped by a read cache
+// and for encryption at rest when they are enabled
+func InitBackend(cfg *config.Config, storeType string, keys db.DataKeyStore) (BlobStorage, error) {
 	var storageService BlobStorage
 	var err error
 
-	storeType := cfg.BlobStorageType
-
-	if storeType == Local {
+	switch storeType {
+	case Local:
 		localStoragePath := cfg.LocalstoragePath
-		storageService, err = NewLocalStorage(localStoragePath)
+		storageService, err = NewLocalStorage(localStoragePath, NewURLSigner(cfg))
 		if err != nil {
 			log.Fatalf("Failed to initialize local storage: %v", err)
 		}
 		log.Printf("Using local file storage at: %s", localStoragePath)
 
-		return storageService, nil
-	} else {
-		// For now, fall back to local storage if type is unrecognized
-		log.Printf("Unrecognized storage type '%s', using local storage", cfg.BlobStorageType)
-		storageService, _ = NewLocalStorage("./uploads")
+	case S3:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		storageService, err = NewS3Storage(ctx, S3Options{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.AWSRegion,
+			Bucket:          cfg.BlobBucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			UseSSL:          cfg.S3UseSSL,
+			ForcePathStyle:  cfg.S3ForcePathStyle,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize S3 storage: %w", err)
+		}
+		log.Printf("Using S3 storage, bucket: %s (endpoint: %s)", cfg.BlobBucket, cfg.S3Endpoint)
+
+	default:
+		return nil, fmt.Errorf("unrecognized storage type '%s'", storeType)
+	}
+
+	// Remote backends get a local read cache. It sits below encryption, so only ciphertext is cached.
+	if cfg.BlobCachePath != "" && storeType != Local {
+		cacheDir := filepath.Join(cfg.BlobCachePath, storeType)
+		storageService, err = NewCachedStorage(storageService, cacheDir, cfg.BlobCacheMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize blob cache: %w", err)
+		}
+		log.Printf("Caching %s storage reads in %s (max %d bytes)", storeType, cacheDir, cfg.BlobCacheMaxBytes)
+	}
+
+	if cfg.EncryptionMasterKey != "" {
+		keyring, err := NewKeyring(cfg.EncryptionMasterKey, cfg.EncryptionPreviousMasterKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize encryption: %w", err)
+		}
+		if keys == nil {
+			return nil, fmt.Errorf("encryption at rest requires a data key store")
+		}
+
+		storageService = NewEncryptedStorage(storageService, keys, keyring, NewURLSigner(cfg))
+		log.Printf("Encryption at rest enabled (master key %s)", keyring.CurrentID())
+	}
+
+	return storageService, nil
+}
+
+// CacheStatsOf collects the statistics of the read caches in a storage stack, keyed by cache directory
+func CacheStatsOf(blobStorage BlobStorage) map[string]CacheStats {
+	stats := map[string]CacheStats{}
+
+	var collect func(BlobStorage)
+	collect = func(b BlobStorage) {
+		switch s := b.(type) {
+		case *CachedStorage:
+			stats[s.Dir] = s.Stats()
+			collect(s.Inner)
+		case *EncryptedStorage:
+			collect(s.Inner)
+		case *FallbackStorage:
+			collect(s.Primary)
+			collect(s.Fallback)
+		}
 	}
+	collect(blobStorage)
 
-	return nil, err
+	return stats
 }